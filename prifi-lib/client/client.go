@@ -12,6 +12,7 @@ package client
  * - REL_CLI_TELL_TRUSTEES_PK - the trustee's identities. We react by sending our identity + ephemeral identity
  * - REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG - the shuffle from the trustees. We do some check, if they pass, we can communicate. We send the first round to the relay.
  * - REL_CLI_DOWNSTREAM_DATA - the data from the relay, for one round. We react by finishing the round (sending our data to the relay)
+ * - REL_CLI_ASK_EPH_PK, REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG - once communicating, the steps of a re-shuffle of the slots, see reshuffle.go
  *
  * local functions :
  *
@@ -39,6 +40,7 @@ import (
 	"github.com/dedis/prifi/prifi-lib/utils"
 	"github.com/dedis/prifi/utils"
 	"go.dedis.ch/kyber/v3/proof"
+	"go.dedis.ch/kyber/v3/suites"
 	"math/rand"
 	"time"
 )
@@ -53,6 +55,18 @@ func (p *PriFiLibClientInstance) Received_ALL_ALL_SHUTDOWN(msg net.ALL_ALL_SHUTD
 	return nil
 }
 
+// setCryptoSuite switches the client to the crypto suite "suite" named "name", imposed by the relay.
+// The long-term keys of the client live in the suite, hence are regenerated if it changes
+func (p *PriFiLibClientInstance) setCryptoSuite(name string, suite suites.Suite) {
+	if name == p.clientState.CryptoSuite {
+		return
+	}
+	log.Lvl2("Client " + strconv.Itoa(p.clientState.ID) + " : switching from crypto suite " + p.clientState.CryptoSuite + " to " + name)
+	p.clientState.CryptoSuite = name
+	p.clientState.suite = suite
+	p.clientState.PublicKey, p.clientState.privateKey = crypto.NewKeyPair(suite)
+}
+
 // Received_ALL_CLI_PARAMETERS handles ALL_CLI_PARAMETERS messages.
 // It uses the message's parameters to initialize the client.
 func (p *PriFiLibClientInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PARAMETERS) error {
@@ -65,8 +79,17 @@ func (p *PriFiLibClientInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PAR
 	payloadSize := msg.IntValueOrElse("PayloadSize", p.clientState.PayloadSize)
 	useUDP := msg.BoolValueOrElse("UseUDP", p.clientState.UseUDP)
 	dcNetType := msg.StringValueOrElse("DCNetType", "not initialized")
+	padCipher := msg.StringValueOrElse("DCNetPadCipher", dcnet.PAD_CIPHER_XOF)
+	cryptoSuite := msg.StringValueOrElse("CryptoSuite", config.DefaultCryptoSuiteName)
 	disruptionProtection := msg.BoolValueOrElse("DisruptionProtectionEnabled", false)
 	equivProtection := msg.BoolValueOrElse("EquivocationProtectionEnabled", false)
+	downstreamConsistencyCheck := msg.BoolValueOrElse("DownstreamConsistencyCheck", false)
+	slotSchedulerName := msg.StringValueOrElse("SlotScheduler", scheduler.SLOT_SCHEDULER_BITMASK)
+	maxSlotsPerClient := msg.IntValueOrElse("MaxSlotsPerClient", 1)
+	variableSlotLengths := msg.BoolValueOrElse("VariableSlotLengths", false)
+	maxPayloadSize := msg.IntValueOrElse("MaxPayloadSize", payloadSize)
+	verifyShuffleTranscript := msg.BoolValueOrElse("ClientsVerifyShuffle", false)
+	windowSize := msg.IntValueOrElse("WindowSize", 1)
 	ForceDisruptionSinceRound3 := msg.BoolValueOrElse("ForceDisruptionSinceRound3", false)
 	//sanity checks
 	if clientID < -1 {
@@ -81,13 +104,29 @@ func (p *PriFiLibClientInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PAR
 	if payloadSize < 1 {
 		return errors.New("PayloadSize cannot be 0")
 	}
-
-	switch dcNetType {
-	case "Verifiable":
-		panic("verifiable not supported yet")
+	if err := dcnet.ValidatePadCipher(padCipher); err != nil {
+		return err
+	}
+	suite, err := config.FindCryptoSuite(cryptoSuite)
+	if err != nil {
+		return err
+	}
+	slotScheduler, err := scheduler.NewSlotScheduler_Client(slotSchedulerName, maxSlotsPerClient)
+	if err != nil {
+		return err
+	}
+	if maxPayloadSize < payloadSize {
+		maxPayloadSize = payloadSize
+	}
+	if maxSlotsPerClient < 1 {
+		maxSlotsPerClient = 1
+	}
+	if windowSize < 1 {
+		windowSize = 1
 	}
 
 	//set the received parameters
+	p.setCryptoSuite(cryptoSuite, suite)
 	p.clientState.ID = clientID
 	p.clientState.Name = "Client-" + strconv.Itoa(clientID)
 	p.clientState.MySlot = -1
@@ -96,12 +135,25 @@ func (p *PriFiLibClientInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PAR
 	p.clientState.PayloadSize = payloadSize
 	p.clientState.UseUDP = useUDP
 	p.clientState.TrusteePublicKey = make([]kyber.Point, nTrustees)
-	p.clientState.sharedSecrets = make([]kyber.Point, nTrustees)
 	p.clientState.RoundNo = int32(0)
 	p.clientState.BufferedRoundData = make(map[int32]net.REL_CLI_DOWNSTREAM_DATA)
-	p.clientState.MessageHistory = config.CryptoSuite.XOF([]byte("init")) //any non-nil, non-empty, constant array
+	p.clientState.WindowSize = windowSize
+	p.clientState.lastNackedRound = -1
+	p.clientState.MessageHistory = p.clientState.suite.XOF([]byte("init")) //any non-nil, non-empty, constant array
 	p.clientState.DisruptionProtectionEnabled = disruptionProtection
 	p.clientState.EquivocationProtectionEnabled = equivProtection
+	p.clientState.DownstreamConsistencyCheck = downstreamConsistencyCheck
+	p.clientState.downstreamDigest = nil
+	p.clientState.downstreamDigestTags = nil
+	p.clientState.VerifiableDCNetEnabled = dcNetType == "Verifiable"
+	p.clientState.PadCipher = padCipher
+	p.clientState.SlotScheduler = slotSchedulerName
+	p.clientState.slotScheduler = slotScheduler
+	p.clientState.MaxSlotsPerClient = maxSlotsPerClient
+	p.clientState.VariableSlotLengths = variableSlotLengths
+	p.clientState.MaxPayloadSize = maxPayloadSize
+	p.clientState.VerifyShuffleTranscript = verifyShuffleTranscript
+	p.clientState.shuffleTranscript = nil
 	p.clientState.ForceDisruptionSinceRound3 = ForceDisruptionSinceRound3
 	p.clientState.MyLastRound = -10
 	p.clientState.DisruptionWrongBitPosition = -1
@@ -173,6 +225,9 @@ func (p *PriFiLibClientInstance) Received_REL_CLI_DOWNSTREAM_DATA(msg net.REL_CL
 		return p.ProcessDownStreamData(msg)
 	} else if msg.RoundID < p.clientState.RoundNo {
 		log.Lvl3("Client " + strconv.Itoa(p.clientState.ID) + " : Received a REL_CLI_DOWNSTREAM_DATA for round " + strconv.Itoa(int(msg.RoundID)) + " but we are in round " + strconv.Itoa(int(p.clientState.RoundNo)) + ", discarding.")
+	} else if p.clientState.UseUDP {
+		//we missed some broadcasts
+		return p.missedDownstreamData(msg)
 	} else if msg.RoundID > p.clientState.RoundNo {
 		log.Lvl3("Client "+strconv.Itoa(p.clientState.ID)+" : Skipping from round", p.clientState.RoundNo, "to round", msg.RoundID)
 		p.clientState.RoundNo = msg.RoundID
@@ -204,6 +259,30 @@ When this function ends, it calls SendUpstreamData() which continues the communi
 func (p *PriFiLibClientInstance) ProcessDownStreamData(msg net.REL_CLI_DOWNSTREAM_DATA) error {
 	timing.StartMeasure("round-processing")
 
+	//the relay re-shuffles the slots from time to time, the new slots start at a given round
+	if msg.SlotsStartRoundID > p.clientState.slotsReceivedStart {
+		return p.waitForReshuffledSlots(msg)
+	}
+	p.switchToReshuffledSlot(msg.RoundID)
+
+	//the relay announces the DC-net epochs, the pads are re-keyed when encoding the first round of a new epoch
+	if err := p.clientState.DCNet.ScheduleEpoch(msg.EpochID, msg.EpochStartRoundID); err != nil {
+		log.Error("Client " + strconv.Itoa(p.clientState.ID) + " : " + err.Error())
+	}
+
+	//the relay announces the length of this round, as asked by the owner of the slot
+	if p.clientState.VariableSlotLengths {
+		p.clientState.DCNet.SetPayloadSizeOfRound(msg.RoundID, msg.SlotLength)
+	}
+
+	//with the equivocation protection, the upstream cell of this round is bound to the downstream cells received so far
+	p.clientState.DCNet.UpdateReceivedMessageHistory(msg.Data)
+
+	//if enabled, the digest of this downstream cell is sent with the upstream cell of this round
+	if p.clientState.DownstreamConsistencyCheck {
+		p.computeDownstreamDigest(msg)
+	}
+
 	/*
 	 * HANDLE THE DOWNSTREAM DATA
 	 */
@@ -259,25 +338,34 @@ func (p *PriFiLibClientInstance) ProcessDownStreamData(msg net.REL_CLI_DOWNSTREA
 		log.Lvl3("Client", p.clientState.ID, "Relay wants to open/closed schedule slots ")
 
 		//do the schedule
-		bmc := new(scheduler.BitMaskSlotScheduler_Client)
-		bmc.Client_ReceivedScheduleRequest(p.clientState.nClients)
+		p.clientState.slotScheduler.Client_ReceivedScheduleRequest(p.clientState.nClients)
 
-		//check if we want to transmit
+		//check if we want to transmit, and in how many slots
 		if p.WantsToTransmit() {
-			bmc.Client_ReserveRound(p.clientState.MySlot)
-			log.Lvl3("Client ", p.clientState.ID, "Gonna reserve slot", p.clientState.MySlot, "(we are in round", msg.RoundID, ")")
+			nSlots := p.slotsToReserve()
+			for i := 0; i < nSlots; i++ {
+				p.clientState.slotScheduler.Client_ReserveRound(p.clientState.MySlot)
+			}
+			log.Lvl3("Client ", p.clientState.ID, "Gonna reserve", nSlots, "slots (slot", p.clientState.MySlot, ", we are in round", msg.RoundID, ")")
 		}
-		contribution := bmc.Client_GetOpenScheduleContribution()
+		contribution := p.clientState.slotScheduler.Client_GetOpenScheduleContribution()
 
 		//produce the next upstream cell
 
-		upstreamCell, _ := p.clientState.DCNet.EncodeForRound(p.clientState.RoundNo, false, contribution)
+		upstreamCell, _, err := p.clientState.DCNet.EncodeForRound(p.clientState.RoundNo, false, contribution)
+		if err != nil {
+			e := "Client " + strconv.Itoa(p.clientState.ID) + " : could not encode the open/closed contribution, " + err.Error()
+			log.Error(e)
+			return errors.New(e)
+		}
 
 		//send the data to the relay
 		toSend := &net.CLI_REL_OPENCLOSED_DATA{
-			ClientID:       p.clientState.ID,
-			RoundID:        p.clientState.RoundNo,
-			OpenClosedData: upstreamCell}
+			ClientID:             p.clientState.ID,
+			RoundID:              p.clientState.RoundNo,
+			OpenClosedData:       upstreamCell,
+			DownstreamDigest:     p.clientState.downstreamDigest,
+			DownstreamDigestTags: p.clientState.downstreamDigestTags}
 		p.messageSender.SendToRelayWithLog(toSend, "(round "+strconv.Itoa(int(p.clientState.RoundNo))+")")
 
 	} else {
@@ -353,6 +441,22 @@ func (p *PriFiLibClientInstance) WantsToTransmit() bool {
 	}
 }
 
+// slotsToReserve returns the number of slots to reserve in the next schedule : one per message waiting to be sent, up to
+// MaxSlotsPerClient. It is called once WantsToTransmit() returned true, hence is at least 1
+func (p *PriFiLibClientInstance) slotsToReserve() int {
+	nSlots := len(p.clientState.DataForDCNet)
+	if p.clientState.NextDataForDCNet != nil {
+		nSlots++
+	}
+	if nSlots < 1 {
+		nSlots = 1
+	}
+	if nSlots > p.clientState.MaxSlotsPerClient {
+		nSlots = p.clientState.MaxSlotsPerClient
+	}
+	return nSlots
+}
+
 /*
 SendUpstreamData determines if it's our round, embeds data (maybe latency-test message) in the payload if we can,
 creates the DC-net cipher and sends it to the relay.
@@ -363,13 +467,16 @@ func (p *PriFiLibClientInstance) SendUpstreamData(ownerSlotID int) error {
 
 	//if we can send data
 	slotOwner := false
-	if ownerSlotID == p.clientState.MySlot {
+	if p.clientState.slotScheduler.Client_OwnsSlot(ownerSlotID, p.clientState.MySlot) {
 		slotOwner = true
 		p.clientState.MyLastRound = p.clientState.RoundNo
 	}
 
 	//how much data we can send
 	actualPayloadSize := p.clientState.PayloadSize
+	if p.clientState.VariableSlotLengths {
+		actualPayloadSize = p.clientState.DCNet.PayloadSizeOfRound(p.clientState.RoundNo)
+	}
 	if p.clientState.DisruptionProtectionEnabled {
 		// Making room for the b_echo_last flag
 		actualPayloadSize--
@@ -383,6 +490,10 @@ func (p *PriFiLibClientInstance) SendUpstreamData(ownerSlotID int) error {
 			log.Fatal("Client", p.clientState.ID, "Cannot have equivocation protection with less than 16 bytes payload")
 		}
 	}
+	if p.clientState.VariableSlotLengths && slotOwner {
+		// Making room for the length of our next slot
+		actualPayloadSize -= dcnet.SLOT_LENGTH_FIELD_LENGTH
+	}
 
 	if slotOwner {
 
@@ -461,6 +572,10 @@ func (p *PriFiLibClientInstance) SendUpstreamData(ownerSlotID int) error {
 			//copy(content[:], upstreamCellContent[:])
 			//p.clientState.DataHistory[p.clientState.RoundNo] = content
 		}
+
+		if p.clientState.VariableSlotLengths {
+			upstreamCellContent = p.fitInSlot(upstreamCellContent, actualPayloadSize)
+		}
 	}
 
 	if p.clientState.DisruptionProtectionEnabled && slotOwner {
@@ -469,7 +584,7 @@ func (p *PriFiLibClientInstance) SendUpstreamData(ownerSlotID int) error {
 			blameRoundID := p.clientState.RoundNo - int32(p.clientState.nClients)*2
 
 			pred := proof.Rep("X", "x", "B")
-			suite := config.CryptoSuite
+			suite := p.clientState.suite
 			B := suite.Point().Base()
 			sval := map[string]kyber.Scalar{"x": p.clientState.ephemeralPrivateKey}
 			pval := map[string]kyber.Point{"B": B, "X": p.clientState.EphemeralPublicKey}
@@ -503,8 +618,10 @@ func (p *PriFiLibClientInstance) SendUpstreamData(ownerSlotID int) error {
 				// Creating hash
 				hash = sha256.Sum256(payload_to_hash)
 			} else {
-				// TODO: CHECK IT FITS
-				upstreamCellContent[3] = byte(p.clientState.ID)
+				// a short slot may have no room for it
+				if len(upstreamCellContent) > 3 {
+					upstreamCellContent[3] = byte(p.clientState.ID)
+				}
 				// Saving data for possible disruption
 				p.clientState.LastMessage = upstreamCellContent
 				// Creating hash
@@ -524,8 +641,27 @@ func (p *PriFiLibClientInstance) SendUpstreamData(ownerSlotID int) error {
 		slice_b_echo_last[0] = b_echo_last
 	}
 	payload := append(slice_b_echo_last, upstreamCellContent...)
+	if p.clientState.VariableSlotLengths && slotOwner {
+		payload = dcnet.PutNextSlotLength(payload, len(payload)+dcnet.SLOT_LENGTH_FIELD_LENGTH, p.nextSlotLength())
+	}
 
-	upstreamCell, plainPayload := p.clientState.DCNet.EncodeForRound(p.clientState.RoundNo, slotOwner, payload)
+	var upstreamCell, plainPayload []byte
+	var err error
+	if p.clientState.VerifiableDCNetEnabled {
+		upstreamCell, err = p.clientState.DCNet.EncodeVerifiableForRound(p.clientState.RoundNo, ownerSlotID, payload)
+		if err != nil {
+			e := "Client " + strconv.Itoa(p.clientState.ID) + " : could not encode the verifiable DC-net cell, " + err.Error()
+			log.Error(e)
+			return errors.New(e)
+		}
+	} else {
+		upstreamCell, plainPayload, err = p.clientState.DCNet.EncodeForRound(p.clientState.RoundNo, slotOwner, payload)
+		if err != nil {
+			e := "Client " + strconv.Itoa(p.clientState.ID) + " : could not encode the DC-net cell, " + err.Error()
+			log.Error(e)
+			return errors.New(e)
+		}
+	}
 
 	if p.clientState.EquivocationProtectionEnabled && p.clientState.DisruptionProtectionEnabled && slotOwner && p.clientState.B_echo_last != 1 {
 		// Saving data for possible disruption
@@ -550,9 +686,11 @@ func (p *PriFiLibClientInstance) SendUpstreamData(ownerSlotID int) error {
 	}
 	//send the data to the relay
 	toSend := &net.CLI_REL_UPSTREAM_DATA{
-		ClientID: p.clientState.ID,
-		RoundID:  p.clientState.RoundNo,
-		Data:     upstreamCell,
+		ClientID:             p.clientState.ID,
+		RoundID:              p.clientState.RoundNo,
+		Data:                 upstreamCell,
+		DownstreamDigest:     p.clientState.downstreamDigest,
+		DownstreamDigestTags: p.clientState.downstreamDigestTags,
 	}
 
 	p.messageSender.SendToRelayWithLog(toSend, "(round "+strconv.Itoa(int(p.clientState.RoundNo))+")")
@@ -585,18 +723,34 @@ func (p *PriFiLibClientInstance) Received_REL_CLI_TELL_TRUSTEES_PK(trusteesPks [
 	}
 
 	p.clientState.TrusteePublicKey = make([]kyber.Point, p.clientState.nTrustees)
-	p.clientState.sharedSecrets = make([]kyber.Point, p.clientState.nTrustees)
+	// the shared secrets only key the DC-net and the downstream digests, they are not kept
+	sharedSecrets := make([]kyber.Point, p.clientState.nTrustees)
+	p.clientState.downstreamDigestKeys = make([][]byte, p.clientState.nTrustees)
 
 	for i := 0; i < len(trusteesPks); i++ {
 		p.clientState.TrusteePublicKey[i] = trusteesPks[i]
-		p.clientState.sharedSecrets[i] = config.CryptoSuite.Point().Mul(p.clientState.privateKey, trusteesPks[i])
+		sharedSecrets[i] = p.clientState.suite.Point().Mul(p.clientState.privateKey, trusteesPks[i])
+		if p.clientState.DownstreamConsistencyCheck {
+			key, err := dcnet.DownstreamDigestKey(sharedSecrets[i])
+			if err != nil {
+				e := "Client " + strconv.Itoa(p.clientState.ID) + " : could not derive the key of the downstream digests, " + err.Error()
+				log.Error(e)
+				return errors.New(e)
+			}
+			p.clientState.downstreamDigestKeys[i] = key
+		}
 	}
 
-	p.clientState.DCNet = dcnet.NewDCNetEntity(p.clientState.ID,
-		dcnet.DCNET_CLIENT, p.clientState.PayloadSize, p.clientState.EquivocationProtectionEnabled, p.clientState.sharedSecrets)
+	if p.clientState.VerifiableDCNetEnabled {
+		p.clientState.DCNet = dcnet.NewVerifiableDCNetEntity(p.clientState.suite, p.clientState.ID,
+			dcnet.DCNET_CLIENT, p.clientState.PayloadSize, sharedSecrets)
+	} else {
+		p.clientState.DCNet = dcnet.NewDCNetEntity(p.clientState.suite, p.clientState.ID,
+			dcnet.DCNET_CLIENT, p.clientState.PayloadSize, p.clientState.EquivocationProtectionEnabled, p.clientState.PadCipher, sharedSecrets)
+	}
 
 	//then, generate our ephemeral keys (used for shuffling)
-	p.clientState.EphemeralPublicKey, p.clientState.ephemeralPrivateKey = crypto.NewKeyPair()
+	p.clientState.EphemeralPublicKey, p.clientState.ephemeralPrivateKey = crypto.NewKeyPair(p.clientState.suite)
 
 	//send the keys to the relay
 	toSend := &net.CLI_REL_TELL_PK_AND_EPH_PK{
@@ -617,14 +771,23 @@ These are sent after the Shuffle protocol has been done by the Trustees and the
 The relay is sending us the result, so we should check that the protocol went well :
 1) each trustee announced must have signed the shuffle
 2) we need to locate which is our slot
-When this is done, we are ready to communicate !
+3) if VerifyShuffleTranscript, we verify every proof of the shuffle ourselves
+When this is done, we are ready to communicate ! If we join while the others communicate (StartRoundID > 0), we start
+at the round StartRoundID, when we receive its downstream data.
 As the client should send the first data, we do so; to keep this function simple, the first data is blank
 (the message has no content / this is a wasted message). The actual embedding of data happens only in the
 "round function", that is Received_REL_CLI_DOWNSTREAM_DATA().
 */
 func (p *PriFiLibClientInstance) Received_REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG(msg net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG) error {
+	if err := p.verifyShuffleTranscript(p.clientState.EphemeralPublicKey, msg); err != nil {
+		e := "Client " + strconv.Itoa(p.clientState.ID) + "; the shuffle is not valid, " + err.Error()
+		log.Error(e)
+		return errors.New(e)
+	}
+
 	//verify the signature
 	neff := new(scheduler.NeffShuffle)
+	neff.Init(p.clientState.suite)
 	mySlot, err := neff.ClientVerifySigAndRecognizeSlot(p.clientState.ephemeralPrivateKey, p.clientState.TrusteePublicKey, msg.Base, msg.EphPks, msg.GetSignatures())
 	p.clientState.EphemeralPublicKeys = msg.EphPks
 	if err != nil {
@@ -634,8 +797,12 @@ func (p *PriFiLibClientInstance) Received_REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG(
 
 	//prepare for commmunication
 	p.clientState.MySlot = mySlot
-	p.clientState.RoundNo = int32(0)
+	p.clientState.RoundNo = msg.StartRoundID
+	p.clientState.slotsReceivedStart = msg.StartRoundID
+	p.clientState.nClients = len(msg.EphPks)
+	p.clientState.DCNet.SetPseudonyms(msg.Base, msg.EphPks, p.clientState.ephemeralPrivateKey)
 	p.clientState.BufferedRoundData = make(map[int32]net.REL_CLI_DOWNSTREAM_DATA)
+	p.clientState.lastNackedRound = msg.StartRoundID - 1
 
 	//if by chance we had a broadcast-listener goroutine, kill it
 	if p.clientState.UseUDP {
@@ -652,6 +819,20 @@ func (p *PriFiLibClientInstance) Received_REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG(
 	p.stateMachine.ChangeState("READY")
 	log.Lvl3("Client", p.clientState.ID, "ready to communicate.")
 
+	//the others already communicate, our first cell is for the round StartRoundID
+	if msg.StartRoundID > 0 {
+		log.Lvl2("Client", p.clientState.ID, "joins the DC-net at round", msg.StartRoundID)
+		// we did not receive the downstream cells before this round, the relay tells us their history
+		if p.clientState.EquivocationProtectionEnabled {
+			if err := p.clientState.DCNet.SetMessageHistory(msg.History); err != nil {
+				e := "Client " + strconv.Itoa(p.clientState.ID) + " : cannot use the history of the downstream cells, " + err.Error()
+				log.Error(e)
+				return errors.New(e)
+			}
+		}
+		return nil
+	}
+
 	//produce a blank cell (we could embed data, but let's keep the code simple, one wasted message is not much)
 	slotOwner := false
 	if p.clientState.ID == 0 {
@@ -683,7 +864,23 @@ func (p *PriFiLibClientInstance) Received_REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG(
 		data = append(slice_b_echo_last, data2...)
 	}
 
-	upstreamCell, plainPayload := p.clientState.DCNet.EncodeForRound(0, slotOwner, data)
+	var upstreamCell, plainPayload []byte
+	if p.clientState.VerifiableDCNetEnabled {
+		// in the verifiable DC-net, no-one owns round 0 (the relay did not announce an owner)
+		upstreamCell, err = p.clientState.DCNet.EncodeVerifiableForRound(0, -1, nil)
+		if err != nil {
+			e := "Client " + strconv.Itoa(p.clientState.ID) + " : could not encode the verifiable DC-net cell, " + err.Error()
+			log.Error(e)
+			return errors.New(e)
+		}
+	} else {
+		upstreamCell, plainPayload, err = p.clientState.DCNet.EncodeForRound(0, slotOwner, data)
+		if err != nil {
+			e := "Client " + strconv.Itoa(p.clientState.ID) + " : could not encode the DC-net cell, " + err.Error()
+			log.Error(e)
+			return errors.New(e)
+		}
+	}
 	if p.clientState.EquivocationProtectionEnabled && p.clientState.DisruptionProtectionEnabled {
 		// Saving data for possible disruption
 		p.clientState.LastMessage = plainPayload
@@ -703,3 +900,33 @@ func (p *PriFiLibClientInstance) Received_REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG(
 
 	return nil
 }
+
+/*
+Received_REL_TRU_TELL_TRANSCRIPT handles REL_TRU_TELL_TRANSCRIPT messages, sent by the relay when we verify the shuffle
+ourselves. We keep the transcript until the relay sends the signed shuffle.
+*/
+func (p *PriFiLibClientInstance) Received_REL_TRU_TELL_TRANSCRIPT(msg net.REL_TRU_TELL_TRANSCRIPT) error {
+	if !p.clientState.VerifyShuffleTranscript {
+		return errors.New("Client " + strconv.Itoa(p.clientState.ID) + " : received a transcript, but we do not verify the shuffle")
+	}
+	p.clientState.shuffleTranscript = &msg
+	return nil
+}
+
+// verifyShuffleTranscript checks, if VerifyShuffleTranscript, that the transcript received before the signed shuffle
+// "msg" is valid, contains our ephemeral key, and ends with this shuffle
+func (p *PriFiLibClientInstance) verifyShuffleTranscript(ephemeralPublicKey kyber.Point, msg net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG) error {
+	if !p.clientState.VerifyShuffleTranscript {
+		return nil
+	}
+	transcript := p.clientState.shuffleTranscript
+	p.clientState.shuffleTranscript = nil
+	if transcript == nil {
+		return errors.New("no transcript received")
+	}
+
+	neff := new(scheduler.NeffShuffle)
+	neff.Init(p.clientState.suite)
+	return neff.ClientVerifyTranscript(ephemeralPublicKey, transcript.InitialEphPks, transcript.Bases, transcript.GetKeys(),
+		transcript.GetProofs(), msg.Base, msg.EphPks)
+}
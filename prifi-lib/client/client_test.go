@@ -13,6 +13,7 @@ import (
 	"crypto/sha256"
 	"github.com/dedis/prifi/prifi-lib/scheduler"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
 	"go.dedis.ch/onet/v3/log"
 	"testing"
 	"time"
@@ -107,15 +108,24 @@ func TestClient(t *testing.T) {
 	trusteesPubKeys := make([]kyber.Point, nTrustees)
 	trusteesPrivKeys := make([]kyber.Scalar, nTrustees)
 	for i := 0; i < nTrustees; i++ {
-		trusteesPubKeys[i], trusteesPrivKeys[i] = crypto.NewKeyPair()
+		trusteesPubKeys[i], trusteesPrivKeys[i] = crypto.NewKeyPair(config.CryptoSuite)
 	}
 
 	msg.TrusteesPks = trusteesPubKeys
 
+	msg.Add("DCNetPadCipher", "RC4")
+	if err := client.ReceivedMessage(*msg); err == nil {
+		t.Error("Client should refuse an unknown pad cipher")
+	}
+	msg.Add("DCNetPadCipher", dcnet.PAD_CIPHER_CHACHA20)
+
 	if err := client.ReceivedMessage(*msg); err != nil {
 		t.Error("Client should be able to receive this message:", err)
 	}
 
+	if cs.PadCipher != dcnet.PAD_CIPHER_CHACHA20 {
+		t.Error("PadCipher should be ChaCha20")
+	}
 	if cs.nClients != 3 {
 		t.Error("NClients should be 3")
 	}
@@ -143,16 +153,14 @@ func TestClient(t *testing.T) {
 	if len(cs.TrusteePublicKey) != nTrustees {
 		t.Error("Len(TrusteePKs) should be equal to NTrustees")
 	}
-	if len(cs.sharedSecrets) != nTrustees {
-		t.Error("Len(SharedSecrets) should be equal to NTrustees")
-	}
 
 	for i := 0; i < nTrustees; i++ {
 		if !cs.TrusteePublicKey[i].Equal(trusteesPubKeys[i]) {
 			t.Error("Pub key", i, "has not been stored correctly")
 		}
 		myPrivKey := cs.privateKey
-		if !cs.sharedSecrets[i].Equal(config.CryptoSuite.Point().Mul(myPrivKey, trusteesPubKeys[i])) {
+		expectedSeed, _ := dcnet.PadSeed(config.CryptoSuite.Point().Mul(myPrivKey, trusteesPubKeys[i]))
+		if seed, err := cs.DCNet.RevealPadSeed(i, 0); err != nil || !bytes.Equal(seed, expectedSeed) {
 			t.Error("Shared secret", i, "has not been computed correctly")
 		}
 	}
@@ -185,12 +193,12 @@ func TestClient(t *testing.T) {
 
 	//neff shuffle
 	n := new(scheduler.NeffShuffle)
-	n.Init()
+	n.Init(config.CryptoSuite)
 	n.RelayView.Init(nTrustees)
 	trustees := make([]*scheduler.NeffShuffle, nTrustees)
 	for i := 0; i < nTrustees; i++ {
 		trustees[i] = new(scheduler.NeffShuffle)
-		trustees[i].Init()
+		trustees[i].Init(config.CryptoSuite)
 		trustees[i].TrusteeView.Init(i, trusteesPrivKeys[i], trusteesPubKeys[i])
 	}
 	n.RelayView.AddClient(cs.EphemeralPublicKey)
@@ -240,7 +248,7 @@ func TestClient(t *testing.T) {
 	if msg6.RoundID != int32(0) {
 		t.Error("Client sent a wrong RoundID")
 	}
-	if len(msg6.Data) != upCellSize+8 {
+	if len(msg6.Data) != upCellSize+dcnet.DCNET_CIPHER_HEADER_LENGTH {
 		t.Error("Client sent a payload with a wrong size")
 	}
 	if cs.RoundNo != int32(1) {
@@ -279,7 +287,7 @@ func TestClient(t *testing.T) {
 	if msg8.RoundID != int32(1) {
 		t.Error("Client sent a wrong RoundID")
 	}
-	if len(msg8.Data) != upCellSize+8 {
+	if len(msg8.Data) != upCellSize+dcnet.DCNET_CIPHER_HEADER_LENGTH {
 		t.Error("Client sent a payload with a wrong size")
 	}
 	if cs.RoundNo != int32(2) {
@@ -357,7 +365,7 @@ func TestClient(t *testing.T) {
 	if msg10.RoundID != int32(4) {
 		t.Error("Client sent a wrong RoundID")
 	}
-	if len(msg10.Data) != upCellSize+8 {
+	if len(msg10.Data) != upCellSize+dcnet.DCNET_CIPHER_HEADER_LENGTH {
 		t.Error("Client sent a payload with a wrong size")
 	}
 	if cs.RoundNo != int32(5) { //we did round 3 already
@@ -400,7 +408,7 @@ func TestClient(t *testing.T) {
 	if latencyMsg.RoundID != int32(5) {
 		t.Error("Client sent a wrong RoundID")
 	}
-	if len(latencyMsg.Data) != upCellSize+8 {
+	if len(latencyMsg.Data) != upCellSize+dcnet.DCNET_CIPHER_HEADER_LENGTH {
 		t.Error("Client sent a payload with a wrong size")
 	}
 
@@ -467,7 +475,7 @@ func TestClient2(t *testing.T) {
 	trusteesPubKeys := make([]kyber.Point, nTrustees)
 	trusteesPrivKeys := make([]kyber.Scalar, nTrustees)
 	for i := 0; i < nTrustees; i++ {
-		trusteesPubKeys[i], trusteesPrivKeys[i] = crypto.NewKeyPair()
+		trusteesPubKeys[i], trusteesPrivKeys[i] = crypto.NewKeyPair(config.CryptoSuite)
 	}
 
 	msg.TrusteesPks = trusteesPubKeys
@@ -485,12 +493,12 @@ func TestClient2(t *testing.T) {
 
 	//neff shuffle
 	n := new(scheduler.NeffShuffle)
-	n.Init()
+	n.Init(config.CryptoSuite)
 	n.RelayView.Init(nTrustees)
 	trustees := make([]*scheduler.NeffShuffle, nTrustees)
 	for i := 0; i < nTrustees; i++ {
 		trustees[i] = new(scheduler.NeffShuffle)
-		trustees[i].Init()
+		trustees[i].Init(config.CryptoSuite)
 		trustees[i].TrusteeView.Init(i, trusteesPrivKeys[i], trusteesPubKeys[i])
 	}
 	n.RelayView.AddClient(cs.EphemeralPublicKey)
@@ -532,7 +540,7 @@ func TestClient2(t *testing.T) {
 	if msg6.RoundID != int32(0) {
 		t.Error("Client sent a wrong RoundID")
 	}
-	if len(msg6.Data) != upCellSize+8 {
+	if len(msg6.Data) != upCellSize+dcnet.DCNET_CIPHER_HEADER_LENGTH {
 		t.Error("Client sent a payload with a wrong size")
 	}
 	if cs.RoundNo != int32(1) {
@@ -600,7 +608,7 @@ func TestDisruptionClient(t *testing.T) {
 	trusteesPubKeys := make([]kyber.Point, nTrustees)
 	trusteesPrivKeys := make([]kyber.Scalar, nTrustees)
 	for i := 0; i < nTrustees; i++ {
-		trusteesPubKeys[i], trusteesPrivKeys[i] = crypto.NewKeyPair()
+		trusteesPubKeys[i], trusteesPrivKeys[i] = crypto.NewKeyPair(config.CryptoSuite)
 	}
 	msg.TrusteesPks = trusteesPubKeys
 
@@ -621,12 +629,12 @@ func TestDisruptionClient(t *testing.T) {
 
 	//neff shuffle
 	n := new(scheduler.NeffShuffle)
-	n.Init()
+	n.Init(config.CryptoSuite)
 	n.RelayView.Init(nTrustees)
 	trustees := make([]*scheduler.NeffShuffle, nTrustees)
 	for i := 0; i < nTrustees; i++ {
 		trustees[i] = new(scheduler.NeffShuffle)
-		trustees[i].Init()
+		trustees[i].Init(config.CryptoSuite)
 		trustees[i].TrusteeView.Init(i, trusteesPrivKeys[i], trusteesPubKeys[i])
 	}
 	n.RelayView.AddClient(cs.EphemeralPublicKey)
@@ -672,7 +680,7 @@ func TestDisruptionClient(t *testing.T) {
 	if msg6.RoundID != int32(0) {
 		t.Error("Client sent a wrong RoundID")
 	}
-	if len(msg6.Data) != upCellSize+8 {
+	if len(msg6.Data) != upCellSize+dcnet.DCNET_CIPHER_HEADER_LENGTH {
 		t.Error("Client sent a payload with a wrong size")
 	}
 	if cs.RoundNo != int32(1) {
@@ -683,18 +691,18 @@ func TestDisruptionClient(t *testing.T) {
 	//set up the DC-nets
 
 	sharedSecrets_t1 := make([]kyber.Point, 1)
-	sharedSecrets_t1[0] = cs.sharedSecrets[0]
+	sharedSecrets_t1[0] = config.CryptoSuite.Point().Mul(trusteesPrivKeys[0], cs.PublicKey)
 	sharedSecrets_t2 := make([]kyber.Point, 1)
-	sharedSecrets_t2[0] = cs.sharedSecrets[1]
+	sharedSecrets_t2[0] = config.CryptoSuite.Point().Mul(trusteesPrivKeys[1], cs.PublicKey)
 
-	t1 := dcnet.NewDCNetEntity(1, dcnet.DCNET_TRUSTEE, upCellSize, true, sharedSecrets_t1)
-	t2 := dcnet.NewDCNetEntity(2, dcnet.DCNET_TRUSTEE, upCellSize, true, sharedSecrets_t2)
+	t1 := dcnet.NewDCNetEntity(config.CryptoSuite, 1, dcnet.DCNET_TRUSTEE, upCellSize, true, dcnet.PAD_CIPHER_XOF, sharedSecrets_t1)
+	t2 := dcnet.NewDCNetEntity(config.CryptoSuite, 2, dcnet.DCNET_TRUSTEE, upCellSize, true, dcnet.PAD_CIPHER_XOF, sharedSecrets_t2)
 
 	x := t1.TrusteeEncodeForRound(0)
 
-	pad1 := dcnet.DCNetCipherFromBytes(x)
-	pad2 := dcnet.DCNetCipherFromBytes(t2.TrusteeEncodeForRound(0))
-	clientPad := dcnet.DCNetCipherFromBytes(msg6.Data)
+	pad1, _ := dcnet.DCNetCipherFromBytes(x)
+	pad2, _ := dcnet.DCNetCipherFromBytes(t2.TrusteeEncodeForRound(0))
+	clientPad, _ := dcnet.DCNetCipherFromBytes(msg6.Data)
 
 	dcNetDecoded := make([]byte, upCellSize)
 	i = 0
@@ -727,9 +735,9 @@ func TestDisruptionClient(t *testing.T) {
 	sentToRelay = make([]interface{}, 0)
 
 	//dcnet.old decode
-	pad1 = dcnet.DCNetCipherFromBytes(t1.TrusteeEncodeForRound(1))
-	pad2 = dcnet.DCNetCipherFromBytes(t2.TrusteeEncodeForRound(1))
-	clientPad = dcnet.DCNetCipherFromBytes(msg8.Data)
+	pad1, _ = dcnet.DCNetCipherFromBytes(t1.TrusteeEncodeForRound(1))
+	pad2, _ = dcnet.DCNetCipherFromBytes(t2.TrusteeEncodeForRound(1))
+	clientPad, _ = dcnet.DCNetCipherFromBytes(msg8.Data)
 
 	dcNetDecoded = make([]byte, upCellSize)
 	i = 0
@@ -761,9 +769,9 @@ func TestDisruptionClient(t *testing.T) {
 	sentToRelay = make([]interface{}, 0)
 
 	//dcnet decode
-	pad1 = dcnet.DCNetCipherFromBytes(t1.TrusteeEncodeForRound(2))
-	pad2 = dcnet.DCNetCipherFromBytes(t2.TrusteeEncodeForRound(2))
-	clientPad = dcnet.DCNetCipherFromBytes(msg10.Data)
+	pad1, _ = dcnet.DCNetCipherFromBytes(t1.TrusteeEncodeForRound(2))
+	pad2, _ = dcnet.DCNetCipherFromBytes(t2.TrusteeEncodeForRound(2))
+	clientPad, _ = dcnet.DCNetCipherFromBytes(msg10.Data)
 	dcNetDecoded = make([]byte, upCellSize)
 	i = 0
 	for i < len(dcNetDecoded) {
@@ -778,3 +786,140 @@ func TestDisruptionClient(t *testing.T) {
 
 	t.SkipNow() //we started a goroutine, let's kill everything, we're good
 }
+
+func TestClientDownstreamEquivocation(t *testing.T) {
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	client := NewClient(true, true, make(chan []byte), make(chan []byte), false, "./", msw)
+	cs := client.clientState
+
+	trusteePub, trusteePriv := crypto.NewKeyPair(config.CryptoSuite)
+	cs.TrusteePublicKey = []kyber.Point{trusteePub}
+
+	// the evidence a trustee sends when the digest of client 1 is missing in round 5
+	evidence := net.TRU_REL_DOWNSTREAM_EQUIVOCATION{
+		TrusteeID: 0,
+		RoundID:   5,
+		ClientIDs: []int{0, 1},
+		Digests:   []net.ByteArray{{Bytes: dcnet.DownstreamDigest([]byte("downstream cell"))}, {Bytes: []byte{}}}}
+	signature, err := schnorr.Sign(config.CryptoSuite, trusteePriv, evidence.SignedData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	evidence.Signature = signature
+
+	if err := client.ReceivedMessage(evidence); err != nil {
+		t.Error("Client should accept the evidence signed by a trustee,", err)
+	}
+	if len(cs.downstreamEquivocations) != 1 || cs.downstreamEquivocations[0].RoundID != 5 {
+		t.Error("Client should have recorded the evidence")
+	}
+
+	// the relay cannot forge nor alter the evidence
+	forged := evidence
+	forged.RoundID = 6
+	if err := client.ReceivedMessage(forged); err == nil {
+		t.Error("Client should refuse an evidence with a wrong signature")
+	}
+	forged = evidence
+	forged.TrusteeID = 1
+	if err := client.ReceivedMessage(forged); err == nil {
+		t.Error("Client should refuse an evidence from an unknown trustee")
+	}
+	if len(cs.downstreamEquivocations) != 1 {
+		t.Error("Client should not record the evidence refused")
+	}
+}
+
+// signShuffle runs the Neff shuffle of "ephPks" with the trustees, and returns the slots signed by the trustees
+func signShuffle(ephPks []kyber.Point, trusteesPubKeys []kyber.Point, trusteesPrivKeys []kyber.Scalar) *net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG {
+	n := new(scheduler.NeffShuffle)
+	n.Init(config.CryptoSuite)
+	n.RelayView.Init(len(trusteesPubKeys))
+	trustees := make([]*scheduler.NeffShuffle, len(trusteesPubKeys))
+	for i := range trustees {
+		trustees[i] = new(scheduler.NeffShuffle)
+		trustees[i].Init(config.CryptoSuite)
+		trustees[i].TrusteeView.Init(i, trusteesPrivKeys[i], trusteesPubKeys[i])
+	}
+	for _, ephPk := range ephPks {
+		n.RelayView.AddClient(ephPk)
+	}
+	for i, isDone := 0, false; !isDone; i++ {
+		toSend, _, _ := n.RelayView.SendToNextTrustee()
+		parsed := toSend.(*net.REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE)
+		toSend2, _ := trustees[i].TrusteeView.ReceivedShuffleFromRelay(parsed.Base, parsed.EphPks, false, make([]byte, 1))
+		parsed2 := toSend2.(*net.TRU_REL_TELL_NEW_BASE_AND_EPH_PKS)
+		isDone, _ = n.RelayView.ReceivedShuffleFromTrustee(parsed2.NewBase, parsed2.NewEphPks, parsed2.Proof)
+	}
+	toSend3, _ := n.RelayView.SendTranscript()
+	parsed3 := toSend3.(*net.REL_TRU_TELL_TRANSCRIPT)
+	for j := range trustees {
+		toSend4, _ := trustees[j].TrusteeView.ReceivedTranscriptFromRelay(parsed3.Bases, parsed3.GetKeys(), parsed3.GetProofs())
+		parsed4 := toSend4.(*net.TRU_REL_SHUFFLE_SIG)
+		n.RelayView.ReceivedSignatureFromTrustee(parsed4.TrusteeID, parsed4.Sig)
+	}
+	toSend5, _ := n.RelayView.VerifySigsAndSendToClients(trusteesPubKeys)
+	return toSend5.(*net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG)
+}
+
+func TestClientReshuffledSlotsLate(t *testing.T) {
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	sentToRelay = make([]interface{}, 0)
+	client := NewClient(false, false, make(chan []byte), make(chan []byte), false, "./", msw)
+	cs := client.clientState
+
+	nTrustees := 2
+	trusteesPubKeys := make([]kyber.Point, nTrustees)
+	trusteesPrivKeys := make([]kyber.Scalar, nTrustees)
+	for i := 0; i < nTrustees; i++ {
+		trusteesPubKeys[i], trusteesPrivKeys[i] = crypto.NewKeyPair(config.CryptoSuite)
+	}
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.ForceParams = true
+	msg.Add("NClients", 1)
+	msg.Add("NTrustees", nTrustees)
+	msg.Add("PayloadSize", 100)
+	msg.Add("NextFreeClientID", 0)
+	msg.Add("DCNetType", "Simple")
+	msg.TrusteesPks = trusteesPubKeys
+	if err := client.ReceivedMessage(*msg); err != nil {
+		t.Fatal("Client should be able to receive this message:", err)
+	}
+	if err := client.ReceivedMessage(*signShuffle([]kyber.Point{cs.EphemeralPublicKey}, trusteesPubKeys, trusteesPrivKeys)); err != nil {
+		t.Fatal("Client should be able to receive the slots:", err)
+	}
+
+	// a re-shuffle starts, the relay opens round 1 with the current slots
+	if err := client.ReceivedMessage(net.REL_CLI_ASK_EPH_PK{}); err != nil {
+		t.Fatal(err)
+	}
+	nextEphPk := cs.nextEphemeralPublicKey
+	if err := client.ReceivedMessage(net.REL_CLI_DOWNSTREAM_DATA{RoundID: 1, Data: []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+	sentToRelay = make([]interface{}, 0)
+
+	// the downstream data of round 2 uses the re-shuffled slots, and arrives before them
+	if err := client.ReceivedMessage(net.REL_CLI_DOWNSTREAM_DATA{RoundID: 2, Data: []byte{1}, SlotsStartRoundID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sentToRelay) != 0 || cs.RoundNo != 2 {
+		t.Fatal("Client should wait for the re-shuffled slots before answering round 2")
+	}
+
+	reshuffled := signShuffle([]kyber.Point{nextEphPk}, trusteesPubKeys, trusteesPrivKeys)
+	reshuffled.StartRoundID = 2
+	if err := client.ReceivedMessage(*reshuffled); err != nil {
+		t.Fatal("Client should be able to receive the re-shuffled slots:", err)
+	}
+	if len(sentToRelay) != 1 || sentToRelay[0].(*net.CLI_REL_UPSTREAM_DATA).RoundID != 2 {
+		t.Fatal("Client should answer round 2 once it has the re-shuffled slots, sent", sentToRelay)
+	}
+	if !cs.EphemeralPublicKey.Equal(nextEphPk) || cs.RoundNo != 3 {
+		t.Error("Client should use the re-shuffled slots from round 2")
+	}
+}
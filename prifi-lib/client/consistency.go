@@ -0,0 +1,72 @@
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/dcnet"
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+computeDownstreamDigest computes the digest of the downstream cell "msg", and its tag for each trustee. They are sent
+with the upstream cell of the same round; the trustees check that all clients received the same downstream cell
+(see dcnet/consistency.go).
+*/
+func (p *PriFiLibClientInstance) computeDownstreamDigest(msg net.REL_CLI_DOWNSTREAM_DATA) {
+	cell, err := (&net.REL_CLI_DOWNSTREAM_DATA_UDP{REL_CLI_DOWNSTREAM_DATA: msg}).ToBytes()
+	if err != nil {
+		log.Error("Client " + strconv.Itoa(p.clientState.ID) + " : could not encode the downstream cell, " + err.Error())
+		p.clientState.downstreamDigest = nil
+		p.clientState.downstreamDigestTags = nil
+		return
+	}
+
+	digest := dcnet.DownstreamDigest(cell)
+	tags := make([]net.ByteArray, len(p.clientState.downstreamDigestKeys))
+	for j, key := range p.clientState.downstreamDigestKeys {
+		tags[j] = net.ByteArray{Bytes: dcnet.DownstreamDigestTag(key, msg.RoundID, digest)}
+	}
+
+	p.clientState.downstreamDigest = digest
+	p.clientState.downstreamDigestTags = tags
+}
+
+/*
+Received_TRU_REL_DOWNSTREAM_EQUIVOCATION handles TRU_REL_DOWNSTREAM_EQUIVOCATION messages, sent by a trustee which
+found that the clients did not receive the same downstream cell, or that the relay did not forward all their digests.
+The trustee sends the evidence to us too, since the relay might be the one that equivocated : we check the signature of
+the trustee, and report the evidence.
+*/
+func (p *PriFiLibClientInstance) Received_TRU_REL_DOWNSTREAM_EQUIVOCATION(msg net.TRU_REL_DOWNSTREAM_EQUIVOCATION) error {
+	if msg.TrusteeID < 0 || msg.TrusteeID >= len(p.clientState.TrusteePublicKey) || len(msg.Digests) != len(msg.ClientIDs) {
+		e := "Client " + strconv.Itoa(p.clientState.ID) + " : malformed TRU_REL_DOWNSTREAM_EQUIVOCATION from trustee " + strconv.Itoa(msg.TrusteeID)
+		log.Error(e)
+		return errors.New(e)
+	}
+	trusteePk := p.clientState.TrusteePublicKey[msg.TrusteeID]
+	if trusteePk == nil {
+		e := "Client " + strconv.Itoa(p.clientState.ID) + " : no public key for trustee " + strconv.Itoa(msg.TrusteeID)
+		log.Error(e)
+		return errors.New(e)
+	}
+	if err := schnorr.Verify(p.clientState.suite, trusteePk, msg.SignedData(), msg.Signature); err != nil {
+		e := "Client " + strconv.Itoa(p.clientState.ID) + " : wrong signature on the TRU_REL_DOWNSTREAM_EQUIVOCATION of trustee " +
+			strconv.Itoa(msg.TrusteeID) + ", " + err.Error()
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	evidence := ""
+	for i, clientID := range msg.ClientIDs {
+		evidence += " client " + strconv.Itoa(clientID) + " received " + hex.EncodeToString(msg.Digests[i].Bytes) + ";"
+	}
+	log.Error("Client", p.clientState.ID, ": trustee", msg.TrusteeID, "reports that the clients did not receive the same downstream cell in round",
+		msg.RoundID, ":"+evidence)
+	p.clientState.downstreamEquivocations = append(p.clientState.downstreamEquivocations, msg)
+
+	return nil
+}
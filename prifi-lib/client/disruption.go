@@ -2,8 +2,8 @@ package client
 
 import (
 	"bytes"
-	"fmt"
-	"github.com/dedis/prifi/prifi-lib/config"
+	"errors"
+	"github.com/dedis/prifi/prifi-lib/crypto"
 	"github.com/dedis/prifi/prifi-lib/net"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/proof"
@@ -25,7 +25,7 @@ func (p *PriFiLibClientInstance) Received_REL_ALL_DISRUPTION_REVEAL(msg net.REL_
 	var pred_array []proof.Predicate
 	sval := make(map[string]kyber.Scalar)
 	pval := make(map[string]kyber.Point)
-	suite := config.CryptoSuite
+	suite := p.clientState.suite
 	B := suite.Point().Base()
 	pval["B"] = B
 	for i, prg := range PRGs {
@@ -66,56 +66,29 @@ func (p *PriFiLibClientInstance) Received_REL_ALL_DISRUPTION_REVEAL(msg net.REL_
 
 /*
 * Received_REL_ALL_REVEAL_SHARED_SECRETS handles REL_ALL_REVEAL_SHARED_SECRETS messages.
-* The method gets the shared secret and sends it to the relay.
+* The method recomputes the secret shared with the trustee, from which the pads of every epoch are derived, and sends
+* it to the relay with a proof that it is correct, bound to the disrupted round.
  */
 func (p *PriFiLibClientInstance) Received_REL_ALL_REVEAL_SHARED_SECRETS(msg net.REL_ALL_REVEAL_SHARED_SECRETS) error {
-	log.Lvl1("Disruption Phase 2: Received a reveal secret message for trustee", msg.EntityID)
-	// TODO: NIZK
+	log.Lvl1("Disruption Phase 2: Received a reveal secret message for trustee", msg.EntityID, "round", msg.RoundID)
 	// TODO: check that the relay asks for the correct entity, and not a honest entity. There should be a signature check on the TRU_REL_DISRUPTION_REVEAL the relay received (and forwarded to the client)
-	secret := p.clientState.sharedSecrets[msg.EntityID]
-
-	// as a pseudorandom base point multiplied by our private key.
-	suite := config.CryptoSuite
-	X := make([]kyber.Point, 1)
-	X[0] = p.clientState.PublicKey
-	B := suite.Point().Base() //BACK
-	// Generate the proof predicate: an OR branch for each public key.
-	sec := map[string]kyber.Scalar{"x": p.clientState.privateKey} //BACK
-	pub := map[string]kyber.Point{"B": B, "BT": p.clientState.TrusteePublicKey[msg.EntityID], "T": p.clientState.sharedSecrets[msg.EntityID]}
-	preds := make([]proof.Predicate, len(X))
-	for i := range X {
-		name := fmt.Sprintf("X[%d]", i) // "X[0]","X[1]",...
-		pub[name] = X[i]                // public point value
-
-		// Predicate indicates knowledge of the private key for X[i]
-		// and correspondence of the key with the linkage tag
-		preds[i] = proof.And(proof.Rep(name, "x", "B"), proof.Rep("T", "x", "BT"))
+	if msg.EntityID < 0 || msg.EntityID >= len(p.clientState.TrusteePublicKey) {
+		return errors.New("Client " + strconv.Itoa(p.clientState.ID) + " : cannot reveal the secret shared with unknown trustee " +
+			strconv.Itoa(msg.EntityID))
 	}
-	pred := proof.Or(preds...) // make a big Or predicate
 
-	// The prover needs to know which Or branch (mine) is actually true.
-	choice := make(map[proof.Predicate]int)
-	choice[pred] = 0
-
-	// Generate the signature
-	M := "SHAREDKEY"
-	prover := pred.Prover(suite, sec, pub, choice)
-	NIZK, _ := proof.HashProve(suite, M, prover)
-
-	// Verify the signature
-	verifier := pred.Verifier(suite, pub)
-	err := proof.HashVerify(suite, M, verifier, NIZK)
+	secret, NIZK, err := crypto.ProveSharedSecret(p.clientState.suite, p.clientState.privateKey,
+		p.clientState.TrusteePublicKey[msg.EntityID], msg.RoundID, p.clientState.ID, msg.EntityID)
 	if err != nil {
-		log.Lvl1("signature failed to verify: ", err)
+		return errors.New("Client " + strconv.Itoa(p.clientState.ID) + " : cannot prove the shared secret, " + err.Error())
 	}
-	log.Lvl1("Linkable Ring Signature verified.")
 
 	toSend := &net.CLI_REL_SHARED_SECRET{
 		ClientID:  p.clientState.ID,
 		TrusteeID: msg.EntityID,
+		RoundID:   msg.RoundID,
 		Secret:    secret,
-		NIZK:      make([]byte, 0),
-		Pub:       pub,
+		NIZK:      NIZK,
 	}
 
 	if p.clientState.ForceDisruptionSinceRound3 && p.clientState.ID == 0 {
@@ -125,8 +98,8 @@ func (p *PriFiLibClientInstance) Received_REL_ALL_REVEAL_SHARED_SECRETS(msg net.
 		// this is just to let the honest trustee answer and see what happens
 	}
 
-	p.messageSender.SendToRelayWithLog(toSend, "Sent secret to relay")
-	log.Lvl1("Reveling secret with trustee", msg.EntityID)
+	p.messageSender.SendToRelayWithLog(toSend, "Sent shared secret to relay")
+	log.Lvl1("Reveling the secret shared with trustee", msg.EntityID)
 	return nil
 }
 
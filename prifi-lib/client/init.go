@@ -10,8 +10,10 @@ package client
  * - ALL_ALL_SHUTDOWN - kill this client
  * - ALL_ALL_PARAMETERS (specialized into ALL_CLI_PARAMETERS) - used to initialize the client over the network / overwrite its configuration
  * - REL_CLI_TELL_TRUSTEES_PK - the trustee's identities. We react by sending our identity + ephemeral identity
+ * - REL_TRU_TELL_TRANSCRIPT - the transcript of the shuffle, if we verify it ourselves. We keep it until we receive the shuffle.
  * - REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG - the shuffle from the trustees. We do some check, if they pass, we can communicate. We send the first round to the relay.
  * - REL_CLI_DOWNSTREAM_DATA - the data from the relay, for one round. We react by finishing the round (sending our data to the relay)
+ * - REL_CLI_DOWNSTREAM_DATA_UDP - the same, broadcast; if we missed some rounds, we ask the relay for them, see retransmissions.go
  *
  * local functions :
  *
@@ -23,12 +25,15 @@ package client
 
 import (
 	"errors"
+	"github.com/dedis/prifi/prifi-lib/config"
 	"github.com/dedis/prifi/prifi-lib/crypto"
 	"github.com/dedis/prifi/prifi-lib/dcnet"
 	prifilog "github.com/dedis/prifi/prifi-lib/log"
 	"github.com/dedis/prifi/prifi-lib/net"
+	"github.com/dedis/prifi/prifi-lib/scheduler"
 	"github.com/dedis/prifi/prifi-lib/utils"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/onet/v3/log"
 	"reflect"
 	"strings"
@@ -59,7 +64,6 @@ type ClientState struct {
 	PayloadSize                   int
 	privateKey                    kyber.Scalar
 	PublicKey                     kyber.Point
-	sharedSecrets                 []kyber.Point
 	TrusteePublicKey              []kyber.Point
 	UseSocksProxy                 bool
 	UseUDP                        bool
@@ -70,7 +74,35 @@ type ClientState struct {
 	DisruptionProtectionEnabled   bool
 	LastWantToSend                time.Time
 	EquivocationProtectionEnabled bool
+	VerifiableDCNetEnabled        bool
+	PadCipher                     string
+	CryptoSuite                   string // see config.CRYPTO_SUITE_*
+	SlotScheduler                 string // see scheduler.SLOT_SCHEDULER_*
+	slotScheduler                 scheduler.SlotScheduler_Client
+	MaxSlotsPerClient             int // the most slots we can reserve in one schedule
+	suite                         suites.Suite
 	EphemeralPublicKeys           []kyber.Point
+	VerifyShuffleTranscript       bool                         // we verify every proof of the shuffles, not only the signatures of the trustees
+	shuffleTranscript             *net.REL_TRU_TELL_TRANSCRIPT // the transcript of the shuffle running, until its signatures
+
+	//variable-length slots, see slots.go
+	VariableSlotLengths bool // we ask for the length of our next slot when we own one
+	MaxPayloadSize      int  // the longest slot we can ask for
+
+	//re-shuffles, see reshuffle.go
+	nextEphemeralPrivateKey kyber.Scalar // the fresh ephemeral key given to the re-shuffle running
+	nextEphemeralPublicKey  kyber.Point
+	reshuffledSlots         *net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG // the result of the re-shuffle, until its first round
+	reshuffledSlot          int                                        // our slot in it
+	slotsReceivedStart      int32                                      // the first round of the last slots received
+
+	//downstream consistency check, see consistency.go
+	DownstreamConsistencyCheck bool
+	downstreamDigestKeys       [][]byte                              // the keys of the tags, one per trustee
+	downstreamDigest           []byte                                // the digest of the last downstream cell received
+	downstreamDigestTags       []net.ByteArray                       // its tag for each trustee
+	downstreamEquivocations    []net.TRU_REL_DOWNSTREAM_EQUIVOCATION // the evidence reported by the trustees
+
 	// TEST DISRUPTION
 	ForceDisruptionSinceRound3 bool
 	AllreadyDisrupted          bool
@@ -78,6 +110,10 @@ type ClientState struct {
 	//concurrent stuff
 	RoundNo           int32
 	BufferedRoundData map[int32]net.REL_CLI_DOWNSTREAM_DATA
+
+	//retransmissions of the downstream data missed on UDP, see retransmissions.go
+	WindowSize      int   // the most rounds the relay opens at once
+	lastNackedRound int32 // the last round we asked for
 }
 
 // PCAPReplayer handles the data needed to replay some .pcap file
@@ -103,7 +139,12 @@ func NewClient(doLatencyTest bool, dataOutputEnabled bool, dataForDCNet chan []b
 	clientState := new(ClientState)
 
 	//instantiates the static stuff
-	clientState.PublicKey, clientState.privateKey = crypto.NewKeyPair()
+	clientState.CryptoSuite = config.DefaultCryptoSuiteName
+	clientState.suite = config.CryptoSuite
+	clientState.PublicKey, clientState.privateKey = crypto.NewKeyPair(clientState.suite)
+	clientState.SlotScheduler = scheduler.SLOT_SCHEDULER_BITMASK
+	clientState.slotScheduler = new(scheduler.BitMaskSlotScheduler_Client)
+	clientState.MaxSlotsPerClient = 1
 	//clientState.StartStopReceiveBroadcast = make(chan bool) //this should stay nil, !=nil -> we have a listener goroutine active
 	clientState.LatencyTest = &prifilog.LatencyTests{
 		DoLatencyTests:       doLatencyTest,
@@ -172,9 +213,20 @@ func (p *PriFiLibClientInstance) ReceivedMessage(msg interface{}) error {
 			err = p.Received_REL_CLI_UDP_DOWNSTREAM_DATA(typedMsg)
 		}
 	case net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG:
-		if p.stateMachine.AssertState("EPH_KEYS_SENT") {
+		if p.stateMachine.State() == "READY" {
+			// the result of a re-shuffle
+			err = p.receivedReshuffledSlots(typedMsg)
+		} else if p.stateMachine.AssertState("EPH_KEYS_SENT") {
 			err = p.Received_REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG(typedMsg)
 		}
+	case net.REL_TRU_TELL_TRANSCRIPT:
+		if p.stateMachine.State() == "READY" || p.stateMachine.AssertState("EPH_KEYS_SENT") {
+			err = p.Received_REL_TRU_TELL_TRANSCRIPT(typedMsg)
+		}
+	case net.REL_CLI_ASK_EPH_PK:
+		if p.stateMachine.AssertState("READY") {
+			err = p.Received_REL_CLI_ASK_EPH_PK(typedMsg)
+		}
 	case net.REL_ALL_DISRUPTION_REVEAL:
 		if p.stateMachine.AssertState("READY") {
 			err = p.Received_REL_ALL_DISRUPTION_REVEAL(typedMsg)
@@ -183,6 +235,8 @@ func (p *PriFiLibClientInstance) ReceivedMessage(msg interface{}) error {
 		if p.stateMachine.AssertState("READY") {
 			err = p.Received_REL_ALL_REVEAL_SHARED_SECRETS(typedMsg)
 		}
+	case net.TRU_REL_DOWNSTREAM_EQUIVOCATION:
+		err = p.Received_TRU_REL_DOWNSTREAM_EQUIVOCATION(typedMsg)
 	default:
 		err = errors.New("Unrecognized message, type" + reflect.TypeOf(msg).String())
 	}
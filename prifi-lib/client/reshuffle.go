@@ -0,0 +1,113 @@
+package client
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/crypto"
+	"github.com/dedis/prifi/prifi-lib/net"
+	"github.com/dedis/prifi/prifi-lib/scheduler"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Re-shuffles (see relay/reshuffle.go). While we keep communicating with our current slot, we give the relay a fresh
+ephemeral key to shuffle. We check the signed shuffle and find our new slot as soon as we receive it, but only use the
+new slot, and the new ephemeral key, from the round StartRoundID on, like all the other clients.
+The downstream data of each round tells the first round of the slots in use. With UDP, the downstream data of the
+round StartRoundID may arrive before the slots, which are sent over TCP : we hold this round until they arrive.
+*/
+
+/*
+Received_REL_CLI_ASK_EPH_PK handles REL_CLI_ASK_EPH_PK messages, sent when the relay starts a re-shuffle. We answer with
+a fresh ephemeral key; the current one stays in use until the re-shuffled slots start.
+*/
+func (p *PriFiLibClientInstance) Received_REL_CLI_ASK_EPH_PK(msg net.REL_CLI_ASK_EPH_PK) error {
+	p.clientState.nextEphemeralPublicKey, p.clientState.nextEphemeralPrivateKey = crypto.NewKeyPair(p.clientState.suite)
+	p.clientState.reshuffledSlots = nil
+
+	toSend := &net.CLI_REL_TELL_PK_AND_EPH_PK{
+		ClientID: p.clientState.ID,
+		Pk:       p.clientState.PublicKey,
+		EphPk:    p.clientState.nextEphemeralPublicKey,
+	}
+	p.messageSender.SendToRelayWithLog(toSend, "(re-shuffle)")
+	return nil
+}
+
+// receivedReshuffledSlots handles the REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG messages received while communicating, i.e.,
+// the result of a re-shuffle. If we can use them, we switch to our new slot at StartRoundID. Then, we process the round
+// which waited for those slots, if any
+func (p *PriFiLibClientInstance) receivedReshuffledSlots(msg net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG) error {
+	err := p.acceptReshuffledSlots(msg)
+	if msg.StartRoundID > p.clientState.slotsReceivedStart {
+		p.clientState.slotsReceivedStart = msg.StartRoundID
+	}
+
+	if data, held := p.clientState.BufferedRoundData[p.clientState.RoundNo]; held && data.SlotsStartRoundID <= p.clientState.slotsReceivedStart {
+		log.Lvl3("Client", p.clientState.ID, ": received the slots, processing round", p.clientState.RoundNo)
+		if errData := p.ProcessDownStreamData(data); err == nil {
+			err = errData
+		}
+	}
+	return err
+}
+
+// acceptReshuffledSlots checks the signatures of the trustees on the re-shuffled slots "msg", and finds our new slot,
+// used from StartRoundID on
+func (p *PriFiLibClientInstance) acceptReshuffledSlots(msg net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG) error {
+	if p.clientState.nextEphemeralPrivateKey == nil {
+		e := "Client " + strconv.Itoa(p.clientState.ID) + " : received re-shuffled slots, but we did not give a fresh ephemeral key"
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	if err := p.verifyShuffleTranscript(p.clientState.nextEphemeralPublicKey, msg); err != nil {
+		e := "Client " + strconv.Itoa(p.clientState.ID) + " : cannot use the re-shuffled slots, " + err.Error()
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	neff := new(scheduler.NeffShuffle)
+	neff.Init(p.clientState.suite)
+	slot, err := neff.ClientVerifySigAndRecognizeSlot(p.clientState.nextEphemeralPrivateKey, p.clientState.TrusteePublicKey, msg.Base, msg.EphPks, msg.GetSignatures())
+	if err != nil {
+		// we keep our current slot; the relay cannot tell, the other clients switch
+		e := "Client " + strconv.Itoa(p.clientState.ID) + " : cannot use the re-shuffled slots, " + err.Error()
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	p.clientState.reshuffledSlots = &msg
+	p.clientState.reshuffledSlot = slot
+	log.Lvl3("Client", p.clientState.ID, ": our slot will be", slot, "from round", msg.StartRoundID)
+	return nil
+}
+
+// waitForReshuffledSlots holds the downstream data "msg", which uses slots we did not receive yet, until they arrive
+func (p *PriFiLibClientInstance) waitForReshuffledSlots(msg net.REL_CLI_DOWNSTREAM_DATA) error {
+	log.Lvl2("Client", p.clientState.ID, ": round", msg.RoundID, "uses the slots starting at round", msg.SlotsStartRoundID,
+		", waiting for them")
+	p.clientState.BufferedRoundData[msg.RoundID] = msg
+	return nil
+}
+
+// switchToReshuffledSlot is called before processing the round "roundID". If the re-shuffled slots start at this round
+// or before, we switch to our new slot and ephemeral key
+func (p *PriFiLibClientInstance) switchToReshuffledSlot(roundID int32) {
+	reshuffled := p.clientState.reshuffledSlots
+	if reshuffled == nil || roundID < reshuffled.StartRoundID {
+		return
+	}
+
+	p.clientState.MySlot = p.clientState.reshuffledSlot
+	p.clientState.EphemeralPublicKeys = reshuffled.EphPks
+	p.clientState.nClients = len(reshuffled.EphPks) // some clients may join with the re-shuffle
+	p.clientState.EphemeralPublicKey = p.clientState.nextEphemeralPublicKey
+	p.clientState.ephemeralPrivateKey = p.clientState.nextEphemeralPrivateKey
+
+	p.clientState.reshuffledSlots = nil
+	p.clientState.nextEphemeralPublicKey = nil
+	p.clientState.nextEphemeralPrivateKey = nil
+	log.Lvl2("Client", p.clientState.ID, ": switching to slot", p.clientState.MySlot, "at round", roundID)
+}
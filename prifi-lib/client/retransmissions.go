@@ -0,0 +1,70 @@
+package client
+
+import (
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Retransmissions of the downstream data. With UseUDP, a broadcast we miss would make its round time out at the relay.
+When we receive the data of a round after the one we expect, we keep it, and ask the relay for the rounds in between
+with a CLI_REL_DOWNSTREAM_NACK; the relay sends them over TCP, and we process the rounds in order. If we miss the last
+round sent, e.g. with a window of one round, no later round shows the gap : the relay sends it again over TCP when we
+do not answer after a part of the round timeout.
+The relay opens at most WindowSize rounds at once : receiving round r means that the rounds before r-WindowSize+1 are
+closed, we skip them.
+*/
+
+// missedDownstreamData handles the downstream data "msg", received by UDP for a round after the one we expect
+func (p *PriFiLibClientInstance) missedDownstreamData(msg net.REL_CLI_DOWNSTREAM_DATA) error {
+	p.clientState.BufferedRoundData[msg.RoundID] = msg
+
+	oldestOpenRound := msg.RoundID - int32(p.clientState.WindowSize) + 1
+	if oldestOpenRound > p.clientState.RoundNo {
+		log.Lvl2("Client "+strconv.Itoa(p.clientState.ID)+" : rounds", p.clientState.RoundNo, "to", oldestOpenRound-1,
+			"are closed, skipping to round", oldestOpenRound)
+		for roundID := range p.clientState.BufferedRoundData {
+			if roundID < oldestOpenRound {
+				delete(p.clientState.BufferedRoundData, roundID)
+			}
+		}
+		p.clientState.RoundNo = oldestOpenRound
+		if data, found := p.clientState.BufferedRoundData[oldestOpenRound]; found {
+			return p.ProcessDownStreamData(data)
+		}
+	}
+
+	p.sendDownstreamNACK(msg.RoundID)
+	return nil
+}
+
+// sendDownstreamNACK asks the relay for the rounds from the one we expect to "roundID" (excluded), which we did not
+// receive nor ask for yet
+func (p *PriFiLibClientInstance) sendDownstreamNACK(roundID int32) {
+	first := p.clientState.RoundNo
+	if p.clientState.lastNackedRound >= first {
+		first = p.clientState.lastNackedRound + 1
+	}
+
+	missing := make([]int32, 0)
+	for r := first; r < roundID; r++ {
+		if _, found := p.clientState.BufferedRoundData[r]; !found {
+			missing = append(missing, r)
+		}
+	}
+	if roundID-1 > p.clientState.lastNackedRound {
+		p.clientState.lastNackedRound = roundID - 1
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	log.Lvl2("Client "+strconv.Itoa(p.clientState.ID)+" : missed the downstream data of rounds", missing, ", asking the relay")
+	toSend := &net.CLI_REL_DOWNSTREAM_NACK{
+		ClientID: p.clientState.ID,
+		RoundIDs: missing,
+	}
+	p.messageSender.SendToRelayWithLog(toSend, "(NACK of "+strconv.Itoa(len(missing))+" rounds)")
+}
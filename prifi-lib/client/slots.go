@@ -0,0 +1,53 @@
+package client
+
+import (
+	"github.com/dedis/prifi/prifi-lib/dcnet"
+)
+
+/*
+Variable-length slots (see dcnet/slots.go). The relay announces the length of each round in REL_CLI_DOWNSTREAM_DATA.
+When we own the slot, we ask, at the end of our payload, for the length of our next slot : long enough for the data we
+could not send, the longest possible if more data is waiting, and the shortest possible if we have nothing to say.
+*/
+
+// maxSlotRoom returns the most data that fits in one of our slots
+func (p *PriFiLibClientInstance) maxSlotRoom() int {
+	return p.clientState.MaxPayloadSize - dcnet.SlotLengthOverhead(p.clientState.DisruptionProtectionEnabled, p.clientState.EquivocationProtectionEnabled)
+}
+
+// fitInSlot returns "content" padded to "room" bytes. Data longer than the slot is kept for our next slot, which we ask
+// long enough; data that does not fit in any slot is split
+func (p *PriFiLibClientInstance) fitInSlot(content []byte, room int) []byte {
+	if len(content) > room {
+		rest := content
+		content = nil
+		if len(rest) > p.maxSlotRoom() {
+			content, rest = rest[:room], rest[room:]
+		}
+		p.clientState.NextDataForDCNet = &rest
+	}
+
+	out := make([]byte, room)
+	copy(out, content)
+	return out
+}
+
+// nextSlotLength returns the length to ask for our next slot
+func (p *PriFiLibClientInstance) nextSlotLength() int {
+	overhead := dcnet.SlotLengthOverhead(p.clientState.DisruptionProtectionEnabled, p.clientState.EquivocationProtectionEnabled)
+
+	switch {
+	case p.clientState.NextDataForDCNet != nil:
+		length := overhead + len(*p.clientState.NextDataForDCNet)
+		if length > p.clientState.MaxPayloadSize {
+			return p.clientState.MaxPayloadSize
+		}
+		return length
+	case len(p.clientState.DataForDCNet) > 0:
+		return p.clientState.MaxPayloadSize
+	case len(p.clientState.LatencyTest.LatencyTestsToSend) > 0 || p.clientState.pcapReplay.Enabled:
+		// those are sized for PayloadSize
+		return p.clientState.PayloadSize
+	}
+	return overhead
+}
@@ -4,8 +4,29 @@ Package config contains the cryptographic primitives that are used by the PriFi
 package config
 
 import (
+	"errors"
+
 	"go.dedis.ch/kyber/v3/suites"
 )
 
-// the suite used in the prifi-lib
-var CryptoSuite = suites.MustFind("Ed25519")
+// CRYPTO_SUITE_* are the names of the suites a PriFi instance can use, as found by suites.Find
+const (
+	CRYPTO_SUITE_ED25519 = "Ed25519"
+	CRYPTO_SUITE_P256    = "P256" // NIST P-256, variable-time
+)
+
+// DefaultCryptoSuiteName is the suite used when the parameters do not specify one
+const DefaultCryptoSuiteName = CRYPTO_SUITE_ED25519
+
+// CryptoSuite is the default suite of the prifi-lib. Each instance uses the suite given in its parameters,
+// and only falls back to this one before receiving them
+var CryptoSuite = suites.MustFind(DefaultCryptoSuiteName)
+
+// FindCryptoSuite returns the suite named "name", which must be one of CRYPTO_SUITE_*
+func FindCryptoSuite(name string) (suites.Suite, error) {
+	switch name {
+	case CRYPTO_SUITE_ED25519, CRYPTO_SUITE_P256:
+		return suites.Find(name)
+	}
+	return nil, errors.New("Unknown crypto suite " + name + ", should be " + CRYPTO_SUITE_ED25519 + " or " + CRYPTO_SUITE_P256)
+}
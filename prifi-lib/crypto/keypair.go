@@ -1,18 +1,18 @@
 package crypto
 
 import (
-	"github.com/dedis/prifi/prifi-lib/config"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
 )
 
 /**
- * creates a public, private key pair using the cryptosuite in config
+ * creates a public, private key pair in the given suite
  */
-func NewKeyPair() (kyber.Point, kyber.Scalar) {
+func NewKeyPair(suite suites.Suite) (kyber.Point, kyber.Scalar) {
 
-	base := config.CryptoSuite.Point().Base()
-	priv := config.CryptoSuite.Scalar().Pick(config.CryptoSuite.RandomStream())
-	pub := config.CryptoSuite.Point().Mul(priv, base)
+	base := suite.Point().Base()
+	priv := suite.Scalar().Pick(suite.RandomStream())
+	pub := suite.Point().Mul(priv, base)
 
 	return pub, priv
 }
@@ -1,19 +1,18 @@
 package crypto
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"math/big"
 
 	"errors"
-	"github.com/dedis/prifi/prifi-lib/config"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
 )
 
-// NeffShuffle implements Andrew Neff's verifiable shuffle proof scheme as described in the
-// paper "Verifiable Mixing (Shuffling) of ElGamal Pairs", April 2004.
-// The function randomly shuffles and re-randomizes a set of ElGamal pairs,
-// producing a correctness proof in the process.
-// Returns (Xbar,Ybar), the shuffled and randomized pairs.
-func NeffShuffle(publicKeys []kyber.Point, base kyber.Point, doShufflePositions bool) ([]kyber.Point, kyber.Point, kyber.Scalar, []byte, error) {
+// NeffShuffle multiplies the public keys and the base by a fresh secret coefficient, and randomly
+// shuffles the keys, producing a correctness proof in the process (see neff_proof.go, and VerifyNeffShuffle).
+// Returns the shuffled keys, the new base, the secret coefficient and the proof.
+func NeffShuffle(suite suites.Suite, publicKeys []kyber.Point, base kyber.Point, doShufflePositions bool) ([]kyber.Point, kyber.Point, kyber.Scalar, []byte, error) {
 
 	if base == nil {
 		return nil, nil, nil, nil, errors.New("Cannot perform a shuffle is base is nil")
@@ -24,7 +23,6 @@ func NeffShuffle(publicKeys []kyber.Point, base kyber.Point, doShufflePositions
 	if len(publicKeys) == 0 {
 		return nil, nil, nil, nil, errors.New("Cannot perform a shuffle is len(publicKeys) is 0")
 	}
-	suite := config.CryptoSuite
 
 	//compute new shares
 	secretCoeff := suite.Scalar().Pick(suite.RandomStream())
@@ -38,16 +36,44 @@ func NeffShuffle(publicKeys []kyber.Point, base kyber.Point, doShufflePositions
 	}
 
 	//shuffle the array
+	perm := make([]int, len(publicKeys2))
+	for i := range perm {
+		perm[i] = i
+	}
 	if doShufflePositions {
 		publicKeys3 := make([]kyber.Point, len(publicKeys2))
-		perm := rand.Perm(len(publicKeys2))
+		var err error
+		perm, err = randomPermutation(len(publicKeys2))
+		if err != nil {
+			return nil, nil, nil, nil, errors.New("Cannot draw the permutation, " + err.Error())
+		}
 		for i, v := range perm {
 			publicKeys3[v] = publicKeys2[i]
 		}
 		publicKeys2 = publicKeys3
 	}
 
-	proof := make([]byte, 50) // TODO : the proof should be done
+	proof, err := proveNeffShuffle(suite, publicKeys, base, publicKeys2, newBase, secretCoeff, perm)
+	if err != nil {
+		return nil, nil, nil, nil, errors.New("Cannot prove the shuffle, " + err.Error())
+	}
 
 	return publicKeys2, newBase, secretCoeff, proof, nil
 }
+
+// randomPermutation returns a uniformly random permutation of [0, n), drawn from crypto/rand with the Fisher-Yates
+// shuffle : the permutation hides which client gets which slot
+func randomPermutation(n int) ([]int, error) {
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+	for i := n - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return nil, err
+		}
+		perm[i], perm[j.Int64()] = perm[j.Int64()], perm[i]
+	}
+	return perm, nil
+}
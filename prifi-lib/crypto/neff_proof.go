@@ -0,0 +1,396 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
+)
+
+/*
+Proof of a shuffle of public keys. A shuffle takes the keys X[0..n-1] and the base B, picks a secret coefficient c and a
+permutation, and outputs the keys Y[perm[j]] = c * X[j] and the new base c * B. The proof follows the proof of a shuffle
+of B. Terelius and D. Wikstrom ("Proofs of Restricted Shuffles", AFRICACRYPT 2010, LNCS 6055, pp. 100-113), adapted
+to keys multiplied by a common secret instead of re-encrypted ciphertexts :
+1) the shuffler commits to the permutation with Pedersen commitments U[j] = r[j] * G + H[perm[j]], where the H are
+independent generators nobody knows the discrete logarithm of
+2) the challenge e[0..n-1] is derived from the statement and the commitments. The shuffler proves, in one
+non-interactive sigma protocol, that it knows an opening of sum(e[j] * U[j]) to the vector e', that the commitments
+open to a permutation matrix (each row sums to 1, and prod(e') = prod(e), checked with the chained commitments Chain),
+and that sum(e'[i] * Y[i]) = c * sum(e[j] * X[j]), with the same c as in the new base.
+The proof has O(n) points and scalars, and is checked with O(n) scalar multiplications.
+*/
+
+// the tags of the Fiat-Shamir hashes and of the generators, so that they cannot be mixed with other hashes
+const (
+	neffShuffleGeneratorsTag = "prifi-neff-shuffle-generators"
+	neffShuffleChallengeTag  = "prifi-neff-shuffle-challenge"
+)
+
+// neffShuffleProof holds the commitments and the responses of a proof of shuffle of n keys
+type neffShuffleProof struct {
+	U     []kyber.Point // commitments to the permutation
+	Chain []kyber.Point // chained commitments to the permuted challenges, for the product argument
+
+	// commitments of the sigma protocol
+	APrime     kyber.Point
+	ChainPrime []kyber.Point
+	CPrime     kyber.Point
+	DPrime     kyber.Point
+	BasePrime  kyber.Point
+	FPrime     kyber.Point
+
+	// responses of the sigma protocol
+	KA     kyber.Scalar
+	KE     []kyber.Scalar
+	KChain []kyber.Scalar
+	KC     kyber.Scalar
+	KD     kyber.Scalar
+	KCoeff kyber.Scalar
+}
+
+// points returns, in order, the points of the proof that are serialized
+func (p *neffShuffleProof) points() []kyber.Point {
+	points := append([]kyber.Point{}, p.U...)
+	points = append(points, p.Chain...)
+	points = append(points, p.APrime)
+	points = append(points, p.ChainPrime...)
+	return append(points, p.CPrime, p.DPrime, p.BasePrime, p.FPrime)
+}
+
+// scalars returns, in order, the scalars of the proof that are serialized
+func (p *neffShuffleProof) scalars() []kyber.Scalar {
+	scalars := []kyber.Scalar{p.KA}
+	scalars = append(scalars, p.KE...)
+	scalars = append(scalars, p.KChain...)
+	return append(scalars, p.KC, p.KD, p.KCoeff)
+}
+
+// newNeffShuffleProof allocates an empty proof of shuffle of n keys
+func newNeffShuffleProof(suite suites.Suite, n int) *neffShuffleProof {
+	p := &neffShuffleProof{
+		U:          make([]kyber.Point, n),
+		Chain:      make([]kyber.Point, n),
+		APrime:     suite.Point(),
+		ChainPrime: make([]kyber.Point, n),
+		CPrime:     suite.Point(),
+		DPrime:     suite.Point(),
+		BasePrime:  suite.Point(),
+		FPrime:     suite.Point(),
+		KA:         suite.Scalar(),
+		KE:         make([]kyber.Scalar, n),
+		KChain:     make([]kyber.Scalar, n),
+		KC:         suite.Scalar(),
+		KD:         suite.Scalar(),
+		KCoeff:     suite.Scalar(),
+	}
+	for i := 0; i < n; i++ {
+		p.U[i] = suite.Point()
+		p.Chain[i] = suite.Point()
+		p.ChainPrime[i] = suite.Point()
+		p.KE[i] = suite.Scalar()
+		p.KChain[i] = suite.Scalar()
+	}
+	return p
+}
+
+// marshal serializes the proof; its length only depends on the number of keys
+func (p *neffShuffleProof) marshal() ([]byte, error) {
+	var b bytes.Buffer
+	if err := writePoints(&b, p.points()...); err != nil {
+		return nil, err
+	}
+	for _, s := range p.scalars() {
+		if _, err := s.MarshalTo(&b); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// unmarshalNeffShuffleProof parses the proof of shuffle of n keys in "data"
+func unmarshalNeffShuffleProof(suite suites.Suite, n int, data []byte) (*neffShuffleProof, error) {
+	p := newNeffShuffleProof(suite, n)
+	r := bytes.NewReader(data)
+	for _, point := range p.points() {
+		if _, err := point.UnmarshalFrom(r); err != nil {
+			return nil, errors.New("cannot read a point of the proof, " + err.Error())
+		}
+	}
+	for _, s := range p.scalars() {
+		if _, err := s.UnmarshalFrom(r); err != nil {
+			return nil, errors.New("cannot read a scalar of the proof, " + err.Error())
+		}
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("the proof has " + strconv.Itoa(r.Len()) + " trailing bytes")
+	}
+	return p, nil
+}
+
+// writePoints writes the binary encoding of the points to w
+func writePoints(w io.Writer, points ...kyber.Point) error {
+	for _, point := range points {
+		if _, err := point.MarshalTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// neffShuffleGenerators returns the n independent generators of the permutation commitments. They are picked from a
+// public seed, hence are the same for everyone, but nobody knows their discrete logarithms
+func neffShuffleGenerators(suite suites.Suite, n int) []kyber.Point {
+	xof := suite.XOF([]byte(neffShuffleGeneratorsTag))
+	generators := make([]kyber.Point, n)
+	for i := range generators {
+		generators[i] = suite.Point().Pick(xof)
+	}
+	return generators
+}
+
+// neffShuffleStatement returns the bytes hashed for the first challenge : the shuffle and the permutation commitments
+func neffShuffleStatement(publicKeys []kyber.Point, base kyber.Point, shuffledKeys []kyber.Point, newBase kyber.Point, u []kyber.Point) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(neffShuffleChallengeTag)
+	b.WriteString(strconv.Itoa(len(publicKeys)))
+	if err := writePoints(&b, base, newBase); err != nil {
+		return nil, err
+	}
+	if err := writePoints(&b, publicKeys...); err != nil {
+		return nil, err
+	}
+	if err := writePoints(&b, shuffledKeys...); err != nil {
+		return nil, err
+	}
+	if err := writePoints(&b, u...); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// neffShuffleChallenges derives the challenges e[0..n-1] from the statement
+func neffShuffleChallenges(suite suites.Suite, statement []byte, n int) []kyber.Scalar {
+	xof := suite.XOF(statement)
+	e := make([]kyber.Scalar, n)
+	for i := range e {
+		e[i] = suite.Scalar().Pick(xof)
+	}
+	return e
+}
+
+// neffShuffleSigmaChallenge derives the challenge of the sigma protocol from the statement and all the commitments
+func neffShuffleSigmaChallenge(suite suites.Suite, statement []byte, p *neffShuffleProof) (kyber.Scalar, error) {
+	b := bytes.NewBuffer(append([]byte{}, statement...))
+	if err := writePoints(b, p.Chain...); err != nil {
+		return nil, err
+	}
+	if err := writePoints(b, p.APrime, p.CPrime, p.DPrime, p.BasePrime, p.FPrime); err != nil {
+		return nil, err
+	}
+	if err := writePoints(b, p.ChainPrime...); err != nil {
+		return nil, err
+	}
+	return suite.Scalar().Pick(suite.XOF(b.Bytes())), nil
+}
+
+// linearCombination returns sum(coeffs[i] * points[i])
+func linearCombination(suite suites.Suite, coeffs []kyber.Scalar, points []kyber.Point) kyber.Point {
+	sum := suite.Point().Null()
+	tmp := suite.Point()
+	for i := range points {
+		sum.Add(sum, tmp.Mul(coeffs[i], points[i]))
+	}
+	return sum
+}
+
+// productOf returns prod(scalars)
+func productOf(suite suites.Suite, scalars []kyber.Scalar) kyber.Scalar {
+	product := suite.Scalar().One()
+	for _, s := range scalars {
+		product.Mul(product, s)
+	}
+	return product
+}
+
+// proveNeffShuffle returns the proof that shuffledKeys[perm[j]] = secretCoeff * publicKeys[j], and
+// newBase = secretCoeff * base
+func proveNeffShuffle(suite suites.Suite, publicKeys []kyber.Point, base kyber.Point, shuffledKeys []kyber.Point,
+	newBase kyber.Point, secretCoeff kyber.Scalar, perm []int) ([]byte, error) {
+
+	n := len(publicKeys)
+	rand := suite.RandomStream()
+	g := suite.Point().Base()
+	h := neffShuffleGenerators(suite, n)
+	p := newNeffShuffleProof(suite, n)
+
+	// commit to the permutation
+	r := make([]kyber.Scalar, n)
+	for j := 0; j < n; j++ {
+		r[j] = suite.Scalar().Pick(rand)
+		p.U[j].Mul(r[j], g).Add(p.U[j], h[perm[j]])
+	}
+
+	statement, err := neffShuffleStatement(publicKeys, base, shuffledKeys, newBase, p.U)
+	if err != nil {
+		return nil, err
+	}
+	e := neffShuffleChallenges(suite, statement, n)
+
+	// the permuted challenges, the openings of the commitments and the chained commitments
+	ePerm := make([]kyber.Scalar, n)
+	a := suite.Scalar().Zero()
+	c := suite.Scalar().Zero()
+	for j := 0; j < n; j++ {
+		ePerm[perm[j]] = e[j]
+		a.Add(a, suite.Scalar().Mul(r[j], e[j]))
+		c.Add(c, r[j])
+	}
+	chainRandomness := make([]kyber.Scalar, n)
+	d := suite.Scalar().Zero()
+	previous := h[0]
+	for i := 0; i < n; i++ {
+		chainRandomness[i] = suite.Scalar().Pick(rand)
+		p.Chain[i].Mul(chainRandomness[i], g).Add(p.Chain[i], suite.Point().Mul(ePerm[i], previous))
+		d.Mul(d, ePerm[i]).Add(d, chainRandomness[i])
+		previous = p.Chain[i]
+	}
+	f := linearCombination(suite, e, publicKeys)
+
+	// commitments of the sigma protocol
+	omegaA := suite.Scalar().Pick(rand)
+	omegaE := make([]kyber.Scalar, n)
+	omegaChain := make([]kyber.Scalar, n)
+	for i := 0; i < n; i++ {
+		omegaE[i] = suite.Scalar().Pick(rand)
+		omegaChain[i] = suite.Scalar().Pick(rand)
+	}
+	omegaC := suite.Scalar().Pick(rand)
+	omegaD := suite.Scalar().Pick(rand)
+	omegaCoeff := suite.Scalar().Pick(rand)
+
+	p.APrime.Mul(omegaA, g).Add(p.APrime, linearCombination(suite, omegaE, h))
+	previous = h[0]
+	for i := 0; i < n; i++ {
+		p.ChainPrime[i].Mul(omegaChain[i], g).Add(p.ChainPrime[i], suite.Point().Mul(omegaE[i], previous))
+		previous = p.Chain[i]
+	}
+	p.CPrime.Mul(omegaC, g)
+	p.DPrime.Mul(omegaD, g)
+	p.BasePrime.Mul(omegaCoeff, base)
+	p.FPrime.Sub(linearCombination(suite, omegaE, shuffledKeys), suite.Point().Mul(omegaCoeff, f))
+
+	// responses
+	v, err := neffShuffleSigmaChallenge(suite, statement, p)
+	if err != nil {
+		return nil, err
+	}
+	respond := func(response, omega, secret kyber.Scalar) {
+		response.Mul(v, secret).Add(response, omega)
+	}
+	respond(p.KA, omegaA, a)
+	for i := 0; i < n; i++ {
+		respond(p.KE[i], omegaE[i], ePerm[i])
+		respond(p.KChain[i], omegaChain[i], chainRandomness[i])
+	}
+	respond(p.KC, omegaC, c)
+	respond(p.KD, omegaD, d)
+	respond(p.KCoeff, omegaCoeff, secretCoeff)
+
+	return p.marshal()
+}
+
+// VerifyNeffShuffle checks the proof of a shuffle made by NeffShuffle, i.e., that shuffledKeys are the keys publicKeys,
+// permuted and multiplied by the secret coefficient that transformed base into newBase. Returns nil iff the proof is valid
+func VerifyNeffShuffle(suite suites.Suite, publicKeys []kyber.Point, base kyber.Point, shuffledKeys []kyber.Point, newBase kyber.Point, proof []byte) error {
+
+	n := len(publicKeys)
+	if n == 0 {
+		return errors.New("Cannot verify a shuffle of 0 keys")
+	}
+	if len(shuffledKeys) != n {
+		return errors.New("Cannot verify a shuffle of " + strconv.Itoa(n) + " keys into " + strconv.Itoa(len(shuffledKeys)) + " keys")
+	}
+	if base == nil || newBase == nil {
+		return errors.New("Cannot verify a shuffle without its bases")
+	}
+	for i := 0; i < n; i++ {
+		if publicKeys[i] == nil || shuffledKeys[i] == nil {
+			return errors.New("Cannot verify a shuffle with a nil key")
+		}
+	}
+
+	p, err := unmarshalNeffShuffleProof(suite, n, proof)
+	if err != nil {
+		return err
+	}
+	g := suite.Point().Base()
+	h := neffShuffleGenerators(suite, n)
+
+	statement, err := neffShuffleStatement(publicKeys, base, shuffledKeys, newBase, p.U)
+	if err != nil {
+		return err
+	}
+	e := neffShuffleChallenges(suite, statement, n)
+	v, err := neffShuffleSigmaChallenge(suite, statement, p)
+	if err != nil {
+		return err
+	}
+
+	// checks that v * commitment + commitmentPrime = expected
+	check := func(name string, commitment, commitmentPrime, expected kyber.Point) error {
+		left := suite.Point().Mul(v, commitment)
+		if !left.Add(left, commitmentPrime).Equal(expected) {
+			return errors.New("the proof of shuffle is invalid (" + name + ")")
+		}
+		return nil
+	}
+
+	// the commitments open to the vector e', which the chained commitments use as well
+	a := linearCombination(suite, e, p.U)
+	expected := suite.Point().Mul(p.KA, g)
+	if err := check("opening", a, p.APrime, expected.Add(expected, linearCombination(suite, p.KE, h))); err != nil {
+		return err
+	}
+	previous := h[0]
+	for i := 0; i < n; i++ {
+		expected := suite.Point().Mul(p.KChain[i], g)
+		expected.Add(expected, suite.Point().Mul(p.KE[i], previous))
+		if err := check("chain "+strconv.Itoa(i), p.Chain[i], p.ChainPrime[i], expected); err != nil {
+			return err
+		}
+		previous = p.Chain[i]
+	}
+
+	// each row of the committed matrix sums to 1
+	c := suite.Point().Null()
+	for i := 0; i < n; i++ {
+		c.Add(c, p.U[i]).Sub(c, h[i])
+	}
+	if err := check("sum", c, p.CPrime, suite.Point().Mul(p.KC, g)); err != nil {
+		return err
+	}
+
+	// prod(e') = prod(e), hence the matrix is a permutation
+	d := suite.Point().Mul(productOf(suite, e), h[0])
+	d.Sub(p.Chain[n-1], d)
+	if err := check("product", d, p.DPrime, suite.Point().Mul(p.KD, g)); err != nil {
+		return err
+	}
+
+	// the new base and the keys are multiplied by the same coefficient
+	if err := check("base", newBase, p.BasePrime, suite.Point().Mul(p.KCoeff, base)); err != nil {
+		return err
+	}
+	f := linearCombination(suite, e, publicKeys)
+	expected = linearCombination(suite, p.KE, shuffledKeys)
+	expected.Sub(expected, suite.Point().Mul(p.KCoeff, f))
+	if !expected.Equal(p.FPrime) {
+		return errors.New("the proof of shuffle is invalid (keys)")
+	}
+
+	return nil
+}
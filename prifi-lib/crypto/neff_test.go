@@ -18,21 +18,21 @@ func TestNeffErrors(t *testing.T) {
 	clientPks := make([]kyber.Point, nClients)
 	clientPrivKeys := make([]kyber.Scalar, nClients)
 	for i := 0; i < nClients; i++ {
-		pub, priv := NewKeyPair()
+		pub, priv := NewKeyPair(config.CryptoSuite)
 		clientPks[i] = pub
 		clientPrivKeys[i] = priv
 	}
 
 	//each of those call should fail
-	_, _, _, _, err := NeffShuffle(nil, base, true)
+	_, _, _, _, err := NeffShuffle(config.CryptoSuite, nil, base, true)
 	if err == nil {
 		t.Error("NeffShuffle without a public key array should fail")
 	}
-	_, _, _, _, err = NeffShuffle(clientPks, nil, true)
+	_, _, _, _, err = NeffShuffle(config.CryptoSuite, clientPks, nil, true)
 	if err == nil {
 		t.Error("NeffShuffle without a base should fail")
 	}
-	_, _, _, _, err = NeffShuffle(make([]kyber.Point, 0), base, true)
+	_, _, _, _, err = NeffShuffle(config.CryptoSuite, make([]kyber.Point, 0), base, true)
 	if err == nil {
 		t.Error("NeffShuffle with 0 public keys should fail")
 	}
@@ -54,13 +54,13 @@ func TestNeffShuffle(t *testing.T) {
 		clientPks := make([]kyber.Point, nClients)
 		clientPrivKeys := make([]kyber.Scalar, nClients)
 		for i := 0; i < nClients; i++ {
-			pub, priv := NewKeyPair()
+			pub, priv := NewKeyPair(config.CryptoSuite)
 			clientPks[i] = pub
 			clientPrivKeys[i] = priv
 		}
 
 		//shuffle
-		shuffledKeys, newBase, secretCoeff, proof, err := NeffShuffle(clientPks, base, true)
+		shuffledKeys, newBase, secretCoeff, proof, err := NeffShuffle(config.CryptoSuite, clientPks, base, true)
 
 		if err != nil {
 			t.Error(err)
@@ -105,7 +105,7 @@ func TestNeffShuffle(t *testing.T) {
 		}
 		fmt.Print("Testing distribution for ", nClients, " clients.")
 		for i := 0; i < repetition; i++ {
-			shuffledKeys, newBase, secretCoeff, proof, err = NeffShuffle(clientPks, base, true)
+			shuffledKeys, newBase, secretCoeff, proof, err = NeffShuffle(config.CryptoSuite, clientPks, base, true)
 
 			if err != nil {
 				t.Error("Shouldn't have an error here," + err.Error())
@@ -156,3 +156,60 @@ func TestNeffShuffle(t *testing.T) {
 	}
 
 }
+
+func TestNeffShuffleProof(t *testing.T) {
+
+	for _, nClients := range []int{1, 2, 5, 20} {
+		clientPks := make([]kyber.Point, nClients)
+		for i := 0; i < nClients; i++ {
+			clientPks[i], _ = NewKeyPair(config.CryptoSuite)
+		}
+		base := config.CryptoSuite.Point().Base()
+
+		for _, doShufflePositions := range []bool{false, true} {
+			shuffledKeys, newBase, _, proof, err := NeffShuffle(config.CryptoSuite, clientPks, base, doShufflePositions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyNeffShuffle(config.CryptoSuite, clientPks, base, shuffledKeys, newBase, proof); err != nil {
+				t.Error("The proof of a shuffle of", nClients, "keys should be valid,", err)
+			}
+
+			// a shuffle of other keys
+			otherKeys := append([]kyber.Point{}, shuffledKeys...)
+			otherKeys[nClients-1], _ = NewKeyPair(config.CryptoSuite)
+			if VerifyNeffShuffle(config.CryptoSuite, clientPks, base, otherKeys, newBase, proof) == nil {
+				t.Error("The proof should not be valid for a shuffle where a key was replaced")
+			}
+
+			// a shuffle with another base
+			otherBase, _ := NewKeyPair(config.CryptoSuite)
+			if VerifyNeffShuffle(config.CryptoSuite, clientPks, base, shuffledKeys, otherBase, proof) == nil {
+				t.Error("The proof should not be valid with another new base")
+			}
+
+			// a tampered proof
+			tampered := append([]byte{}, proof...)
+			tampered[len(tampered)-1] ^= 1
+			if VerifyNeffShuffle(config.CryptoSuite, clientPks, base, shuffledKeys, newBase, tampered) == nil {
+				t.Error("A tampered proof should not be valid")
+			}
+			if VerifyNeffShuffle(config.CryptoSuite, clientPks, base, shuffledKeys, newBase, proof[:len(proof)-1]) == nil {
+				t.Error("A truncated proof should not be valid")
+			}
+		}
+
+		// a key duplicated in place of another one, with the coefficient and the proof of a genuine shuffle
+		if nClients > 1 {
+			shuffledKeys, newBase, secretCoeff, proof, err := NeffShuffle(config.CryptoSuite, clientPks, base, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			duplicated := append([]kyber.Point{}, shuffledKeys...)
+			duplicated[1] = config.CryptoSuite.Point().Mul(secretCoeff, clientPks[0])
+			if VerifyNeffShuffle(config.CryptoSuite, clientPks, base, duplicated, newBase, proof) == nil {
+				t.Error("The proof should not be valid for a shuffle where a key was duplicated")
+			}
+		}
+	}
+}
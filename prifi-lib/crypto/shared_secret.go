@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"errors"
+	"strconv"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/proof"
+	"go.dedis.ch/kyber/v3/suites"
+)
+
+/*
+In the blame protocol of the disruption protection, the client and the trustee of a pair whose revealed bits mismatch
+reveal the secret they share, from which the pads of every epoch are derived. The secret comes with a proof that it is
+the Diffie-Hellman key of their long-term keys, X = x*B and K = x*Y where Y is the public key of the peer, bound to the
+blamed round and pair : a wrong secret is caught, and the relay regenerates the true pad from a correct one.
+*/
+
+// sharedSecretContext is the message of the proofs revealing the secret of the client "clientID" and the trustee
+// "trusteeID", to blame the round "roundID"
+func sharedSecretContext(roundID int32, clientID int, trusteeID int) string {
+	return "SHAREDKEY" + strconv.Itoa(int(roundID)) + "-" + strconv.Itoa(clientID) + "-" + strconv.Itoa(trusteeID)
+}
+
+// sharedSecretPredicate : the prover knows x such that X = x*B, and the shared secret is K = x*Y
+func sharedSecretPredicate() proof.Predicate {
+	return proof.And(proof.Rep("X", "x", "B"), proof.Rep("K", "x", "Y"))
+}
+
+// ProveSharedSecret returns the secret shared by the key "private" and the peer of public key "peerPublic", and a
+// proof that it is correct, to blame the round "roundID" between the client "clientID" and the trustee "trusteeID"
+func ProveSharedSecret(suite suites.Suite, private kyber.Scalar, peerPublic kyber.Point, roundID int32, clientID int,
+	trusteeID int) (kyber.Point, []byte, error) {
+	secret := suite.Point().Mul(private, peerPublic)
+	sval := map[string]kyber.Scalar{"x": private}
+	pval := map[string]kyber.Point{
+		"B": suite.Point().Base(),
+		"X": suite.Point().Mul(private, nil),
+		"Y": peerPublic,
+		"K": secret}
+	prover := sharedSecretPredicate().Prover(suite, sval, pval, nil)
+	NIZK, err := proof.HashProve(suite, sharedSecretContext(roundID, clientID, trusteeID), prover)
+	if err != nil {
+		return nil, nil, err
+	}
+	return secret, NIZK, nil
+}
+
+// VerifySharedSecret returns an error if "NIZK" does not prove that "secret" is the secret shared by the entity of
+// public key "public" with the peer of public key "peerPublic", to blame the round "roundID" between the client
+// "clientID" and the trustee "trusteeID"
+func VerifySharedSecret(suite suites.Suite, public kyber.Point, peerPublic kyber.Point, secret kyber.Point, roundID int32,
+	clientID int, trusteeID int, NIZK []byte) error {
+	if public == nil || peerPublic == nil || secret == nil {
+		return errors.New("shared_secret.go : missing public key or secret")
+	}
+	pval := map[string]kyber.Point{
+		"B": suite.Point().Base(),
+		"X": public,
+		"Y": peerPublic,
+		"K": secret}
+	verifier := sharedSecretPredicate().Verifier(suite, pval)
+	return proof.HashVerify(suite, sharedSecretContext(roundID, clientID, trusteeID), verifier, NIZK)
+}
@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+)
+
+func TestSharedSecret(t *testing.T) {
+	suite := config.CryptoSuite
+	clientPub, clientPriv := NewKeyPair(suite)
+	trusteePub, trusteePriv := NewKeyPair(suite)
+
+	secret, NIZK, err := ProveSharedSecret(suite, clientPriv, trusteePub, 12, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secret.Equal(suite.Point().Mul(trusteePriv, clientPub)) {
+		t.Error("The client and the trustee should share the same secret")
+	}
+	if err := VerifySharedSecret(suite, clientPub, trusteePub, secret, 12, 1, 0, NIZK); err != nil {
+		t.Error("The proof of the secret should verify, got", err)
+	}
+
+	// the proof does not hold for another secret, another entity, or another blame
+	wrongSecret := suite.Point().Add(secret, suite.Point().Base())
+	if VerifySharedSecret(suite, clientPub, trusteePub, wrongSecret, 12, 1, 0, NIZK) == nil {
+		t.Error("The proof should not hold for another secret")
+	}
+	otherPub, _ := NewKeyPair(suite)
+	if VerifySharedSecret(suite, otherPub, trusteePub, secret, 12, 1, 0, NIZK) == nil {
+		t.Error("The proof should not hold for another entity")
+	}
+	if VerifySharedSecret(suite, clientPub, trusteePub, secret, 13, 1, 0, NIZK) == nil ||
+		VerifySharedSecret(suite, clientPub, trusteePub, secret, 12, 2, 0, NIZK) == nil {
+		t.Error("The proof should not hold for another round or pair")
+	}
+	if VerifySharedSecret(suite, clientPub, trusteePub, nil, 12, 1, 0, NIZK) == nil {
+		t.Error("A missing secret should be refused")
+	}
+
+	// a secret of the right form, but not computed from the private key of the prover
+	_, otherPriv := NewKeyPair(suite)
+	forged, forgedNIZK, err := ProveSharedSecret(suite, otherPriv, trusteePub, 12, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifySharedSecret(suite, clientPub, trusteePub, forged, 12, 1, 0, forgedNIZK) == nil {
+		t.Error("The proof of another key should not hold")
+	}
+}
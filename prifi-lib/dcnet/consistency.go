@@ -0,0 +1,69 @@
+package dcnet
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"go.dedis.ch/kyber/v3"
+)
+
+/*
+Optional check of the consistency of the downstream cells. With each upstream cell, a client sends a short digest of
+the downstream cell it answers, and a tag of this digest for each trustee, computed with a key derived from the secret
+they share. The relay forwards the digests of each round to the trustees, which check that all clients received the
+same downstream cell. A relay that sends different downstream cells to different clients is hence detected explicitly,
+instead of only making the cells protected against equivocation undecodable.
+
+The relay does not know the shared secrets, hence cannot forge nor modify the digests of the clients; it can only drop
+them, which it cannot do for long without ignoring the ciphers of those clients too. Note that a disagreement shows that
+the clients did not receive the same cell, or that a client lied about the cell it received.
+*/
+
+// DOWNSTREAM_DIGEST_LENGTH is the length of the digest of a downstream cell
+const DOWNSTREAM_DIGEST_LENGTH = 16
+
+// DOWNSTREAM_DIGEST_TAG_LENGTH is the length of the tag authenticating a digest for one trustee
+const DOWNSTREAM_DIGEST_TAG_LENGTH = 16
+
+// domain separation between the digests, their keys and the other uses of the shared secrets
+const (
+	downstreamDigestDomain    = "dcnet-downstream-digest"
+	downstreamDigestKeyDomain = "dcnet-downstream-digest-key"
+)
+
+// DownstreamDigestKey derives the key of the tags of the downstream digests from a key shared between a client and a trustee
+func DownstreamDigestKey(sharedKey kyber.Point) ([]byte, error) {
+	sharedKeyBytes, err := sharedKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte(downstreamDigestKeyDomain))
+	h.Write(sharedKeyBytes)
+	return h.Sum(nil), nil
+}
+
+// DownstreamDigest returns the digest of a downstream cell. "cell" must be an encoding of the whole cell, round included
+func DownstreamDigest(cell []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(downstreamDigestDomain))
+	h.Write(cell)
+	return h.Sum(nil)[:DOWNSTREAM_DIGEST_LENGTH]
+}
+
+// DownstreamDigestTag returns the tag of the digest of the downstream cell of the round "roundID", under the key "key"
+func DownstreamDigestTag(key []byte, roundID int32, digest []byte) []byte {
+	roundBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(roundBytes, uint32(roundID))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(roundBytes)
+	mac.Write(digest)
+	return mac.Sum(nil)[:DOWNSTREAM_DIGEST_TAG_LENGTH]
+}
+
+// CheckDownstreamDigestTag returns true if "tag" is the tag of the digest of the downstream cell of the round "roundID", under the key "key"
+func CheckDownstreamDigestTag(key []byte, roundID int32, digest []byte, tag []byte) bool {
+	return hmac.Equal(tag, DownstreamDigestTag(key, roundID, digest))
+}
@@ -0,0 +1,42 @@
+package dcnet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dedis/prifi/prifi-lib/config"
+)
+
+func TestDownstreamDigest(t *testing.T) {
+	sharedKey := config.CryptoSuite.Point().Pick(config.CryptoSuite.RandomStream())
+	key, err := DownstreamDigestKey(sharedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := DownstreamDigestKey(config.CryptoSuite.Point().Pick(config.CryptoSuite.RandomStream()))
+
+	digest := DownstreamDigest([]byte("cell"))
+	if len(digest) != DOWNSTREAM_DIGEST_LENGTH {
+		t.Error("Wrong digest length", len(digest))
+	}
+	if bytes.Equal(digest, DownstreamDigest([]byte("other cell"))) {
+		t.Error("Different cells should have different digests")
+	}
+
+	tag := DownstreamDigestTag(key, 3, digest)
+	if len(tag) != DOWNSTREAM_DIGEST_TAG_LENGTH {
+		t.Error("Wrong tag length", len(tag))
+	}
+	if !CheckDownstreamDigestTag(key, 3, digest, tag) {
+		t.Error("The tag should be accepted")
+	}
+	if CheckDownstreamDigestTag(key, 4, digest, tag) {
+		t.Error("The tag of another round should be refused")
+	}
+	if CheckDownstreamDigestTag(otherKey, 3, digest, tag) {
+		t.Error("The tag under another key should be refused")
+	}
+	if CheckDownstreamDigestTag(key, 3, DownstreamDigest([]byte("other cell")), tag) {
+		t.Error("The tag of another digest should be refused")
+	}
+}
@@ -1,8 +1,8 @@
 package dcnet
 
 import (
+	"errors"
 	"fmt"
-	"github.com/dedis/prifi/prifi-lib/config"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/onet/v3/log"
@@ -30,11 +30,21 @@ type DCNetEntity struct {
 	Entity                        DCNET_ENTITY
 	EquivocationProtectionEnabled bool
 	DCNetPayloadSize              int
+	PadCipher                     string
 
 	cryptoSuite  suites.Suite
-	sharedKeys   []kyber.Point // keys shared with other DC-net members
-	sharedPRNGs  []kyber.XOF   // PRNGs shared with other DC-net members (seeded with sharedKeys)
-	currentRound int32
+	padSeeds     [][]byte    // seeds of the pads of the current epoch, shared with other DC-net members
+	padCiphers   []PadCipher // pad generators shared with other DC-net members (keyed with padSeeds)
+	padBuffers   [][]byte    // one pad per peer, reused from round to round
+	currentRound int32       // the first round not encoded yet
+
+	//Variable-length slots, see slots.go
+	roundPayloadSizes map[int32]int // the length of the payload of the rounds not of DCNetPayloadSize bytes
+
+	//Epochs, see epochs.go
+	epoch      int32            // the epoch of padSeeds
+	epochStart int32            // the first round of the current epoch
+	nextEpochs []scheduledEpoch // the next epochs announced by the relay, in order
 
 	//Used by the relay
 	DCNetRoundDecoder *DCNetRoundDecoder //nil if unused
@@ -42,6 +52,10 @@ type DCNetEntity struct {
 	//Equivocation protection
 	equivocationProtection    *EquivocationProtection //nil if unused
 	equivocationContribLength int                     //0 if equivocation protection is disabled
+	roundHistories            map[int32]kyber.Scalar  //used by the relay, the history of each round sent and not decoded yet
+
+	//Verifiable DC-net
+	verifiable *verifiableDCNet //nil if unused
 
 	verbose bool
 }
@@ -52,14 +66,17 @@ type DCNetRoundDecoder struct {
 	xorBuffer                []byte
 	equivTrusteeContribs     [][]byte
 	equivClientContribs      [][]byte
+	verifiableBuffer         []kyber.Point
 }
 
 // Used by clients, trustees
 func NewDCNetEntity(
+	suite suites.Suite,
 	entityID int,
 	entity DCNET_ENTITY,
 	PayloadSize int,
 	equivocationProtection bool,
+	padCipher string,
 	sharedKeys []kyber.Point) *DCNetEntity {
 
 	e := new(DCNetEntity)
@@ -67,40 +84,50 @@ func NewDCNetEntity(
 	e.Entity = entity
 	e.DCNetPayloadSize = PayloadSize
 	e.EquivocationProtectionEnabled = equivocationProtection
+	e.PadCipher = padCipher
 	e.DCNetRoundDecoder = nil
 	e.currentRound = 0
+	e.roundPayloadSizes = make(map[int32]int)
+	e.roundHistories = make(map[int32]kyber.Scalar)
 
 	e.verbose = false // todo: wire in the .toml
 
 	if equivocationProtection {
-		e.equivocationProtection = NewEquivocation()
+		e.equivocationProtection = NewEquivocation(suite)
 	}
 
-	e.cryptoSuite = config.CryptoSuite
+	e.cryptoSuite = suite
 
 	// if the node participates in the DC-net
 	if entity != DCNET_RELAY {
-		e.sharedKeys = sharedKeys
-
-		// Use the provided shared secrets to seed a pseudorandom DC-nets ciphers shared with each peer.
-		e.sharedPRNGs = make([]kyber.XOF, len(sharedKeys))
+		// Use the provided shared secrets to key the pseudorandom DC-nets pads shared with each peer.
+		e.padSeeds = make([][]byte, len(sharedKeys))
+		e.padCiphers = make([]PadCipher, len(sharedKeys))
 		for i := range sharedKeys {
 			e.verbosePrint("key", i, ":", sharedKeys[i])
-			seed, err := sharedKeys[i].MarshalBinary()
+			seed, err := PadSeed(sharedKeys[i])
 			if err != nil {
 				log.Fatal("Could not extract data from shared key", err)
 			}
-			e.sharedPRNGs[i] = e.cryptoSuite.XOF(seed)
+			e.padSeeds[i] = seed
+			e.padCiphers[i], err = NewPadCipher(padCipher, e.cryptoSuite, seed)
+			if err != nil {
+				panic("DCNet: " + err.Error())
+			}
 		}
 	} else {
-		e.sharedKeys = make([]kyber.Point, 0)
-		e.sharedPRNGs = make([]kyber.XOF, 0)
+		e.padSeeds = make([][]byte, 0)
+		e.padCiphers = make([]PadCipher, 0)
+	}
+	e.padBuffers = make([][]byte, len(e.padCiphers))
+	for i := range e.padBuffers {
+		e.padBuffers[i] = make([]byte, PayloadSize)
 	}
 
 	// if the equivocation protection is enabled
 	if equivocationProtection {
 		e.verbosePrint("equivocation = true")
-		e.equivocationProtection = NewEquivocation()
+		e.equivocationProtection = NewEquivocation(suite)
 		zero := e.equivocationProtection.suite.Scalar().Zero()
 		one := e.equivocationProtection.suite.Scalar().One()
 		minusOne := e.equivocationProtection.suite.Scalar().Sub(zero, one) //max value
@@ -138,93 +165,116 @@ func (e *DCNetEntity) verbosePrint(info ...interface{}) {
 	log.Lvl1(s, s2)
 }
 
-// Encodes "Payload" in the correct round. Will skip PRNG material if the round is in the future,
-// and crash if the round is in the past or the Payload is too long
+// Encodes the trustee's cipher for the round "roundID". Returns nil if the round is in an epoch already erased
 func (e *DCNetEntity) TrusteeEncodeForRound(roundID int32) []byte {
-	upstreamCell, _ := e.EncodeForRound(roundID, false, nil)
+	if e.verifiable != nil {
+		return e.verifiableTrusteeEncode(roundID)
+	}
+	upstreamCell, _, err := e.EncodeForRound(roundID, false, nil)
+	if err != nil {
+		// there is no payload to encode, the round is in an erased epoch
+		e.verbosePrint("r[", roundID, "]: ", err)
+		return nil
+	}
 	return upstreamCell
 }
 
-// Encodes "Payload" in the correct round. Will skip PRNG material if the round is in the future,
-// and crash if the round is in the past or the Payload is too long
-func (e *DCNetEntity) EncodeForRound(roundID int32, slotOwner bool, payload []byte) ([]byte, []byte) {
-	if len(payload) > e.DCNetPayloadSize {
-		panic("DCNet: cannot encode Payload of length " + strconv.Itoa(int(len(payload))) + " max length is " + strconv.Itoa(len(payload)))
+// Encodes "Payload" in the correct round. The pads are generated for "roundID" directly, so rounds
+// can be encoded in any order, as long as they are in the current epoch or later. The payload of the round
+// has PayloadSizeOfRound(roundID) bytes. Returns an error wrapping ErrPayloadTooLong if the Payload is too long,
+// or ErrWrongEpoch if the round is in an epoch already erased
+func (e *DCNetEntity) EncodeForRound(roundID int32, slotOwner bool, payload []byte) ([]byte, []byte, error) {
+	payloadSize := e.PayloadSizeOfRound(roundID)
+	maxLength := payloadSize
+	if e.EquivocationProtectionEnabled && slotOwner {
+		maxLength -= 16
 	}
-
-	if roundID < e.currentRound {
-		sharedKeys := e.sharedKeys
-
-		// Use the provided shared secrets to seed a pseudorandom DC-nets ciphers shared with each peer.
-		sharedPRNGsCopy := make([]kyber.XOF, len(sharedKeys))
-		for i := range sharedKeys {
-			e.verbosePrint("key", i, ":", sharedKeys[i])
-			seed, err := sharedKeys[i].MarshalBinary()
-			if err != nil {
-				log.Fatal("Could not extract data from shared key", err)
-			}
-			sharedPRNGsCopy[i] = e.cryptoSuite.XOF(seed)
-		}
-		round := int32(0)
-		for round < roundID {
-			//discard crypto material
-
-			// consume the PRNGs
-			for i := range e.sharedPRNGs {
-				dummy := make([]byte, e.DCNetPayloadSize)
-				sharedPRNGsCopy[i].XORKeyStream(dummy, dummy)
-			}
-
-			round++
-
-		}
-		e.sharedPRNGs = sharedPRNGsCopy
-		e.currentRound = roundID
+	if len(payload) > maxLength {
+		return nil, nil, fmt.Errorf("%w: cannot encode Payload of length %d, max length is %d", ErrPayloadTooLong, len(payload), maxLength)
 	}
-
-	for e.currentRound < roundID {
-		//discard crypto material
-		log.Lvl4("DCNet: Discarding round", e.currentRound)
-
-		// consume the PRNGs
-		for i := range e.sharedPRNGs {
-			dummy := make([]byte, e.DCNetPayloadSize)
-			e.sharedPRNGs[i].XORKeyStream(dummy, dummy)
-		}
-
-		e.currentRound++
+	if err := e.enterEpochOfRound(roundID); err != nil {
+		return nil, nil, err
 	}
+
 	var plainPayload []byte
 	var c *DCNetCipher
 	if e.Entity == DCNET_CLIENT {
-		c, plainPayload = e.clientEncode(slotOwner, payload)
+		c, plainPayload = e.clientEncode(roundID, payloadSize, slotOwner, payload)
 	} else {
-		c = e.trusteeEncode()
+		c = e.trusteeEncode(roundID, payloadSize)
 	}
-	e.currentRound++
+	c.RoundID = roundID
+	if roundID >= e.currentRound {
+		e.currentRound = roundID + 1
+	}
+	e.forgetPayloadSizesBefore(roundID)
 
 	e.verbosePrint("r[", roundID, "]:\n", c.Payload)
 	e.verbosePrint("r[", roundID, "]: equiv\n", c.EquivocationProtectionTag)
-	return c.ToBytes(), plainPayload
+	return c.ToBytes(), plainPayload, nil
+}
+
+// padsOfRound returns the pads of "size" bytes shared with each peer for the round "roundID". They are written in
+// the entity's buffers, hence only valid until the next call
+func (e *DCNetEntity) padsOfRound(roundID int32, size int) [][]byte {
+	for i := range e.padBuffers {
+		if cap(e.padBuffers[i]) < size {
+			e.padBuffers[i] = make([]byte, size)
+		}
+		e.padBuffers[i] = e.padBuffers[i][:size]
+	}
+	if len(e.padCiphers) > 0 {
+		generatePads(e.padCiphers, roundID, e.padBuffers)
+	}
+	return e.padBuffers
 }
 
-// Adds `newdata` into the sponge representing the received downstream data
+// Adds `newdata` into the sponge representing the received downstream data. Called by the clients on the
+// downstream cell of each round, in round order, before encoding the round
 func (e *DCNetEntity) UpdateReceivedMessageHistory(newData []byte) {
 	if e.EquivocationProtectionEnabled {
 		e.equivocationProtection.UpdateHistory(newData)
 	}
 }
 
+// Adds `newdata`, the downstream cell of the round "roundID", into the sponge representing the sent downstream data.
+// Called by the relay on each downstream cell sent, in round order. With a window, the next cells are sent before
+// "roundID" is decoded : the history of "roundID" is kept until then
+func (e *DCNetEntity) UpdateSentMessageHistory(roundID int32, newData []byte) {
+	if e.EquivocationProtectionEnabled {
+		e.equivocationProtection.UpdateHistory(newData)
+		e.roundHistories[roundID] = e.cryptoSuite.Scalar().Set(e.equivocationProtection.history)
+	}
+}
+
+// MessageHistory returns the history of the downstream cells sent so far, nil without the equivocation protection.
+// Called by the relay for the clients joining at the next round
+func (e *DCNetEntity) MessageHistory() ([]byte, error) {
+	if !e.EquivocationProtectionEnabled {
+		return nil, nil
+	}
+	return e.equivocationProtection.History()
+}
+
+// SetMessageHistory replaces the history of the received downstream data by "history", as returned by MessageHistory.
+// Called by a client joining the DC-net, before its first round
+func (e *DCNetEntity) SetMessageHistory(history []byte) error {
+	if !e.EquivocationProtectionEnabled {
+		return nil
+	}
+	return e.equivocationProtection.SetHistory(history)
+}
+
 // Encode for clients
-func (e *DCNetEntity) clientEncode(slotOwner bool, payload []byte) (*DCNetCipher, []byte) {
+func (e *DCNetEntity) clientEncode(roundID int32, payloadSize int, slotOwner bool, payload []byte) (*DCNetCipher, []byte) {
 
 	c := new(DCNetCipher)
 
 	if payload == nil {
-		payload = make([]byte, e.DCNetPayloadSize)
+		payload = make([]byte, payloadSize)
 	} else {
 		// deep clone and pad
-		dcnetPayloadSize := e.DCNetPayloadSize
+		dcnetPayloadSize := payloadSize
 		if e.EquivocationProtectionEnabled && slotOwner {
 			dcnetPayloadSize -= 16
 		}
@@ -235,12 +285,8 @@ func (e *DCNetEntity) clientEncode(slotOwner bool, payload []byte) (*DCNetCipher
 	c.Payload = payload
 
 	// prepare the pads
-	p_ij := make([][]byte, len(e.sharedPRNGs))
-	for i := range p_ij {
-		p_ij[i] = make([]byte, e.DCNetPayloadSize)
-		e.sharedPRNGs[i].XORKeyStream(p_ij[i], p_ij[i])
-	}
-	plainPayload := make([]byte, e.DCNetPayloadSize)
+	p_ij := e.padsOfRound(roundID, payloadSize)
+	plainPayload := make([]byte, payloadSize)
 
 	// if the equivocation protection is enabled, encrypt the Payload, and add the tag
 	if e.EquivocationProtectionEnabled {
@@ -254,31 +300,23 @@ func (e *DCNetEntity) clientEncode(slotOwner bool, payload []byte) (*DCNetCipher
 
 	// DC-net encrypt the Payload
 	for i := range p_ij {
-		for k := range c.Payload {
-			c.Payload[k] ^= p_ij[i][k] // XORs in the pads
-		}
+		xorBytes(c.Payload, p_ij[i]) // XORs in the pads
 	}
 	return c, plainPayload[:]
 }
 
 // Encode for trustees
-func (e *DCNetEntity) trusteeEncode() *DCNetCipher {
+func (e *DCNetEntity) trusteeEncode(roundID int32, payloadSize int) *DCNetCipher {
 	c := new(DCNetCipher)
 
-	c.Payload = make([]byte, e.DCNetPayloadSize)
+	c.Payload = make([]byte, payloadSize)
 
 	// prepare the pads
-	p_ij := make([][]byte, len(e.sharedPRNGs))
-	for i := range p_ij {
-		p_ij[i] = make([]byte, e.DCNetPayloadSize)
-		e.sharedPRNGs[i].XORKeyStream(p_ij[i], p_ij[i])
-	}
+	p_ij := e.padsOfRound(roundID, payloadSize)
 
 	// DC-net encrypt the Payload
 	for i := range p_ij {
-		for k := range c.Payload {
-			c.Payload[k] ^= p_ij[i][k] // XORs in the pads
-		}
+		xorBytes(c.Payload, p_ij[i]) // XORs in the pads
 	}
 
 	// if the equivocation protection is enabled, encrypt the Payload, and add the tag
@@ -290,129 +328,158 @@ func (e *DCNetEntity) trusteeEncode() *DCNetCipher {
 	return c
 }
 
-// Function to get the bits from previous round in an exact position.
+// Function to get the bits from previous round in an exact position. The pads are regenerated
+// for "roundID" only, the state used to encode the next rounds is left untouched. Returns nil if the round
+// was not encoded yet, or is in an epoch already erased.
 func (e *DCNetEntity) GetBitsOfRound(roundID int32, bitPosition int32) (map[int]int, [][]byte) {
-	if roundID >= e.currentRound {
+	if roundID >= e.currentRound || roundID < e.epochStart {
 		return nil, nil
 	}
 
-	sharedKeys := e.sharedKeys
-
-	// Use the provided shared secrets to seed a pseudorandom DC-nets ciphers shared with each peer.
-	sharedPRNGsCopy := make([]kyber.XOF, len(sharedKeys))
-	for i := range sharedKeys {
-		e.verbosePrint("key", i, ":", sharedKeys[i])
-		seed, err := sharedKeys[i].MarshalBinary()
-		if err != nil {
-			log.Fatal("Could not extract data from shared key", err)
-		}
-		sharedPRNGsCopy[i] = e.cryptoSuite.XOF(seed)
-	}
-	round := int32(0)
-	for round < roundID {
-		//discard crypto material
-
-		// consume the PRNGs
-		for i := range e.sharedPRNGs {
-			dummy := make([]byte, e.DCNetPayloadSize)
-			sharedPRNGsCopy[i].XORKeyStream(dummy, dummy)
-		}
-
-		round++
-
-	}
-	e.sharedPRNGs = sharedPRNGsCopy
-	e.currentRound = roundID
-
 	rtn := make(map[int]int)
 
-	// prepare the pads
-
-	p_ij := make([][]byte, len(e.sharedPRNGs))
+	// prepare the pads, in fresh buffers since they are returned to the caller
+	p_ij := make([][]byte, len(e.padCiphers))
 	for i := range p_ij {
 		p_ij[i] = make([]byte, e.DCNetPayloadSize)
-		e.sharedPRNGs[i].XORKeyStream(p_ij[i], p_ij[i])
 	}
-	// DC-net encrypt the Payload
-	for i := range p_ij {
-		bytePosition := int(bitPosition / 8)
-		// TODO: CHECK WHY THIS HAPPEN, CLEARLY A BUG HERE
-		if !e.EquivocationProtectionEnabled {
-			bytePosition++
-		}
-		byte_toGet := p_ij[i][bytePosition]
-		bitInByte := (8-bitPosition%8)%8 - 1
-		mask := byte(1 << uint(bitInByte))
-		if (byte_toGet & mask) == 0 {
-			rtn[i] = 0
-		} else {
-			rtn[i] = 1
-		}
+	generatePads(e.padCiphers, roundID, p_ij)
 
+	// the bit positions are counted after the b_echo_last flag, the first byte of the payload
+	// TODO: CHECK WHY THIS IS NOT THE CASE WITH THE EQUIVOCATION PROTECTION, CLEARLY A BUG HERE
+	if !e.EquivocationProtectionEnabled {
+		bitPosition += 8
+	}
+	for i := range p_ij {
+		rtn[i] = BitAt(p_ij[i], int(bitPosition))
 	}
 
 	return rtn, p_ij
 }
 
-// Used by the relay to start decoding a round
+// Used by the relay to start decoding a round, of PayloadSizeOfRound(roundID) bytes
 func (e *DCNetEntity) DecodeStart(roundID int32) {
+	e.forgetPayloadSizesBefore(roundID)
+	for r := range e.roundHistories {
+		if r < roundID {
+			delete(e.roundHistories, r)
+		}
+	}
 	e.DCNetRoundDecoder = new(DCNetRoundDecoder)
 	e.DCNetRoundDecoder.currentRoundBeingDecoded = roundID
-	e.DCNetRoundDecoder.xorBuffer = make([]byte, e.DCNetPayloadSize)
+	e.DCNetRoundDecoder.xorBuffer = make([]byte, e.PayloadSizeOfRound(roundID))
 	e.DCNetRoundDecoder.equivClientContribs = make([][]byte, 0)
 	e.DCNetRoundDecoder.equivTrusteeContribs = make([][]byte, 0)
-}
 
-// called by the relay to decode a client contribution
-func (e *DCNetEntity) DecodeClient(roundID int32, slice []byte) {
+	if e.verifiable != nil {
+		e.DCNetRoundDecoder.verifiableBuffer = make([]kyber.Point, e.verifiable.nChunks)
+		for i := range e.DCNetRoundDecoder.verifiableBuffer {
+			e.DCNetRoundDecoder.verifiableBuffer[i] = e.cryptoSuite.Point().Null()
+		}
+	}
+}
 
-	dcNetCipher := DCNetCipherFromBytes(slice)
+// called by the relay to decode a client contribution. If the contribution is malformed, or for another round,
+// it is ignored and an error is returned (wrapping one of the Err* errors of this package)
+func (e *DCNetEntity) DecodeClient(roundID int32, slice []byte) error {
 
-	if roundID != e.DCNetRoundDecoder.currentRoundBeingDecoded {
-		panic("Cannot DecodeClient for round" +
-			strconv.Itoa(int(roundID)) + ", we are in round " + strconv.Itoa(int(e.DCNetRoundDecoder.currentRoundBeingDecoded)))
+	if e.verifiable != nil {
+		return errors.New("Cannot DecodeClient in the verifiable DC-net, use DecodeVerifiableClient")
 	}
 
-	for i := range dcNetCipher.Payload {
-		e.DCNetRoundDecoder.xorBuffer[i] ^= dcNetCipher.Payload[i]
+	dcNetCipher, err := e.parseContribution(roundID, slice)
+	if err != nil {
+		return err
 	}
 
+	xorBytes(e.DCNetRoundDecoder.xorBuffer, dcNetCipher.Payload)
+
 	if e.EquivocationProtectionEnabled {
 		e.DCNetRoundDecoder.equivClientContribs = append(e.DCNetRoundDecoder.equivClientContribs, dcNetCipher.EquivocationProtectionTag)
 	}
+	return nil
 }
 
-// called by the relay to decode a client contribution
-func (e *DCNetEntity) DecodeTrustee(roundID int32, slice []byte) {
+// called by the relay to decode a trustee contribution. If the contribution is malformed, or for another round,
+// it is ignored and an error is returned (wrapping one of the Err* errors of this package)
+func (e *DCNetEntity) DecodeTrustee(roundID int32, slice []byte) error {
 
-	dcNetCipher := DCNetCipherFromBytes(slice)
-
-	if roundID != e.DCNetRoundDecoder.currentRoundBeingDecoded {
-		panic("Cannot DecodeClient for round" +
-			strconv.Itoa(int(roundID)) + ", we are in round " + strconv.Itoa(int(e.DCNetRoundDecoder.currentRoundBeingDecoded)))
+	if e.verifiable != nil {
+		if err := e.checkDecodingRound(roundID); err != nil {
+			return err
+		}
+		return e.verifiableDecodeTrustee(roundID, slice)
 	}
 
-	for i := range dcNetCipher.Payload {
-		e.DCNetRoundDecoder.xorBuffer[i] ^= dcNetCipher.Payload[i]
+	dcNetCipher, err := e.parseContribution(roundID, slice)
+	if err != nil {
+		return err
 	}
 
+	xorBytes(e.DCNetRoundDecoder.xorBuffer, dcNetCipher.Payload)
+
 	if e.EquivocationProtectionEnabled {
 		e.DCNetRoundDecoder.equivTrusteeContribs = append(e.DCNetRoundDecoder.equivTrusteeContribs, dcNetCipher.EquivocationProtectionTag)
 	}
+	return nil
 }
 
-// Called on the relay to decode the cell, after having stored the cryptographic materials
-func (e *DCNetEntity) DecodeCell(isOpenClosedSlot bool) ([]byte, []byte) {
+// checkDecodingRound returns an error if the relay is not decoding the round "roundID"
+func (e *DCNetEntity) checkDecodingRound(roundID int32) error {
+	if e.DCNetRoundDecoder == nil {
+		return ErrNotDecoding
+	}
+	if roundID != e.DCNetRoundDecoder.currentRoundBeingDecoded {
+		return fmt.Errorf("%w: cannot decode round %d, we are in round %d", ErrWrongRound, roundID, e.DCNetRoundDecoder.currentRoundBeingDecoded)
+	}
+	return nil
+}
+
+// parseContribution parses and checks a contribution of a client or a trustee for the round "roundID",
+// without changing the state of the decoder
+func (e *DCNetEntity) parseContribution(roundID int32, slice []byte) (*DCNetCipher, error) {
+	if err := e.checkDecodingRound(roundID); err != nil {
+		return nil, err
+	}
+
+	dcNetCipher, err := DCNetCipherFromBytes(slice)
+	if err != nil {
+		return nil, err
+	}
+	if err := dcNetCipher.checkRound(roundID); err != nil {
+		return nil, err
+	}
+
+	if len(dcNetCipher.Payload) != len(e.DCNetRoundDecoder.xorBuffer) {
+		return nil, fmt.Errorf("%w: %d bytes, expected %d", ErrWrongPayloadLength, len(dcNetCipher.Payload), len(e.DCNetRoundDecoder.xorBuffer))
+	}
+	if e.EquivocationProtectionEnabled && len(dcNetCipher.EquivocationProtectionTag) != e.equivocationContribLength {
+		return nil, fmt.Errorf("%w: equivocation tag of %d bytes, expected %d", ErrMalformedCipher, len(dcNetCipher.EquivocationProtectionTag), e.equivocationContribLength)
+	}
+	return dcNetCipher, nil
+}
+
+// Called on the relay to decode the cell, after having stored the cryptographic materials. With the equivocation
+// protection, returns an error wrapping ErrEquivocation if the payload cannot be decrypted : the clients did not all
+// receive the same downstream cells, the output is meaningless
+func (e *DCNetEntity) DecodeCell(isOpenClosedSlot bool) ([]byte, []byte, error) {
+	if e.verifiable != nil {
+		decoded, cipherText := e.verifiableDecodeCell()
+		return decoded, cipherText, nil
+	}
+
 	//No Equivocation -> just XOR
 	d := e.DCNetRoundDecoder
 
 	cipherText := d.xorBuffer
-	var decoded []byte
 	if e.EquivocationProtectionEnabled && !isOpenClosedSlot {
-		decoded = e.equivocationProtection.RelayDecode(d.xorBuffer, d.equivTrusteeContribs, d.equivClientContribs)
-	} else {
-		decoded = cipherText
+		history, found := e.roundHistories[d.currentRoundBeingDecoded]
+		if !found {
+			// no downstream cell was sent for this round
+			history = e.equivocationProtection.history
+		}
+		decoded, err := e.equivocationProtection.RelayDecode(history, d.xorBuffer, d.equivTrusteeContribs, d.equivClientContribs)
+		return decoded, cipherText, err
 	}
-
-	return decoded, cipherText
+	return cipherText, cipherText, nil
 }
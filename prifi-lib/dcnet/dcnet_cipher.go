@@ -2,55 +2,104 @@ package dcnet
 
 import (
 	"encoding/binary"
-	"math"
+	"fmt"
+)
+
+// DCNET_CIPHER_VERSION is the version of the wire format written by ToBytes. DCNetCipherFromBytes refuses any other version
+const DCNET_CIPHER_VERSION byte = 1
+
+// DCNET_CIPHER_HEADER_LENGTH is the length of the header preceding the equivocation tag and the payload :
+// version (1 byte) | round (4 bytes) | tag length (4 bytes) | payload length (4 bytes), all big-endian
+const DCNET_CIPHER_HEADER_LENGTH = 13
+
+// offsets of the fields in the header
+const (
+	cipherVersionOffset       = 0
+	cipherRoundOffset         = 1
+	cipherTagLengthOffset     = 5
+	cipherPayloadLengthOffset = 9
 )
 
 // DCNetCipher is the output of a DC-net round
 type DCNetCipher struct {
+	RoundID                   int32
 	EquivocationProtectionTag []byte
 	Payload                   []byte
 }
 
 // Converts the DCNetCipher to []byte
 func (c *DCNetCipher) ToBytes() []byte {
-	out := make([]byte, 8)
-	equivocationTagStart := -1
-	payloadStart := 8
-
-	if c.EquivocationProtectionTag != nil {
-		equivocationTagStart = 8
-		payloadStart += len(c.EquivocationProtectionTag)
-	}
+	out := make([]byte, DCNET_CIPHER_HEADER_LENGTH, c.Length())
 
-	binary.BigEndian.PutUint32(out[0:4], uint32(equivocationTagStart))
-	binary.BigEndian.PutUint32(out[4:8], uint32(payloadStart))
+	out[cipherVersionOffset] = DCNET_CIPHER_VERSION
+	binary.BigEndian.PutUint32(out[cipherRoundOffset:], uint32(c.RoundID))
+	binary.BigEndian.PutUint32(out[cipherTagLengthOffset:], uint32(len(c.EquivocationProtectionTag)))
+	binary.BigEndian.PutUint32(out[cipherPayloadLengthOffset:], uint32(len(c.Payload)))
 
-	if c.EquivocationProtectionTag != nil {
-		out = append(out, c.EquivocationProtectionTag...)
-	}
+	out = append(out, c.EquivocationProtectionTag...)
 	out = append(out, c.Payload...)
 
 	return out
 }
 
-// Decodes some bytes into a DCNetCipher
-func DCNetCipherFromBytes(data []byte) *DCNetCipher {
-	c := new(DCNetCipher)
+// Length returns the length of the DCNetCipher once converted to []byte
+func (c *DCNetCipher) Length() int {
+	return DCNET_CIPHER_HEADER_LENGTH + len(c.EquivocationProtectionTag) + len(c.Payload)
+}
 
-	if len(data) < 8 {
-		panic("DCNetCipherFromBytes: data too short")
+// PayloadBit returns the bit at "bitPosition" of the payload, the bits being numbered from the most significant bit
+// of the first byte. Returns an error wrapping ErrWrongPayloadLength if the payload is too short
+func (c *DCNetCipher) PayloadBit(bitPosition int) (int, error) {
+	if bitPosition < 0 || bitPosition/8 >= len(c.Payload) {
+		return 0, fmt.Errorf("%w: no bit %d in a payload of %d bytes", ErrWrongPayloadLength, bitPosition, len(c.Payload))
 	}
+	return BitAt(c.Payload, bitPosition), nil
+}
 
-	minusOneInUint32 := math.MaxInt32
+// BitAt returns the bit at "bitPosition" of "data", the bits being numbered from the most significant bit
+// of the first byte. "bitPosition" must be in the data
+func BitAt(data []byte, bitPosition int) int {
+	mask := byte(1 << uint(7-bitPosition%8))
+	if data[bitPosition/8]&mask == 0 {
+		return 0
+	}
+	return 1
+}
+
+// checkRound returns an error wrapping ErrWrongRound if the DCNetCipher is not for the round "roundID"
+func (c *DCNetCipher) checkRound(roundID int32) error {
+	if c.RoundID != roundID {
+		return fmt.Errorf("%w: cipher of round %d, expected round %d", ErrWrongRound, c.RoundID, roundID)
+	}
+	return nil
+}
+
+// Decodes some bytes into a DCNetCipher. The returned cipher shares its memory with "data".
+// Returns an error wrapping ErrMalformedCipher if "data" is not exactly one DCNetCipher of the current version
+func DCNetCipherFromBytes(data []byte) (*DCNetCipher, error) {
+	c := new(DCNetCipher)
 
-	equivocationTagStart := int(binary.BigEndian.Uint32(data[0:4]))
-	payloadStart := int(binary.BigEndian.Uint32(data[4:8]))
+	if len(data) < DCNET_CIPHER_HEADER_LENGTH {
+		return nil, fmt.Errorf("%w: %d bytes, the header alone is %d bytes", ErrMalformedCipher, len(data), DCNET_CIPHER_HEADER_LENGTH)
+	}
+	if data[cipherVersionOffset] != DCNET_CIPHER_VERSION {
+		return nil, fmt.Errorf("%w: version %d, expected %d", ErrMalformedCipher, data[cipherVersionOffset], DCNET_CIPHER_VERSION)
+	}
 
-	if equivocationTagStart != minusOneInUint32 {
-		c.EquivocationProtectionTag = data[8:payloadStart]
+	c.RoundID = int32(binary.BigEndian.Uint32(data[cipherRoundOffset:]))
+	tagLength := uint64(binary.BigEndian.Uint32(data[cipherTagLengthOffset:]))
+	payloadLength := uint64(binary.BigEndian.Uint32(data[cipherPayloadLengthOffset:]))
+
+	if DCNET_CIPHER_HEADER_LENGTH+tagLength+payloadLength != uint64(len(data)) {
+		return nil, fmt.Errorf("%w: tag of %d bytes and payload of %d bytes, but %d bytes after the header",
+			ErrMalformedCipher, tagLength, payloadLength, len(data)-DCNET_CIPHER_HEADER_LENGTH)
 	}
 
+	payloadStart := DCNET_CIPHER_HEADER_LENGTH + int(tagLength)
+	if tagLength > 0 {
+		c.EquivocationProtectionTag = data[DCNET_CIPHER_HEADER_LENGTH:payloadStart]
+	}
 	c.Payload = data[payloadStart:]
 
-	return c
+	return c, nil
 }
@@ -3,7 +3,11 @@ package dcnet
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	mrand "math/rand"
 	"testing"
 )
 
@@ -14,6 +18,9 @@ func randomBytes(length int) []byte {
 }
 
 func assertEqual(a, b *DCNetCipher) bool {
+	if a.RoundID != b.RoundID {
+		return false
+	}
 	if !bytes.Equal(a.EquivocationProtectionTag, b.EquivocationProtectionTag) {
 		return false
 	}
@@ -23,6 +30,15 @@ func assertEqual(a, b *DCNetCipher) bool {
 	return true
 }
 
+// parses some bytes that must be a valid DCNetCipher
+func mustParse(t *testing.T, data []byte) *DCNetCipher {
+	c, err := DCNetCipherFromBytes(data)
+	if err != nil {
+		t.Fatal("DCNetCipherFromBytes should parse", data, "but", err)
+	}
+	return c
+}
+
 func TestDCNetSerialization(t *testing.T) {
 	ChangeLength(10, t)
 	ChangeLength(20, t)
@@ -37,40 +53,185 @@ func ChangeLength(length int, t *testing.T) {
 		EquivocationProtectionTag: randomBytes(length),
 		Payload:                   nil,
 	}
-	if !assertEqual(&a, DCNetCipherFromBytes(a.ToBytes())) {
+	if !assertEqual(&a, mustParse(t, a.ToBytes())) {
 		t.Error("DCNetCipher could not be marshalled-unmarshalled")
 		fmt.Printf("%+v\n", a)
 		fmt.Printf("%+v\n", a.ToBytes())
-		fmt.Printf("%+v\n", DCNetCipherFromBytes(a.ToBytes()))
+		fmt.Printf("%+v\n", mustParse(t, a.ToBytes()))
 	}
 
 	a = DCNetCipher{
 		EquivocationProtectionTag: nil,
 		Payload:                   nil,
 	}
-	if !assertEqual(&a, DCNetCipherFromBytes(a.ToBytes())) {
+	if !assertEqual(&a, mustParse(t, a.ToBytes())) {
 		t.Error("DCNetCipher could not be marshalled-unmarshalled")
 		fmt.Printf("%+v\n", a)
-		fmt.Printf("%+v\n", DCNetCipherFromBytes(a.ToBytes()))
+		fmt.Printf("%+v\n", mustParse(t, a.ToBytes()))
 	}
 
 	a = DCNetCipher{
 		EquivocationProtectionTag: nil,
 		Payload:                   randomBytes(length),
 	}
-	if !assertEqual(&a, DCNetCipherFromBytes(a.ToBytes())) {
+	if !assertEqual(&a, mustParse(t, a.ToBytes())) {
 		t.Error("DCNetCipher could not be marshalled-unmarshalled")
 		fmt.Printf("%+v\n", a)
-		fmt.Printf("%+v\n", DCNetCipherFromBytes(a.ToBytes()))
+		fmt.Printf("%+v\n", mustParse(t, a.ToBytes()))
 	}
 
 	a = DCNetCipher{
+		RoundID:                   int32(length),
 		EquivocationProtectionTag: randomBytes(length),
 		Payload:                   randomBytes(length),
 	}
-	if !assertEqual(&a, DCNetCipherFromBytes(a.ToBytes())) {
+	if !assertEqual(&a, mustParse(t, a.ToBytes())) {
 		t.Error("DCNetCipher could not be marshalled-unmarshalled")
 		fmt.Printf("%+v\n", a)
-		fmt.Printf("%+v\n", DCNetCipherFromBytes(a.ToBytes()))
+		fmt.Printf("%+v\n", mustParse(t, a.ToBytes()))
+	}
+}
+
+func TestDCNetCipherFromBytesMalformed(t *testing.T) {
+	valid := DCNetCipher{
+		EquivocationProtectionTag: randomBytes(4),
+		Payload:                   randomBytes(10),
+	}
+	validNoTag := DCNetCipher{
+		Payload: randomBytes(10),
+	}
+
+	unknownVersion := valid.ToBytes()
+	unknownVersion[cipherVersionOffset] = DCNET_CIPHER_VERSION + 1
+
+	malformed := map[string][]byte{
+		"nil":                   nil,
+		"too short":             randomBytes(DCNET_CIPHER_HEADER_LENGTH - 1),
+		"of an unknown version": unknownVersion,
+		"truncated":             valid.ToBytes()[:20],
+		"followed by garbage":   append(valid.ToBytes(), 0),
+		"tag too long":          setUint32(valid.ToBytes(), cipherTagLengthOffset, 5),
+		"tag too short":         setUint32(valid.ToBytes(), cipherTagLengthOffset, 3),
+		"tag of 4GB":            setUint32(valid.ToBytes(), cipherTagLengthOffset, math.MaxUint32),
+		"payload too long":      setUint32(validNoTag.ToBytes(), cipherPayloadLengthOffset, 11),
+		"payload of 4GB":        setUint32(validNoTag.ToBytes(), cipherPayloadLengthOffset, math.MaxUint32),
+	}
+	for name, data := range malformed {
+		c, err := DCNetCipherFromBytes(data)
+		if err == nil || c != nil {
+			t.Error("DCNetCipherFromBytes should refuse a cipher that is", name)
+		}
+		if !errors.Is(err, ErrMalformedCipher) {
+			t.Error("DCNetCipherFromBytes should return an ErrMalformedCipher for", name, "but returned", err)
+		}
+	}
+}
+
+func TestDCNetCipherHeader(t *testing.T) {
+	c := DCNetCipher{
+		RoundID:                   -3,
+		EquivocationProtectionTag: randomBytes(4),
+		Payload:                   randomBytes(10),
+	}
+	data := c.ToBytes()
+
+	if len(data) != DCNET_CIPHER_HEADER_LENGTH+4+10 || len(data) != c.Length() {
+		t.Error("Wrong length", len(data))
+	}
+	if data[0] != DCNET_CIPHER_VERSION {
+		t.Error("The first byte should be the version")
+	}
+	if binary.BigEndian.Uint32(data[1:5]) != uint32(c.RoundID) || binary.BigEndian.Uint32(data[5:9]) != 4 ||
+		binary.BigEndian.Uint32(data[9:13]) != 10 {
+		t.Error("Wrong header", data[:DCNET_CIPHER_HEADER_LENGTH])
+	}
+	if !bytes.Equal(data[DCNET_CIPHER_HEADER_LENGTH+4:], c.Payload) {
+		t.Error("The payload should follow the equivocation tag")
+	}
+
+	if err := c.checkRound(-3); err != nil {
+		t.Error(err)
+	}
+	if err := c.checkRound(3); !errors.Is(err, ErrWrongRound) {
+		t.Error("checkRound should return an ErrWrongRound, got", err)
+	}
+}
+
+func TestDCNetCipherPayloadBit(t *testing.T) {
+	c := DCNetCipher{Payload: []byte{0x80, 0x01}}
+	for pos, expected := range []int{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1} {
+		bit, err := c.PayloadBit(pos)
+		if err != nil || bit != expected {
+			t.Error("Bit", pos, "should be", expected, "got", bit, err)
+		}
+	}
+	if _, err := c.PayloadBit(16); !errors.Is(err, ErrWrongPayloadLength) {
+		t.Error("PayloadBit should refuse a bit after the payload")
+	}
+	if _, err := c.PayloadBit(-1); !errors.Is(err, ErrWrongPayloadLength) {
+		t.Error("PayloadBit should refuse a negative position")
+	}
+}
+
+func setUint32(data []byte, pos int, value uint32) []byte {
+	binary.BigEndian.PutUint32(data[pos:pos+4], value)
+	return data
+}
+
+// checkCipherFromBytes checks that DCNetCipherFromBytes either refuses "data" with an ErrMalformedCipher, or accepts
+// it entirely and re-serializes it to the same bytes
+func checkCipherFromBytes(t *testing.T, data []byte) {
+	c, err := DCNetCipherFromBytes(data)
+	if err != nil {
+		if c != nil || !errors.Is(err, ErrMalformedCipher) {
+			t.Fatal("DCNetCipherFromBytes returned", c, err, "for", data)
+		}
+		return
+	}
+	if c.Length() != len(data) {
+		t.Fatal("DCNetCipherFromBytes ignored some bytes of", data)
+	}
+	if !bytes.Equal(c.ToBytes(), data) {
+		t.Fatal("DCNetCipherFromBytes accepted", data, "which does not re-serialize to the same bytes")
+	}
+}
+
+// checks that DCNetCipherFromBytes never panics on truncated, modified or random buffers
+func TestDCNetCipherFromMalformedBytes(t *testing.T) {
+	valid := [][]byte{
+		{},
+		(&DCNetCipher{RoundID: 7, Payload: []byte("payload")}).ToBytes(),
+		(&DCNetCipher{EquivocationProtectionTag: []byte("tag"), Payload: []byte("payload")}).ToBytes(),
+		(&DCNetCipher{EquivocationProtectionTag: []byte("tag")}).ToBytes(),
+	}
+
+	rng := mrand.New(mrand.NewSource(1))
+	for _, data := range valid {
+		checkCipherFromBytes(t, data)
+
+		// every truncation, and every buffer with trailing bytes
+		for i := 0; i < len(data); i++ {
+			checkCipherFromBytes(t, data[:i])
+		}
+		checkCipherFromBytes(t, append(append([]byte{}, data...), 0))
+
+		// every byte changed, in particular the version and the lengths of the header
+		for i := range data {
+			for _, value := range []byte{0, 1, 0x7F, 0x80, 0xFF, byte(rng.Intn(256))} {
+				modified := append([]byte{}, data...)
+				modified[i] = value
+				checkCipherFromBytes(t, modified)
+			}
+		}
+	}
+
+	// random headers followed by random bytes
+	for i := 0; i < 10000; i++ {
+		data := make([]byte, rng.Intn(3*DCNET_CIPHER_HEADER_LENGTH))
+		rng.Read(data)
+		if len(data) > cipherVersionOffset && rng.Intn(2) == 0 {
+			data[cipherVersionOffset] = DCNET_CIPHER_VERSION
+		}
+		checkCipherFromBytes(t, data)
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/dedis/prifi/prifi-lib/config"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
 	"testing"
 )
 
@@ -36,6 +37,15 @@ func TestDCNetCreation(t *testing.T) {
 	}
 }
 
+func TestDCNetP256(t *testing.T) {
+	suite, err := config.FindCryptoSuite(config.CRYPTO_SUITE_P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SimulateRounds(t, newTestGroupWithSuite(t, suite, false, 100, 3, 2), 20)
+	SimulateRounds(t, newTestGroupWithSuite(t, suite, true, 100, 3, 2), 20)
+}
+
 func VariousLevelsOfProtection(t *testing.T, nRounds int32, dcNetMessageSize, NClients, NTrustees int) {
 	tg := NewTestGroup(t, false, dcNetMessageSize, NClients, NTrustees)
 	SimulateRounds(t, tg, nRounds)
@@ -43,19 +53,23 @@ func VariousLevelsOfProtection(t *testing.T, nRounds int32, dcNetMessageSize, NC
 	SimulateRounds(t, tg, nRounds)
 }
 
-func NewTestGroup(t *testing.T, equivocationProtectionEnabled bool, dcNetMessageSize, nclients, ntrustees int) *TestGroup {
+func NewTestGroup(t testing.TB, equivocationProtectionEnabled bool, dcNetMessageSize, nclients, ntrustees int) *TestGroup {
+	return newTestGroupWithSuite(t, config.CryptoSuite, equivocationProtectionEnabled, dcNetMessageSize, nclients, ntrustees)
+}
+
+func newTestGroupWithSuite(t testing.TB, suite suites.Suite, equivocationProtectionEnabled bool, dcNetMessageSize, nclients, ntrustees int) *TestGroup {
 
 	// Use a pseudorandom stream from a well-known seed
 	// for all our setup randomness,
 	// so we can reproduce the same keys etc on each node.
-	rand := config.CryptoSuite.XOF([]byte("DCTest"))
+	rand := suite.XOF([]byte("DCTest"))
 
 	nodes := make([]*TestNode, nclients+ntrustees)
-	base := config.CryptoSuite.Point().Base()
+	base := suite.Point().Base()
 	for i := range nodes {
 		nodes[i] = new(TestNode)
-		nodes[i].privKey = config.CryptoSuite.Scalar().Pick(rand)
-		nodes[i].pubKey = config.CryptoSuite.Point().Mul(nodes[i].privKey, base)
+		nodes[i].privKey = suite.Scalar().Pick(rand)
+		nodes[i].pubKey = suite.Point().Mul(nodes[i].privKey, base)
 	}
 
 	clients := nodes[:nclients]
@@ -63,7 +77,7 @@ func NewTestGroup(t *testing.T, equivocationProtectionEnabled bool, dcNetMessage
 
 	relay := new(TestNode)
 	relay.name = "Relay"
-	relay.DCNetEntity = NewDCNetEntity(0, DCNET_RELAY, dcNetMessageSize, equivocationProtectionEnabled, nil)
+	relay.DCNetEntity = NewDCNetEntity(suite, 0, DCNET_RELAY, dcNetMessageSize, equivocationProtectionEnabled, PAD_CIPHER_XOF, nil)
 
 	// Create tables of the clients' and the trustees' public session keys
 	clientsKeys := make([]kyber.Point, nclients)
@@ -83,9 +97,9 @@ func NewTestGroup(t *testing.T, equivocationProtectionEnabled bool, dcNetMessage
 		n.peerKeys = trusteesKeys
 		n.sharedSecrets = make([]kyber.Point, len(n.peerKeys))
 		for i := range n.peerKeys {
-			n.sharedSecrets[i] = config.CryptoSuite.Point().Mul(n.privKey, n.peerKeys[i])
+			n.sharedSecrets[i] = suite.Point().Mul(n.privKey, n.peerKeys[i])
 		}
-		n.DCNetEntity = NewDCNetEntity(i, DCNET_CLIENT, dcNetMessageSize, equivocationProtectionEnabled, n.sharedSecrets)
+		n.DCNetEntity = NewDCNetEntity(suite, i, DCNET_CLIENT, dcNetMessageSize, equivocationProtectionEnabled, PAD_CIPHER_XOF, n.sharedSecrets)
 	}
 
 	for i, n := range trustees {
@@ -95,9 +109,9 @@ func NewTestGroup(t *testing.T, equivocationProtectionEnabled bool, dcNetMessage
 		n.peerKeys = clientsKeys
 		n.sharedSecrets = make([]kyber.Point, len(n.peerKeys))
 		for i := range n.peerKeys {
-			n.sharedSecrets[i] = config.CryptoSuite.Point().Mul(n.privKey, n.peerKeys[i])
+			n.sharedSecrets[i] = suite.Point().Mul(n.privKey, n.peerKeys[i])
 		}
-		n.DCNetEntity = NewDCNetEntity(i, DCNET_TRUSTEE, dcNetMessageSize, equivocationProtectionEnabled, n.sharedSecrets)
+		n.DCNetEntity = NewDCNetEntity(suite, i, DCNET_TRUSTEE, dcNetMessageSize, equivocationProtectionEnabled, PAD_CIPHER_XOF, n.sharedSecrets)
 	}
 
 	// Create a set of fake history streams for the relay and clients
@@ -134,17 +148,17 @@ func SimulateRounds(t *testing.T, tg *TestGroup, maxRounds int32) {
 		for i := range tg.Clients {
 			tg.Clients[i].DCNetEntity.UpdateReceivedMessageHistory(downstreamMessage)
 		}
-		tg.Relay.DCNetEntity.UpdateReceivedMessageHistory(downstreamMessage)
+		tg.Relay.DCNetEntity.UpdateSentMessageHistory(roundID, downstreamMessage)
 
 		// Generate the clients dc-net cryptographic material
 		for i := range tg.Clients {
 			var m []byte
 			if first {
 				//fmt.Println("Embedding message:", message)
-				m, _ = tg.Clients[i].DCNetEntity.EncodeForRound(roundID, true, message)
+				m, _, _ = tg.Clients[i].DCNetEntity.EncodeForRound(roundID, true, message)
 				first = false
 			} else {
-				m, _ = tg.Clients[i].DCNetEntity.EncodeForRound(roundID, false, nil)
+				m, _, _ = tg.Clients[i].DCNetEntity.EncodeForRound(roundID, false, nil)
 			}
 			clientMessages = append(clientMessages, m)
 		}
@@ -158,13 +172,20 @@ func SimulateRounds(t *testing.T, tg *TestGroup, maxRounds int32) {
 		// The relay decodes the cryptographic material
 		tg.Relay.DCNetEntity.DecodeStart(roundID)
 		for _, m := range clientMessages {
-			tg.Relay.DCNetEntity.DecodeClient(roundID, m)
+			if err := tg.Relay.DCNetEntity.DecodeClient(roundID, m); err != nil {
+				t.Error(err)
+			}
 		}
 		for _, m := range trusteesMessages {
-			tg.Relay.DCNetEntity.DecodeTrustee(roundID, m)
+			if err := tg.Relay.DCNetEntity.DecodeTrustee(roundID, m); err != nil {
+				t.Error(err)
+			}
 		}
 
-		output, _ := tg.Relay.DCNetEntity.DecodeCell(false)
+		output, _, err := tg.Relay.DCNetEntity.DecodeCell(false)
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		//fmt.Println("-----------------")
 		//fmt.Println(output)
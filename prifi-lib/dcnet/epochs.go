@@ -0,0 +1,249 @@
+package dcnet
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"go.dedis.ch/kyber/v3"
+)
+
+/*
+The rounds are grouped in epochs, announced by the relay. The seeds of the pads of epoch e+1 are derived from the
+ones of epoch e with a one-way function, and the seeds of epoch e are erased as soon as the first round of epoch e+1
+is encoded. Hence, the DC-net state of a node compromised in epoch e+1 does not reveal the pads of the previous epochs.
+
+Epoch 0 starts at round 0 and is keyed with the shared secrets. The relay announces each new epoch (ID and first
+round) before it starts; an entity that missed some announcements ratchets several epochs at once.
+The rounds of an erased epoch cannot be encoded anymore, nor their bits revealed in the blame protocol.
+
+The peers of the DC-net only change at the start of an epoch : a client joining at epoch e shares pads with each
+trustee from the first round of epoch e on, keyed with their shared secret ratcheted e times, as if it had been there
+since epoch 0. A client leaving at epoch e is dropped the same way : from the first round of epoch e on, the trustees
+stop XORing in the pads shared with it, and erase their seeds. Its index is kept, with an all-zero pad, so that the
+indices of the other peers do not change.
+
+The DC-net state does not keep the shared secrets once the seeds of epoch 0 (or of the epoch where a peer joins) are
+derived from them. In the blame protocol, the client and the trustee of the mismatching pair recompute their shared
+secret from their long-term key, and reveal it with a proof that it is correct (see crypto.ProveSharedSecret) : the
+relay derives the seed of the epoch of the disrupted round with EpochSeed, and regenerates the pad.
+*/
+
+// domain separation between the seeds of the different epochs
+const epochDomain = "dcnet-epoch"
+
+// RatchetSeed derives the pad seed of the next epoch from the pad seed of the current epoch
+func RatchetSeed(seed []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(epochDomain))
+	h.Write(seed)
+	return h.Sum(nil)
+}
+
+// EpochSeed returns the seed of the pads of epoch "epoch", derived from a key shared with a peer
+func EpochSeed(sharedKey kyber.Point, epoch int32) ([]byte, error) {
+	seed, err := PadSeed(sharedKey)
+	if err != nil {
+		return nil, err
+	}
+	for i := int32(0); i < epoch; i++ {
+		next := RatchetSeed(seed)
+		clearBytes(seed)
+		seed = next
+	}
+	return seed, nil
+}
+
+// RevealPadSeed returns a copy of the seed of the pads shared with the peer "peer" in the epoch of the round "roundID".
+// Returns an error wrapping ErrWrongEpoch if this epoch is erased or not entered yet
+func (e *DCNetEntity) RevealPadSeed(peer int, roundID int32) ([]byte, error) {
+	if peer < 0 || peer >= len(e.padSeeds) || e.padSeeds[peer] == nil {
+		return nil, fmt.Errorf("%w: no pads shared with peer %d", ErrWrongEpoch, peer)
+	}
+	if roundID < e.epochStart {
+		return nil, fmt.Errorf("%w: round %d is before epoch %d, which started at round %d", ErrWrongEpoch,
+			roundID, e.epoch, e.epochStart)
+	}
+	if len(e.nextEpochs) > 0 && roundID >= e.nextEpochs[0].start {
+		return nil, fmt.Errorf("%w: round %d is in epoch %d, not entered yet", ErrWrongEpoch, roundID,
+			e.nextEpochs[0].epoch)
+	}
+	seed := make([]byte, len(e.padSeeds[peer]))
+	copy(seed, e.padSeeds[peer])
+	return seed, nil
+}
+
+// Epoch returns the current epoch of the entity, and its first round
+func (e *DCNetEntity) Epoch() (int32, int32) {
+	return e.epoch, e.epochStart
+}
+
+// scheduledEpoch is an epoch announced by the relay, which the entity did not enter yet
+type scheduledEpoch struct {
+	epoch         int32
+	start         int32
+	joiningKeys   []kyber.Point // the keys shared with the peers joining at this epoch
+	departedPeers []int         // the indices of the peers leaving at this epoch
+}
+
+// lastEpochScheduled returns the last epoch announced, or the current one, and its first round
+func (e *DCNetEntity) lastEpochScheduled() (int32, int32) {
+	if n := len(e.nextEpochs); n > 0 {
+		return e.nextEpochs[n-1].epoch, e.nextEpochs[n-1].start
+	}
+	return e.epoch, e.epochStart
+}
+
+// ScheduleEpoch is called when the relay announces that the epoch "epoch" starts at the round "startRound".
+// The entity moves to this epoch when encoding the round "startRound" or any later round. Announcements of
+// epochs older than the current or the scheduled ones are ignored
+func (e *DCNetEntity) ScheduleEpoch(epoch int32, startRound int32) error {
+	lastEpoch, lastStart := e.lastEpochScheduled()
+	if epoch <= lastEpoch {
+		return nil
+	}
+	if startRound <= lastStart {
+		return fmt.Errorf("%w: epoch %d cannot start at round %d, epoch %d starts at round %d", ErrWrongEpoch,
+			epoch, startRound, lastEpoch, lastStart)
+	}
+	e.nextEpochs = append(e.nextEpochs, scheduledEpoch{epoch: epoch, start: startRound})
+	return nil
+}
+
+// ScheduleEpochWithNewPeers is ScheduleEpoch for an epoch where new peers join the DC-net : from the first round of
+// this epoch on, the entity also shares pads with them, keyed with "sharedKeys". Unlike ScheduleEpoch, an epoch already
+// current or announced is refused, the new peers would be lost
+func (e *DCNetEntity) ScheduleEpochWithNewPeers(epoch int32, startRound int32, sharedKeys []kyber.Point) error {
+	next, err := e.scheduleEpochChangingPeers(epoch, startRound)
+	if err != nil {
+		return err
+	}
+	next.joiningKeys = sharedKeys
+	return nil
+}
+
+// ScheduleEpochWithoutPeers is ScheduleEpoch for an epoch where some peers leave the DC-net : from the first round of
+// this epoch on, the entity stops sharing pads with the peers of index "peers". As for ScheduleEpochWithNewPeers, an
+// epoch already current or announced is refused
+func (e *DCNetEntity) ScheduleEpochWithoutPeers(epoch int32, startRound int32, peers []int) error {
+	for _, i := range peers {
+		if i < 0 || i >= len(e.padCiphers) {
+			return fmt.Errorf("%w: cannot remove peer %d at epoch %d, we have %d peers", ErrWrongEpoch, i, epoch,
+				len(e.padCiphers))
+		}
+	}
+	next, err := e.scheduleEpochChangingPeers(epoch, startRound)
+	if err != nil {
+		return err
+	}
+	next.departedPeers = peers
+	return nil
+}
+
+// scheduleEpochChangingPeers schedules an epoch where the peers change, and returns it. The epoch must not be already
+// current or announced
+func (e *DCNetEntity) scheduleEpochChangingPeers(epoch int32, startRound int32) (*scheduledEpoch, error) {
+	if lastEpoch, _ := e.lastEpochScheduled(); epoch <= lastEpoch {
+		return nil, fmt.Errorf("%w: cannot change the peers at epoch %d, epoch %d is already current or announced",
+			ErrWrongEpoch, epoch, lastEpoch)
+	}
+	if err := e.ScheduleEpoch(epoch, startRound); err != nil {
+		return nil, err
+	}
+	return &e.nextEpochs[len(e.nextEpochs)-1], nil
+}
+
+// enterEpochOfRound ratchets the pad seeds if "roundID" is in one of the next epochs, and returns an error wrapping
+// ErrWrongEpoch if "roundID" is in an epoch already erased
+func (e *DCNetEntity) enterEpochOfRound(roundID int32) error {
+	for len(e.nextEpochs) > 0 && roundID >= e.nextEpochs[0].start {
+		next := e.nextEpochs[0]
+		e.nextEpochs = e.nextEpochs[1:]
+		e.ratchet(next.epoch - e.epoch)
+		e.epoch = next.epoch
+		e.epochStart = next.start
+		e.addPeers(next.joiningKeys)
+		e.removePeers(next.departedPeers)
+	}
+	if roundID < e.epochStart {
+		return fmt.Errorf("%w: round %d is before epoch %d, which started at round %d", ErrWrongEpoch,
+			roundID, e.epoch, e.epochStart)
+	}
+	return nil
+}
+
+// ratchet replaces the pad seeds by the ones "n" epochs later, and erases the previous seeds
+func (e *DCNetEntity) ratchet(n int32) {
+	for i := range e.padSeeds {
+		seed := e.padSeeds[i]
+		if seed == nil {
+			// the peer left the DC-net
+			continue
+		}
+		for k := int32(0); k < n; k++ {
+			next := RatchetSeed(seed)
+			clearBytes(seed)
+			seed = next
+		}
+		e.padSeeds[i] = seed
+
+		padCipher, err := NewPadCipher(e.PadCipher, e.cryptoSuite, seed)
+		if err != nil {
+			// cannot happen, the pad cipher was accepted when creating the entity
+			panic("DCNet: " + err.Error())
+		}
+		e.padCiphers[i] = padCipher
+	}
+	e.verbosePrint("ratcheted the pad seeds", n, "epochs forward")
+}
+
+// addPeers adds the pads shared with the peers joining at the current epoch, which was just entered
+func (e *DCNetEntity) addPeers(sharedKeys []kyber.Point) {
+	for _, key := range sharedKeys {
+		seed, err := EpochSeed(key, e.epoch)
+		if err != nil {
+			// cannot happen, the key is a valid point
+			panic("DCNet: " + err.Error())
+		}
+		padCipher, err := NewPadCipher(e.PadCipher, e.cryptoSuite, seed)
+		if err != nil {
+			// cannot happen, the pad cipher was accepted when creating the entity
+			panic("DCNet: " + err.Error())
+		}
+		e.padSeeds = append(e.padSeeds, seed)
+		e.padCiphers = append(e.padCiphers, padCipher)
+		e.padBuffers = append(e.padBuffers, make([]byte, e.DCNetPayloadSize))
+	}
+	if len(sharedKeys) > 0 {
+		e.verbosePrint("added", len(sharedKeys), "peers at epoch", e.epoch)
+	}
+}
+
+// removePeers erases the pads shared with the peers of index "peers", which leave the DC-net at the current epoch,
+// which was just entered
+func (e *DCNetEntity) removePeers(peers []int) {
+	for _, i := range peers {
+		if e.padSeeds[i] == nil {
+			continue
+		}
+		clearBytes(e.padSeeds[i])
+		e.padSeeds[i] = nil
+		e.padCiphers[i] = departedPadCipher{}
+	}
+	if len(peers) > 0 {
+		e.verbosePrint("removed peers", peers, "at epoch", e.epoch)
+	}
+}
+
+// departedPadCipher is the pad of a peer which left the DC-net : all zeros
+type departedPadCipher struct{}
+
+// Name returns the name of the pad cipher
+func (departedPadCipher) Name() string {
+	return "none"
+}
+
+// Pad fills "pad" with zeros
+func (departedPadCipher) Pad(roundID int32, pad []byte) {
+	for i := range pad {
+		pad[i] = 0
+	}
+}
@@ -0,0 +1,270 @@
+package dcnet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// encodes and decodes the round "roundID" of the test group, client 0 transmitting a random message
+func runRound(t *testing.T, tg *TestGroup, roundID int32) {
+	payloadSize := tg.Relay.DCNetEntity.DCNetPayloadSize
+	message := randomBytes(payloadSize)
+
+	tg.Relay.DCNetEntity.DecodeStart(roundID)
+	for i := range tg.Clients {
+		var m []byte
+		var err error
+		if i == 0 {
+			m, _, err = tg.Clients[i].DCNetEntity.EncodeForRound(roundID, true, message)
+		} else {
+			m, _, err = tg.Clients[i].DCNetEntity.EncodeForRound(roundID, false, nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tg.Relay.DCNetEntity.DecodeClient(roundID, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range tg.Trustees {
+		if err := tg.Relay.DCNetEntity.DecodeTrustee(roundID, tg.Trustees[i].DCNetEntity.TrusteeEncodeForRound(roundID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output, _, err := tg.Relay.DCNetEntity.DecodeCell(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, message) {
+		t.Error("DC-net decoding failed for round", roundID)
+	}
+}
+
+func TestDCNetEpochs(t *testing.T) {
+	tg := NewTestGroup(t, false, 50, 2, 2)
+	nodes := append(append([]*TestNode{}, tg.Clients...), tg.Trustees...)
+	trustee := tg.Trustees[0].DCNetEntity
+	epoch0Seed := trustee.padSeeds[0]
+	epoch0Cipher := append([]byte{}, trustee.TrusteeEncodeForRound(5)...)
+
+	for _, n := range nodes {
+		if err := n.DCNetEntity.ScheduleEpoch(1, 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for roundID := int32(0); roundID < 10; roundID++ {
+		runRound(t, tg, roundID)
+		if epoch, start := trustee.Epoch(); (roundID < 5 && epoch != 0) || (roundID >= 5 && (epoch != 1 || start != 5)) {
+			t.Error("Wrong epoch", epoch, "starting at", start, "in round", roundID)
+		}
+	}
+
+	// the seeds of epoch 0 are erased, and its rounds cannot be encoded anymore
+	if !bytes.Equal(epoch0Seed, make([]byte, len(epoch0Seed))) {
+		t.Error("The seed of epoch 0 should be erased")
+	}
+	if bytes.Equal(epoch0Cipher, trustee.TrusteeEncodeForRound(5)) {
+		t.Error("The pads should change with the epoch")
+	}
+	if trustee.TrusteeEncodeForRound(4) != nil {
+		t.Error("A trustee should not encode a round of an erased epoch")
+	}
+	if _, _, err := tg.Clients[0].DCNetEntity.EncodeForRound(4, false, nil); !errors.Is(err, ErrWrongEpoch) {
+		t.Error("A client should not encode a round of an erased epoch, got", err)
+	}
+	if bits, _ := trustee.GetBitsOfRound(4, 0); bits != nil {
+		t.Error("GetBitsOfRound should refuse a round of an erased epoch")
+	}
+
+	// an old announcement is ignored, an epoch cannot start before the current one
+	if err := trustee.ScheduleEpoch(1, 8); err != nil {
+		t.Error(err)
+	}
+	if err := trustee.ScheduleEpoch(2, 5); !errors.Is(err, ErrWrongEpoch) {
+		t.Error("ScheduleEpoch should refuse an epoch starting before the current one, got", err)
+	}
+}
+
+func TestDCNetMissedEpochs(t *testing.T) {
+	tg := NewTestGroup(t, false, 50, 2, 1)
+	runRound(t, tg, 0)
+
+	// client 0 and the trustee go through epochs 1 and 2, client 1 misses them
+	for _, n := range []*TestNode{tg.Clients[0], tg.Trustees[0]} {
+		for epoch := int32(1); epoch <= 2; epoch++ {
+			if err := n.DCNetEntity.ScheduleEpoch(epoch, 2*epoch); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := n.DCNetEntity.EncodeForRound(2*epoch, false, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// everyone learns about epoch 3, client 1 ratchets three epochs at once
+	for _, n := range append(tg.Clients, tg.Trustees...) {
+		if err := n.DCNetEntity.ScheduleEpoch(3, 6); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runRound(t, tg, 6)
+	runRound(t, tg, 7)
+}
+
+func TestDCNetJoiningPeers(t *testing.T) {
+	tg := NewTestGroup(t, false, 50, 3, 2)
+	joining := tg.Clients[2]
+
+	// the trustees start with clients 0 and 1 only, client 2 joins at epoch 2, starting at round 6
+	running := &TestGroup{Relay: tg.Relay, Clients: tg.Clients[:2], Trustees: tg.Trustees}
+	for i, n := range tg.Trustees {
+		n.DCNetEntity = NewDCNetEntity(n.DCNetEntity.cryptoSuite, i, DCNET_TRUSTEE, 50, false, PAD_CIPHER_XOF, n.sharedSecrets[:2])
+	}
+	for _, n := range running.Clients {
+		if err := n.DCNetEntity.ScheduleEpoch(1, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range tg.Trustees {
+		if err := n.DCNetEntity.ScheduleEpoch(1, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for roundID := int32(0); roundID < 6; roundID++ {
+		runRound(t, running, roundID)
+	}
+
+	for _, n := range tg.Trustees {
+		if err := n.DCNetEntity.ScheduleEpochWithNewPeers(2, 6, n.sharedSecrets[2:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range tg.Clients {
+		if err := n.DCNetEntity.ScheduleEpoch(2, 6); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// epoch 3 is announced before anyone enters epoch 2
+	for _, n := range append(tg.Clients, tg.Trustees...) {
+		if err := n.DCNetEntity.ScheduleEpoch(3, 8); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the pads of client 2 start at round 6, it ratchets from epoch 0 to epoch 2 at once
+	for roundID := int32(6); roundID < 10; roundID++ {
+		runRound(t, tg, roundID)
+	}
+	if epoch, _ := joining.DCNetEntity.Epoch(); epoch != 3 {
+		t.Error("The joining client should be in epoch 3, got", epoch)
+	}
+
+	// the peers cannot join at an epoch already announced
+	if err := tg.Trustees[0].DCNetEntity.ScheduleEpochWithNewPeers(3, 12, tg.Trustees[0].sharedSecrets[2:]); !errors.Is(err, ErrWrongEpoch) {
+		t.Error("ScheduleEpochWithNewPeers should refuse the current epoch, got", err)
+	}
+}
+
+func TestDCNetDepartingPeers(t *testing.T) {
+	tg := NewTestGroup(t, false, 50, 3, 2)
+
+	for roundID := int32(0); roundID < 4; roundID++ {
+		runRound(t, tg, roundID)
+	}
+
+	// client 1 leaves at epoch 1, starting at round 6; it still takes part in the rounds 4 and 5
+	for _, n := range tg.Trustees {
+		if err := n.DCNetEntity.ScheduleEpochWithoutPeers(1, 6, []int{1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range tg.Clients {
+		if err := n.DCNetEntity.ScheduleEpoch(1, 6); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for roundID := int32(4); roundID < 6; roundID++ {
+		runRound(t, tg, roundID)
+	}
+
+	// the other peers keep their index, and decode without client 1
+	remaining := &TestGroup{Relay: tg.Relay, Clients: []*TestNode{tg.Clients[0], tg.Clients[2]}, Trustees: tg.Trustees}
+	for roundID := int32(6); roundID < 10; roundID++ {
+		runRound(t, remaining, roundID)
+	}
+	for _, n := range tg.Trustees {
+		if n.DCNetEntity.padSeeds[1] != nil {
+			t.Error("The seed shared with the client which left should be erased")
+		}
+	}
+
+	// the peers cannot leave at an epoch already announced, nor be unknown
+	if err := tg.Trustees[0].DCNetEntity.ScheduleEpochWithoutPeers(1, 12, []int{2}); !errors.Is(err, ErrWrongEpoch) {
+		t.Error("ScheduleEpochWithoutPeers should refuse the current epoch, got", err)
+	}
+	if err := tg.Trustees[0].DCNetEntity.ScheduleEpochWithoutPeers(2, 12, []int{3}); !errors.Is(err, ErrWrongEpoch) {
+		t.Error("ScheduleEpochWithoutPeers should refuse an unknown peer, got", err)
+	}
+}
+
+func TestEpochSeed(t *testing.T) {
+	tg := NewTestGroup(t, false, 50, 1, 1)
+	trustee := tg.Trustees[0]
+
+	if err := trustee.DCNetEntity.ScheduleEpoch(3, 10); err != nil {
+		t.Fatal(err)
+	}
+	trustee.DCNetEntity.TrusteeEncodeForRound(10)
+
+	seed, err := EpochSeed(trustee.sharedSecrets[0], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(seed, trustee.DCNetEntity.padSeeds[0]) {
+		t.Error("EpochSeed should give the seed of the epoch")
+	}
+	if bytes.Equal(seed, RatchetSeed(seed)) {
+		t.Error("RatchetSeed should change the seed")
+	}
+	sharedKey, _ := trustee.sharedSecrets[0].MarshalBinary()
+	if epoch0, _ := PadSeed(trustee.sharedSecrets[0]); bytes.Equal(epoch0, sharedKey) {
+		t.Error("PadSeed should not reveal the shared key")
+	}
+}
+
+func TestRevealPadSeed(t *testing.T) {
+	tg := NewTestGroup(t, false, 50, 1, 1)
+	trustee := tg.Trustees[0]
+
+	if err := trustee.DCNetEntity.ScheduleEpoch(1, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := trustee.DCNetEntity.ScheduleEpoch(2, 10); err != nil {
+		t.Fatal(err)
+	}
+	trustee.DCNetEntity.TrusteeEncodeForRound(5)
+
+	seed, err := trustee.DCNetEntity.RevealPadSeed(0, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := EpochSeed(trustee.sharedSecrets[0], 1)
+	if !bytes.Equal(seed, expected) {
+		t.Error("RevealPadSeed should give the seed of the epoch of the round")
+	}
+	seed[0] ^= 1
+	if bytes.Equal(seed, trustee.DCNetEntity.padSeeds[0]) {
+		t.Error("RevealPadSeed should return a copy of the seed")
+	}
+
+	// the seeds of epoch 0 are erased, the ones of epoch 2 are not derived yet
+	if _, err := trustee.DCNetEntity.RevealPadSeed(0, 4); !errors.Is(err, ErrWrongEpoch) {
+		t.Error("RevealPadSeed should refuse a round of an erased epoch, got", err)
+	}
+	if _, err := trustee.DCNetEntity.RevealPadSeed(0, 10); !errors.Is(err, ErrWrongEpoch) {
+		t.Error("RevealPadSeed should refuse a round of an epoch not entered yet, got", err)
+	}
+	if _, err := trustee.DCNetEntity.RevealPadSeed(1, 7); !errors.Is(err, ErrWrongEpoch) {
+		t.Error("RevealPadSeed should refuse an unknown peer, got", err)
+	}
+}
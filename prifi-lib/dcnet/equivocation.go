@@ -5,7 +5,7 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
-	"github.com/dedis/prifi/prifi-lib/config"
+	"fmt"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/onet/v3/log"
@@ -31,10 +31,10 @@ type EquivocationProtection struct {
 	suite      suites.Suite
 }
 
-// NewEquivocation creates the structure that handle equivocation protection
-func NewEquivocation() *EquivocationProtection {
+// NewEquivocation creates the structure that handle equivocation protection, in the given suite
+func NewEquivocation(suite suites.Suite) *EquivocationProtection {
 	e := new(EquivocationProtection)
-	e.suite = config.CryptoSuite
+	e.suite = suite
 	e.history = e.suite.Scalar().One()
 
 	randomKey := make([]byte, 32)
@@ -52,15 +52,26 @@ func (e *EquivocationProtection) hashInGroup(data []byte) kyber.Scalar {
 	return e.suite.Scalar().SetBytes(data)
 }
 
-// Update History adds those bits to the history hash chain
+// Update History adds those bits to the history hash chain : history = H(history || data), mapped in the group
 func (e *EquivocationProtection) UpdateHistory(data []byte) {
 	historyB, err := e.history.MarshalBinary()
 	if err != nil {
 		log.Fatal("Could not unmarshall bytes", err)
 	}
-	toBeHashed := make([]byte, len(historyB)+len(data))
-	newPayload := sha256.Sum256(toBeHashed)
-	e.history.SetBytes(newPayload[:])
+	h := sha256.New()
+	h.Write(historyB)
+	h.Write(data)
+	e.history.SetBytes(h.Sum(nil))
+}
+
+// History returns the history hash chain, to be handed to a client joining the DC-net
+func (e *EquivocationProtection) History() ([]byte, error) {
+	return e.history.MarshalBinary()
+}
+
+// SetHistory replaces the history hash chain by "history", as returned by History
+func (e *EquivocationProtection) SetHistory(history []byte) error {
+	return e.history.UnmarshalBinary(history)
 }
 
 // a function that takes a payload x, encrypt it as x' = x + k, and returns x' and kappa = k + history * (sum of the (hashes of pads))
@@ -158,8 +169,9 @@ func (e *EquivocationProtection) TrusteeProve() []byte {
 	return nil
 }
 
-// given all contributions, decodes the payload
-func (e *EquivocationProtection) RelayDecode(encryptedPayload []byte, trusteesContributions [][]byte, clientsContributions [][]byte) []byte {
+// given all contributions and the history of the round, decodes the payload. Returns an error wrapping ErrEquivocation
+// if the payload cannot be decrypted, e.g. because the clients did not receive the same downstream data as the relay
+func (e *EquivocationProtection) RelayDecode(history kyber.Scalar, encryptedPayload []byte, trusteesContributions [][]byte, clientsContributions [][]byte) ([]byte, error) {
 
 	//reconstitute the abstract.Point values
 	trustee_kappa_j := make([]kyber.Scalar, len(trusteesContributions))
@@ -184,7 +196,7 @@ func (e *EquivocationProtection) RelayDecode(encryptedPayload []byte, trusteesCo
 		sumClients = sumClients.Add(sumClients, v)
 	}
 
-	prod := sumTrustees.Mul(sumTrustees, e.history)
+	prod := sumTrustees.Mul(sumTrustees, history)
 	k_i := sumClients.Sub(sumClients, prod)
 
 	//now use k to decrypt the payload
@@ -204,10 +216,10 @@ func (e *EquivocationProtection) RelayDecode(encryptedPayload []byte, trusteesCo
 		}
 		log.Lvl1("sumTrustees:", sumTrustees)
 		log.Lvl1("sumClients:", sumClients)
-		log.Lvl1("history:", e.history)
+		log.Lvl1("history:", history)
 		log.Lvl1("prod:", prod)
 		log.Lvl1("k_i:", k_i)
-		return make([]byte, 0)
+		return make([]byte, 0), fmt.Errorf("%w: could not recover the key of the slot owner", ErrEquivocation)
 	}
 
 	// decrypt the payload
@@ -225,9 +237,8 @@ func (e *EquivocationProtection) RelayDecode(encryptedPayload []byte, trusteesCo
 
 	message, err := aesgcm.Open(nil, nonce, encryptedPayload, nil)
 	if err != nil {
-		//TODO: Bubble up this disruption in the client
-		message = make([]byte, len(encryptedPayload)-16)
+		return make([]byte, len(encryptedPayload)-16), fmt.Errorf("%w: %v", ErrEquivocation, err)
 	}
 
-	return message
+	return message, nil
 }
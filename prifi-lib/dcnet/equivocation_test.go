@@ -25,9 +25,9 @@ func TestEquivocation(t *testing.T) {
 func equivocationTestForDataLength(t *testing.T, payloadSize int) {
 
 	// set up the Shared secrets
-	tpub, _ := crypto.NewKeyPair()
-	_, c1priv := crypto.NewKeyPair()
-	_, c2priv := crypto.NewKeyPair()
+	tpub, _ := crypto.NewKeyPair(config.CryptoSuite)
+	_, c1priv := crypto.NewKeyPair(config.CryptoSuite)
+	_, c2priv := crypto.NewKeyPair(config.CryptoSuite)
 
 	sharedSecret_c1 := make([]kyber.Point, 1)
 	sharedSecret_c1[0] = config.CryptoSuite.Point().Mul(c1priv, tpub)
@@ -39,18 +39,18 @@ func equivocationTestForDataLength(t *testing.T, payloadSize int) {
 	sharedSecrets_t[1] = config.CryptoSuite.Point().Mul(c2priv, tpub)
 
 	// set up the DC-nets
-	dcnet_Trustee := NewDCNetEntity(0, DCNET_TRUSTEE, payloadSize, false, sharedSecrets_t)
-	dcnet_Client1 := NewDCNetEntity(0, DCNET_CLIENT, payloadSize, false, sharedSecret_c1)
-	dcnet_Client2 := NewDCNetEntity(1, DCNET_CLIENT, payloadSize, false, sharedSecret_c2)
+	dcnet_Trustee := NewDCNetEntity(config.CryptoSuite, 0, DCNET_TRUSTEE, payloadSize, false, PAD_CIPHER_XOF, sharedSecrets_t)
+	dcnet_Client1 := NewDCNetEntity(config.CryptoSuite, 0, DCNET_CLIENT, payloadSize, false, PAD_CIPHER_XOF, sharedSecret_c1)
+	dcnet_Client2 := NewDCNetEntity(config.CryptoSuite, 1, DCNET_CLIENT, payloadSize, false, PAD_CIPHER_XOF, sharedSecret_c2)
 
 	data := randomBytes(payloadSize)
 
 	// get the pads
-	padRound2_t := DCNetCipherFromBytes(dcnet_Trustee.TrusteeEncodeForRound(0))
-	encode_for_round_1, _ := dcnet_Client1.EncodeForRound(0, true, data)
-	padRound1_c1 := DCNetCipherFromBytes(encode_for_round_1)
-	encode_for_round_2, _ := dcnet_Client2.EncodeForRound(0, false, nil)
-	padRound1_c2 := DCNetCipherFromBytes(encode_for_round_2)
+	padRound2_t, _ := DCNetCipherFromBytes(dcnet_Trustee.TrusteeEncodeForRound(0))
+	encode_for_round_1, _, _ := dcnet_Client1.EncodeForRound(0, true, data)
+	padRound1_c1, _ := DCNetCipherFromBytes(encode_for_round_1)
+	encode_for_round_2, _, _ := dcnet_Client2.EncodeForRound(0, false, nil)
+	padRound1_c2, _ := DCNetCipherFromBytes(encode_for_round_2)
 
 	res := make([]byte, payloadSize)
 	for i := range padRound1_c2.Payload {
@@ -70,10 +70,10 @@ func equivocationTestForDataLength(t *testing.T, payloadSize int) {
 
 	payload := randomBytes(payloadSize)
 
-	e_client0 := NewEquivocation()
-	e_client1 := NewEquivocation()
-	e_trustee := NewEquivocation()
-	e_relay := NewEquivocation()
+	e_client0 := NewEquivocation(config.CryptoSuite)
+	e_client1 := NewEquivocation(config.CryptoSuite)
+	e_trustee := NewEquivocation(config.CryptoSuite)
+	e_relay := NewEquivocation(config.CryptoSuite)
 
 	// set some data as downstream history
 
@@ -108,7 +108,10 @@ func equivocationTestForDataLength(t *testing.T, payloadSize int) {
 	clientContrib[0] = kappa1
 	clientContrib[1] = kappa2
 
-	payloadPlaintext := e_relay.RelayDecode(x_prim1, trusteesContrib, clientContrib)
+	payloadPlaintext, err := e_relay.RelayDecode(e_relay.history, x_prim1, trusteesContrib, clientContrib)
+	if err != nil {
+		t.Error(err)
+	}
 
 	if bytes.Compare(payload, payloadPlaintext) != 0 {
 		log.Lvl1(payload)
@@ -116,3 +119,23 @@ func equivocationTestForDataLength(t *testing.T, payloadSize int) {
 		t.Error("payloads don't match")
 	}
 }
+
+func TestEquivocationHistory(t *testing.T) {
+	e := NewEquivocation(config.CryptoSuite)
+	e2 := NewEquivocation(config.CryptoSuite)
+	initial := e.history.Clone()
+
+	e.UpdateHistory([]byte{1, 2, 3})
+	if e.history.Equal(initial) {
+		t.Error("UpdateHistory should change the history")
+	}
+	e2.UpdateHistory([]byte{1, 2, 4})
+	if e.history.Equal(e2.history) {
+		t.Error("The history should depend on the data")
+	}
+	e2.history.Set(initial)
+	e2.UpdateHistory([]byte{1, 2, 3})
+	if !e.history.Equal(e2.history) {
+		t.Error("The same data should give the same history")
+	}
+}
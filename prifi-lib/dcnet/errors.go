@@ -0,0 +1,29 @@
+package dcnet
+
+import "errors"
+
+// Errors returned when encoding or decoding DC-net ciphers. They are wrapped with the details
+// of the failure, test them with errors.Is
+var (
+	// ErrMalformedCipher is returned when some bytes cannot be parsed as a DCNetCipher
+	ErrMalformedCipher = errors.New("malformed DC-net cipher")
+
+	// ErrWrongPayloadLength is returned when the payload of a cipher does not have the DC-net payload size
+	ErrWrongPayloadLength = errors.New("wrong DC-net payload length")
+
+	// ErrPayloadTooLong is returned when encoding a payload longer than the DC-net payload size
+	ErrPayloadTooLong = errors.New("DC-net payload too long")
+
+	// ErrWrongRound is returned when decoding a contribution for another round than the one being decoded
+	ErrWrongRound = errors.New("wrong DC-net round")
+
+	// ErrNotDecoding is returned when decoding a contribution before DecodeStart
+	ErrNotDecoding = errors.New("DC-net decoding not started")
+
+	// ErrWrongEpoch is returned when encoding a round of an epoch already erased, or when scheduling an epoch in the past
+	ErrWrongEpoch = errors.New("wrong DC-net epoch")
+
+	// ErrEquivocation is returned when the payload protected against equivocation cannot be decrypted, which happens
+	// when the history of downstream data of the slot owner differs from the relay's
+	ErrEquivocation = errors.New("DC-net equivocation detected")
+)
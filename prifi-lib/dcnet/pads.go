@@ -0,0 +1,154 @@
+package dcnet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Names of the pad ciphers, as used in prifi.toml and in ALL_ALL_PARAMETERS
+const (
+	// Pads read from the suite's XOF (BLAKE2-based for Ed25519)
+	PAD_CIPHER_XOF = "XOF"
+
+	// Pads from AES-256 in counter mode (AES-NI where available)
+	PAD_CIPHER_AES_CTR = "AES-CTR"
+
+	// Pads from the ChaCha20 stream cipher
+	PAD_CIPHER_CHACHA20 = "ChaCha20"
+)
+
+// domain separation between the DC-net pads and the other uses of the shared keys
+const padDomain = "dcnet-pad"
+
+// domain separation between the seeds of the pads and the other uses of the shared keys
+const padSeedDomain = "dcnet-pad-seed"
+
+// PadCipher generates the pads shared between a client and a trustee. It is keyed once with the
+// shared seed, then the pad of any round, past or future, is generated in constant time without
+// replaying the previous rounds.
+type PadCipher interface {
+	// Name returns the name of the pad cipher, one of the PAD_CIPHER_* constants
+	Name() string
+
+	// Pad fills "pad" with the pad of the round "roundID"
+	Pad(roundID int32, pad []byte)
+}
+
+// PadSeed derives the seed of the pads of epoch 0 from a key shared between a client and a trustee. The key cannot be
+// recovered from the seed
+func PadSeed(sharedKey kyber.Point) ([]byte, error) {
+	sharedKeyBytes, err := sharedKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte(padSeedDomain))
+	h.Write(sharedKeyBytes)
+	return h.Sum(nil), nil
+}
+
+// ValidatePadCipher returns an error if "name" is not a known pad cipher
+func ValidatePadCipher(name string) error {
+	switch name {
+	case PAD_CIPHER_XOF, PAD_CIPHER_AES_CTR, PAD_CIPHER_CHACHA20:
+		return nil
+	}
+	return errors.New("Unknown pad cipher " + name + ", should be " + PAD_CIPHER_XOF + ", " + PAD_CIPHER_AES_CTR + " or " + PAD_CIPHER_CHACHA20)
+}
+
+// NewPadCipher returns the pad cipher "name", keyed with "seed"
+func NewPadCipher(name string, suite suites.Suite, seed []byte) (PadCipher, error) {
+	switch name {
+	case PAD_CIPHER_XOF:
+		return &xofPadCipher{suite: suite, seed: seed}, nil
+	case PAD_CIPHER_AES_CTR:
+		block, err := aes.NewCipher(padKey(seed))
+		if err != nil {
+			return nil, err
+		}
+		return &aesCTRPadCipher{block: block}, nil
+	case PAD_CIPHER_CHACHA20:
+		return &chacha20PadCipher{key: padKey(seed)}, nil
+	}
+	return nil, ValidatePadCipher(name)
+}
+
+// padKey derives a 256-bit symmetric key from the shared seed
+func padKey(seed []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(padDomain))
+	h.Write(seed)
+	return h.Sum(nil)
+}
+
+// xofPadCipher reads the pad of round r from XOF(seed || padDomain || r)
+type xofPadCipher struct {
+	suite suites.Suite
+	seed  []byte
+}
+
+func (c *xofPadCipher) Name() string {
+	return PAD_CIPHER_XOF
+}
+
+func (c *xofPadCipher) Pad(roundID int32, pad []byte) {
+	input := make([]byte, len(c.seed)+len(padDomain)+4)
+	copy(input, c.seed)
+	copy(input[len(c.seed):], padDomain)
+	binary.BigEndian.PutUint32(input[len(c.seed)+len(padDomain):], uint32(roundID))
+
+	c.suite.XOF(input).Read(pad)
+}
+
+// aesCTRPadCipher is AES-256-CTR, the round number fills the high bytes of the IV
+// and the low 64 bits are the block counter
+type aesCTRPadCipher struct {
+	block cipher.Block
+}
+
+func (c *aesCTRPadCipher) Name() string {
+	return PAD_CIPHER_AES_CTR
+}
+
+func (c *aesCTRPadCipher) Pad(roundID int32, pad []byte) {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv, uint32(roundID))
+
+	clearBytes(pad)
+	cipher.NewCTR(c.block, iv).XORKeyStream(pad, pad)
+}
+
+// chacha20PadCipher is ChaCha20 (RFC 7539), the round number is the nonce
+type chacha20PadCipher struct {
+	key []byte
+}
+
+func (c *chacha20PadCipher) Name() string {
+	return PAD_CIPHER_CHACHA20
+}
+
+func (c *chacha20PadCipher) Pad(roundID int32, pad []byte) {
+	nonce := make([]byte, chacha20.NonceSize)
+	binary.BigEndian.PutUint32(nonce, uint32(roundID))
+
+	s, err := chacha20.NewUnauthenticatedCipher(c.key, nonce)
+	if err != nil {
+		// cannot happen, the key and the nonce have the right size
+		panic("ChaCha20: " + err.Error())
+	}
+	clearBytes(pad)
+	s.XORKeyStream(pad, pad)
+}
+
+// clearBytes sets all the bytes of b to zero, since stream ciphers only XOR their keystream
+func clearBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
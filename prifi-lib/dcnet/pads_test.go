@@ -0,0 +1,162 @@
+package dcnet
+
+import (
+	"bytes"
+	"github.com/dedis/prifi/prifi-lib/config"
+	"testing"
+)
+
+var padCiphers = []string{PAD_CIPHER_XOF, PAD_CIPHER_AES_CTR, PAD_CIPHER_CHACHA20}
+
+func newPadCipher(t testing.TB, name string, seed []byte) PadCipher {
+	c, err := NewPadCipher(name, config.CryptoSuite, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// returns the pad of "length" bytes of the round "roundID"
+func pad(c PadCipher, roundID int32, length int) []byte {
+	p := make([]byte, length)
+	c.Pad(roundID, p)
+	return p
+}
+
+func TestPadCiphers(t *testing.T) {
+	seed := randomBytes(32)
+
+	for _, name := range padCiphers {
+		c := newPadCipher(t, name, seed)
+		if c.Name() != name {
+			t.Error("Wrong name", c.Name(), "for", name)
+		}
+
+		p1 := pad(c, 7, 100)
+		if !bytes.Equal(p1, pad(newPadCipher(t, name, seed), 7, 100)) {
+			t.Error(name, "should be deterministic")
+		}
+		if bytes.Equal(p1, make([]byte, 100)) {
+			t.Error(name, "should not return zeros")
+		}
+		if bytes.Equal(p1, pad(c, 8, 100)) {
+			t.Error(name, ": two rounds should have different pads")
+		}
+		if bytes.Equal(p1, pad(newPadCipher(t, name, randomBytes(32)), 7, 100)) {
+			t.Error(name, ": two seeds should give different pads")
+		}
+		if !bytes.Equal(p1[:50], pad(c, 7, 50)) {
+			t.Error(name, ": a shorter pad should be a prefix of the longer one")
+		}
+	}
+
+	// the ciphers are not interchangeable
+	if bytes.Equal(pad(newPadCipher(t, PAD_CIPHER_AES_CTR, seed), 0, 32), pad(newPadCipher(t, PAD_CIPHER_CHACHA20, seed), 0, 32)) {
+		t.Error("AES-CTR and ChaCha20 should give different pads")
+	}
+
+	if _, err := NewPadCipher("RC4", config.CryptoSuite, seed); err == nil {
+		t.Error("NewPadCipher should refuse an unknown cipher")
+	}
+	if ValidatePadCipher("RC4") == nil || ValidatePadCipher(PAD_CIPHER_CHACHA20) != nil {
+		t.Error("ValidatePadCipher is wrong")
+	}
+}
+
+func TestDCNetRoundsOutOfOrder(t *testing.T) {
+	payloadSize := 50
+	rounds := []int32{5, 2, 1000, 3, 0, 5}
+
+	for _, name := range padCiphers {
+		tg := NewTestGroup(t, false, payloadSize, 2, 2)
+		for _, n := range append(tg.Clients, tg.Trustees...) {
+			n.DCNetEntity = NewDCNetEntity(config.CryptoSuite, n.DCNetEntity.EntityID, n.DCNetEntity.Entity, payloadSize, false, name, n.sharedSecrets)
+		}
+
+		for _, roundID := range rounds {
+			message := randomBytes(payloadSize)
+			tg.Relay.DCNetEntity.DecodeStart(roundID)
+			for i := range tg.Clients {
+				var m []byte
+				if i == 0 {
+					m, _, _ = tg.Clients[i].DCNetEntity.EncodeForRound(roundID, true, message)
+				} else {
+					m, _, _ = tg.Clients[i].DCNetEntity.EncodeForRound(roundID, false, nil)
+				}
+				if err := tg.Relay.DCNetEntity.DecodeClient(roundID, m); err != nil {
+					t.Error(err)
+				}
+			}
+			for i := range tg.Trustees {
+				if err := tg.Relay.DCNetEntity.DecodeTrustee(roundID, tg.Trustees[i].DCNetEntity.TrusteeEncodeForRound(roundID)); err != nil {
+					t.Error(err)
+				}
+			}
+			output, _, err := tg.Relay.DCNetEntity.DecodeCell(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(output, message) {
+				t.Error("DC-net encoding with", name, "failed for round", roundID)
+			}
+		}
+	}
+}
+
+func TestGetBitsOfRoundKeepsState(t *testing.T) {
+	tg := NewTestGroup(t, false, 20, 1, 2)
+	trustee := tg.Trustees[0].DCNetEntity
+
+	if bits, _ := trustee.GetBitsOfRound(0, 3); bits != nil {
+		t.Error("GetBitsOfRound should refuse a round not encoded yet")
+	}
+
+	cipher0 := trustee.TrusteeEncodeForRound(0)
+	cipher1 := trustee.TrusteeEncodeForRound(1)
+
+	_, pads := trustee.GetBitsOfRound(0, 3)
+	if len(pads) != 1 || !bytes.Equal(pads[0], pad(trustee.padCiphers[0], 0, 20)) {
+		t.Error("GetBitsOfRound returned the wrong pads")
+	}
+
+	// re-encoding after the lookup gives the same ciphers, and the next round is still available
+	if !bytes.Equal(cipher1, trustee.TrusteeEncodeForRound(1)) || !bytes.Equal(cipher0, trustee.TrusteeEncodeForRound(0)) {
+		t.Error("GetBitsOfRound changed the pads of the trustee")
+	}
+	if bits, _ := trustee.GetBitsOfRound(1, 3); bits == nil {
+		t.Error("GetBitsOfRound should accept a round already encoded")
+	}
+}
+
+func benchmarkPadCipher(b *testing.B, name string, payloadSize int) {
+	c := newPadCipher(b, name, randomBytes(32))
+	buffer := make([]byte, payloadSize)
+	b.SetBytes(int64(payloadSize))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Pad(int32(i), buffer)
+	}
+}
+
+func BenchmarkPadXOF1KB(b *testing.B)        { benchmarkPadCipher(b, PAD_CIPHER_XOF, 1000) }
+func BenchmarkPadAESCTR1KB(b *testing.B)     { benchmarkPadCipher(b, PAD_CIPHER_AES_CTR, 1000) }
+func BenchmarkPadChaCha20_1KB(b *testing.B)  { benchmarkPadCipher(b, PAD_CIPHER_CHACHA20, 1000) }
+func BenchmarkPadXOF64KB(b *testing.B)       { benchmarkPadCipher(b, PAD_CIPHER_XOF, 64000) }
+func BenchmarkPadAESCTR64KB(b *testing.B)    { benchmarkPadCipher(b, PAD_CIPHER_AES_CTR, 64000) }
+func BenchmarkPadChaCha20_64KB(b *testing.B) { benchmarkPadCipher(b, PAD_CIPHER_CHACHA20, 64000) }
+
+// a trustee encoding a large cell for 50 clients
+func benchmarkTrusteeEncode(b *testing.B, name string) {
+	tg := NewTestGroup(b, false, 5000, 50, 1)
+	trustee := tg.Trustees[0]
+	trustee.DCNetEntity = NewDCNetEntity(config.CryptoSuite, 0, DCNET_TRUSTEE, 5000, false, name, trustee.sharedSecrets)
+	b.SetBytes(int64(5000 * 50))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trustee.DCNetEntity.TrusteeEncodeForRound(int32(i))
+	}
+}
+
+func BenchmarkTrusteeEncodeXOF(b *testing.B)      { benchmarkTrusteeEncode(b, PAD_CIPHER_XOF) }
+func BenchmarkTrusteeEncodeAESCTR(b *testing.B)   { benchmarkTrusteeEncode(b, PAD_CIPHER_AES_CTR) }
+func BenchmarkTrusteeEncodeChaCha20(b *testing.B) { benchmarkTrusteeEncode(b, PAD_CIPHER_CHACHA20) }
@@ -0,0 +1,74 @@
+package dcnet
+
+import (
+	"encoding/binary"
+)
+
+/*
+Variable-length slots. The owner of a slot asks, in the last SLOT_LENGTH_FIELD_LENGTH bytes of its payload, for the
+length of the payload of its next slot. The relay announces the length of each round to the clients and the trustees,
+which encode the round with pads of that length. The pads of a round are the prefix of the same stream whatever their
+length, hence a round can be encoded again with another length.
+The rounds whose length is not set have DCNetPayloadSize bytes.
+*/
+
+// SLOT_LENGTH_FIELD_LENGTH is the length of the field in which the owner of a slot asks for the length of its next slot
+const SLOT_LENGTH_FIELD_LENGTH = 4
+
+// SlotLengthOverhead returns the shortest length of a slot : the field asking for the next slot, the b_echo_last flag of
+// the disruption protection and the tag of the equivocation protection, when enabled
+func SlotLengthOverhead(disruptionProtection, equivocationProtection bool) int {
+	overhead := SLOT_LENGTH_FIELD_LENGTH
+	if disruptionProtection {
+		overhead++
+	}
+	if equivocationProtection {
+		overhead += 16
+	}
+	return overhead
+}
+
+// SetPayloadSizeOfRound sets the length of the payload of the round "roundID"
+func (e *DCNetEntity) SetPayloadSizeOfRound(roundID int32, size int) {
+	if size <= 0 || size == e.DCNetPayloadSize {
+		delete(e.roundPayloadSizes, roundID)
+		return
+	}
+	e.roundPayloadSizes[roundID] = size
+}
+
+// PayloadSizeOfRound returns the length of the payload of the round "roundID"
+func (e *DCNetEntity) PayloadSizeOfRound(roundID int32) int {
+	if size, found := e.roundPayloadSizes[roundID]; found {
+		return size
+	}
+	return e.DCNetPayloadSize
+}
+
+// forgetPayloadSizesBefore forgets the lengths set for the rounds before "roundID"
+func (e *DCNetEntity) forgetPayloadSizesBefore(roundID int32) {
+	for r := range e.roundPayloadSizes {
+		if r < roundID {
+			delete(e.roundPayloadSizes, r)
+		}
+	}
+}
+
+// PutNextSlotLength returns "payload" padded to "size" bytes, the last ones asking for "nextLength" bytes in the next slot.
+// "payload" must be at most "size" - SLOT_LENGTH_FIELD_LENGTH bytes long
+func PutNextSlotLength(payload []byte, size int, nextLength int) []byte {
+	out := make([]byte, size)
+	copy(out, payload)
+	binary.BigEndian.PutUint32(out[size-SLOT_LENGTH_FIELD_LENGTH:], uint32(nextLength))
+	return out
+}
+
+// NextSlotLength returns the length asked for the next slot in the decoded "payload", and the payload without it.
+// Returns 0 if the payload is too short to hold the field
+func NextSlotLength(payload []byte) (int, []byte) {
+	if len(payload) < SLOT_LENGTH_FIELD_LENGTH {
+		return 0, payload
+	}
+	fieldStart := len(payload) - SLOT_LENGTH_FIELD_LENGTH
+	return int(binary.BigEndian.Uint32(payload[fieldStart:])), payload[:fieldStart]
+}
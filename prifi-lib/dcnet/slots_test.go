@@ -0,0 +1,85 @@
+package dcnet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVariableLengthSlots(t *testing.T) {
+	for _, equivocation := range []bool{false, true} {
+		tg := NewTestGroup(t, equivocation, 100, 2, 2)
+
+		// a short round, a long round, and a round of the default length
+		for roundID, size := range map[int32]int{0: 30, 1: 250, 2: 0} {
+			expectedSize := size
+			if size == 0 {
+				expectedSize = 100
+			}
+			message := randomBytes(expectedSize - 16)
+
+			tg.Relay.DCNetEntity.SetPayloadSizeOfRound(roundID, size)
+			tg.Relay.DCNetEntity.DecodeStart(roundID)
+
+			for i := range tg.Clients {
+				tg.Clients[i].DCNetEntity.SetPayloadSizeOfRound(roundID, size)
+				var payload []byte
+				if i == 0 {
+					payload = message
+				}
+				m, _, err := tg.Clients[i].DCNetEntity.EncodeForRound(roundID, i == 0, payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := tg.Relay.DCNetEntity.DecodeClient(roundID, m); err != nil {
+					t.Error(err)
+				}
+			}
+			for i := range tg.Trustees {
+				// the trustee first encodes the round with the default length, then again once told the length
+				m := tg.Trustees[i].DCNetEntity.TrusteeEncodeForRound(roundID)
+				if size != 0 {
+					err := tg.Relay.DCNetEntity.DecodeTrustee(roundID, m)
+					if !errors.Is(err, ErrWrongPayloadLength) {
+						t.Error("a cipher of the wrong length should be rejected, got", err)
+					}
+					tg.Trustees[i].DCNetEntity.SetPayloadSizeOfRound(roundID, size)
+					m = tg.Trustees[i].DCNetEntity.TrusteeEncodeForRound(roundID)
+				}
+				if err := tg.Relay.DCNetEntity.DecodeTrustee(roundID, m); err != nil {
+					t.Error(err)
+				}
+			}
+
+			output, _, err := tg.Relay.DCNetEntity.DecodeCell(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equivocation {
+				output = output[:len(message)]
+			}
+			if !bytes.Equal(output, message) {
+				t.Error("DC-net encoding failed for a payload of", expectedSize, "bytes, equivocation", equivocation)
+			}
+		}
+	}
+}
+
+func TestNextSlotLength(t *testing.T) {
+	payload := PutNextSlotLength([]byte{1, 2, 3}, 10, 4242)
+	if len(payload) != 10 {
+		t.Error("the payload should be padded to 10 bytes, got", len(payload))
+	}
+
+	length, data := NextSlotLength(payload)
+	if length != 4242 {
+		t.Error("the next slot length should be 4242, got", length)
+	}
+	if !bytes.Equal(data, []byte{1, 2, 3, 0, 0, 0}) {
+		t.Error("the field should be removed from the payload, got", data)
+	}
+
+	if length, _ := NextSlotLength([]byte{1}); length != 0 {
+		t.Error("a payload too short has no next slot length, got", length)
+	}
+}
@@ -0,0 +1,533 @@
+package dcnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/onet/v3/log"
+	"strconv"
+)
+
+// Verifiable DC-net (in the spirit of Verdict)
+//
+// Instead of XORing byte pads, the payload is cut in chunks of EmbedLen() bytes, and each chunk is embedded in a
+// group element. For round r and chunk c, everyone derives the same generator H_rc, whose discrete log is unknown.
+// Client i and trustee j share a scalar s_ij, derived from their Diffie-Hellman secret.
+//
+// Clients compute:
+// V_ic = k_i * H_rc (+ M_c if slot owner), where k_i = SUM_j(s_ij)
+//
+// Trustees compute:
+// T_jc = -t_j * H_rc, where t_j = SUM_i(s_ij)
+//
+// Relay compute:
+// M_c = SUM_i(V_ic) + SUM_j(T_jc)
+//
+// During the shuffle, each trustee j publishes S_ij = s_ij * G for all clients i (its "verifiable DC-net key"), so the
+// relay knows K_i = SUM_j(S_ij) = k_i * G. Along with its cell, each client sends a NIZK proof of
+// [ log_G(K_i) = log_H_rc(V_ic) for all c ] OR [ knowledge of x such that P_owner = x * B ],
+// where B is the base and P_owner the pseudonym of the slot owner output by the shuffle. A non-owner can only prove
+// the first statement, hence cannot embed anything in the cell; the relay checks the proof before decoding the cell.
+
+// verifiableDCNet holds the state of the verifiable DC-net of one entity
+type verifiableDCNet struct {
+	suite     suites.Suite
+	chunkSize int
+	nChunks   int
+
+	//clients and trustees only
+	secret      kyber.Scalar   // k_i for a client, t_j for a trustee
+	peerSecrets []kyber.Scalar // s_ij, for each peer
+
+	//set after the shuffle
+	base            kyber.Point
+	pseudonyms      []kyber.Point
+	pseudonymSecret kyber.Scalar // nil for the relay
+	mySlot          int          // -1 for the relay
+
+	//relay only, K_i for each client i
+	verificationKeys []kyber.Point
+
+	//generators H_rc of the last round used
+	generatorsRound int32
+	generators      []kyber.Point
+}
+
+// NewVerifiableDCNetEntity creates a DCNetEntity using the verifiable DC-net. Used by clients, trustees and the relay.
+func NewVerifiableDCNetEntity(
+	suite suites.Suite,
+	entityID int,
+	entity DCNET_ENTITY,
+	PayloadSize int,
+	sharedKeys []kyber.Point) *DCNetEntity {
+
+	e := NewDCNetEntity(suite, entityID, entity, PayloadSize, false, PAD_CIPHER_XOF, sharedKeys)
+
+	v := new(verifiableDCNet)
+	v.suite = suite
+	v.chunkSize = v.suite.Point().EmbedLen()
+	v.nChunks = (PayloadSize + v.chunkSize - 1) / v.chunkSize
+	v.mySlot = -1
+	v.generatorsRound = -1
+
+	if entity != DCNET_RELAY {
+		v.peerSecrets = make([]kyber.Scalar, len(sharedKeys))
+		v.secret = v.suite.Scalar().Zero()
+		for i := range sharedKeys {
+			seed, err := sharedKeys[i].MarshalBinary()
+			if err != nil {
+				log.Fatal("Could not extract data from shared key", err)
+			}
+			seed = append([]byte("verifiable-dcnet"), seed...)
+			v.peerSecrets[i] = v.suite.Scalar().Pick(v.suite.XOF(seed))
+			v.secret.Add(v.secret, v.peerSecrets[i])
+		}
+		if entity == DCNET_TRUSTEE {
+			v.secret.Neg(v.secret)
+		}
+	}
+
+	e.verifiable = v
+	return e
+}
+
+// IsVerifiable returns true if this entity uses the verifiable DC-net
+func (e *DCNetEntity) IsVerifiable() bool {
+	return e.verifiable != nil
+}
+
+// VerifiableDCNetKey is used by the trustees, and returns S_ij = s_ij * G for all clients i, which the relay needs to check
+// the clients' proofs
+func (e *DCNetEntity) VerifiableDCNetKey() ([]byte, error) {
+	if e.verifiable == nil || e.Entity != DCNET_TRUSTEE {
+		return nil, errors.New("only trustees using the verifiable DC-net have a verifiable DC-net key")
+	}
+	v := e.verifiable
+
+	out := make([]byte, 0)
+	for i := range v.peerSecrets {
+		b, err := v.suite.Point().Mul(v.peerSecrets[i], nil).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// SetVerifiableDCNetKeys is used by the relay, and combines the verifiable DC-net keys of all trustees into the
+// verification key K_i of each client
+func (e *DCNetEntity) SetVerifiableDCNetKeys(trusteesKeys [][]byte, nClients int) error {
+	if e.verifiable == nil {
+		return errors.New("this DC-net is not verifiable")
+	}
+	v := e.verifiable
+	pointSize := v.suite.PointLen()
+
+	keys := make([]kyber.Point, nClients)
+	for i := range keys {
+		keys[i] = v.suite.Point().Null()
+	}
+	for j, trusteeKey := range trusteesKeys {
+		if len(trusteeKey) != nClients*pointSize {
+			return errors.New("verifiable DC-net key of trustee " + strconv.Itoa(j) + " has length " +
+				strconv.Itoa(len(trusteeKey)) + ", expected " + strconv.Itoa(nClients*pointSize))
+		}
+		for i := range keys {
+			S_ij := v.suite.Point()
+			if err := S_ij.UnmarshalBinary(trusteeKey[i*pointSize : (i+1)*pointSize]); err != nil {
+				return errors.New("verifiable DC-net key of trustee " + strconv.Itoa(j) + " is invalid, " + err.Error())
+			}
+			keys[i].Add(keys[i], S_ij)
+		}
+	}
+
+	v.verificationKeys = keys
+	return nil
+}
+
+// SetPseudonyms stores the base and the (shuffled) pseudonyms which define the slot owners. Clients also give the
+// secret of their own pseudonym, the relay gives nil.
+func (e *DCNetEntity) SetPseudonyms(base kyber.Point, pseudonyms []kyber.Point, pseudonymSecret kyber.Scalar) {
+	if e.verifiable == nil {
+		return
+	}
+	v := e.verifiable
+	v.base = base
+	v.pseudonyms = pseudonyms
+	v.pseudonymSecret = pseudonymSecret
+	v.mySlot = -1
+
+	if pseudonymSecret != nil {
+		myPseudonym := v.suite.Point().Mul(pseudonymSecret, base)
+		for slot := range pseudonyms {
+			if pseudonyms[slot].Equal(myPseudonym) {
+				v.mySlot = slot
+			}
+		}
+	}
+}
+
+// EncodeVerifiableForRound is used by clients, and encodes "payload" in round "roundID", whose slot owner is
+// "ownerSlot" (-1 if no-one owns this round). The payload is ignored if we are not the slot owner.
+func (e *DCNetEntity) EncodeVerifiableForRound(roundID int32, ownerSlot int, payload []byte) ([]byte, error) {
+	if e.verifiable == nil || e.Entity != DCNET_CLIENT {
+		return nil, errors.New("only clients using the verifiable DC-net can call EncodeVerifiableForRound")
+	}
+	if len(payload) > e.DCNetPayloadSize {
+		return nil, errors.New("DCNet: cannot encode Payload of length " + strconv.Itoa(len(payload)) +
+			" max length is " + strconv.Itoa(e.DCNetPayloadSize))
+	}
+	v := e.verifiable
+	if ownerSlot >= len(v.pseudonyms) {
+		return nil, errors.New("DCNet: unknown slot owner " + strconv.Itoa(ownerSlot))
+	}
+	slotOwner := ownerSlot >= 0 && ownerSlot == v.mySlot
+
+	generators := v.generatorsForRound(roundID)
+	cells := make([]kyber.Point, v.nChunks)
+	for c := range cells {
+		cells[c] = v.suite.Point().Mul(v.secret, generators[c])
+	}
+
+	if slotOwner {
+		padded := make([]byte, e.DCNetPayloadSize)
+		copy(padded, payload)
+		for c := range cells {
+			M_c := v.suite.Point().Embed(v.chunk(padded, c), v.suite.RandomStream())
+			cells[c].Add(cells[c], M_c)
+		}
+	}
+
+	K_i := v.suite.Point().Mul(v.secret, nil)
+	proof, err := v.prove(roundID, e.EntityID, ownerSlot, slotOwner, K_i, cells)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DCNetCipher{RoundID: roundID}
+	c.Payload, err = v.marshalPoints(cells)
+	if err != nil {
+		return nil, err
+	}
+	c.Payload = append(c.Payload, proof...)
+
+	e.verbosePrint("r[", roundID, "]: verifiable\n", c.Payload)
+	return c.ToBytes(), nil
+}
+
+// Encode for trustees, in the verifiable DC-net
+func (e *DCNetEntity) verifiableTrusteeEncode(roundID int32) []byte {
+	v := e.verifiable
+
+	generators := v.generatorsForRound(roundID)
+	cells := make([]kyber.Point, v.nChunks)
+	for c := range cells {
+		cells[c] = v.suite.Point().Mul(v.secret, generators[c])
+	}
+
+	c := &DCNetCipher{RoundID: roundID}
+	payload, err := v.marshalPoints(cells)
+	if err != nil {
+		log.Fatal("Could not marshal the verifiable DC-net cell", err)
+	}
+	c.Payload = payload
+
+	e.verbosePrint("r[", roundID, "]: verifiable\n", c.Payload)
+	return c.ToBytes()
+}
+
+// DecodeVerifiableClient is called by the relay to decode a client contribution in the verifiable DC-net. It returns
+// an error (and ignores the contribution) if the cell is malformed, or if its proof does not verify, i.e., if the
+// client tried to transmit outside of its slot.
+func (e *DCNetEntity) DecodeVerifiableClient(roundID int32, clientID int, ownerSlot int, slice []byte) error {
+	if e.verifiable == nil {
+		return errors.New("this DC-net is not verifiable")
+	}
+	if err := e.checkDecodingRound(roundID); err != nil {
+		return err
+	}
+	v := e.verifiable
+	d := e.DCNetRoundDecoder
+
+	if clientID < 0 || clientID >= len(v.verificationKeys) {
+		return errors.New("no verification key for client " + strconv.Itoa(clientID))
+	}
+	if ownerSlot >= len(v.pseudonyms) {
+		return errors.New("unknown slot owner " + strconv.Itoa(ownerSlot))
+	}
+
+	dcNetCipher, err := DCNetCipherFromBytes(slice)
+	if err != nil {
+		return err
+	}
+	if err := dcNetCipher.checkRound(roundID); err != nil {
+		return err
+	}
+	cellsLength := v.nChunks * v.suite.PointLen()
+	if len(dcNetCipher.Payload) != cellsLength+v.proofLength() {
+		return fmt.Errorf("%w: cell of client %d has length %d, expected %d", ErrWrongPayloadLength, clientID,
+			len(dcNetCipher.Payload), cellsLength+v.proofLength())
+	}
+
+	cells, err := v.unmarshalPoints(dcNetCipher.Payload[:cellsLength])
+	if err != nil {
+		return errors.New("cell of client " + strconv.Itoa(clientID) + " is invalid, " + err.Error())
+	}
+	proof := dcNetCipher.Payload[cellsLength:]
+
+	if err := v.verify(roundID, clientID, ownerSlot, v.verificationKeys[clientID], cells, proof); err != nil {
+		return errors.New("proof of client " + strconv.Itoa(clientID) + " is invalid, " + err.Error())
+	}
+
+	for c := range cells {
+		d.verifiableBuffer[c].Add(d.verifiableBuffer[c], cells[c])
+	}
+	return nil
+}
+
+// Decode for the relay, trustees side, in the verifiable DC-net
+func (e *DCNetEntity) verifiableDecodeTrustee(roundID int32, slice []byte) error {
+	v := e.verifiable
+	d := e.DCNetRoundDecoder
+
+	dcNetCipher, err := DCNetCipherFromBytes(slice)
+	if err != nil {
+		return err
+	}
+	if err := dcNetCipher.checkRound(roundID); err != nil {
+		return err
+	}
+	if len(dcNetCipher.Payload) != v.nChunks*v.suite.PointLen() {
+		return fmt.Errorf("%w: trustee cell has length %d, expected %d", ErrWrongPayloadLength,
+			len(dcNetCipher.Payload), v.nChunks*v.suite.PointLen())
+	}
+	cells, err := v.unmarshalPoints(dcNetCipher.Payload)
+	if err != nil {
+		return fmt.Errorf("%w: invalid trustee cell, %v", ErrMalformedCipher, err)
+	}
+	for c := range cells {
+		d.verifiableBuffer[c].Add(d.verifiableBuffer[c], cells[c])
+	}
+	return nil
+}
+
+// Decode the cell, for the relay, in the verifiable DC-net
+func (e *DCNetEntity) verifiableDecodeCell() ([]byte, []byte) {
+	v := e.verifiable
+	d := e.DCNetRoundDecoder
+
+	decoded := make([]byte, e.DCNetPayloadSize)
+	for c, M_c := range d.verifiableBuffer {
+		data, err := M_c.Data()
+		if err != nil {
+			// no-one embedded anything (or someone disrupted), leave zeros
+			continue
+		}
+		copy(v.chunk(decoded, c), data)
+	}
+
+	cipherText, err := v.marshalPoints(d.verifiableBuffer)
+	if err != nil {
+		log.Error("DCNet: could not marshal the verifiable cell", err)
+	}
+
+	return decoded, cipherText
+}
+
+// returns the c-th chunk of data (a sub-slice)
+func (v *verifiableDCNet) chunk(data []byte, c int) []byte {
+	start := c * v.chunkSize
+	end := start + v.chunkSize
+	if end > len(data) {
+		end = len(data)
+	}
+	return data[start:end]
+}
+
+// returns the generators H_rc for round r, which are the same for every entity
+func (v *verifiableDCNet) generatorsForRound(roundID int32) []kyber.Point {
+	if v.generators != nil && v.generatorsRound == roundID {
+		return v.generators
+	}
+
+	seed := make([]byte, 4)
+	binary.BigEndian.PutUint32(seed, uint32(roundID))
+	seed = append([]byte("verifiable-dcnet-generators"), seed...)
+	xof := v.suite.XOF(seed)
+
+	generators := make([]kyber.Point, v.nChunks)
+	for c := range generators {
+		generators[c] = v.suite.Point().Pick(xof)
+	}
+
+	v.generators = generators
+	v.generatorsRound = roundID
+	return generators
+}
+
+func (v *verifiableDCNet) proofLength() int {
+	return 4 * v.suite.ScalarLen()
+}
+
+func (v *verifiableDCNet) marshalPoints(points []kyber.Point) ([]byte, error) {
+	out := make([]byte, 0, len(points)*v.suite.PointLen())
+	for _, p := range points {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+func (v *verifiableDCNet) unmarshalPoints(data []byte) ([]kyber.Point, error) {
+	pointSize := v.suite.PointLen()
+	if len(data)%pointSize != 0 {
+		return nil, errors.New("length " + strconv.Itoa(len(data)) + " is not a multiple of " + strconv.Itoa(pointSize))
+	}
+	points := make([]kyber.Point, len(data)/pointSize)
+	for i := range points {
+		points[i] = v.suite.Point()
+		if err := points[i].UnmarshalBinary(data[i*pointSize : (i+1)*pointSize]); err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+// computes the commitments of the first statement, log_G(K) = log_H_rc(V_c) for all c, from the challenge e and
+// the response z (i.e., A_0 = z*G + e*K and A_c = z*H_rc + e*V_c)
+func (v *verifiableDCNet) noPayloadCommitments(generators []kyber.Point, K kyber.Point, cells []kyber.Point, e, z kyber.Scalar) []kyber.Point {
+	commits := make([]kyber.Point, len(cells)+1)
+	commits[0] = v.suite.Point().Add(v.suite.Point().Mul(z, nil), v.suite.Point().Mul(e, K))
+	for c := range cells {
+		commits[c+1] = v.suite.Point().Add(v.suite.Point().Mul(z, generators[c]), v.suite.Point().Mul(e, cells[c]))
+	}
+	return commits
+}
+
+// the Fiat-Shamir challenge of the proof
+func (v *verifiableDCNet) challenge(roundID int32, clientID int, ownerSlot int, K kyber.Point, cells []kyber.Point,
+	commits []kyber.Point, ownerCommit kyber.Point) (kyber.Scalar, error) {
+
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[0:4], uint32(roundID))
+	binary.BigEndian.PutUint32(header[4:8], uint32(clientID))
+	binary.BigEndian.PutUint32(header[8:12], uint32(ownerSlot))
+
+	transcript := append([]byte("verifiable-dcnet-proof"), header...)
+	points := append([]kyber.Point{K}, cells...)
+	points = append(points, commits...)
+	if ownerSlot >= 0 {
+		points = append(points, v.base, v.pseudonyms[ownerSlot], ownerCommit)
+	}
+	b, err := v.marshalPoints(points)
+	if err != nil {
+		return nil, err
+	}
+	transcript = append(transcript, b...)
+
+	return v.suite.Scalar().Pick(v.suite.XOF(transcript)), nil
+}
+
+// produces the proof (e1, z1, e2, z2) that this cell carries no payload, or that we own the slot.
+// If ownerSlot is -1, only the first statement is proven (e2 = z2 = 0).
+func (v *verifiableDCNet) prove(roundID int32, clientID int, ownerSlot int, slotOwner bool, K kyber.Point, cells []kyber.Point) ([]byte, error) {
+	suite := v.suite
+	rand := suite.RandomStream()
+	generators := v.generatorsForRound(roundID)
+
+	var e1, z1, e2, z2 kyber.Scalar
+	var commits []kyber.Point
+	var ownerCommit kyber.Point
+
+	if !slotOwner {
+		// real proof for the first statement, simulated proof for the second one
+		e2 = suite.Scalar().Zero()
+		z2 = suite.Scalar().Zero()
+		if ownerSlot >= 0 {
+			e2 = suite.Scalar().Pick(rand)
+			z2 = suite.Scalar().Pick(rand)
+			ownerCommit = suite.Point().Add(suite.Point().Mul(z2, v.base), suite.Point().Mul(e2, v.pseudonyms[ownerSlot]))
+		}
+
+		w1 := suite.Scalar().Pick(rand)
+		commits = make([]kyber.Point, len(cells)+1)
+		commits[0] = suite.Point().Mul(w1, nil)
+		for c := range cells {
+			commits[c+1] = suite.Point().Mul(w1, generators[c])
+		}
+
+		e, err := v.challenge(roundID, clientID, ownerSlot, K, cells, commits, ownerCommit)
+		if err != nil {
+			return nil, err
+		}
+		e1 = suite.Scalar().Sub(e, e2)
+		z1 = suite.Scalar().Sub(w1, suite.Scalar().Mul(e1, v.secret))
+	} else {
+		// simulated proof for the first statement, real proof for the second one
+		e1 = suite.Scalar().Pick(rand)
+		z1 = suite.Scalar().Pick(rand)
+		commits = v.noPayloadCommitments(generators, K, cells, e1, z1)
+
+		w2 := suite.Scalar().Pick(rand)
+		ownerCommit = suite.Point().Mul(w2, v.base)
+
+		e, err := v.challenge(roundID, clientID, ownerSlot, K, cells, commits, ownerCommit)
+		if err != nil {
+			return nil, err
+		}
+		e2 = suite.Scalar().Sub(e, e1)
+		z2 = suite.Scalar().Sub(w2, suite.Scalar().Mul(e2, v.pseudonymSecret))
+	}
+
+	out := make([]byte, 0, v.proofLength())
+	for _, s := range []kyber.Scalar{e1, z1, e2, z2} {
+		b, err := s.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// verifies the proof (e1, z1, e2, z2) of a client's cell
+func (v *verifiableDCNet) verify(roundID int32, clientID int, ownerSlot int, K kyber.Point, cells []kyber.Point, proof []byte) error {
+	suite := v.suite
+	scalarSize := suite.ScalarLen()
+	if len(proof) != v.proofLength() {
+		return errors.New("proof has length " + strconv.Itoa(len(proof)) + ", expected " + strconv.Itoa(v.proofLength()))
+	}
+	scalars := make([]kyber.Scalar, 4)
+	for i := range scalars {
+		scalars[i] = suite.Scalar()
+		if err := scalars[i].UnmarshalBinary(proof[i*scalarSize : (i+1)*scalarSize]); err != nil {
+			return err
+		}
+	}
+	e1, z1, e2, z2 := scalars[0], scalars[1], scalars[2], scalars[3]
+
+	generators := v.generatorsForRound(roundID)
+	commits := v.noPayloadCommitments(generators, K, cells, e1, z1)
+
+	var ownerCommit kyber.Point
+	if ownerSlot >= 0 {
+		ownerCommit = suite.Point().Add(suite.Point().Mul(z2, v.base), suite.Point().Mul(e2, v.pseudonyms[ownerSlot]))
+	} else if !e2.Equal(suite.Scalar().Zero()) || !z2.Equal(suite.Scalar().Zero()) {
+		return errors.New("no-one owns this round, the cell cannot be proven as the slot owner's")
+	}
+
+	e, err := v.challenge(roundID, clientID, ownerSlot, K, cells, commits, ownerCommit)
+	if err != nil {
+		return err
+	}
+	if !e.Equal(suite.Scalar().Add(e1, e2)) {
+		return errors.New("challenge mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package dcnet
+
+import (
+	"bytes"
+	"github.com/dedis/prifi/prifi-lib/config"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
+	"testing"
+)
+
+type VerifiableTestGroup struct {
+	Relay            *DCNetEntity
+	Clients          []*DCNetEntity
+	Trustees         []*DCNetEntity
+	PseudonymSecrets []kyber.Scalar
+}
+
+func NewVerifiableTestGroup(t *testing.T, dcNetMessageSize, nclients, ntrustees int) *VerifiableTestGroup {
+	return newVerifiableTestGroupWithSuite(t, config.CryptoSuite, dcNetMessageSize, nclients, ntrustees)
+}
+
+func newVerifiableTestGroupWithSuite(t *testing.T, suite suites.Suite, dcNetMessageSize, nclients, ntrustees int) *VerifiableTestGroup {
+
+	rand := suite.XOF([]byte("VerifiableDCTest"))
+
+	clientsPriv := make([]kyber.Scalar, nclients)
+	clientsPub := make([]kyber.Point, nclients)
+	for i := range clientsPriv {
+		clientsPriv[i] = suite.Scalar().Pick(rand)
+		clientsPub[i] = suite.Point().Mul(clientsPriv[i], nil)
+	}
+	trusteesPriv := make([]kyber.Scalar, ntrustees)
+	trusteesPub := make([]kyber.Point, ntrustees)
+	for j := range trusteesPriv {
+		trusteesPriv[j] = suite.Scalar().Pick(rand)
+		trusteesPub[j] = suite.Point().Mul(trusteesPriv[j], nil)
+	}
+
+	tg := new(VerifiableTestGroup)
+	tg.Relay = NewVerifiableDCNetEntity(suite, 0, DCNET_RELAY, dcNetMessageSize, nil)
+	tg.Clients = make([]*DCNetEntity, nclients)
+	tg.Trustees = make([]*DCNetEntity, ntrustees)
+
+	for i := range tg.Clients {
+		sharedSecrets := make([]kyber.Point, ntrustees)
+		for j := range sharedSecrets {
+			sharedSecrets[j] = suite.Point().Mul(clientsPriv[i], trusteesPub[j])
+		}
+		tg.Clients[i] = NewVerifiableDCNetEntity(suite, i, DCNET_CLIENT, dcNetMessageSize, sharedSecrets)
+	}
+
+	vkeys := make([][]byte, ntrustees)
+	for j := range tg.Trustees {
+		sharedSecrets := make([]kyber.Point, nclients)
+		for i := range sharedSecrets {
+			sharedSecrets[i] = suite.Point().Mul(trusteesPriv[j], clientsPub[i])
+		}
+		tg.Trustees[j] = NewVerifiableDCNetEntity(suite, j, DCNET_TRUSTEE, dcNetMessageSize, sharedSecrets)
+
+		vkey, err := tg.Trustees[j].VerifiableDCNetKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		vkeys[j] = vkey
+	}
+	if err := tg.Relay.SetVerifiableDCNetKeys(vkeys, nclients); err != nil {
+		t.Fatal(err)
+	}
+
+	// fake shuffle : the pseudonyms are in the reverse order of the clients, on a new base
+	base := suite.Point().Pick(rand)
+	tg.PseudonymSecrets = make([]kyber.Scalar, nclients)
+	pseudonyms := make([]kyber.Point, nclients)
+	for i := range tg.PseudonymSecrets {
+		tg.PseudonymSecrets[i] = suite.Scalar().Pick(rand)
+		pseudonyms[nclients-1-i] = suite.Point().Mul(tg.PseudonymSecrets[i], base)
+	}
+	tg.Relay.SetPseudonyms(base, pseudonyms, nil)
+	for i := range tg.Clients {
+		tg.Clients[i].SetPseudonyms(base, pseudonyms, tg.PseudonymSecrets[i])
+	}
+
+	return tg
+}
+
+// encodes one round where the owner of "ownerSlot" transmits "message", returns the clients' and trustees' ciphers
+func (tg *VerifiableTestGroup) encodeRound(t *testing.T, roundID int32, ownerSlot int, message []byte) ([][]byte, [][]byte) {
+	clientsCiphers := make([][]byte, len(tg.Clients))
+	for i := range tg.Clients {
+		c, err := tg.Clients[i].EncodeVerifiableForRound(roundID, ownerSlot, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientsCiphers[i] = c
+	}
+	trusteesCiphers := make([][]byte, len(tg.Trustees))
+	for j := range tg.Trustees {
+		trusteesCiphers[j] = tg.Trustees[j].TrusteeEncodeForRound(roundID)
+	}
+	return clientsCiphers, trusteesCiphers
+}
+
+func TestVerifiableDCNet(t *testing.T) {
+	for _, name := range []string{config.CRYPTO_SUITE_ED25519, config.CRYPTO_SUITE_P256} {
+		suite, err := config.FindCryptoSuite(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testVerifiableDCNet(t, suite)
+	}
+}
+
+func testVerifiableDCNet(t *testing.T, suite suites.Suite) {
+
+	payloadSize := 100
+	nClients := 3
+	nTrustees := 2
+	tg := newVerifiableTestGroupWithSuite(t, suite, payloadSize, nClients, nTrustees)
+
+	for roundID := int32(0); roundID < 6; roundID++ {
+		ownerSlot := int(roundID) % nClients
+		message := randomBytes(payloadSize)
+		if roundID == 0 {
+			ownerSlot = -1 // no-one owns this round
+		}
+
+		clientsCiphers, trusteesCiphers := tg.encodeRound(t, roundID, ownerSlot, message)
+
+		tg.Relay.DecodeStart(roundID)
+		for i, c := range clientsCiphers {
+			if err := tg.Relay.DecodeVerifiableClient(roundID, i, ownerSlot, c); err != nil {
+				t.Error("Relay should accept the cell of client", i, "in round", roundID, "but", err)
+			}
+		}
+		for _, c := range trusteesCiphers {
+			if err := tg.Relay.DecodeTrustee(roundID, c); err != nil {
+				t.Error(err)
+			}
+		}
+		output, _, err := tg.Relay.DecodeCell(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ownerSlot == -1 {
+			if !bytes.Equal(output, make([]byte, payloadSize)) {
+				t.Error("Verifiable DC-net should decode zeros when no-one owns the round")
+			}
+		} else if !bytes.Equal(output, message) {
+			t.Error("Verifiable DC-net encoding failed in round", roundID)
+		}
+	}
+}
+
+func TestVerifiableDCNetRejectsDisruption(t *testing.T) {
+
+	payloadSize := 50
+	nClients := 2
+	nTrustees := 2
+	tg := NewVerifiableTestGroup(t, payloadSize, nClients, nTrustees)
+	roundID := int32(1)
+	ownerSlot := 0 // owned by the last client, since the pseudonyms are reversed
+	disruptor := 0
+	suite := config.CryptoSuite
+
+	clientsCiphers, _ := tg.encodeRound(t, roundID, ownerSlot, randomBytes(payloadSize))
+
+	// the non-owner adds a payload in its cell, without being able to prove it owns the slot
+	// (DCNetCipherFromBytes does not copy, work on a copy of the cell)
+	c, _ := DCNetCipherFromBytes(append([]byte{}, clientsCiphers[disruptor]...))
+	V := suite.Point()
+	if err := V.UnmarshalBinary(c.Payload[:suite.PointLen()]); err != nil {
+		t.Fatal(err)
+	}
+	V.Add(V, suite.Point().Embed([]byte("disrupting"), suite.RandomStream()))
+	vBytes, _ := V.MarshalBinary()
+	copy(c.Payload, vBytes)
+	disrupted := c.ToBytes()
+
+	tg.Relay.DecodeStart(roundID)
+	if err := tg.Relay.DecodeVerifiableClient(roundID, disruptor, ownerSlot, disrupted); err == nil {
+		t.Error("Relay should reject a cell carrying a payload outside of the owner's slot")
+	}
+
+	// a tampered proof is rejected
+	c, _ = DCNetCipherFromBytes(append([]byte{}, clientsCiphers[disruptor]...))
+	c.Payload[len(c.Payload)-1]++
+	if err := tg.Relay.DecodeVerifiableClient(roundID, disruptor, ownerSlot, c.ToBytes()); err == nil {
+		t.Error("Relay should reject a cell with an invalid proof")
+	}
+
+	// a valid cell replayed under another identity, or in another round, or for another owner, is rejected
+	if err := tg.Relay.DecodeVerifiableClient(roundID, 1, ownerSlot, clientsCiphers[disruptor]); err == nil {
+		t.Error("Relay should reject a cell of client 0 presented as the cell of client 1")
+	}
+	if err := tg.Relay.DecodeVerifiableClient(roundID, disruptor, 1, clientsCiphers[disruptor]); err == nil {
+		t.Error("Relay should reject a cell proven for another slot owner")
+	}
+	tg.Relay.DecodeStart(roundID + 1)
+	if err := tg.Relay.DecodeVerifiableClient(roundID+1, disruptor, ownerSlot, clientsCiphers[disruptor]); err == nil {
+		t.Error("Relay should reject a cell of another round")
+	}
+
+	// the genuine cells are accepted
+	tg.Relay.DecodeStart(roundID)
+	for i, c := range clientsCiphers {
+		if err := tg.Relay.DecodeVerifiableClient(roundID, i, ownerSlot, c); err != nil {
+			t.Error("Relay should accept the cell of client", i, "but", err)
+		}
+	}
+
+	// garbage is rejected
+	garbage := DCNetCipher{Payload: randomBytes(20)}
+	if err := tg.Relay.DecodeVerifiableClient(roundID, 0, ownerSlot, garbage.ToBytes()); err == nil {
+		t.Error("Relay should reject a malformed cell")
+	}
+}
+
+func TestVerifiableDCNetKeys(t *testing.T) {
+	tg := NewVerifiableTestGroup(t, 10, 2, 1)
+
+	if err := tg.Relay.SetVerifiableDCNetKeys([][]byte{make([]byte, 3)}, 2); err == nil {
+		t.Error("Relay should refuse a verifiable DC-net key of the wrong length")
+	}
+	if _, err := tg.Clients[0].VerifiableDCNetKey(); err == nil {
+		t.Error("Only trustees have a verifiable DC-net key")
+	}
+	if _, err := tg.Trustees[0].EncodeVerifiableForRound(0, -1, nil); err == nil {
+		t.Error("Only clients can call EncodeVerifiableForRound")
+	}
+	if _, err := tg.Clients[0].EncodeVerifiableForRound(0, 5, nil); err == nil {
+		t.Error("EncodeVerifiableForRound should refuse an unknown slot owner")
+	}
+
+	simple := NewDCNetEntity(config.CryptoSuite, 0, DCNET_RELAY, 10, false, PAD_CIPHER_XOF, nil)
+	if simple.IsVerifiable() || !tg.Relay.IsVerifiable() {
+		t.Error("IsVerifiable is wrong")
+	}
+}
@@ -0,0 +1,53 @@
+package dcnet
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync"
+)
+
+// under this many bytes of pads per round, generating them in parallel costs more than it saves
+const parallelPadsThreshold = 32 * 1024
+
+// xorBytes sets dst[i] ^= src[i] for i < min(len(dst), len(src)), 8 bytes at a time
+func xorBytes(dst, src []byte) {
+	n := len(dst)
+	if len(src) < n {
+		n = len(src)
+	}
+
+	w := n - n%8
+	for i := 0; i < w; i += 8 {
+		binary.LittleEndian.PutUint64(dst[i:], binary.LittleEndian.Uint64(dst[i:])^binary.LittleEndian.Uint64(src[i:]))
+	}
+	for i := w; i < n; i++ {
+		dst[i] ^= src[i]
+	}
+}
+
+// generatePads fills pads[i] with the pad of padCiphers[i] for the round "roundID". When the pads are
+// large enough, they are generated in parallel by at most one worker per CPU.
+func generatePads(padCiphers []PadCipher, roundID int32, pads [][]byte) {
+	nWorkers := runtime.NumCPU()
+	if nWorkers > len(padCiphers) {
+		nWorkers = len(padCiphers)
+	}
+	if nWorkers <= 1 || len(padCiphers)*len(pads[0]) < parallelPadsThreshold {
+		for i := range padCiphers {
+			padCiphers[i].Pad(roundID, pads[i])
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(nWorkers)
+	for w := 0; w < nWorkers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(padCiphers); i += nWorkers {
+				padCiphers[i].Pad(roundID, pads[i])
+			}
+		}(w)
+	}
+	wg.Wait()
+}
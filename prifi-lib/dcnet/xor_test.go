@@ -0,0 +1,93 @@
+package dcnet
+
+import (
+	"bytes"
+	"testing"
+)
+
+// the reference byte-by-byte XOR
+func xorBytesSlow(dst, src []byte) {
+	for i := range dst {
+		if i < len(src) {
+			dst[i] ^= src[i]
+		}
+	}
+}
+
+func TestXorBytes(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 8, 9, 15, 16, 17, 100, 1003} {
+		a := randomBytes(n)
+		b := randomBytes(n)
+		expected := append([]byte{}, a...)
+		xorBytesSlow(expected, b)
+		xorBytes(a, b)
+		if !bytes.Equal(a, expected) {
+			t.Error("xorBytes failed for length", n)
+		}
+	}
+
+	// src shorter than dst, and the opposite
+	a := randomBytes(20)
+	b := randomBytes(13)
+	expected := append([]byte{}, a...)
+	xorBytesSlow(expected, b)
+	xorBytes(a, b)
+	if !bytes.Equal(a, expected) {
+		t.Error("xorBytes failed with a shorter source")
+	}
+	a = randomBytes(13)
+	b = randomBytes(20)
+	expected = append([]byte{}, a...)
+	xorBytesSlow(expected, b)
+	xorBytes(a, b)
+	if !bytes.Equal(a, expected) {
+		t.Error("xorBytes failed with a longer source")
+	}
+}
+
+func TestGeneratePadsInParallel(t *testing.T) {
+	nPeers := 20
+	padSize := parallelPadsThreshold // large enough to be generated in parallel
+
+	padCiphers := make([]PadCipher, nPeers)
+	pads := make([][]byte, nPeers)
+	for i := range padCiphers {
+		padCiphers[i] = newPadCipher(t, PAD_CIPHER_AES_CTR, randomBytes(32))
+		pads[i] = randomBytes(padSize) // the buffers are dirty, they must be overwritten
+	}
+
+	generatePads(padCiphers, 3, pads)
+	for i := range pads {
+		if !bytes.Equal(pads[i], pad(padCiphers[i], 3, padSize)) {
+			t.Error("Pad", i, "was not generated correctly")
+		}
+	}
+}
+
+func TestPadBuffersAreReused(t *testing.T) {
+	tg := NewTestGroup(t, true, 100, 1, 3)
+	client := tg.Clients[0].DCNetEntity
+
+	p0 := client.padsOfRound(0, 100)
+	expected := pad(client.padCiphers[1], 4, 100)
+	p4 := client.padsOfRound(4, 100)
+	if &p0[1][0] != &p4[1][0] {
+		t.Error("padsOfRound should reuse its buffers")
+	}
+	if !bytes.Equal(p4[1], expected) {
+		t.Error("padsOfRound returned the wrong pad")
+	}
+}
+
+func benchmarkXor(b *testing.B, xor func(dst, src []byte)) {
+	dst := randomBytes(64000)
+	src := randomBytes(64000)
+	b.SetBytes(int64(len(dst)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		xor(dst, src)
+	}
+}
+
+func BenchmarkXorBytes(b *testing.B)     { benchmarkXor(b, xorBytes) }
+func BenchmarkXorBytesSlow(b *testing.B) { benchmarkXor(b, xorBytesSlow) }
@@ -0,0 +1,76 @@
+package log
+
+import (
+	"fmt"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+//HistoryStatistics holds statistics about the rounds kept by the relay for the disruption protection
+type HistoryStatistics struct {
+	begin      time.Time
+	nextReport time.Time
+	period     time.Duration
+
+	rounds        int
+	bytes         int
+	peakBytes     int
+	evictedRounds int64
+
+	reportNo int
+}
+
+//NewHistoryStatistics create a new HistoryStatistics struct, with a period (for reporting) of 5 second
+func NewHistoryStatistics() *HistoryStatistics {
+	fiveSec := time.Duration(5) * time.Second
+	now := time.Now()
+	stats := HistoryStatistics{
+		begin:      now,
+		nextReport: now,
+		period:     fiveSec,
+		reportNo:   0}
+	return &stats
+}
+
+//SetSize sets the number of rounds in the history, and their size in bytes
+func (stats *HistoryStatistics) SetSize(rounds, bytes int) {
+	stats.rounds = rounds
+	stats.bytes = bytes
+	if bytes > stats.peakBytes {
+		stats.peakBytes = bytes
+	}
+}
+
+//AddEvictedRounds adds n to the count of rounds removed from the history
+func (stats *HistoryStatistics) AddEvictedRounds(n int) {
+	stats.evictedRounds += int64(n)
+}
+
+//Report prints (if t>period=5 seconds have passed since the last report) all the information, without extra data
+func (stats *HistoryStatistics) Report() string {
+	return stats.ReportWithInfo("")
+}
+
+//ReportWithInfo prints (if t>period=5 seconds have passed since the last report) all the information, with extra data "info"
+func (stats *HistoryStatistics) ReportWithInfo(info string) string {
+	now := time.Now()
+	if now.After(stats.nextReport) {
+
+		//human-readable output
+		str := fmt.Sprintf("[%v] History %v rounds, %0.1f kB (peak %0.1f kB), %v rounds evicted %s",
+			stats.reportNo, stats.rounds, float64(stats.bytes)/1024, float64(stats.peakBytes)/1024, stats.evictedRounds, info)
+		log.Lvl2(str)
+
+		//json output
+		strJSON := fmt.Sprintf("{ \"type\"=\"relay_history\", \"report_id\"=\"%v\", \"rounds\"=\"%v\", \"bytes\"=\"%v\", \"peak_bytes\"=\"%v\", \"evicted_rounds\"=\"%v\" }\n",
+			stats.reportNo, stats.rounds, stats.bytes, stats.peakBytes, stats.evictedRounds)
+
+		stats.nextReport = now.Add(stats.period)
+		stats.reportNo++
+
+		return strJSON
+	}
+
+	return ""
+}
@@ -2,6 +2,7 @@ package log
 
 import (
 	"testing"
+	"time"
 )
 
 func TestBWStatistics(t *testing.T) {
@@ -55,3 +56,29 @@ func TestUtils(t *testing.T) {
 		t.Error("ConfidenceInterval95 is wrong", delta, "!= 2.66")
 	}
 }
+
+func TestHistoryStatistics(t *testing.T) {
+	b := NewHistoryStatistics()
+	b.SetSize(4, 2000)
+	b.SetSize(2, 1000)
+	b.AddEvictedRounds(2)
+	if b.peakBytes != 2000 || b.bytes != 1000 || b.evictedRounds != 2 {
+		t.Error("Wrong history statistics", b.peakBytes, b.bytes, b.evictedRounds)
+	}
+	if b.Report() == "" {
+		t.Error("The first report should not be empty")
+	}
+}
+
+func TestTimeoutStatistics(t *testing.T) {
+	b := NewTimeoutStatistics()
+	b.AddRound(200 * time.Millisecond)
+	b.AddRound(100 * time.Millisecond)
+	b.AddTimeout()
+	if b.totalRounds != 2 || b.totalTimeouts != 1 || b.lastTimeout != 100*time.Millisecond {
+		t.Error("Wrong timeout statistics", b.totalRounds, b.totalTimeouts, b.lastTimeout)
+	}
+	if b.Report() == "" || b.instantRounds != 0 || b.instantTimeouts != 0 {
+		t.Error("The first report should be printed, and reset the instant counters")
+	}
+}
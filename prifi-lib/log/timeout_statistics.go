@@ -0,0 +1,82 @@
+package log
+
+import (
+	"fmt"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+//TimeoutStatistics holds statistics about the round timeouts of the relay : their value, and how often they fire
+type TimeoutStatistics struct {
+	begin      time.Time
+	nextReport time.Time
+	period     time.Duration
+
+	totalRounds   int64
+	totalTimeouts int64
+
+	instantRounds   int64
+	instantTimeouts int64
+	lastTimeout     time.Duration
+
+	reportNo int
+}
+
+//NewTimeoutStatistics create a new TimeoutStatistics struct, with a period (for reporting) of 5 second
+func NewTimeoutStatistics() *TimeoutStatistics {
+	fiveSec := time.Duration(5) * time.Second
+	now := time.Now()
+	stats := TimeoutStatistics{
+		begin:      now,
+		nextReport: now,
+		period:     fiveSec,
+		reportNo:   0}
+	return &stats
+}
+
+//AddRound counts a round opened, with the timeout "timeout"
+func (stats *TimeoutStatistics) AddRound(timeout time.Duration) {
+	stats.totalRounds++
+	stats.instantRounds++
+	stats.lastTimeout = timeout
+}
+
+//AddTimeout counts a timeout which fired
+func (stats *TimeoutStatistics) AddTimeout() {
+	stats.totalTimeouts++
+	stats.instantTimeouts++
+}
+
+//Report prints (if t>period=5 seconds have passed since the last report) all the information, without extra data
+func (stats *TimeoutStatistics) Report() string {
+	return stats.ReportWithInfo("")
+}
+
+//ReportWithInfo prints (if t>period=5 seconds have passed since the last report) all the information, with extra data "info"
+func (stats *TimeoutStatistics) ReportWithInfo(info string) string {
+	now := time.Now()
+	if now.After(stats.nextReport) {
+
+		//human-readable output
+		str := fmt.Sprintf("[%v] Timeouts %v fired out of %v rounds (%v out of %v total), timeout %v ms %s",
+			stats.reportNo, stats.instantTimeouts, stats.instantRounds, stats.totalTimeouts, stats.totalRounds,
+			stats.lastTimeout.Nanoseconds()/1e6, info)
+		log.Lvl1(str)
+
+		//json output
+		strJSON := fmt.Sprintf("{ \"type\"=\"relay_timeouts\", \"report_id\"=\"%v\", \"timeouts\"=\"%v\", \"rounds\"=\"%v\", \"total_timeouts\"=\"%v\", \"total_rounds\"=\"%v\", \"timeout_ms\"=\"%v\" }\n",
+			stats.reportNo, stats.instantTimeouts, stats.instantRounds, stats.totalTimeouts, stats.totalRounds,
+			stats.lastTimeout.Nanoseconds()/1e6)
+
+		stats.instantRounds = 0
+		stats.instantTimeouts = 0
+
+		stats.nextReport = now.Add(stats.period)
+		stats.reportNo++
+
+		return strJSON
+	}
+
+	return ""
+}
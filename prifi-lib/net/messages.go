@@ -3,6 +3,7 @@ package net
 import (
 	"encoding/binary"
 	"errors"
+	"strconv"
 
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v3/log"
@@ -18,9 +19,12 @@ import (
 // ALL_ALL_SHUTDOWN
 // ALL_ALL_PARAMETERS
 // CLI_REL_TELL_PK_AND_EPH_PK
+// CLI_REL_JOIN
+// CLI_REL_LEAVE
 // CLI_REL_UPSTREAM_DATA
 // REL_CLI_DOWNSTREAM_DATA
 // REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG
+// REL_CLI_ASK_EPH_PK
 // REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE
 // REL_TRU_TELL_TRANSCRIPT
 // TRU_REL_DC_CIPHER
@@ -29,10 +33,14 @@ import (
 // TRU_REL_TELL_NEW_BASE_AND_EPH_PKS
 // TRU_REL_TELL_PK
 // REL_TRU_TELL_RATE_CHANGE
+// REL_TRU_TELL_EPOCH
+// REL_TRU_TELL_SLOT_LENGTH
+// REL_TRU_DOWNSTREAM_DIGESTS
+// TRU_REL_DOWNSTREAM_EQUIVOCATION
+// CLI_REL_DOWNSTREAM_NACK
 
 //not used yet :
 // REL_CLI_DOWNSTREAM_DATA
-// CLI_REL_DOWNSTREAM_NACK
 
 // ALL_ALL_SHUTDOWN message tells the participants to stop the protocol.
 type ALL_ALL_SHUTDOWN struct {
@@ -46,19 +54,42 @@ type CLI_REL_TELL_PK_AND_EPH_PK struct {
 	EphPk    kyber.Point
 }
 
+// CLI_REL_JOIN message announces a client which connects while the relay communicates, with the ID it gets. It is
+// given to the relay by its host; the client joins the DC-net at the next re-shuffle.
+type CLI_REL_JOIN struct {
+	ClientID int
+}
+
+// CLI_REL_LEAVE message announces a client whose connection closed while the relay communicates. It is given to the
+// relay by its host; the client is dropped from the DC-net at the next round.
+type CLI_REL_LEAVE struct {
+	ClientID int
+}
+
 // CLI_REL_UPSTREAM_DATA message contains the upstream data of a client for a given round
 // and is sent to the relay.
 type CLI_REL_UPSTREAM_DATA struct {
+	ClientID             int
+	RoundID              int32 // rounds increase 1 by 1, only represent ciphers
+	Data                 []byte
+	DownstreamDigest     []byte      // digest of the downstream cell of this round, nil if the consistency check is disabled
+	DownstreamDigestTags []ByteArray // the tag of DownstreamDigest for each trustee
+}
+
+// CLI_REL_DOWNSTREAM_NACK message is sent by a client which missed the downstream data of some rounds on the UDP
+// broadcast, and asks the relay to send it again.
+type CLI_REL_DOWNSTREAM_NACK struct {
 	ClientID int
-	RoundID  int32 // rounds increase 1 by 1, only represent ciphers
-	Data     []byte
+	RoundIDs []int32
 }
 
 // CLI_REL_OPENCLOSED_DATA message contains whether slots are gonna be Open or Closed in the next round
 type CLI_REL_OPENCLOSED_DATA struct {
-	ClientID       int
-	RoundID        int32
-	OpenClosedData []byte
+	ClientID             int
+	RoundID              int32
+	OpenClosedData       []byte
+	DownstreamDigest     []byte      // as in CLI_REL_UPSTREAM_DATA
+	DownstreamDigestTags []ByteArray // as in CLI_REL_UPSTREAM_DATA
 }
 
 // REL_CLI_DOWNSTREAM_DATA message contains the downstream data for a client for a given round
@@ -70,6 +101,10 @@ type REL_CLI_DOWNSTREAM_DATA struct {
 	Data                       []byte
 	FlagResync                 bool
 	FlagOpenClosedRequest      bool
+	EpochID                    int32 // the last epoch announced by the relay,
+	EpochStartRoundID          int32 // and its first round (possibly in the future)
+	SlotLength                 int   // the length of the upstream payload of this round, 0 for PayloadSize
+	SlotsStartRoundID          int32 // the first round of the slots used in this round, see relay/reshuffle.go
 }
 
 //Converts []ByteArray -> [][]byte and returns it
@@ -87,6 +122,13 @@ type REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG struct {
 	Base         kyber.Point
 	EphPks       []kyber.Point
 	TrusteesSigs []ByteArray
+	StartRoundID int32  // the first round using those slots, 0 at setup
+	History      []byte // with the equivocation protection, the history of the downstream cells before StartRoundID, for the joining clients
+}
+
+// REL_CLI_ASK_EPH_PK message asks the clients for a fresh ephemeral key, to be shuffled while the DC-net keeps running.
+// The clients answer with CLI_REL_TELL_PK_AND_EPH_PK. It is sent by the relay.
+type REL_CLI_ASK_EPH_PK struct {
 }
 
 // REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE message contains the public keys and ephemeral keys
@@ -126,17 +168,19 @@ func (m *REL_TRU_TELL_TRANSCRIPT) GetProofs() [][]byte {
 }
 
 // REL_TRU_TELL_TRANSCRIPT message contains all the shuffles perfomrmed in a Neff shuffle round.
-// It is sent by the relay to the trustees to be verified.
+// It is sent by the relay to the trustees to be verified, and to the clients if they verify the shuffle themselves.
 type REL_TRU_TELL_TRANSCRIPT struct {
-	Bases  []kyber.Point
-	EphPks []PublicKeyArray
-	Proofs []ByteArray
+	InitialEphPks []kyber.Point // the keys given to the first trustee
+	Bases         []kyber.Point
+	EphPks        []PublicKeyArray
+	Proofs        []ByteArray
 }
 
 // TRU_REL_DC_CIPHER message contains the DC-net cipher of a trustee for a given round and is sent to the relay.
 type TRU_REL_DC_CIPHER struct {
 	RoundID   int32
 	TrusteeID int
+	EpochID   int32 // the epoch of the pads used to encode Data
 	Data      []byte
 }
 
@@ -152,6 +196,58 @@ type REL_TRU_TELL_RATE_CHANGE struct {
 	WindowCapacity int
 }
 
+// REL_TRU_TELL_EPOCH message announces that the epoch EpochID starts at the round StartRoundID, i.e., that
+// the trustees must re-key their pads from this round on. It is sent by the relay.
+type REL_TRU_TELL_EPOCH struct {
+	EpochID         int32
+	StartRoundID    int32
+	NewClientsPks   []kyber.Point // the public keys of the clients joining the DC-net at this epoch, in the order of their IDs
+	DepartedClients []int         // the IDs of the clients leaving the DC-net at this epoch
+}
+
+// REL_TRU_TELL_SLOT_LENGTH message announces that the upstream payload of the round RoundID has Length bytes,
+// as asked by the owner of the slot. It is sent by the relay; a trustee that already sent this round sends it again.
+type REL_TRU_TELL_SLOT_LENGTH struct {
+	RoundID int32
+	Length  int
+}
+
+// REL_TRU_DOWNSTREAM_DIGESTS message contains the digests of the downstream cell of a round sent by the clients,
+// and their tags for the trustee receiving it. It is sent by the relay for every round, the trustee checks that every
+// client sent a digest, and that the digests are equal.
+type REL_TRU_DOWNSTREAM_DIGESTS struct {
+	RoundID   int32
+	ClientIDs []int
+	Digests   []ByteArray
+	Tags      []ByteArray
+}
+
+// TRU_REL_DOWNSTREAM_EQUIVOCATION message contains the evidence that the clients did not receive the same downstream
+// cell in a round : the digests they sent (empty if missing), signed by the trustee which checked them. It is sent to
+// the relay and to the clients.
+type TRU_REL_DOWNSTREAM_EQUIVOCATION struct {
+	TrusteeID int
+	RoundID   int32
+	ClientIDs []int
+	Digests   []ByteArray
+	Signature []byte
+}
+
+// SignedData returns the content of the evidence signed by the trustee
+func (m *TRU_REL_DOWNSTREAM_EQUIVOCATION) SignedData() []byte {
+	out := make([]byte, 8, 8+len(m.ClientIDs)*(8+16))
+	binary.BigEndian.PutUint32(out[0:4], uint32(m.TrusteeID))
+	binary.BigEndian.PutUint32(out[4:8], uint32(m.RoundID))
+	for i := range m.ClientIDs {
+		clientAndLength := make([]byte, 8)
+		binary.BigEndian.PutUint32(clientAndLength[0:4], uint32(m.ClientIDs[i]))
+		binary.BigEndian.PutUint32(clientAndLength[4:8], uint32(len(m.Digests[i].Bytes)))
+		out = append(out, clientAndLength...)
+		out = append(out, m.Digests[i].Bytes...)
+	}
+	return out
+}
+
 // TRU_REL_TELL_NEW_BASE_AND_EPH_PKS message contains the new ephemeral key of a trustee and
 // is sent to the relay.
 type TRU_REL_TELL_NEW_BASE_AND_EPH_PKS struct {
@@ -193,7 +289,7 @@ func (m *REL_CLI_DOWNSTREAM_DATA_UDP) ToBytes() ([]byte, error) {
 
 	//convert the message to bytes
 	hashLen := len(m.REL_CLI_DOWNSTREAM_DATA.HashOfPreviousUpstreamData)
-	buf := make([]byte, 4+4+4+hashLen+len(m.REL_CLI_DOWNSTREAM_DATA.Data)+4+4)
+	buf := make([]byte, 4+4+4+4+4+4+4+hashLen+len(m.REL_CLI_DOWNSTREAM_DATA.Data)+4+4)
 
 	resyncInt := 0
 	if m.REL_CLI_DOWNSTREAM_DATA.FlagResync {
@@ -204,13 +300,18 @@ func (m *REL_CLI_DOWNSTREAM_DATA_UDP) ToBytes() ([]byte, error) {
 		openclosedInt = 1
 	}
 
-	// [0:4 roundID] [4:8 OwnershipID] [8:12 Length of Hash] [Variable: Hash] [8:end-8 data] [end-8:end-4 resyncFlag] [end-4:end openClosedFlag]
+	// [0:4 roundID] [4:8 OwnershipID] [8:12 EpochID] [12:16 EpochStartRoundID] [16:20 SlotLength] [20:24 SlotsStartRoundID]
+	// [24:28 Length of Hash] [Variable: Hash] [28+hashLen:end-8 data] [end-8:end-4 resyncFlag] [end-4:end openClosedFlag]
 	binary.BigEndian.PutUint32(buf[0:4], uint32(m.REL_CLI_DOWNSTREAM_DATA.RoundID))
 	binary.BigEndian.PutUint32(buf[4:8], uint32(m.REL_CLI_DOWNSTREAM_DATA.OwnershipID))
-	binary.BigEndian.PutUint32(buf[8:12], uint32(hashLen))
-	startIndex := 12
+	binary.BigEndian.PutUint32(buf[8:12], uint32(m.REL_CLI_DOWNSTREAM_DATA.EpochID))
+	binary.BigEndian.PutUint32(buf[12:16], uint32(m.REL_CLI_DOWNSTREAM_DATA.EpochStartRoundID))
+	binary.BigEndian.PutUint32(buf[16:20], uint32(m.REL_CLI_DOWNSTREAM_DATA.SlotLength))
+	binary.BigEndian.PutUint32(buf[20:24], uint32(m.REL_CLI_DOWNSTREAM_DATA.SlotsStartRoundID))
+	binary.BigEndian.PutUint32(buf[24:28], uint32(hashLen))
+	startIndex := 28
 	if hashLen > 0 {
-		copy(buf[12:12+hashLen], m.REL_CLI_DOWNSTREAM_DATA.HashOfPreviousUpstreamData)
+		copy(buf[28:28+hashLen], m.REL_CLI_DOWNSTREAM_DATA.HashOfPreviousUpstreamData)
 		startIndex += hashLen
 	}
 
@@ -225,20 +326,29 @@ func (m *REL_CLI_DOWNSTREAM_DATA_UDP) ToBytes() ([]byte, error) {
 // FromBytes decodes the message contained in the message's byteEncoded field.
 func (m *REL_CLI_DOWNSTREAM_DATA_UDP) FromBytes(buffer []byte) (interface{}, error) {
 
-	//the smallest message is 4 bytes, indicating a length of 0
-	if len(buffer) < 8 { //4 (roundID) + 4 (flagResync)
-		e := "Messages.go : FromBytes() : cannot decode, smaller than 8 bytes"
+	//the smallest message has no hash and no data
+	if len(buffer) < 36 { //28 (header) + 8 (flags)
+		e := "Messages.go : FromBytes() : cannot decode, smaller than 36 bytes"
 		return REL_CLI_DOWNSTREAM_DATA_UDP{}, errors.New(e)
 	}
 
-	// [0:4 roundID] [4:8 OwnershipID] [8:12 Length of Hash] [Variable: Hash] [8:end-8 data] [end-8:end-4 resyncFlag] [end-4:end openClosedFlag]
+	// [0:4 roundID] [4:8 OwnershipID] [8:12 EpochID] [12:16 EpochStartRoundID] [16:20 SlotLength] [20:24 SlotsStartRoundID]
+	// [24:28 Length of Hash] [Variable: Hash] [28+hashLen:end-8 data] [end-8:end-4 resyncFlag] [end-4:end openClosedFlag]
 	roundID := int32(binary.BigEndian.Uint32(buffer[0:4]))
 	ownerShipID := int(binary.BigEndian.Uint32(buffer[4:8]))
-	hashLen := int(binary.BigEndian.Uint32(buffer[8:12]))
+	epochID := int32(binary.BigEndian.Uint32(buffer[8:12]))
+	epochStartRoundID := int32(binary.BigEndian.Uint32(buffer[12:16]))
+	slotLength := int(binary.BigEndian.Uint32(buffer[16:20]))
+	slotsStartRoundID := int32(binary.BigEndian.Uint32(buffer[20:24]))
+	hashLen := int(binary.BigEndian.Uint32(buffer[24:28]))
+	if hashLen < 0 || hashLen > len(buffer)-36 {
+		e := "Messages.go : FromBytes() : cannot decode, hash length " + strconv.Itoa(hashLen) + " is too big"
+		return REL_CLI_DOWNSTREAM_DATA_UDP{}, errors.New(e)
+	}
 	flagResyncInt := int(binary.BigEndian.Uint32(buffer[len(buffer)-8 : len(buffer)-4]))
 	flagOpenClosedInt := int(binary.BigEndian.Uint32(buffer[len(buffer)-4:]))
-	hashOfPreviousUpstreamData := buffer[12 : 12+hashLen]
-	data := buffer[12+hashLen : len(buffer)-8]
+	hashOfPreviousUpstreamData := buffer[28 : 28+hashLen]
+	data := buffer[28+hashLen : len(buffer)-8]
 
 	flagResync := false
 	if flagResyncInt == 1 {
@@ -249,7 +359,18 @@ func (m *REL_CLI_DOWNSTREAM_DATA_UDP) FromBytes(buffer []byte) (interface{}, err
 		flagOpenClosed = true
 	}
 
-	innerMessage := REL_CLI_DOWNSTREAM_DATA{roundID, ownerShipID, hashOfPreviousUpstreamData, data, flagResync, flagOpenClosed}
+	innerMessage := REL_CLI_DOWNSTREAM_DATA{
+		RoundID:                    roundID,
+		OwnershipID:                ownerShipID,
+		HashOfPreviousUpstreamData: hashOfPreviousUpstreamData,
+		Data:                       data,
+		FlagResync:                 flagResync,
+		FlagOpenClosedRequest:      flagOpenClosed,
+		EpochID:                    epochID,
+		EpochStartRoundID:          epochStartRoundID,
+		SlotLength:                 slotLength,
+		SlotsStartRoundID:          slotsStartRoundID,
+	}
 	resultMessage := REL_CLI_DOWNSTREAM_DATA_UDP{innerMessage}
 
 	return resultMessage, nil
@@ -293,25 +414,27 @@ type TRU_REL_DISRUPTION_REVEAL struct {
 	Pval      map[string]kyber.Point
 }
 
-// REL_ALL_REVEAL_SHARED_SECRETS contains request ro reveal the shared secret with the specified recipient, and is sent by the relay
+// REL_ALL_REVEAL_SHARED_SECRETS contains request ro reveal the secret shared with the specified recipient, to blame the
+// round RoundID, and is sent by the relay
 type REL_ALL_REVEAL_SHARED_SECRETS struct {
 	EntityID int
+	RoundID  int32
 }
 
 // CLI_REL_SHARED_SECRET contains the shared secret requested by the relay, with a proof we computed it correctly
 type CLI_REL_SHARED_SECRET struct {
 	ClientID  int
 	TrusteeID int
+	RoundID   int32
 	Secret    kyber.Point
 	NIZK      []byte
-	Pub       map[string]kyber.Point
 }
 
 // TRU_REL_SHARED_SECRET contains the shared secret requested by the relay, with a proof we computed it correctly
 type TRU_REL_SHARED_SECRET struct {
 	TrusteeID int
 	ClientID  int
+	RoundID   int32
 	Secret    kyber.Point
 	NIZK      []byte
-	Pub       map[string]kyber.Point
 }
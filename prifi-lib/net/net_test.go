@@ -3,7 +3,9 @@ package net
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"github.com/dedis/prifi/prifi-lib/config"
 	"github.com/dedis/prifi/prifi-lib/crypto"
 	"go.dedis.ch/kyber/v3"
 	"testing"
@@ -126,8 +128,8 @@ func TestTELL_TRANSCRIPT_Message(t *testing.T) {
 
 	msg := new(REL_TRU_TELL_TRANSCRIPT)
 	pks := make([]kyber.Point, 2)
-	pks[0], _ = crypto.NewKeyPair()
-	pks[1], _ = crypto.NewKeyPair()
+	pks[0], _ = crypto.NewKeyPair(config.CryptoSuite)
+	pks[1], _ = crypto.NewKeyPair(config.CryptoSuite)
 	msg.EphPks = make([]PublicKeyArray, 1)
 	msg.EphPks[0] = PublicKeyArray{Keys: pks}
 
@@ -152,6 +154,10 @@ func TestUDPMessage(t *testing.T) {
 	content.FlagResync = true
 	content.Data = genDataSlice()
 	content.FlagOpenClosedRequest = true
+	content.EpochID = 3
+	content.EpochStartRoundID = 1000
+	content.SlotLength = 42
+	content.SlotsStartRoundID = 990
 
 	msg.SetContent(*content)
 
@@ -180,6 +186,15 @@ func TestUDPMessage(t *testing.T) {
 	if parsedMsg.OwnershipID != content.OwnershipID {
 		t.Error("OwnershipID unparsed incorrectly")
 	}
+	if parsedMsg.EpochID != content.EpochID || parsedMsg.EpochStartRoundID != content.EpochStartRoundID {
+		t.Error("Epoch unparsed incorrectly")
+	}
+	if parsedMsg.SlotLength != content.SlotLength {
+		t.Error("SlotLength unparsed incorrectly")
+	}
+	if parsedMsg.SlotsStartRoundID != content.SlotsStartRoundID {
+		t.Error("SlotsStartRoundID unparsed incorrectly")
+	}
 	if parsedMsg.FlagResync != content.FlagResync {
 		t.Error("FlagResync unparsed incorrectly")
 	}
@@ -195,4 +210,10 @@ func TestUDPMessage(t *testing.T) {
 	if err2 == nil {
 		t.Error("REL_CLI_DOWNSTREAM_DATA_UDP should not allow to decode message < 4 bytes")
 	}
+
+	//this should fail, the hash length is bigger than the message
+	binary.BigEndian.PutUint32(msgBytes[24:28], uint32(len(msgBytes)))
+	if _, err2 = void.FromBytes(msgBytes); err2 == nil {
+		t.Error("REL_CLI_DOWNSTREAM_DATA_UDP should not allow a hash longer than the message")
+	}
 }
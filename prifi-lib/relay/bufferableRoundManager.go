@@ -16,11 +16,23 @@ import (
 type BufferableRoundManager struct {
 	sync.Mutex
 
-	//immutable
+	//immutable, except nClients when clients join
 	nClients                    int
 	nTrustees                   int
 	maxNumberOfConcurrentRounds int
 
+	//the clients who joined take part from the round nClientsSince on; the rounds before have previousNClients clients
+	previousNClients int
+	nClientsSince    int32
+
+	//the clients who left, and the first round without them
+	departedClients map[int]int32
+
+	//the number of rounds opened at once, between 1 and maxNumberOfConcurrentRounds if adaptive, see window.go
+	adaptiveWindow        bool
+	window                float64
+	shortestRoundDuration time.Duration
+
 	//the ACK map for this round
 	clientAckMap  map[int]bool
 	trusteeAckMap map[int]bool
@@ -155,11 +167,15 @@ func NewBufferableRoundManager(nClients, nTrustees, maxNumberOfConcurrentRounds
 	b.nClients = nClients
 	b.nTrustees = nTrustees
 	b.maxNumberOfConcurrentRounds = maxNumberOfConcurrentRounds
+	b.window = float64(maxNumberOfConcurrentRounds)
 	b.lastRoundClosed = -1 // next is round 0
 	b.lastOwner = -1       // next is client 0
 	b.nextOCSlotRound = 1  // first is 1, the first downstream data from relay
 
-	b.resetACKmaps()
+	b.previousNClients = nClients
+	b.nClientsSince = 0
+	b.departedClients = make(map[int]int32)
+	b.resetACKmaps(0)
 
 	b.dataAlreadySent = make(map[int32]*net.REL_CLI_DOWNSTREAM_DATA)
 	b.openRounds = make(map[int32]time.Time)
@@ -242,7 +258,12 @@ func (b *BufferableRoundManager) UpdateAndGetNextOwnerID() int {
 
 func (b *BufferableRoundManager) updateAndGetNextOwnerID() int {
 
-	nextOwnerIDCandidate := (b.lastOwner + 1) % b.nClients
+	// the slots are the clients' slots from the shuffle, unless the schedule has its own slots (e.g., footprints)
+	nSlots := b.nClients
+	if len(b.storedOwnerSchedule) > 0 {
+		nSlots = len(b.storedOwnerSchedule)
+	}
+	nextOwnerIDCandidate := (b.lastOwner + 1) % nSlots
 
 	if b.storedOwnerSchedule == nil || len(b.storedOwnerSchedule) == 0 {
 
@@ -255,7 +276,7 @@ func (b *BufferableRoundManager) updateAndGetNextOwnerID() int {
 	// check if disabled in the schedule, iterate until find a non-closed slot (or go further than the schedule in time)
 	loopCount := 0
 	for found && !open {
-		nextOwnerIDCandidate = (nextOwnerIDCandidate + 1) % b.nClients
+		nextOwnerIDCandidate = (nextOwnerIDCandidate + 1) % nSlots
 		open, found = b.storedOwnerSchedule[nextOwnerIDCandidate]
 
 		if loopCount == len(b.storedOwnerSchedule) {
@@ -268,6 +289,18 @@ func (b *BufferableRoundManager) updateAndGetNextOwnerID() int {
 	return nextOwnerIDCandidate
 }
 
+// SlotOwnerOfRound returns the slot owner announced with the downstream data of this round, or -1 if none was announced
+// (e.g., in round 0)
+func (b *BufferableRoundManager) SlotOwnerOfRound(roundID int32) int {
+	b.Lock()
+	defer b.Unlock()
+
+	if data, found := b.dataAlreadySent[roundID]; found && data != nil {
+		return data.OwnershipID
+	}
+	return -1
+}
+
 // Open next round, fetch the buffered ciphers, reset the ACK map
 func (b *BufferableRoundManager) OpenNextRound() int32 {
 	b.Lock()
@@ -292,10 +325,10 @@ func (b *BufferableRoundManager) OpenNextRound() int32 {
 
 	//if no round was opened before, then by opening this one, you need to pull the already-buffered ciphers
 	if !anyRoundOpen {
-		b.resetACKmaps()
+		b.resetACKmaps(roundID)
 		//use the cipher we already stored
-		for i := 0; i < b.nClients; i++ {
-			if _, exists := b.bufferedClientCiphers[i][roundID]; exists {
+		for i := 0; i < b.nClientsOfRound(roundID); i++ {
+			if _, exists := b.bufferedClientCiphers[i][roundID]; exists && b.takesPart(i, roundID) {
 				b.clientAckMap[i] = true
 			}
 		}
@@ -325,8 +358,10 @@ func (b *BufferableRoundManager) CollectRoundData() ([][]byte, [][]byte, error)
 
 	//prepare the output, discard those ciphers
 	clientsOut := make([][]byte, 0)
-	for i := 0; i < b.nClients; i++ {
-		clientsOut = append(clientsOut, b.bufferedClientCiphers[i][currentRoundID])
+	for i := 0; i < b.nClientsOfRound(currentRoundID); i++ {
+		if b.takesPart(i, currentRoundID) {
+			clientsOut = append(clientsOut, b.bufferedClientCiphers[i][currentRoundID])
+		}
 		delete(b.bufferedClientCiphers[i], currentRoundID)
 	}
 	trusteesOut := make([][]byte, 0)
@@ -351,7 +386,7 @@ func (b *BufferableRoundManager) closeRound() error {
 	delete(b.openRounds, currentRoundID)
 
 	//discard the buffered ciphers
-	for i := 0; i < b.nClients; i++ {
+	for i := range b.bufferedClientCiphers {
 		delete(b.bufferedClientCiphers[i], currentRoundID)
 	}
 	for i := 0; i < b.nTrustees; i++ {
@@ -365,16 +400,16 @@ func (b *BufferableRoundManager) closeRound() error {
 
 	b.lastRoundClosed = currentRoundID
 
-	//reset the map
-	b.resetACKmaps()
-
 	anyRoundOpen, newRoundID := b.currentRound()
 
+	//reset the map
+	b.resetACKmaps(newRoundID)
+
 	//if anyround is open (several rounds were open before closing this one), use the buffered ciphers
 	if anyRoundOpen {
 		//use the cipher we already stored
-		for i := 0; i < b.nClients; i++ {
-			if _, exists := b.bufferedClientCiphers[i][newRoundID]; exists {
+		for i := 0; i < b.nClientsOfRound(newRoundID); i++ {
+			if _, exists := b.bufferedClientCiphers[i][newRoundID]; exists && b.takesPart(i, newRoundID) {
 				b.clientAckMap[i] = true
 			}
 		}
@@ -403,6 +438,7 @@ func (b *BufferableRoundManager) CloseRound() error {
 		return errors.New("Cannot close round " + strconv.Itoa(int(currentRoundID)) + ", does not have all ciphers")
 	}
 
+	b.roundClosedAfter(time.Since(b.openRounds[currentRoundID]))
 	return b.closeRound()
 }
 
@@ -411,6 +447,7 @@ func (b *BufferableRoundManager) ForceCloseRound() error {
 	b.Lock()
 	defer b.Unlock()
 
+	b.roundTimedOut()
 	return b.closeRound()
 }
 
@@ -432,20 +469,73 @@ func (b *BufferableRoundManager) TimeSpentInRound(roundID int32) time.Duration {
 	return time.Duration(0)
 }
 
-// resetACKmaps resets to 0 (all false) the two acks maps
-func (b *BufferableRoundManager) resetACKmaps() {
+// TimeSinceOpened returns the time elapsed since the round "roundID" was opened, and false if it is not open
+func (b *BufferableRoundManager) TimeSinceOpened(roundID int32) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	startTime, found := b.openRounds[roundID]
+	if !found {
+		return 0, false
+	}
+	return time.Since(startTime), true
+}
+
+// resetACKmaps resets to 0 (all false) the two acks maps, for the clients of the round "roundID"
+func (b *BufferableRoundManager) resetACKmaps(roundID int32) {
 
 	b.clientAckMap = make(map[int]bool)
 	b.trusteeAckMap = make(map[int]bool)
 
-	for i := 0; i < b.nClients; i++ {
-		b.clientAckMap[i] = false
+	for i := 0; i < b.nClientsOfRound(roundID); i++ {
+		if b.takesPart(i, roundID) {
+			b.clientAckMap[i] = false
+		}
 	}
 	for i := 0; i < b.nTrustees; i++ {
 		b.trusteeAckMap[i] = false
 	}
 }
 
+// SetNClients is called when clients join : the rounds from "fromRound" on have "nClients" clients. The rounds
+// before keep the previous number of clients; they must all be opened before "fromRound"
+func (b *BufferableRoundManager) SetNClients(nClients int, fromRound int32) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.previousNClients = b.nClientsOfRound(fromRound - 1)
+	b.nClients = nClients
+	b.nClientsSince = fromRound
+}
+
+// nClientsOfRound returns the number of clients taking part in the round "roundID"
+func (b *BufferableRoundManager) nClientsOfRound(roundID int32) int {
+	if roundID < b.nClientsSince {
+		return b.previousNClients
+	}
+	return b.nClients
+}
+
+// DropClient is called when a client leaves : the rounds from "fromRound" on do not wait for its ciphers. If the
+// current round is one of them, it is not waited for either
+func (b *BufferableRoundManager) DropClient(clientID int, fromRound int32) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.departedClients[clientID] = fromRound
+	if anyRoundOpen, currentRoundID := b.currentRound(); anyRoundOpen && currentRoundID >= fromRound {
+		delete(b.clientAckMap, clientID)
+	}
+}
+
+// takesPart returns true if the client "clientID" takes part in the round "roundID", i.e., did not leave before
+func (b *BufferableRoundManager) takesPart(clientID int, roundID int32) bool {
+	if fromRound, departed := b.departedClients[clientID]; departed && roundID >= fromRound {
+		return false
+	}
+	return clientID < b.nClientsOfRound(roundID)
+}
+
 // IsNextDownstreamRoundForOpenClosedRequest return true if the next downstream round should have flagOpenCloseScheduleRequest == true
 func (b *BufferableRoundManager) IsNextDownstreamRoundForOpenClosedRequest(nClients int) bool {
 	b.Lock()
@@ -479,7 +569,7 @@ func (b *BufferableRoundManager) SetStoredRoundSchedule(s map[int]bool) {
 
 	_, currentRoundID := b.currentRound()
 	//there will be numberOfOpenSlots after this one for data, then, next one is OC slot
-	b.nextOCSlotRound = currentRoundID + int32(numberOfOpenSlots) + int32(b.maxNumberOfConcurrentRounds) + 1
+	b.nextOCSlotRound = currentRoundID + int32(numberOfOpenSlots) + int32(b.windowSize()) + 1
 }
 
 // SetDataAlreadySent sets the "DataAlreadySent" field for the given round
@@ -508,6 +598,19 @@ func (b *BufferableRoundManager) GetDataAlreadySent(roundID int32) *net.REL_CLI_
 	return nil
 }
 
+// FindDataAlreadySent returns the "DataAlreadySent" field for the given round, and false if the round is not open
+// anymore, or no data was sent for it
+func (b *BufferableRoundManager) FindDataAlreadySent(roundID int32) (*net.REL_CLI_DOWNSTREAM_DATA, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.isRoundOpen(roundID) {
+		return nil, false
+	}
+	data, found := b.dataAlreadySent[roundID]
+	return data, found && data != nil
+}
+
 // AddTrusteeCipher adds a trustee cipher for a given round
 func (b *BufferableRoundManager) AddTrusteeCipher(roundID int32, trusteeID int, data []byte) error {
 	b.Lock()
@@ -535,6 +638,21 @@ func (b *BufferableRoundManager) AddTrusteeCipher(roundID int32, trusteeID int,
 	return nil
 }
 
+// DiscardTrusteeCiphers forgets the trustee ciphers received for the round "roundID", which will be sent again
+func (b *BufferableRoundManager) DiscardTrusteeCiphers(roundID int32) {
+	b.Lock()
+	defer b.Unlock()
+
+	anyRoundOpen, currentRound := b.currentRound()
+	for i := 0; i < b.nTrustees; i++ {
+		delete(b.bufferedTrusteeCiphers[i], roundID)
+		if anyRoundOpen && roundID == currentRound {
+			b.trusteeAckMap[i] = false
+		}
+		b.sendRateChangeIfNeeded(i)
+	}
+}
+
 // AddClientCipher adds a client cipher for a given round
 func (b *BufferableRoundManager) AddClientCipher(roundID int32, clientID int, data []byte) error {
 
@@ -555,7 +673,7 @@ func (b *BufferableRoundManager) AddClientCipher(roundID int32, clientID int, da
 	}
 	b.addToBuffer(&b.bufferedClientCiphers, roundID, clientID, data)
 
-	if roundID == currendRound {
+	if roundID == currendRound && b.takesPart(clientID, roundID) {
 		b.clientAckMap[clientID] = true
 	}
 
@@ -611,6 +729,20 @@ func (b *BufferableRoundManager) MissingCiphersForCurrentRound() ([]int, []int)
 	return clientMissing, trusteeMissing
 }
 
+// MissingClientCiphers returns the clients taking part in the round "roundID" which did not send their cipher for it
+func (b *BufferableRoundManager) MissingClientCiphers(roundID int32) []int {
+	b.Lock()
+	defer b.Unlock()
+
+	missing := make([]int, 0)
+	for i := 0; i < b.nClientsOfRound(roundID); i++ {
+		if _, found := b.bufferedClientCiphers[i][roundID]; b.takesPart(i, roundID) && !found {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
 // IsRoundOpenend checks if we are in the given round (ie, used to check if we are stuck)
 func (b *BufferableRoundManager) IsRoundOpenend(roundID int32) bool {
 	b.Lock()
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"go.dedis.ch/onet/v3/log"
 	"testing"
+	"time"
 )
 
 /*
@@ -105,6 +106,21 @@ func TestOwnerSlots(test *testing.T) {
 	}
 }
 
+func TestOwnerSlotsWithFootprintSchedule(test *testing.T) {
+
+	b := NewBufferableRoundManager(2, 1, 1)
+
+	//a footprint schedule has more slots than clients; slots 1 and 3 were reserved
+	schedule := map[int]bool{0: false, 1: true, 2: false, 3: true}
+	b.SetStoredRoundSchedule(schedule)
+
+	for _, expected := range []int{1, 3, 1, 3} {
+		if owner := b.UpdateAndGetNextOwnerID(); owner != expected {
+			test.Error("UpdateAndGetNextOwnerID should be at", expected, "got", owner)
+		}
+	}
+}
+
 func TestRoundSuccessionWithSchedule(test *testing.T) {
 
 	window := 10
@@ -437,6 +453,168 @@ func TestCipherBuffering(test *testing.T) {
 	}
 }
 
+func TestClientsJoining(test *testing.T) {
+
+	window := 2
+	nClients := 2
+	nTrustees := 1
+	b := NewBufferableRoundManager(nClients, nTrustees, window)
+	b.OpenNextRound()
+
+	//client 2 joins at round 1, while round 0 is still open
+	b.SetNClients(3, 1)
+	b.OpenNextRound()
+	for roundID := int32(0); roundID < 2; roundID++ {
+		b.AddTrusteeCipher(roundID, 0, genDataSlice())
+		b.AddClientCipher(roundID, 0, genDataSlice())
+		b.AddClientCipher(roundID, 1, genDataSlice())
+	}
+
+	//round 0 does not wait for client 2
+	if !b.HasAllCiphersForCurrentRound() {
+		test.Error("Round 0 should only wait for the clients 0 and 1")
+	}
+	clientSlices, _, err := b.CollectRoundData()
+	if err != nil || len(clientSlices) != 2 {
+		test.Error("Round 0 should have 2 client ciphers, got", len(clientSlices), err)
+	}
+	b.CloseRound()
+
+	//round 1 does
+	if b.CurrentRound() != 1 || b.HasAllCiphersForCurrentRound() {
+		test.Error("Round 1 should wait for client 2")
+	}
+	if c, _ := b.MissingCiphersForCurrentRound(); len(c) != 1 || c[0] != 2 {
+		test.Error("Round 1 should miss the cipher of client 2, got", c)
+	}
+	if c := b.MissingClientCiphers(1); len(c) != 1 || c[0] != 2 {
+		test.Error("Round 1 should miss the cipher of client 2, got", c)
+	}
+	b.AddClientCipher(1, 2, genDataSlice())
+	clientSlices, _, err = b.CollectRoundData()
+	if err != nil || len(clientSlices) != 3 {
+		test.Error("Round 1 should have 3 client ciphers, got", len(clientSlices), err)
+	}
+}
+
+func TestClientDropped(test *testing.T) {
+
+	window := 2
+	nClients := 3
+	nTrustees := 1
+	b := NewBufferableRoundManager(nClients, nTrustees, window)
+	b.OpenNextRound()
+	b.OpenNextRound()
+	for roundID := int32(0); roundID < 2; roundID++ {
+		b.AddTrusteeCipher(roundID, 0, genDataSlice())
+		b.AddClientCipher(roundID, 0, genDataSlice())
+		b.AddClientCipher(roundID, 2, genDataSlice())
+	}
+
+	//client 1 left, the rounds from 1 on do not wait for it
+	b.DropClient(1, 1)
+	if b.HasAllCiphersForCurrentRound() {
+		test.Error("Round 0 should still wait for client 1")
+	}
+	b.ForceCloseRound()
+
+	if b.CurrentRound() != 1 || !b.HasAllCiphersForCurrentRound() {
+		test.Error("Round 1 should not wait for client 1")
+	}
+	clientSlices, _, err := b.CollectRoundData()
+	if err != nil || len(clientSlices) != 2 {
+		test.Error("Round 1 should have 2 client ciphers, got", len(clientSlices), err)
+	}
+	b.CloseRound()
+
+	//a cipher of client 1 does not count anymore
+	b.OpenNextRound()
+	b.AddTrusteeCipher(2, 0, genDataSlice())
+	b.AddClientCipher(2, 1, genDataSlice())
+	if c, _ := b.MissingCiphersForCurrentRound(); len(c) != 2 {
+		test.Error("Round 2 should miss the ciphers of clients 0 and 2, got", c)
+	}
+
+	//the current round does not wait for a client dropped from this round on
+	b.DropClient(2, 2)
+	b.AddClientCipher(2, 0, genDataSlice())
+	if !b.HasAllCiphersForCurrentRound() {
+		test.Error("Round 2 should not wait for client 2")
+	}
+}
+
+func TestAdaptiveWindow(test *testing.T) {
+
+	maxWindow := 4
+	nClients := 1
+	nTrustees := 1
+	b := NewBufferableRoundManager(nClients, nTrustees, maxWindow)
+	if b.WindowSize() != maxWindow {
+		test.Error("The window should be static by default, got", b.WindowSize())
+	}
+	b.EnableAdaptiveWindow()
+	if b.WindowSize() != 1 {
+		test.Error("The adaptive window should start at 1, got", b.WindowSize())
+	}
+
+	closeRounds := func(n int, duration time.Duration) {
+		for i := 0; i < n; i++ {
+			roundID := b.OpenNextRound()
+			b.AddTrusteeCipher(roundID, 0, genDataSlice())
+			b.AddClientCipher(roundID, 0, genDataSlice())
+			b.roundClosedAfter(duration)
+			if err := b.closeRound(); err != nil {
+				test.Fatal(err)
+			}
+		}
+	}
+
+	//rounds bound by the latency : one round per window of rounds closed
+	closeRounds(1, 10*time.Millisecond)
+	if b.WindowSize() != 2 {
+		test.Error("The window should grow to 2, got", b.WindowSize())
+	}
+	closeRounds(1, 10*time.Millisecond)
+	if b.WindowSize() != 2 {
+		test.Error("The window should grow by 1/window per round, got", b.WindowSize())
+	}
+	closeRounds(2, 11*time.Millisecond)
+	if b.WindowSize() != 3 {
+		test.Error("The window should grow to 3 after a window of rounds, got", b.WindowSize())
+	}
+	closeRounds(20, 12*time.Millisecond)
+	if b.WindowSize() != maxWindow {
+		test.Error("The window should not grow over", maxWindow, ", got", b.WindowSize())
+	}
+
+	//rounds twice as long as the shortest : with 4 rounds open, 2 are queued, the window stays
+	closeRounds(20, 20*time.Millisecond)
+	if b.WindowSize() != maxWindow {
+		test.Error("A window with 2 rounds queued should not change, got", b.WindowSize())
+	}
+
+	//rounds bound by the processing, whatever the round timeout : the window shrinks until 1 or 2 rounds are queued
+	closeRounds(20, 40*time.Millisecond)
+	if b.WindowSize() != 2 {
+		test.Error("The window should shrink to 2 when the rounds queue, got", b.WindowSize())
+	}
+
+	//multiplicative decrease
+	closeRounds(20, 10*time.Millisecond)
+	b.OpenNextRound()
+	b.ForceCloseRound()
+	if b.WindowSize() != maxWindow/2 {
+		test.Error("A timeout should halve the window, got", b.WindowSize())
+	}
+	b.OpenNextRound()
+	b.ForceCloseRound()
+	b.OpenNextRound()
+	b.ForceCloseRound()
+	if b.WindowSize() != 1 {
+		test.Error("The window should not shrink under 1, got", b.WindowSize())
+	}
+}
+
 func TestRateLimiter(test *testing.T) {
 
 	window := 100
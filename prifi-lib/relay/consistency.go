@@ -0,0 +1,102 @@
+package relay
+
+import (
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+When the downstream consistency check is enabled, the clients send with each upstream cell a digest of the downstream
+cell they answer, and its tag for each trustee (see dcnet/consistency.go). The relay collects them, and forwards them to
+the trustees when closing the round, for every round. A trustee that finds a digest missing, or different digests,
+sends the evidence, signed, to the relay and to the clients. Unless the relay itself equivocated, this means that some
+downstream cells were modified on their way to the clients.
+*/
+
+// downstreamDigest is the digest of a downstream cell sent by a client, with its tag for each trustee
+type downstreamDigest struct {
+	digest []byte
+	tags   []net.ByteArray
+}
+
+// collectDownstreamDigest stores the digest sent by the client "clientID" with its cipher for the round "roundID"
+func (p *PriFiLibRelayInstance) collectDownstreamDigest(roundID int32, clientID int, digest []byte, tags []net.ByteArray) {
+	if !p.relayState.DownstreamConsistencyCheck || digest == nil {
+		return
+	}
+	if p.relayState.downstreamDigests[roundID] == nil {
+		p.relayState.downstreamDigests[roundID] = make(map[int]downstreamDigest)
+	}
+	p.relayState.downstreamDigests[roundID][clientID] = downstreamDigest{digest: digest, tags: tags}
+}
+
+// forwardDownstreamDigests sends the digests collected for the round "roundID" to the trustees, each with its own
+// tags, and forgets the digests of this round and of the previous ones. The trustees expect the digests of every round,
+// even if there are none
+func (p *PriFiLibRelayInstance) forwardDownstreamDigests(roundID int32) {
+	if !p.relayState.DownstreamConsistencyCheck {
+		return
+	}
+	digests := p.relayState.downstreamDigests[roundID]
+	for r := range p.relayState.downstreamDigests {
+		if r <= roundID {
+			delete(p.relayState.downstreamDigests, r)
+		}
+	}
+	clientIDs := make([]int, 0, len(digests))
+	for clientID := range digests {
+		clientIDs = append(clientIDs, clientID)
+	}
+	sort.Ints(clientIDs)
+
+	for j := 0; j < p.relayState.nTrustees; j++ {
+		toSend := &net.REL_TRU_DOWNSTREAM_DIGESTS{
+			RoundID:   roundID,
+			ClientIDs: clientIDs,
+			Digests:   make([]net.ByteArray, len(clientIDs)),
+			Tags:      make([]net.ByteArray, len(clientIDs))}
+		for i, clientID := range clientIDs {
+			d := digests[clientID]
+			toSend.Digests[i] = net.ByteArray{Bytes: d.digest}
+			if j < len(d.tags) {
+				toSend.Tags[i] = d.tags[j]
+			}
+		}
+		p.messageSender.SendToTrusteeWithLog(j, toSend, "(trustee "+strconv.Itoa(j)+", round "+strconv.Itoa(int(roundID))+")")
+	}
+}
+
+/*
+Received_TRU_REL_DOWNSTREAM_EQUIVOCATION handles TRU_REL_DOWNSTREAM_EQUIVOCATION messages, sent by a trustee which
+found that the clients did not receive the same downstream cell. The relay checks the signature of the trustee,
+and reports the evidence.
+*/
+func (p *PriFiLibRelayInstance) Received_TRU_REL_DOWNSTREAM_EQUIVOCATION(msg net.TRU_REL_DOWNSTREAM_EQUIVOCATION) error {
+	if msg.TrusteeID < 0 || msg.TrusteeID >= len(p.relayState.trustees) || len(msg.Digests) != len(msg.ClientIDs) {
+		e := "Relay : malformed TRU_REL_DOWNSTREAM_EQUIVOCATION from trustee " + strconv.Itoa(msg.TrusteeID)
+		log.Error(e)
+		return errors.New(e)
+	}
+	trusteePk := p.relayState.trustees[msg.TrusteeID].PublicKey
+	if err := schnorr.Verify(p.relayState.suite, trusteePk, msg.SignedData(), msg.Signature); err != nil {
+		e := "Relay : wrong signature on the TRU_REL_DOWNSTREAM_EQUIVOCATION of trustee " + strconv.Itoa(msg.TrusteeID) + ", " + err.Error()
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	evidence := ""
+	for i, clientID := range msg.ClientIDs {
+		evidence += " client " + strconv.Itoa(clientID) + " received " + hex.EncodeToString(msg.Digests[i].Bytes) + ";"
+	}
+	log.Error("Relay : trustee", msg.TrusteeID, "reports that the clients did not receive the same downstream cell in round",
+		msg.RoundID, ":"+evidence)
+	p.relayState.downstreamEquivocations = append(p.relayState.downstreamEquivocations, msg)
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package relay
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/crypto"
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Clients leaving while we communicate. A client which stops sending its ciphers makes every round time out; after
+MaxNumberOfConsecutiveFailedRounds failed rounds, instead of restarting the protocol, we drop it from the DC-net :
+1) a new DC-net epoch starts at the next round opened, and we announce it to the trustees with the clients leaving.
+From this round on, the trustees stop XORing in the pads shared with those clients, and we stop waiting for their
+ciphers. The other clients share no pads with them, nothing changes for them.
+2) the trustee ciphers of this round and the next ones already received contain those pads, the trustees send them again
+The rounds already opened still wait for the clients leaving, they are lost. A client dropped keeps its ID, and its
+slot, which stays empty; at the next re-shuffles, we give a key of our own for it.
+When our host sees the connection of a client close, it tells us with CLI_REL_LEAVE : we drop the client right away,
+without waiting for the rounds to time out.
+As for the clients joining (see join.go), the disruption protection and the verifiable DC-net need a restart.
+*/
+
+// dropDepartedClients is called when too many rounds failed in a row, and the clients "missingClients" and the
+// trustees "missingTrustees" did not send their ciphers for the current round, or when our host tells us that the
+// clients "missingClients" left. If only clients are missing, and some
+// clients remain, they are dropped from the DC-net, and it returns true; otherwise, the protocol needs a restart
+func (p *PriFiLibRelayInstance) dropDepartedClients(missingClients []int, missingTrustees []int) bool {
+	if len(missingTrustees) > 0 || len(missingClients) == 0 {
+		return false
+	}
+	if p.relayState.dcNetType == "Verifiable" || p.relayState.DisruptionProtectionEnabled {
+		return false
+	}
+
+	departing := make([]int, 0)
+	for _, clientID := range missingClients {
+		if !p.isDeparted(clientID) {
+			departing = append(departing, clientID)
+		}
+	}
+	if len(departing) == 0 {
+		// this round was opened before those clients were dropped
+		p.relayState.numberOfConsecutiveFailedRounds = 0
+		return true
+	}
+	if len(p.relayState.departedClients)+len(departing) >= p.relayState.nClients {
+		log.Lvl1("Relay : clients", departing, "stopped answering, no client would remain")
+		return false
+	}
+	sort.Ints(departing)
+
+	// the epoch starts at the next round opened, unless an epoch is announced for this round or later
+	roundID := p.relayState.roundManager.NextRoundToOpen()
+	if _, lastEpochStart := p.lastEpoch(); lastEpochStart >= roundID {
+		roundID = lastEpochStart + 1
+	}
+
+	for _, clientID := range departing {
+		p.relayState.departedClients[clientID] = roundID
+		p.relayState.roundManager.DropClient(clientID, roundID)
+	}
+	epoch := p.announceEpoch(roundID, nil, departing)
+	p.reshuffleWithoutDepartedClients()
+	p.relayState.numberOfConsecutiveFailedRounds = 0
+
+	log.Lvl1("Relay : clients", departing, "stopped answering, dropped from the DC-net at round", roundID, ", epoch", epoch)
+	return true
+}
+
+// Received_CLI_REL_LEAVE handles CLI_REL_LEAVE messages, sent by our host when the connection of a client closes while
+// we communicate. The client is dropped from the DC-net at the next round opened; if it cannot be, our host restarts
+// the protocol
+func (p *PriFiLibRelayInstance) Received_CLI_REL_LEAVE(msg net.CLI_REL_LEAVE) error {
+	if msg.ClientID < 0 || msg.ClientID >= p.relayState.nClients {
+		return errors.New("Relay : client " + strconv.Itoa(msg.ClientID) + " left, but it is not in the DC-net")
+	}
+	if !p.dropDepartedClients([]int{msg.ClientID}, nil) {
+		return errors.New("Relay : client " + strconv.Itoa(msg.ClientID) + " left, and cannot be dropped from the DC-net")
+	}
+	return nil
+}
+
+// isDeparted returns true if the client "clientID" was dropped from the DC-net
+func (p *PriFiLibRelayInstance) isDeparted(clientID int) bool {
+	_, departed := p.relayState.departedClients[clientID]
+	return departed
+}
+
+// reshuffleWithoutDepartedClients gives a key of our own for the slots of the clients dropped, to the re-shuffle
+// collecting the fresh ephemeral keys of the clients, if any. Their slots stay empty
+func (p *PriFiLibRelayInstance) reshuffleWithoutDepartedClients() {
+	if p.relayState.reshuffle == nil || p.relayState.reshuffle.CannotAddNewKeys {
+		return
+	}
+	for clientID := range p.relayState.departedClients {
+		if _, found := p.relayState.reshuffleEphPks[clientID]; !found {
+			p.relayState.reshuffleEphPks[clientID], _ = crypto.NewKeyPair(p.relayState.suite)
+		}
+	}
+	if err := p.reshuffle1_sendKeysIfComplete(); err != nil {
+		log.Error(err)
+	}
+}
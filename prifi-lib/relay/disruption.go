@@ -1,20 +1,25 @@
 package relay
 
 import (
-	"github.com/dedis/prifi/prifi-lib/config"
+	"errors"
+
+	"github.com/dedis/prifi/prifi-lib/crypto"
+	"github.com/dedis/prifi/prifi-lib/dcnet"
 	"github.com/dedis/prifi/prifi-lib/net"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v3/log"
 
-	"fmt"
 	"go.dedis.ch/kyber/v3/proof"
 	"strconv"
 )
 
+// the disrupted bit positions are counted in the upstream data, after the b_echo_last flag (the first byte of the payload)
+const bEchoFlagBits = 8
+
 // Received_CLI_REL_BLAME
 func (p *PriFiLibRelayInstance) Received_CLI_REL_DISRUPTION_BLAME(msg net.CLI_REL_DISRUPTION_BLAME) error {
 	pred := proof.Rep("X", "x", "B")
-	suite := config.CryptoSuite
+	suite := p.relayState.suite
 	//B := suite.Point().Base()
 	/*for _, key := range(p.relayState.EphemeralPublicKeys) {
 		pval := map[string]kyber.Point{"B": B, "X": key}
@@ -42,6 +47,7 @@ func (p *PriFiLibRelayInstance) Received_CLI_REL_DISRUPTION_BLAME(msg net.CLI_RE
 		NIZK:    msg.NIZK,
 	}
 	p.relayState.blamingData.RoundID = msg.RoundID
+	p.relayState.blameRunning = true
 	p.relayState.blamingData.BitPos = msg.BitPos
 
 	// broadcast to all trustees
@@ -73,7 +79,7 @@ func (p *PriFiLibRelayInstance) Received_CLI_REL_DISRUPTION_REVEAL(msg net.CLI_R
 
 	log.Lvl1("Disruption Phase 1: Received bits from Client", msg.ClientID, "value", msg.Bits)
 	var pred_array []proof.Predicate
-	suite := config.CryptoSuite
+	suite := p.relayState.suite
 	for i := 1; i < p.relayState.nTrustees; i++ {
 		i_string := strconv.Itoa(i)
 		pred_array = append(pred_array, proof.Rep("T"+i_string, "t"+i_string, "B"))
@@ -95,14 +101,7 @@ func (p *PriFiLibRelayInstance) Received_CLI_REL_DISRUPTION_REVEAL(msg net.CLI_R
 		log.Lvl1("Disruption Phase 1: Trustee", msg.ClientID, ", is consistent with itself, checking mismatches with all trustees...")
 		mismatch := p.checkMismatchingPairs()
 		if mismatch {
-			toClient := &net.REL_ALL_REVEAL_SHARED_SECRETS{
-				EntityID: p.relayState.blamingData.TrusteeID,
-			}
-			toTrustee := &net.REL_ALL_REVEAL_SHARED_SECRETS{
-				EntityID: p.relayState.blamingData.ClientID,
-			}
-			p.messageSender.SendToTrusteeWithLog(p.relayState.blamingData.ClientID, toTrustee, "")
-			p.messageSender.SendToClientWithLog(p.relayState.blamingData.TrusteeID, toClient, "")
+			p.requestSharedSecrets()
 		} else {
 			log.Fatal("Disruption Phase 2: No mismatching pairs ? this should never occur.")
 		}
@@ -124,7 +123,7 @@ func (p *PriFiLibRelayInstance) Received_TRU_REL_DISRUPTION_REVEAL(msg net.TRU_R
 	log.Lvl1("Disruption Phase 1: Received bits from Trustee", msg.TrusteeID, "value", msg.Bits)
 
 	var pred_array []proof.Predicate
-	suite := config.CryptoSuite
+	suite := p.relayState.suite
 	for i := 1; i < p.relayState.nTrustees; i++ {
 		i_string := strconv.Itoa(i)
 		pred_array = append(pred_array, proof.Rep("T"+i_string, "t"+i_string, "B"))
@@ -145,14 +144,7 @@ func (p *PriFiLibRelayInstance) Received_TRU_REL_DISRUPTION_REVEAL(msg net.TRU_R
 		log.Lvl1("Disruption Phase 1: Trustee", msg.TrusteeID, ", is consistent with itself, checking mismatches with all clients...")
 		mismatch := p.checkMismatchingPairs()
 		if mismatch {
-			toClient := &net.REL_ALL_REVEAL_SHARED_SECRETS{
-				EntityID: p.relayState.blamingData.TrusteeID,
-			}
-			toTrustee := &net.REL_ALL_REVEAL_SHARED_SECRETS{
-				EntityID: p.relayState.blamingData.ClientID,
-			}
-			p.messageSender.SendToTrusteeWithLog(p.relayState.blamingData.ClientID, toTrustee, "")
-			p.messageSender.SendToClientWithLog(p.relayState.blamingData.TrusteeID, toClient, "")
+			p.requestSharedSecrets()
 		} else {
 			log.Fatal("Disruption Phase 2: No mismatching pairs ? this should never occur.")
 		}
@@ -167,22 +159,24 @@ func (p *PriFiLibRelayInstance) Received_TRU_REL_DISRUPTION_REVEAL(msg net.TRU_R
 func (p *PriFiLibRelayInstance) compareBits(id int, bits map[int]int, CiphertextsHistory map[int32]map[int32][]byte) bool {
 	round := p.relayState.blamingData.RoundID
 	bitPosition := p.relayState.blamingData.BitPos
-	bytePosition := bitPosition/8 + 9 // LB->CV: why + 9 ? avoid magic numbers :)
 
 	log.Lvl2("Disruption: comparing", bits, "with", CiphertextsHistory[int32(id)][int32(round)])
 
-	byteToGet := CiphertextsHistory[int32(id)][int32(round)][bytePosition]
-	bitInBytePosition := (8-bitPosition%8)%8 - 1
-	mask := byte(1 << uint(bitInBytePosition))
+	cipher, err := dcnet.DCNetCipherFromBytes(CiphertextsHistory[int32(id)][int32(round)])
+	if err != nil {
+		log.Error("Disruption: cannot parse the cipher of", id, "in round", round, ":", err)
+		return false
+	}
+	bitPreviousResult, err := cipher.PayloadBit(bEchoFlagBits + bitPosition)
+	if err != nil {
+		log.Error("Disruption: cannot read the disrupted bit of", id, "in round", round, ":", err)
+		return false
+	}
+
 	result := 0
 	for _, bit := range bits {
 		result ^= bit
 	}
-	bytePreviousResult := mask & byteToGet
-	bitPreviousResult := 0
-	if bytePreviousResult != 0 {
-		bitPreviousResult = 1
-	}
 
 	return (result == bitPreviousResult)
 }
@@ -209,117 +203,124 @@ func (p *PriFiLibRelayInstance) checkMismatchingPairs() bool {
 	return false
 }
 
+/*
+* requestSharedSecrets asks the client and the trustee of the mismatching pair for the secret they share, from which
+* the pads of every epoch are derived
+ */
+func (p *PriFiLibRelayInstance) requestSharedSecrets() {
+	blame := &p.relayState.blamingData
+	toClient := &net.REL_ALL_REVEAL_SHARED_SECRETS{
+		EntityID: blame.TrusteeID,
+		RoundID:  blame.RoundID,
+	}
+	toTrustee := &net.REL_ALL_REVEAL_SHARED_SECRETS{
+		EntityID: blame.ClientID,
+		RoundID:  blame.RoundID,
+	}
+	p.messageSender.SendToTrusteeWithLog(blame.TrusteeID, toTrustee, "")
+	p.messageSender.SendToClientWithLog(blame.ClientID, toClient, "")
+}
+
 /*
 Received_TRU_REL_SHARED_SECRETS handles TRU_REL_SECRET messages
-Check the NIZK, if correct regenerate the cipher up to the disrupted round and check if this trustee is the disruptor
+Check the proof of the secret revealed; if correct, regenerate the pad of the disrupted round and find the disruptor
 */
 func (p *PriFiLibRelayInstance) Received_TRU_REL_SHARED_SECRETS(msg net.TRU_REL_SHARED_SECRET) error {
-	log.Lvl1("Disruption Phase 2: Received shared secret from Trustee", msg.TrusteeID, "for client", msg.ClientID, "value", msg.Secret)
-
-	M := "SHAREDKEY"
-	X := make([]kyber.Point, 1)
-	X[0] = p.relayState.trustees[msg.TrusteeID].PublicKey
-	preds := make([]proof.Predicate, len(X))
-	for i := range X {
-		name := fmt.Sprintf("X[%d]", i) // "X[0]","X[1]",...
-		msg.Pub[name] = X[i]            // public point value
-
-		// Predicate indicates knowledge of the private key for X[i]
-		// and correspondence of the key with the linkage tag
-		preds[i] = proof.And(proof.Rep(name, "x", "B"), proof.Rep("T", "x", "BT"))
+	log.Lvl1("Disruption Phase 2: Received the shared secret of Trustee", msg.TrusteeID, "for client", msg.ClientID)
+
+	if err := p.checkSharedSecretExpected(msg.ClientID, msg.TrusteeID, msg.RoundID); err != nil {
+		return err
 	}
-	pred := proof.Or(preds...) // make a big Or predicate
-	suite := config.CryptoSuite
-	// Verify the signature
-	verifier := pred.Verifier(suite, msg.Pub)
-	err := proof.HashVerify(suite, M, verifier, msg.NIZK)
+	err := crypto.VerifySharedSecret(p.relayState.suite, p.relayState.trustees[msg.TrusteeID].PublicKey,
+		p.relayState.clients[msg.ClientID].PublicKey, msg.Secret, msg.RoundID, msg.ClientID, msg.TrusteeID, msg.NIZK)
 	if err != nil {
-		log.Error("signature failed to verify: ", err)
-	}
-	log.Lvl3("Linkable Ring Signature verified.")
-
-	val := p.replayRounds(msg.Secret)
-	if val != p.relayState.blamingData.TrusteeBitRevealed {
-		log.Fatal("Disruption Phase 2: Disruptor is Trustee", msg.TrusteeID, ".")
-	} else {
-		log.Lvl1("Disruption Phase 2: Trustee", msg.TrusteeID, "didn't lie, so it should be Client", msg.ClientID, ".")
+		log.Error("Disruption Phase 2: Trustee", msg.TrusteeID, "revealed a wrong shared secret,", err)
+		p.blameVerdict(nil, []int{msg.TrusteeID})
+		return nil
 	}
+	p.judgeWithSharedSecret(msg.Secret)
 	return nil
 }
 
 /*
 Received_CLI_REL_SHARED_SECRET handles CLI_REL_SECRET messages
-Check the NIZK, if correct regenerate the cipher up to the disrupted round and check if this client is the disruptor
+Check the proof of the secret revealed; if correct, regenerate the pad of the disrupted round and find the disruptor
 */
 func (p *PriFiLibRelayInstance) Received_CLI_REL_SHARED_SECRET(msg net.CLI_REL_SHARED_SECRET) error {
-	log.Lvl1("Disruption Phase 2: Received shared secret from Client", msg.ClientID, "for Trustee", msg.TrusteeID, "value", msg.Secret)
-
-	M := "SHAREDKEY"
-	X := make([]kyber.Point, 1)
-	X[0] = p.relayState.trustees[msg.TrusteeID].PublicKey
-	preds := make([]proof.Predicate, len(X))
-	for i := range X {
-		name := fmt.Sprintf("X[%d]", i) // "X[0]","X[1]",...
-		msg.Pub[name] = X[i]            // public point value
-
-		// Predicate indicates knowledge of the private key for X[i]
-		// and correspondence of the key with the linkage tag
-		preds[i] = proof.And(proof.Rep(name, "x", "B"), proof.Rep("T", "x", "BT"))
+	log.Lvl1("Disruption Phase 2: Received the shared secret of Client", msg.ClientID, "for Trustee", msg.TrusteeID)
+
+	if err := p.checkSharedSecretExpected(msg.ClientID, msg.TrusteeID, msg.RoundID); err != nil {
+		return err
 	}
-	pred := proof.Or(preds...) // make a big Or predicate
-	suite := config.CryptoSuite
-	// Verify the signature
-	verifier := pred.Verifier(suite, msg.Pub)
-	err := proof.HashVerify(suite, M, verifier, msg.NIZK)
+	err := crypto.VerifySharedSecret(p.relayState.suite, p.relayState.clients[msg.ClientID].PublicKey,
+		p.relayState.trustees[msg.TrusteeID].PublicKey, msg.Secret, msg.RoundID, msg.ClientID, msg.TrusteeID, msg.NIZK)
 	if err != nil {
-		log.Error("signature failed to verify: ", err)
+		log.Error("Disruption Phase 2: Client", msg.ClientID, "revealed a wrong shared secret,", err)
+		p.blameVerdict([]int{msg.ClientID}, nil)
+		return nil
 	}
-	log.Lvl3("Linkable Ring Signature verified.")
+	p.judgeWithSharedSecret(msg.Secret)
+	return nil
+}
 
-	val := p.replayRounds(msg.Secret)
-	if val != p.relayState.blamingData.ClientBitRevealed {
-		log.Fatal("Disruption Phase 2: Disruptor is Client", msg.ClientID, ".")
-	} else {
-		log.Lvl1("Disruption Phase 2: Client", msg.ClientID, "didn't lie, so it should be Client", msg.TrusteeID, ".")
+/*
+checkSharedSecretExpected returns an error if we are not blaming the pair of the client "clientID" and the trustee
+"trusteeID" for the round "roundID"
+*/
+func (p *PriFiLibRelayInstance) checkSharedSecretExpected(clientID, trusteeID int, roundID int32) error {
+	blame := p.relayState.blamingData
+	if !p.relayState.blameRunning || clientID != blame.ClientID || trusteeID != blame.TrusteeID || roundID != blame.RoundID {
+		return errors.New("Disruption Phase 2: unexpected secret of client " + strconv.Itoa(clientID) + " and trustee " +
+			strconv.Itoa(trusteeID) + " for round " + strconv.Itoa(int(roundID)))
 	}
 	return nil
 }
 
 /*
-replayRounds takes the secret revealed by a user and recomputes until the disrupted bit
+judgeWithSharedSecret is called with the secret of the mismatching pair, proven correct by the client or the trustee.
+The pad of the disrupted round is regenerated, and the one whose revealed bit differs from the pad is the disruptor
 */
-func (p *PriFiLibRelayInstance) replayRounds(secret kyber.Point) int {
-	seed, err := secret.MarshalBinary()
+func (p *PriFiLibRelayInstance) judgeWithSharedSecret(secret kyber.Point) {
+	blame := p.relayState.blamingData
+	val, err := p.replayRounds(secret)
 	if err != nil {
-		log.Fatal("Could not extract data from shared key", err)
+		log.Error("Disruption Phase 2: cannot regenerate the pad of round", blame.RoundID, ",", err)
+		return
 	}
-	sharedPRNG := config.CryptoSuite.XOF(seed)
-
-	round := int32(0)
-	disruptive_round := p.relayState.blamingData.RoundID
-	for round < disruptive_round {
-
-		dummy := make([]byte, p.relayState.DCNet.DCNetPayloadSize)
-		sharedPRNG.XORKeyStream(dummy, dummy)
-		round++
-
+	if val != blame.TrusteeBitRevealed {
+		p.blameVerdict(nil, []int{blame.TrusteeID})
+	} else {
+		// the bits revealed by the pair mismatch, the client lied
+		p.blameVerdict([]int{blame.ClientID}, nil)
 	}
+}
 
-	p_ij := make([]byte, p.relayState.DCNet.DCNetPayloadSize)
-	sharedPRNG.XORKeyStream(p_ij, p_ij)
-
-	var rtn int
+/*
+blameVerdict ends the blame protocol, and reports the disruptors to the timeout handler, like the misbehaving nodes
+*/
+func (p *PriFiLibRelayInstance) blameVerdict(clients, trustees []int) {
+	log.Error("Disruption Phase 2: Disruptor is clients", clients, "and trustees", trustees, ".")
+	p.relayState.blameRunning = false
+	p.relayState.timeoutHandler(clients, trustees)
+}
 
-	bitPosition := p.relayState.blamingData.BitPos
-	bytePosition := int(bitPosition/8) + 1
-	byte_toGet := p_ij[bytePosition]
-	bitInByte := (8-bitPosition%8)%8 - 1
-	mask := byte(1 << uint(bitInByte))
-	if (byte_toGet & mask) == 0 {
-		rtn = 0
-	} else {
-		rtn = 1
+/*
+replayRounds takes the secret revealed, ratchets it to the epoch of the disrupted round, and regenerates the disrupted bit
+*/
+func (p *PriFiLibRelayInstance) replayRounds(secret kyber.Point) (int, error) {
+	roundID := p.relayState.blamingData.RoundID
+	seed, err := dcnet.EpochSeed(secret, p.epochOfRound(roundID))
+	if err != nil {
+		return 0, err
 	}
+	padCipher, err := dcnet.NewPadCipher(p.relayState.PadCipher, p.relayState.suite, seed)
+	if err != nil {
+		return 0, err
+	}
+
+	// the pads are seekable, directly regenerate the one of the disrupted round
+	p_ij := make([]byte, p.relayState.PayloadSize)
+	padCipher.Pad(roundID, p_ij)
 
-	return rtn
+	return dcnet.BitAt(p_ij, bEchoFlagBits+p.relayState.blamingData.BitPos), nil
 }
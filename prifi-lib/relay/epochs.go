@@ -0,0 +1,96 @@
+package relay
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+The relay decides when a new DC-net epoch starts (every EpochRounds rounds, or every EpochDuration ms), and announces it
+before the clients and trustees need it : to the clients in every REL_CLI_DOWNSTREAM_DATA, and to the trustees with
+REL_TRU_TELL_EPOCH. The trustees run ahead of the relay; a trustee that already sent some rounds of the new epoch with
+the pads of the previous epoch re-sends them, and the relay drops the ciphers computed in the wrong epoch.
+*/
+
+// epochOfRound returns the epoch of the round "roundID", among the epochs announced. The rounds before the epochs
+// pruned are given the first epoch kept
+func (p *PriFiLibRelayInstance) epochOfRound(roundID int32) int32 {
+	starts := p.relayState.epochStarts
+	for i := len(starts) - 1; i > 0; i-- {
+		if roundID >= starts[i] {
+			return p.relayState.firstEpoch + int32(i)
+		}
+	}
+	return p.relayState.firstEpoch
+}
+
+// lastEpoch returns the last epoch announced, and its first round
+func (p *PriFiLibRelayInstance) lastEpoch() (int32, int32) {
+	last := len(p.relayState.epochStarts) - 1
+	return p.relayState.firstEpoch + int32(last), p.relayState.epochStarts[last]
+}
+
+// pruneEpochs forgets the epochs which ended before the round "oldestRound", the first round still in the history :
+// no cipher of those epochs is expected anymore, and the blame protocol does not need them
+func (p *PriFiLibRelayInstance) pruneEpochs(oldestRound int32) {
+	for len(p.relayState.epochStarts) > 1 && p.relayState.epochStarts[1] <= oldestRound {
+		p.relayState.epochStarts = p.relayState.epochStarts[1:]
+		p.relayState.firstEpoch++
+	}
+}
+
+// scheduleNextEpochIfNeeded is called when opening the round "roundID". If a new epoch is due, it starts at the first
+// round that neither the clients nor the trustees encoded yet (as far as the relay knows), and is announced to the trustees.
+// The trustee ciphers of the new epoch still in flight were computed with the old pads, they are dropped when received
+func (p *PriFiLibRelayInstance) scheduleNextEpochIfNeeded(roundID int32) {
+	if p.relayState.EpochRounds <= 0 && p.relayState.EpochDuration <= 0 {
+		return
+	}
+	_, lastEpochStart := p.lastEpoch()
+	if lastEpochStart > roundID {
+		// the last epoch announced did not start yet
+		return
+	}
+
+	earliest := roundID + 1
+	if p.relayState.lastTrusteeRoundReceived+1 > earliest {
+		earliest = p.relayState.lastTrusteeRoundReceived + 1
+	}
+	roundsElapsed := p.relayState.EpochRounds > 0 && int(earliest-lastEpochStart) >= p.relayState.EpochRounds
+	timeElapsed := p.relayState.EpochDuration > 0 &&
+		time.Since(p.relayState.epochScheduledAt) >= time.Duration(p.relayState.EpochDuration)*time.Millisecond
+	if !roundsElapsed && !timeElapsed {
+		return
+	}
+
+	epoch := p.announceEpoch(earliest, nil, nil)
+	log.Lvl2("Relay : DC-net epoch", epoch, "starts at round", earliest)
+}
+
+// announceEpoch starts a new epoch at the round "roundID", and announces it to the trustees, with the clients joining
+// and leaving the DC-net at this epoch. The trustee ciphers already received for the rounds of this epoch were computed
+// with the pads of the previous epoch, they are discarded, and the trustees send them again. Returns the new epoch
+func (p *PriFiLibRelayInstance) announceEpoch(roundID int32, newClientsPks []kyber.Point, departedClients []int) int32 {
+	lastEpoch, _ := p.lastEpoch()
+	epoch := lastEpoch + 1
+	p.relayState.epochStarts = append(p.relayState.epochStarts, roundID)
+	p.relayState.epochScheduledAt = time.Now()
+
+	toSend := &net.REL_TRU_TELL_EPOCH{
+		EpochID:         epoch,
+		StartRoundID:    roundID,
+		NewClientsPks:   newClientsPks,
+		DepartedClients: departedClients}
+	for j := 0; j < p.relayState.nTrustees; j++ {
+		p.messageSender.SendToTrusteeWithLog(j, toSend, "(trustee "+strconv.Itoa(j)+", epoch "+strconv.Itoa(int(epoch))+")")
+	}
+
+	for r := roundID; r <= p.relayState.lastTrusteeRoundReceived; r++ {
+		p.relayState.roundManager.DiscardTrusteeCiphers(r)
+	}
+	return epoch
+}
@@ -0,0 +1,133 @@
+package relay
+
+import (
+	"sort"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+History of the disruption protection. To find a disruptor, the relay needs the ciphers of every client and trustee for
+the disrupted round, and it retransmits the upstream data of a round nClients rounds later (b_echo_last). A blame
+request refers to the round 2*nClients rounds before the one carrying it, hence we keep the ciphers, the upstream data
+and the b_echo_last flags of the last HistoryRounds rounds closed (by default, 2*nClients, the blame window); the older
+rounds are evicted when a round is closed. With HistoryMaxBytes > 0, the oldest rounds are evicted earlier, to keep the
+history under this size : a disruption in those rounds cannot be blamed anymore.
+The rounds in the history are kept in increasing order, so that evicting a round only looks at the oldest ones.
+The round being blamed is kept until the end of the blame.
+*/
+
+// historyRoundsKept returns the number of rounds closed kept in the history
+func (p *PriFiLibRelayInstance) historyRoundsKept() int {
+	if p.relayState.HistoryRounds > 0 {
+		return p.relayState.HistoryRounds
+	}
+	return 2 * p.relayState.nClients
+}
+
+// recordCipher stores the cipher "data" of the entity "id", out of "nEntities", for the round "roundID" in "history",
+// unless this round is already evicted
+func (p *PriFiLibRelayInstance) recordCipher(history map[int32]map[int32][]byte, id int, nEntities int, roundID int32, data []byte) {
+	if id < 0 || id >= nEntities {
+		log.Error("Relay : not recording the cipher of unknown entity", id, "for round", roundID)
+		return
+	}
+	if roundID < p.relayState.historyOldestRound {
+		return
+	}
+	if history[int32(id)] == nil {
+		history[int32(id)] = make(map[int32][]byte)
+	}
+	p.addToHistorySize(roundID, len(data)-len(history[int32(id)][roundID]))
+	history[int32(id)][roundID] = data
+}
+
+// recordUpstreamData stores the upstream data of the round "roundID", retransmitted if a client asks for it
+func (p *PriFiLibRelayInstance) recordUpstreamData(roundID int32, data []byte) {
+	if roundID < p.relayState.historyOldestRound {
+		return
+	}
+	p.addToHistorySize(roundID, len(data)-len(p.relayState.LastMessageOfClients[roundID]))
+	p.relayState.LastMessageOfClients[roundID] = data
+}
+
+// recordBEchoFlag stores the b_echo_last flag of the round "roundID"
+func (p *PriFiLibRelayInstance) recordBEchoFlag(roundID int32, flag byte) {
+	if roundID < p.relayState.historyOldestRound {
+		return
+	}
+	if _, found := p.relayState.BEchoFlags[roundID]; !found {
+		p.addToHistorySize(roundID, 1)
+	}
+	p.relayState.BEchoFlags[roundID] = flag
+}
+
+// addToHistorySize adds "n" bytes to the size of the round "roundID" in the history
+func (p *PriFiLibRelayInstance) addToHistorySize(roundID int32, n int) {
+	if _, found := p.relayState.historySizes[roundID]; !found {
+		// mostly the newest round, the ciphers of the trustees come first
+		rounds := p.relayState.historyRounds
+		i := sort.Search(len(rounds), func(i int) bool { return rounds[i] > roundID })
+		rounds = append(rounds, 0)
+		copy(rounds[i+1:], rounds[i:])
+		rounds[i] = roundID
+		p.relayState.historyRounds = rounds
+	}
+	p.relayState.historySizes[roundID] += n
+	p.relayState.historyBytes += n
+}
+
+// forgetHistory is called when the round "roundID" is closed. It evicts the rounds out of the blame window, then the
+// oldest rounds while the history is over HistoryMaxBytes, and updates the statistics
+func (p *PriFiLibRelayInstance) forgetHistory(roundID int32) {
+	oldestRound := roundID - int32(p.historyRoundsKept()) + 1
+	if oldestRound > p.relayState.historyOldestRound {
+		p.relayState.historyOldestRound = oldestRound
+	}
+
+	// the oldest rounds first; the round being blamed stays at the front
+	evicted := 0
+	kept := 0
+	for kept < len(p.relayState.historyRounds) {
+		r := p.relayState.historyRounds[kept]
+		overBudget := p.relayState.HistoryMaxBytes > 0 && p.relayState.historyBytes > p.relayState.HistoryMaxBytes
+		if r >= p.relayState.historyOldestRound && (!overBudget || r > roundID) {
+			break
+		}
+		if r >= p.relayState.historyOldestRound {
+			// over budget, this round cannot be blamed anymore
+			p.relayState.historyOldestRound = r + 1
+		}
+		if p.evictRound(r) == 0 {
+			kept++
+			continue
+		}
+		evicted++
+		p.relayState.historyRounds = append(p.relayState.historyRounds[:kept], p.relayState.historyRounds[kept+1:]...)
+	}
+
+	p.pruneEpochs(p.relayState.historyOldestRound)
+
+	p.relayState.historyStatistics.AddEvictedRounds(evicted)
+	p.relayState.historyStatistics.SetSize(len(p.relayState.historySizes), p.relayState.historyBytes)
+	p.collectExperimentResult(p.relayState.historyStatistics.Report())
+}
+
+// evictRound removes the round "roundID" from the history, unless it is being blamed. Returns 1 if it was evicted
+func (p *PriFiLibRelayInstance) evictRound(roundID int32) int {
+	if p.relayState.blameRunning && roundID == p.relayState.blamingData.RoundID {
+		return 0
+	}
+
+	for _, ciphers := range p.relayState.CiphertextsHistoryClients {
+		delete(ciphers, roundID)
+	}
+	for _, ciphers := range p.relayState.CiphertextsHistoryTrustees {
+		delete(ciphers, roundID)
+	}
+	delete(p.relayState.LastMessageOfClients, roundID)
+	delete(p.relayState.BEchoFlags, roundID)
+	p.relayState.historyBytes -= p.relayState.historySizes[roundID]
+	delete(p.relayState.historySizes, roundID)
+	return 1
+}
@@ -15,9 +15,15 @@ Then, this file simple handle the answer to the different message kind :
 - TRU_REL_TELL_NEW_BASE_AND_EPH_PKS - when we receive the result of one shuffle, we forward it to the next trustee
 - TRU_REL_SHUFFLE_SIG - when the shuffle has been done by all trustee, we send the transcript, and they answer with a signature, which we
 						   broadcast to the clients
+- CLI_REL_TELL_PK_AND_EPH_PK, TRU_REL_TELL_NEW_BASE_AND_EPH_PKS, TRU_REL_SHUFFLE_SIG - while communicating, the steps of a
+						   re-shuffle of the slots, see reshuffle.go
+- CLI_REL_JOIN - a client connects while communicating, it joins at the next re-shuffle, see join.go
+- CLI_REL_LEAVE - the connection of a client closed while communicating, it is dropped at the next round, see departures.go
 - CLI_REL_UPSTREAM_DATA - data for the DC-net
+- CLI_REL_DOWNSTREAM_NACK - a client missed some downstream data on UDP, we send it again over TCP, see retransmissions.go
 - REL_CLI_UDP_DOWNSTREAM_DATA - is NEVER received here, but casted to CLI_REL_UPSTREAM_DATA by messages.go
 - TRU_REL_DC_CIPHER - data for the DC-net
+- TRU_REL_DOWNSTREAM_EQUIVOCATION - a trustee found that the clients did not receive the same downstream cell
 
 local functions :
 
@@ -28,24 +34,32 @@ checkIfRoundHasEndedAfterTimeOut_Phase1() - called by sendDownstreamData(), whic
 											   retransmit messages to client over TCP
 checkIfRoundHasEndedAfterTimeOut_Phase2() - called by checkIfRoundHasEndedAfterTimeOut_Phase1(). After some long time, entities that didn't send us data should be
 considered disconnected
+dropDepartedClients() - called by checkIfRoundHasEndedAfterTimeOut_Phase1(), when too many rounds failed because of some
+						clients only : they are dropped from the DC-net instead of restarting, see departures.go
+forgetHistory() - called when a round is closed, evicts the rounds out of the blame window from the history of the
+				  disruption protection, see history.go
 
 */
 
 import (
 	"errors"
 
+	"github.com/dedis/prifi/prifi-lib/config"
 	"github.com/dedis/prifi/prifi-lib/dcnet"
 	prifilog "github.com/dedis/prifi/prifi-lib/log"
 	"github.com/dedis/prifi/prifi-lib/net"
 	"github.com/dedis/prifi/prifi-lib/scheduler"
 	"github.com/dedis/prifi/prifi-lib/utils"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/onet/v3/log"
 
 	"github.com/dedis/prifi/prifi-lib/crypto"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // PriFiLibInstance contains the mutable state of a PriFi entity.
@@ -77,14 +91,21 @@ func NewRelay(dataOutputEnabled bool, dataForClients chan []byte, dataFromDCNet
 	relayState.timeStatistics["waiting-on-trustees"] = prifilog.NewTimeStatistics()
 	relayState.timeStatistics["sending-data"] = prifilog.NewTimeStatistics()
 	relayState.timeStatistics["pcap-delay"] = prifilog.NewTimeStatistics()
-	relayState.PublicKey, relayState.privateKey = crypto.NewKeyPair()
+	relayState.timeStatistics["window-size"] = prifilog.NewTimeStatistics()
+	relayState.CryptoSuite = config.DefaultCryptoSuiteName
+	relayState.suite = config.CryptoSuite
+	relayState.PublicKey, relayState.privateKey = crypto.NewKeyPair(relayState.suite)
+	relayState.SlotScheduler = scheduler.SLOT_SCHEDULER_BITMASK
 	relayState.slotScheduler = new(scheduler.BitMaskSlotScheduler_Relay)
+	relayState.MaxSlotsPerClient = 1
 	relayState.roundManager = new(BufferableRoundManager)
 	relayState.processingLock = *new(sync.Mutex)
 	neffShuffle := new(scheduler.NeffShuffle)
-	neffShuffle.Init()
+	neffShuffle.Init(relayState.suite)
 	relayState.neffShuffle = neffShuffle.RelayView
 	relayState.Name = "Relay"
+	relayState.dcNetType = "Simple"
+	relayState.PadCipher = dcnet.PAD_CIPHER_XOF
 
 	//init the state machine
 	states := []string{"BEFORE_INIT", "COLLECTING_TRUSTEES_PKS", "COLLECTING_CLIENT_PKS", "COLLECTING_SHUFFLES", "COLLECTING_SHUFFLE_SIGNATURES", "COMMUNICATING", "BLAMING", "SHUTDOWN"}
@@ -157,14 +178,15 @@ type RelayState struct {
 	UseOpenClosedSlots                     bool
 	UseUDP                                 bool
 	numberOfNonAckedDownstreamPackets      int
-	WindowSize                             int
+	WindowSize                             int  // the most rounds opened at once
+	AdaptiveWindow                         bool // the number of rounds opened at once adapts to the round durations, see window.go
 	ExperimentResultChannel                chan interface{}
 	ExperimentResultData                   []string
 	timeoutHandler                         func([]int, []int)
 	bitrateStatistics                      *prifilog.BitrateStatistics
 	schedulesStatistics                    *prifilog.SchedulesStatistics
 	timeStatistics                         map[string]*prifilog.TimeStatistics
-	slotScheduler                          *scheduler.BitMaskSlotScheduler_Relay
+	slotScheduler                          scheduler.SlotScheduler_Relay
 	dcNetType                              string
 	time0                                  uint64
 	pcapLogger                             *utils.PCAPLog
@@ -174,10 +196,57 @@ type RelayState struct {
 	numberOfConsecutiveFailedRounds        int
 	MaxNumberOfConsecutiveFailedRounds     int // Kill the protocol if that many rounds fail consecutively
 	ProcessingLoopSleepTime                int
-	RoundTimeOut                           int //The timeout before retransmission (UDP) and/or considering the round failed
+	RoundTimeOut                           int                   //The timeout before retransmission (UDP) and/or considering the round failed
+	AdaptiveRoundTimeOut                   bool                  // the timeout follows the response times, up to RoundTimeOut, see rtt.go
+	clientsRTT                             map[int]*rttEstimator // the response times of the clients
+	trusteesRTT                            map[int]*rttEstimator // the response times of the trustees
+	timeoutStatistics                      *prifilog.TimeoutStatistics
 	TrusteeCacheLowBound                   int // Number of ciphertexts buffered by trustees. When <= TRUSTEE_CACHE_LOWBOUND, resume sending
 	TrusteeCacheHighBound                  int // Number of ciphertexts buffered by trustees. When >= TRUSTEE_CACHE_HIGHBOUND, stop sending
 	EquivocationProtectionEnabled          bool
+	MisbehavingNodes                       map[int32]*MisbehavingNodes // the nodes whose ciphers were rejected, per round
+	PadCipher                              string                      // the generator of the DC-net pads, see dcnet.PAD_CIPHER_*
+	CryptoSuite                            string                      // the crypto suite of the keys, shuffle and NIZKs, see config.CRYPTO_SUITE_*
+	EpochRounds                            int                         // a new DC-net epoch starts every that many rounds (0 = never)
+	EpochDuration                          int                         // a new DC-net epoch starts every that many ms (0 = never)
+	DownstreamConsistencyCheck             bool                        // the trustees check that the clients received the same downstream cells
+	SlotScheduler                          string                      // the scheduler of the open/closed slots, see scheduler.SLOT_SCHEDULER_*
+	scheduleRequestPending                 bool                        // true while the rounds following a footprint schedule request wait for its decoding
+	MaxSlotsPerClient                      int                         // the most slots a client can reserve in one schedule
+	ReshuffleRounds                        int                         // the slots are re-shuffled every that many rounds (0 = never)
+	ReshuffleDuration                      int                         // the slots are re-shuffled every that many ms (0 = never)
+	ClientsVerifyShuffle                   bool                        // the clients get the transcript of each shuffle, and verify all its proofs
+	suite                                  suites.Suite
+
+	//DC-net epochs, see epochs.go
+	epochStarts              []int32 // the first round of each epoch announced and not pruned, from firstEpoch on
+	firstEpoch               int32   // the epoch of epochStarts[0]
+	epochScheduledAt         time.Time
+	lastTrusteeRoundReceived int32 // the highest round for which a trustee cipher was received
+
+	//re-shuffles, see reshuffle.go
+	reshuffle       *scheduler.NeffShuffleRelay                // the re-shuffle running, or waiting for its first round
+	reshuffleEphPks map[int]kyber.Point                        // the fresh ephemeral keys of the clients, per client
+	reshuffleResult *net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG // the signed re-shuffle, until its first round
+	slotsStartRound int32                                      // the first round of the current slots
+	slotsStartedAt  time.Time
+
+	//clients joining while communicating, see join.go
+	joiningClients   []NodeRepresentation // the clients announced with CLI_REL_JOIN, in the order of their IDs
+	reshuffleJoiners int                  // how many of them are in the re-shuffle running
+
+	//clients leaving while communicating, see departures.go
+	departedClients map[int]int32 // the clients dropped from the DC-net, and the first round without them
+
+	//downstream consistency check, see consistency.go
+	downstreamDigests       map[int32]map[int]downstreamDigest    // the digests sent by the clients, per round and client
+	downstreamEquivocations []net.TRU_REL_DOWNSTREAM_EQUIVOCATION // the evidence reported by the trustees
+
+	//variable-length slots, see slots.go
+	VariableSlotLengths bool          // the owner of a slot asks for the length of its next slot
+	MaxPayloadSize      int           // the longest slot an owner can ask for
+	nextSlotLengths     map[int]int   // the length asked by the owner of each slot, for its next slot
+	roundSlotLengths    map[int32]int // the length of the rounds opened, when it is not PayloadSize
 
 	// sync
 	processingLock sync.Mutex // either we treat a message, or a timeout, never both
@@ -191,8 +260,18 @@ type RelayState struct {
 	clientBitMap               map[int]map[int]int
 	trusteeBitMap              map[int]map[int]int
 	blamingData                BlamingData
+	blameRunning               bool // the round of blamingData is kept in the history
 	EphemeralPublicKeys        []kyber.Point
 
+	//bounded history of the disruption protection, see history.go
+	HistoryRounds      int           // the rounds closed kept in the history (0 = twice the number of clients)
+	HistoryMaxBytes    int           // the oldest rounds are evicted earlier to keep the history under this size (0 = no limit)
+	historySizes       map[int32]int // the bytes stored for each round in the history
+	historyRounds      []int32       // the rounds in the history, in increasing order
+	historyBytes       int           // the bytes stored in the history
+	historyOldestRound int32         // the rounds before this one are evicted
+	historyStatistics  *prifilog.HistoryStatistics
+
 	//disruption testing
 	ForceDisruptionSinceRound3 bool
 
@@ -240,6 +319,11 @@ func (p *PriFiLibRelayInstance) ReceivedMessage(msg interface{}) error {
 		if p.stateMachine.AssertState("COMMUNICATING") {
 			err = p.Received_CLI_REL_OPENCLOSED_DATA(typedMsg)
 		}
+	case net.CLI_REL_DOWNSTREAM_NACK:
+		// a late NACK, after a restart, is ignored
+		if p.stateMachine.State() == "COMMUNICATING" {
+			err = p.Received_CLI_REL_DOWNSTREAM_NACK(typedMsg)
+		}
 	case net.TRU_REL_DC_CIPHER:
 		if p.stateMachine.AssertStateOrState("COMMUNICATING", "COLLECTING_SHUFFLE_SIGNATURES") {
 			err = p.Received_TRU_REL_DC_CIPHER(typedMsg)
@@ -248,22 +332,50 @@ func (p *PriFiLibRelayInstance) ReceivedMessage(msg interface{}) error {
 		if p.stateMachine.AssertState("COLLECTING_TRUSTEES_PKS") {
 			err = p.Received_TRU_REL_TELL_PK(typedMsg)
 		}
+	case net.CLI_REL_JOIN:
+		if p.stateMachine.State() == "COMMUNICATING" {
+			err = p.Received_CLI_REL_JOIN(typedMsg)
+		} else {
+			// our host restarts the protocol instead
+			err = errors.New("Relay : client " + strconv.Itoa(typedMsg.ClientID) + " cannot join, we are not communicating")
+		}
+	case net.CLI_REL_LEAVE:
+		if p.stateMachine.State() == "COMMUNICATING" {
+			err = p.Received_CLI_REL_LEAVE(typedMsg)
+		} else {
+			// our host restarts the protocol instead
+			err = errors.New("Relay : client " + strconv.Itoa(typedMsg.ClientID) + " cannot leave, we are not communicating")
+		}
 	case net.CLI_REL_TELL_PK_AND_EPH_PK:
-		if p.stateMachine.AssertState("COLLECTING_CLIENT_PKS") {
+		if p.stateMachine.State() == "COMMUNICATING" && typedMsg.ClientID >= p.relayState.nClients {
+			// the keys of a client joining
+			err = p.join1_collectKeys(typedMsg)
+		} else if p.stateMachine.State() == "COMMUNICATING" {
+			// a fresh ephemeral key, for a re-shuffle
+			err = p.reshuffle1_collectEphPk(typedMsg)
+		} else if p.stateMachine.AssertState("COLLECTING_CLIENT_PKS") {
 			err = p.Received_CLI_REL_TELL_PK_AND_EPH_PK(typedMsg)
 		}
 	case net.TRU_REL_TELL_NEW_BASE_AND_EPH_PKS:
-		if p.stateMachine.AssertState("COLLECTING_SHUFFLES") {
+		if p.stateMachine.State() == "COMMUNICATING" {
+			err = p.reshuffle2_collectShuffle(typedMsg)
+		} else if p.stateMachine.AssertState("COLLECTING_SHUFFLES") {
 			err = p.Received_TRU_REL_TELL_NEW_BASE_AND_EPH_PKS(typedMsg)
 		}
 	case net.TRU_REL_SHUFFLE_SIG:
-		if p.stateMachine.AssertState("COLLECTING_SHUFFLE_SIGNATURES") {
+		if p.stateMachine.State() == "COMMUNICATING" {
+			err = p.reshuffle3_collectSignature(typedMsg)
+		} else if p.stateMachine.AssertState("COLLECTING_SHUFFLE_SIGNATURES") {
 			err = p.Received_TRU_REL_SHUFFLE_SIG(typedMsg)
 		}
 	case net.CLI_REL_DISRUPTION_BLAME:
 		if p.stateMachine.AssertState("COMMUNICATING") {
 			err = p.Received_CLI_REL_DISRUPTION_BLAME(typedMsg)
 		}
+	case net.TRU_REL_DOWNSTREAM_EQUIVOCATION:
+		if p.stateMachine.AssertState("COMMUNICATING") {
+			err = p.Received_TRU_REL_DOWNSTREAM_EQUIVOCATION(typedMsg)
+		}
 	default:
 		err = errors.New("Unrecognized message, type" + reflect.TypeOf(msg).String())
 	}
@@ -0,0 +1,107 @@
+package relay
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Clients joining while we communicate. The host of the relay announces a client which connects with CLI_REL_JOIN, instead
+of restarting the protocol; the other clients keep communicating, and the new client joins at the next re-shuffle :
+1) we send it the parameters, as at setup, and it answers with its keys (CLI_REL_TELL_PK_AND_EPH_PK)
+2) the next re-shuffle starts as soon as we have its keys, even if no re-shuffle is due, and its ephemeral key is
+shuffled with the fresh ones of the other clients
+3) a new DC-net epoch starts at the first round of the re-shuffled slots : we announce the new clients to the trustees
+with this epoch, and they share pads with them from this round on. The new clients start communicating at this round.
+The trustee ciphers of this round and the next ones already received were computed without the new clients, the
+trustees send them again.
+With the equivocation protection, the new clients receive the history of the downstream cells sent before this round,
+with the re-shuffled slots. The disruption protection and the verifiable DC-net keep a state about every client since
+the setup, they need a restart : the join is refused, and the host restarts the protocol with the new client.
+*/
+
+// Received_CLI_REL_JOIN handles CLI_REL_JOIN messages, sent by our host when a client connects while we communicate. The
+// client gets the next free ID; we send it the parameters, for it to send us its keys
+func (p *PriFiLibRelayInstance) Received_CLI_REL_JOIN(msg net.CLI_REL_JOIN) error {
+	if p.relayState.dcNetType == "Verifiable" || p.relayState.DisruptionProtectionEnabled {
+		return errors.New("Relay : client " + strconv.Itoa(msg.ClientID) + " cannot join, the verifiable DC-net and the " +
+			"disruption protection need a restart")
+	}
+	nClients := p.relayState.nClients + len(p.relayState.joiningClients) + 1
+	if msg.ClientID != nClients-1 {
+		return errors.New("Relay : client " + strconv.Itoa(msg.ClientID) + " cannot join, the next free client ID is " +
+			strconv.Itoa(nClients-1))
+	}
+	if p.relayState.UseOpenClosedSlots && p.relayState.slotScheduler.Relay_ContributionLength(nClients) > p.relayState.PayloadSize {
+		return errors.New("Relay : client " + strconv.Itoa(msg.ClientID) + " cannot join, the contributions of the " +
+			p.relayState.SlotScheduler + " slot scheduler would not fit in PayloadSize")
+	}
+
+	p.relayState.joiningClients = append(p.relayState.joiningClients, NodeRepresentation{ID: msg.ClientID})
+	log.Lvl1("Relay : client", msg.ClientID, "joins, it will communicate after the next re-shuffle")
+
+	toSend := p.clientsParameters()
+	toSend.Add("NClients", nClients)
+	toSend.Add("NextFreeClientID", msg.ClientID)
+	toSend.TrusteesPks = make([]kyber.Point, p.relayState.nTrustees)
+	for i := range toSend.TrusteesPks {
+		toSend.TrusteesPks[i] = p.relayState.trustees[i].PublicKey
+	}
+	p.messageSender.SendToClientWithLog(msg.ClientID, toSend, "(joining client "+strconv.Itoa(msg.ClientID)+")")
+	return nil
+}
+
+// join1_collectKeys handles the CLI_REL_TELL_PK_AND_EPH_PK messages of the joining clients. Their ephemeral key is
+// shuffled by the next re-shuffle
+func (p *PriFiLibRelayInstance) join1_collectKeys(msg net.CLI_REL_TELL_PK_AND_EPH_PK) error {
+	i := msg.ClientID - p.relayState.nClients
+	if i < 0 || i >= len(p.relayState.joiningClients) {
+		return errors.New("Relay : received the keys of client " + strconv.Itoa(msg.ClientID) + ", which is not joining")
+	}
+	if msg.Pk == nil || msg.EphPk == nil {
+		return errors.New("Relay : joining client " + strconv.Itoa(msg.ClientID) + " sent no keys")
+	}
+	if p.relayState.joiningClients[i].PublicKey != nil {
+		return errors.New("Relay : joining client " + strconv.Itoa(msg.ClientID) + " already sent its keys")
+	}
+
+	p.relayState.joiningClients[i] = NodeRepresentation{msg.ClientID, true, msg.Pk, msg.EphPk}
+	log.Lvl2("Relay : received the keys of joining client", msg.ClientID)
+	return nil
+}
+
+// joiningClientsReady returns how many joining clients can be added to a re-shuffle : the ones which sent their keys,
+// up to the first which did not, so that the IDs of the clients stay contiguous
+func (p *PriFiLibRelayInstance) joiningClientsReady() int {
+	for i, c := range p.relayState.joiningClients {
+		if c.PublicKey == nil {
+			return i
+		}
+	}
+	return len(p.relayState.joiningClients)
+}
+
+// join2_addClients is called when the re-shuffled slots start at the round "roundID", if the re-shuffle has joining
+// clients. They take part in the DC-net from this round on, which starts a new epoch, announced to the trustees with
+// the keys of the new clients
+func (p *PriFiLibRelayInstance) join2_addClients(roundID int32) {
+	joining := p.relayState.joiningClients[:p.relayState.reshuffleJoiners]
+	newClientsPks := make([]kyber.Point, len(joining))
+	for i, c := range joining {
+		newClientsPks[i] = c.PublicKey
+		p.relayState.CiphertextsHistoryClients[int32(c.ID)] = make(map[int32][]byte)
+	}
+	p.relayState.clients = append(p.relayState.clients, joining...)
+	p.relayState.nClients += len(joining)
+	p.relayState.joiningClients = append([]NodeRepresentation{}, p.relayState.joiningClients[len(joining):]...)
+	p.relayState.reshuffleJoiners = 0
+	p.relayState.roundManager.SetNClients(p.relayState.nClients, roundID)
+
+	epoch := p.announceEpoch(roundID, newClientsPks, nil)
+	log.Lvl1("Relay :", len(newClientsPks), "clients joined at round", roundID, ", epoch", epoch, ",", p.relayState.nClients,
+		"clients now")
+}
@@ -0,0 +1,96 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"go.dedis.ch/onet/v3/log"
+	"strconv"
+)
+
+/*
+A cipher that cannot be decoded (malformed, wrong length, wrong round, invalid proof) must not crash the relay.
+It is quarantined: left out of the DC-net, and its sender is marked as misbehaving for that round. Since its pads
+are missing, the output of the round is meaningless and discarded. The round then counts as a failed round, like
+a round that timed out; if too many rounds fail consecutively, the misbehaving nodes are reported to the timeout
+handler (which restarts the protocol).
+A cell protected against equivocation that cannot be decrypted (the clients did not all receive the same downstream
+cells) fails the round the same way, without any node to blame.
+*/
+
+// MisbehavingNodes holds the clients and trustees whose ciphers were rejected in a round
+type MisbehavingNodes struct {
+	Clients  []int
+	Trustees []int
+}
+
+// decodeRoundCiphers feeds the ciphers of the round "roundID" to the DC-net. The ciphers that cannot be decoded
+// are left out, and their senders are returned.
+func (p *PriFiLibRelayInstance) decodeRoundCiphers(roundID int32, clientSlices, trusteesSlices [][]byte) *MisbehavingNodes {
+	rejected := new(MisbehavingNodes)
+
+	ownerSlot := -1
+	if p.relayState.DCNet.IsVerifiable() {
+		ownerSlot = p.relayState.roundManager.SlotOwnerOfRound(roundID)
+	}
+	for clientID, s := range clientSlices {
+		var err error
+		if p.relayState.DCNet.IsVerifiable() {
+			err = p.relayState.DCNet.DecodeVerifiableClient(roundID, clientID, ownerSlot, s)
+		} else {
+			err = p.relayState.DCNet.DecodeClient(roundID, s)
+		}
+		if err != nil {
+			log.Error("Relay : rejected the cipher of client", clientID, "in round", roundID, ":", err)
+			rejected.Clients = append(rejected.Clients, clientID)
+		}
+	}
+	for trusteeID, s := range trusteesSlices {
+		if err := p.relayState.DCNet.DecodeTrustee(roundID, s); err != nil {
+			log.Error("Relay : rejected the cipher of trustee", trusteeID, "in round", roundID, ":", err)
+			rejected.Trustees = append(rejected.Trustees, trusteeID)
+		}
+	}
+
+	if len(rejected.Clients) == 0 && len(rejected.Trustees) == 0 {
+		return nil
+	}
+	return rejected
+}
+
+// quarantine marks the nodes as misbehaving for the round "roundID", and returns the error discarding the round
+func (p *PriFiLibRelayInstance) quarantine(roundID int32, misbehaving *MisbehavingNodes) error {
+	p.relayState.MisbehavingNodes[roundID] = misbehaving
+	return errors.New("Relay : round " + strconv.Itoa(int(roundID)) + " discarded, rejected the ciphers of clients " +
+		fmt.Sprint(misbehaving.Clients) + " and trustees " + fmt.Sprint(misbehaving.Trustees))
+}
+
+// undecodableCell marks the round "roundID" as failed, since its cell could not be decoded, and returns the error
+// discarding the round
+func (p *PriFiLibRelayInstance) undecodableCell(roundID int32, err error) error {
+	log.Error("Relay : could not decode the cell of round", roundID, ", the clients did not receive the same downstream cells :", err)
+	p.relayState.MisbehavingNodes[roundID] = new(MisbehavingNodes)
+	return errors.New("Relay : round " + strconv.Itoa(int(roundID)) + " discarded, " + err.Error())
+}
+
+// handleMisbehavingNodes is called when the round "roundID" is finalized. If some ciphers were rejected in this round,
+// it counts as a failed round, and if too many rounds failed, the misbehaving nodes are reported to the timeout handler.
+// Returns true if the round failed.
+func (p *PriFiLibRelayInstance) handleMisbehavingNodes(roundID int32) bool {
+	misbehaving, found := p.relayState.MisbehavingNodes[roundID]
+	if !found {
+		return false
+	}
+	delete(p.relayState.MisbehavingNodes, roundID)
+
+	p.relayState.numberOfConsecutiveFailedRounds++
+	log.Lvl1("WARNING: Round", roundID, "failed because of clients", misbehaving.Clients, "and trustees", misbehaving.Trustees,
+		". Already", p.relayState.numberOfConsecutiveFailedRounds, "consecutive failed rounds (killing when =>",
+		p.relayState.MaxNumberOfConsecutiveFailedRounds, ")")
+
+	if p.relayState.numberOfConsecutiveFailedRounds >= p.relayState.MaxNumberOfConsecutiveFailedRounds {
+		log.Error("MAX_NUMBER_OF_CONSECUTIVE_FAILED_ROUNDS (", p.relayState.MaxNumberOfConsecutiveFailedRounds,
+			") reached because of misbehaving nodes, killing protocol.")
+		p.relayState.timeoutHandler(misbehaving.Clients, misbehaving.Trustees)
+	}
+	return true
+}
@@ -42,12 +42,15 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"github.com/dedis/prifi/prifi-lib/config"
+	"github.com/dedis/prifi/prifi-lib/crypto"
 	"github.com/dedis/prifi/prifi-lib/dcnet"
 	prifilog "github.com/dedis/prifi/prifi-lib/log"
 	"github.com/dedis/prifi/prifi-lib/net"
+	"github.com/dedis/prifi/prifi-lib/scheduler"
 	"github.com/dedis/prifi/prifi-lib/utils"
 	"github.com/dedis/prifi/utils"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/onet/v3/log"
 	"os/exec"
 	"runtime"
@@ -94,24 +97,111 @@ func (p *PriFiLibRelayInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PARA
 	payloadSize := msg.IntValueOrElse("PayloadSize", p.relayState.PayloadSize)
 	downCellSize := msg.IntValueOrElse("DownstreamCellSize", p.relayState.DownstreamCellSize)
 	windowSize := msg.IntValueOrElse("WindowSize", p.relayState.WindowSize)
+	adaptiveWindow := msg.BoolValueOrElse("RelayAdaptiveWindow", p.relayState.AdaptiveWindow)
 	useDummyDown := msg.BoolValueOrElse("UseDummyDataDown", p.relayState.UseDummyDataDown)
 	useOpenClosedSlots := msg.BoolValueOrElse("UseOpenClosedSlots", p.relayState.UseOpenClosedSlots)
 	reportingLimit := msg.IntValueOrElse("ExperimentRoundLimit", p.relayState.ExperimentRoundLimit)
 	useUDP := msg.BoolValueOrElse("UseUDP", p.relayState.UseUDP)
 	dcNetType := msg.StringValueOrElse("DCNetType", p.relayState.dcNetType)
+	padCipher := msg.StringValueOrElse("DCNetPadCipher", p.relayState.PadCipher)
+	cryptoSuite := msg.StringValueOrElse("CryptoSuite", p.relayState.CryptoSuite)
 	disruptionProtection := msg.BoolValueOrElse("DisruptionProtectionEnabled", false)
 	openClosedSlotsMinDelayBetweenRequests := msg.IntValueOrElse("OpenClosedSlotsMinDelayBetweenRequests", p.relayState.OpenClosedSlotsMinDelayBetweenRequests)
 	maxNumberOfConsecutiveFailedRounds := msg.IntValueOrElse("RelayMaxNumberOfConsecutiveFailedRounds", p.relayState.MaxNumberOfConsecutiveFailedRounds)
 	processingLoopSleepTime := msg.IntValueOrElse("RelayProcessingLoopSleepTime", p.relayState.ProcessingLoopSleepTime)
 	roundTimeOut := msg.IntValueOrElse("RelayRoundTimeOut", p.relayState.RoundTimeOut)
+	adaptiveRoundTimeOut := msg.BoolValueOrElse("RelayAdaptiveRoundTimeOut", p.relayState.AdaptiveRoundTimeOut)
 	trusteeCacheLowBound := msg.IntValueOrElse("RelayTrusteeCacheLowBound", p.relayState.TrusteeCacheLowBound)
 	trusteeCacheHighBound := msg.IntValueOrElse("RelayTrusteeCacheHighBound", p.relayState.TrusteeCacheHighBound)
 	equivocationProtectionEnabled := msg.BoolValueOrElse("EquivocationProtectionEnabled", p.relayState.EquivocationProtectionEnabled)
+	epochRounds := msg.IntValueOrElse("DCNetEpochRounds", p.relayState.EpochRounds)
+	epochDuration := msg.IntValueOrElse("DCNetEpochDuration", p.relayState.EpochDuration)
+	downstreamConsistencyCheck := msg.BoolValueOrElse("DownstreamConsistencyCheck", p.relayState.DownstreamConsistencyCheck)
+	slotSchedulerName := msg.StringValueOrElse("SlotScheduler", p.relayState.SlotScheduler)
+	maxSlotsPerClient := msg.IntValueOrElse("MaxSlotsPerClient", p.relayState.MaxSlotsPerClient)
+	variableSlotLengths := msg.BoolValueOrElse("VariableSlotLengths", p.relayState.VariableSlotLengths)
+	maxPayloadSize := msg.IntValueOrElse("MaxPayloadSize", p.relayState.MaxPayloadSize)
+	reshuffleRounds := msg.IntValueOrElse("ReshuffleRounds", p.relayState.ReshuffleRounds)
+	reshuffleDuration := msg.IntValueOrElse("ReshuffleDuration", p.relayState.ReshuffleDuration)
+	clientsVerifyShuffle := msg.BoolValueOrElse("ClientsVerifyShuffle", p.relayState.ClientsVerifyShuffle)
+	historyRounds := msg.IntValueOrElse("RelayHistoryRounds", p.relayState.HistoryRounds)
+	historyMaxBytes := msg.IntValueOrElse("RelayHistoryMaxBytes", p.relayState.HistoryMaxBytes)
 	ForceDisruptionSinceRound3 := msg.BoolValueOrElse("ForceDisruptionSinceRound3", false)
 
 	if payloadSize < 1 {
 		return errors.New("payloadSize cannot be 0")
 	}
+	if padCipher == "" {
+		padCipher = dcnet.PAD_CIPHER_XOF
+	}
+	if err := dcnet.ValidatePadCipher(padCipher); err != nil {
+		return err
+	}
+	if cryptoSuite == "" {
+		cryptoSuite = config.DefaultCryptoSuiteName
+	}
+	suite, err := config.FindCryptoSuite(cryptoSuite)
+	if err != nil {
+		return err
+	}
+
+	switch dcNetType {
+	case "Simple":
+	case "Verifiable":
+		// the verifiable DC-net rejects disruptive cells when decoding them, hence does not need the blame protocol.
+		// It cannot carry the open/closed requests (every client would transmit outside of its slot)
+		// Its pads are not re-keyed, its slots have a fixed length, and its pseudonyms are those of the setup shuffle
+		incompatible := make([]string, 0)
+		if disruptionProtection {
+			incompatible = append(incompatible, "DisruptionProtectionEnabled")
+		}
+		if equivocationProtectionEnabled {
+			incompatible = append(incompatible, "EquivocationProtectionEnabled")
+		}
+		if useOpenClosedSlots {
+			incompatible = append(incompatible, "UseOpenClosedSlots")
+		}
+		if epochRounds > 0 || epochDuration > 0 {
+			incompatible = append(incompatible, "DCNetEpochRounds/DCNetEpochDuration")
+		}
+		if variableSlotLengths {
+			incompatible = append(incompatible, "VariableSlotLengths")
+		}
+		if reshuffleRounds > 0 || reshuffleDuration > 0 {
+			incompatible = append(incompatible, "ReshuffleRounds/ReshuffleDuration")
+		}
+		if len(incompatible) > 0 {
+			return errors.New("The Verifiable DC-net does not support " + strings.Join(incompatible, ", ") + ", disable them or use the Simple DC-net")
+		}
+	default:
+		return errors.New("Unknown DCNetType " + dcNetType + ", should be Simple or Verifiable")
+	}
+
+	if slotSchedulerName == "" {
+		slotSchedulerName = scheduler.SLOT_SCHEDULER_BITMASK
+	}
+	if maxSlotsPerClient < 1 {
+		maxSlotsPerClient = 1
+	}
+	if maxSlotsPerClient > 1 && (!useOpenClosedSlots || slotSchedulerName != scheduler.SLOT_SCHEDULER_FOOTPRINT) {
+		return errors.New("MaxSlotsPerClient > 1 needs the open/closed slots with the " + scheduler.SLOT_SCHEDULER_FOOTPRINT + " slot scheduler")
+	}
+	slotScheduler, err := scheduler.NewSlotScheduler_Relay(slotSchedulerName, maxSlotsPerClient)
+	if err != nil {
+		return err
+	}
+	if useOpenClosedSlots && slotScheduler.Relay_ContributionLength(nClients) > payloadSize {
+		return errors.New("The contributions of the " + slotSchedulerName + " slot scheduler (" +
+			strconv.Itoa(slotScheduler.Relay_ContributionLength(nClients)) + " bytes) do not fit in PayloadSize (" + strconv.Itoa(payloadSize) + " bytes)")
+	}
+
+	if maxPayloadSize == 0 {
+		maxPayloadSize = payloadSize
+	}
+	if maxPayloadSize < payloadSize {
+		return errors.New("MaxPayloadSize (" + strconv.Itoa(maxPayloadSize) + " bytes) cannot be smaller than PayloadSize (" +
+			strconv.Itoa(payloadSize) + " bytes)")
+	}
 
 	p.relayState.clients = make([]NodeRepresentation, nClients)
 	p.relayState.trustees = make([]NodeRepresentation, nTrustees)
@@ -123,23 +213,60 @@ func (p *PriFiLibRelayInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PARA
 	p.relayState.PayloadSize = payloadSize
 	p.relayState.DownstreamCellSize = downCellSize
 	p.relayState.bitrateStatistics = prifilog.NewBitRateStatistics(payloadSize)
+	p.relayState.timeoutStatistics = prifilog.NewTimeoutStatistics()
 	p.relayState.UseDummyDataDown = useDummyDown
 	p.relayState.UseOpenClosedSlots = useOpenClosedSlots
 	p.relayState.UseUDP = useUDP
 	p.relayState.WindowSize = windowSize
+	p.relayState.AdaptiveWindow = adaptiveWindow
 	p.relayState.numberOfNonAckedDownstreamPackets = 0
 	p.relayState.OpenClosedSlotsMinDelayBetweenRequests = openClosedSlotsMinDelayBetweenRequests
 	p.relayState.MaxNumberOfConsecutiveFailedRounds = maxNumberOfConsecutiveFailedRounds
 	p.relayState.ProcessingLoopSleepTime = processingLoopSleepTime
 	p.relayState.RoundTimeOut = roundTimeOut
+	p.relayState.AdaptiveRoundTimeOut = adaptiveRoundTimeOut
+	p.relayState.clientsRTT = newRTTEstimators(nClients)
+	p.relayState.trusteesRTT = newRTTEstimators(nTrustees)
 	p.relayState.TrusteeCacheLowBound = trusteeCacheLowBound
 	p.relayState.TrusteeCacheHighBound = trusteeCacheHighBound
 	p.relayState.EquivocationProtectionEnabled = equivocationProtectionEnabled
+	p.relayState.PadCipher = padCipher
+	p.setCryptoSuite(cryptoSuite, suite)
+	p.relayState.EpochRounds = epochRounds
+	p.relayState.EpochDuration = epochDuration
+	p.relayState.epochStarts = []int32{0}
+	p.relayState.firstEpoch = 0
+	p.relayState.epochScheduledAt = time.Now()
+	p.relayState.lastTrusteeRoundReceived = 0
+	p.relayState.DownstreamConsistencyCheck = downstreamConsistencyCheck
+	p.relayState.downstreamDigests = make(map[int32]map[int]downstreamDigest)
+	p.relayState.SlotScheduler = slotSchedulerName
+	p.relayState.slotScheduler = slotScheduler
+	p.relayState.MaxSlotsPerClient = maxSlotsPerClient
+	p.relayState.scheduleRequestPending = false
+	p.relayState.VariableSlotLengths = variableSlotLengths
+	p.relayState.MaxPayloadSize = maxPayloadSize
+	p.relayState.nextSlotLengths = make(map[int]int)
+	p.relayState.roundSlotLengths = make(map[int32]int)
+	p.relayState.ReshuffleRounds = reshuffleRounds
+	p.relayState.ReshuffleDuration = reshuffleDuration
+	p.relayState.reshuffle = nil
+	p.relayState.reshuffleEphPks = nil
+	p.relayState.reshuffleResult = nil
+	p.relayState.slotsStartRound = 0
+	p.relayState.slotsStartedAt = time.Now()
+	p.relayState.joiningClients = nil
+	p.relayState.reshuffleJoiners = 0
+	p.relayState.departedClients = make(map[int]int32)
+	p.relayState.ClientsVerifyShuffle = clientsVerifyShuffle
 	p.relayState.ForceDisruptionSinceRound3 = ForceDisruptionSinceRound3
-	p.relayState.MessageHistory = config.CryptoSuite.XOF([]byte("init")) //any non-nil, non-empty, constant array
+	p.relayState.MessageHistory = p.relayState.suite.XOF([]byte("init")) //any non-nil, non-empty, constant array
 	p.relayState.VerifiableDCNetKeys = make([][]byte, nTrustees)
 	p.relayState.nVkeysCollected = 0
 	p.relayState.roundManager = NewBufferableRoundManager(nClients, nTrustees, windowSize)
+	if adaptiveWindow {
+		p.relayState.roundManager.EnableAdaptiveWindow()
+	}
 	p.relayState.dcNetType = dcNetType
 	p.relayState.pcapLogger = utils.NewPCAPLog()
 	p.relayState.DisruptionProtectionEnabled = disruptionProtection
@@ -147,6 +274,7 @@ func (p *PriFiLibRelayInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PARA
 	p.relayState.trusteeBitMap = make(map[int]map[int]int)
 	p.relayState.OpenClosedSlotsRequestsRoundID = make(map[int32]bool)
 	p.relayState.LastMessageOfClients = make(map[int32][]byte)
+	p.relayState.MisbehavingNodes = make(map[int32]*MisbehavingNodes)
 	p.relayState.BEchoFlags = make(map[int32]byte)
 	p.relayState.CiphertextsHistoryTrustees = make(map[int32]map[int32][]byte)
 	p.relayState.CiphertextsHistoryClients = make(map[int32]map[int32][]byte)
@@ -157,11 +285,14 @@ func (p *PriFiLibRelayInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PARA
 	for j := int32(0); j < int32(nTrustees); j++ {
 		p.relayState.CiphertextsHistoryTrustees[j] = make(map[int32][]byte)
 	}
-	switch dcNetType {
-	case "Verifiable":
-		panic("Verifiable DCNet not implemented yet")
-	}
-
+	p.relayState.HistoryRounds = historyRounds
+	p.relayState.HistoryMaxBytes = historyMaxBytes
+	p.relayState.historySizes = make(map[int32]int)
+	p.relayState.historyRounds = make([]int32, 0)
+	p.relayState.historyBytes = 0
+	p.relayState.historyOldestRound = 0
+	p.relayState.historyStatistics = prifilog.NewHistoryStatistics()
+	p.relayState.blameRunning = false
 	//this should be in NewRelayState, but we need p
 	if !p.relayState.roundManager.DoSendStopResumeMessages {
 		//Add rate-limiting component to buffer manager
@@ -194,6 +325,21 @@ func (p *PriFiLibRelayInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PARA
 	return nil
 }
 
+// setCryptoSuite switches the relay to the crypto suite "suite" named "name". The keys of the relay live in the suite,
+// hence are regenerated if it changes
+func (p *PriFiLibRelayInstance) setCryptoSuite(name string, suite suites.Suite) {
+	if name == p.relayState.CryptoSuite {
+		return
+	}
+	log.Lvl2("Relay : switching from crypto suite", p.relayState.CryptoSuite, "to", name)
+	p.relayState.CryptoSuite = name
+	p.relayState.suite = suite
+	p.relayState.PublicKey, p.relayState.privateKey = crypto.NewKeyPair(suite)
+	neffShuffle := new(scheduler.NeffShuffle)
+	neffShuffle.Init(suite)
+	p.relayState.neffShuffle = neffShuffle.RelayView
+}
+
 // ConnectToTrustees connects to the trustees and initializes them with default parameters.
 func (p *PriFiLibRelayInstance) BroadcastParameters() error {
 
@@ -205,8 +351,11 @@ func (p *PriFiLibRelayInstance) BroadcastParameters() error {
 	msg.Add("StartNow", true)
 	msg.Add("PayloadSize", p.relayState.PayloadSize)
 	msg.Add("DCNetType", p.relayState.dcNetType)
+	msg.Add("DCNetPadCipher", p.relayState.PadCipher)
+	msg.Add("CryptoSuite", p.relayState.CryptoSuite)
 	msg.Add("DisruptionProtectionEnabled", p.relayState.DisruptionProtectionEnabled)
 	msg.Add("EquivocationProtectionEnabled", p.relayState.EquivocationProtectionEnabled)
+	msg.Add("DownstreamConsistencyCheck", p.relayState.DownstreamConsistencyCheck)
 	msg.ForceParams = true
 
 	// Send those parameters to all trustees
@@ -220,6 +369,31 @@ func (p *PriFiLibRelayInstance) BroadcastParameters() error {
 	return nil
 }
 
+// clientsParameters returns the parameters sent to the clients with the public keys of the trustees, without
+// NextFreeClientID
+func (p *PriFiLibRelayInstance) clientsParameters() *net.ALL_ALL_PARAMETERS {
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.Add("NClients", p.relayState.nClients)
+	msg.Add("NTrustees", p.relayState.nTrustees)
+	msg.Add("UseUDP", p.relayState.UseUDP)
+	msg.Add("WindowSize", p.relayState.WindowSize)
+	msg.Add("StartNow", true)
+	msg.Add("PayloadSize", p.relayState.PayloadSize)
+	msg.Add("DCNetType", p.relayState.dcNetType)
+	msg.Add("DCNetPadCipher", p.relayState.PadCipher)
+	msg.Add("CryptoSuite", p.relayState.CryptoSuite)
+	msg.Add("DisruptionProtectionEnabled", p.relayState.DisruptionProtectionEnabled)
+	msg.Add("EquivocationProtectionEnabled", p.relayState.EquivocationProtectionEnabled)
+	msg.Add("DownstreamConsistencyCheck", p.relayState.DownstreamConsistencyCheck)
+	msg.Add("SlotScheduler", p.relayState.SlotScheduler)
+	msg.Add("MaxSlotsPerClient", p.relayState.MaxSlotsPerClient)
+	msg.Add("VariableSlotLengths", p.relayState.VariableSlotLengths)
+	msg.Add("MaxPayloadSize", p.relayState.MaxPayloadSize)
+	msg.Add("ClientsVerifyShuffle", p.relayState.ClientsVerifyShuffle)
+	msg.Add("ForceDisruptionSinceRound3", p.relayState.ForceDisruptionSinceRound3)
+	return msg
+}
+
 /*
 Received_CLI_REL_UPSTREAM_DATA handles CLI_REL_UPSTREAM_DATA messages and is part of PriFi's main loop.
 This is what happens in one round, for the relay. We receive some upstream data.
@@ -229,12 +403,9 @@ If we finished a round (we had collected all data, and called DecodeCell()), we
 Either we send something from the SOCKS/VPN buffer, or we answer the latency-test message if we received any, or we send 1 bit.
 */
 func (p *PriFiLibRelayInstance) Received_CLI_REL_UPSTREAM_DATA(msg net.CLI_REL_UPSTREAM_DATA) error {
-	// CV-LB: I am not sure if this is a good programing practice...
-	if p.relayState.CiphertextsHistoryClients[int32(msg.ClientID)] == nil {
-		p.relayState.CiphertextsHistoryClients[int32(msg.ClientID)] = make(map[int32][]byte)
-	}
-	p.relayState.CiphertextsHistoryClients[int32(msg.ClientID)][msg.RoundID] = msg.Data
-	// TODO: CLEAN HISTORY
+	p.recordCipher(p.relayState.CiphertextsHistoryClients, msg.ClientID, p.relayState.nClients, msg.RoundID, msg.Data)
+	p.recordResponseTime(p.relayState.clientsRTT, msg.ClientID, p.relayState.nClients, msg.RoundID)
+	p.collectDownstreamDigest(msg.RoundID, msg.ClientID, msg.DownstreamDigest, msg.DownstreamDigestTags)
 	p.relayState.roundManager.AddClientCipher(msg.RoundID, msg.ClientID, msg.Data)
 	if p.relayState.roundManager.HasAllCiphersForCurrentRound() {
 		p.upstreamPhase1_processCiphers(true)
@@ -249,11 +420,23 @@ If it's for this round, we call decode on it, and remember we received it.
 If for a future round we need to Buffer it.
 */
 func (p *PriFiLibRelayInstance) Received_TRU_REL_DC_CIPHER(msg net.TRU_REL_DC_CIPHER) error {
-	if p.relayState.CiphertextsHistoryTrustees[int32(msg.TrusteeID)] == nil {
-		p.relayState.CiphertextsHistoryTrustees[int32(msg.TrusteeID)] = make(map[int32][]byte)
+	if expectedEpoch := p.epochOfRound(msg.RoundID); msg.EpochID != expectedEpoch {
+		// computed before the trustee learnt about the new epoch, it will send this round again
+		log.Lvl2("Relay : dropping the cipher of trustee", msg.TrusteeID, "for round", msg.RoundID, ", epoch", msg.EpochID,
+			"instead of", expectedEpoch)
+		return nil
+	}
+	if !p.hasSlotLength(msg.RoundID, msg.Data) {
+		// computed before the trustee learnt about the length of this round, it will send this round again
+		log.Lvl2("Relay : dropping the cipher of trustee", msg.TrusteeID, "for round", msg.RoundID, ", it does not have the length announced")
+		return nil
+	}
+	if msg.RoundID > p.relayState.lastTrusteeRoundReceived {
+		p.relayState.lastTrusteeRoundReceived = msg.RoundID
 	}
-	p.relayState.CiphertextsHistoryTrustees[int32(msg.TrusteeID)][msg.RoundID] = msg.Data
-	// TODO: CLEAN HISTORY
+
+	p.recordCipher(p.relayState.CiphertextsHistoryTrustees, msg.TrusteeID, p.relayState.nTrustees, msg.RoundID, msg.Data)
+	p.recordResponseTime(p.relayState.trusteesRTT, msg.TrusteeID, p.relayState.nTrustees, msg.RoundID)
 	p.relayState.roundManager.AddTrusteeCipher(msg.RoundID, msg.TrusteeID, msg.Data)
 	if p.relayState.roundManager.HasAllCiphersForCurrentRound() {
 		p.upstreamPhase1_processCiphers(true)
@@ -265,6 +448,7 @@ func (p *PriFiLibRelayInstance) Received_TRU_REL_DC_CIPHER(msg net.TRU_REL_DC_CI
 // Received_CLI_REL_OPENCLOSED_DATA handles the reception of the OpenClosed map, which details which
 // pseudonymous clients want to transmit in a given round
 func (p *PriFiLibRelayInstance) Received_CLI_REL_OPENCLOSED_DATA(msg net.CLI_REL_OPENCLOSED_DATA) error {
+	p.collectDownstreamDigest(msg.RoundID, msg.ClientID, msg.DownstreamDigest, msg.DownstreamDigestTags)
 	p.relayState.roundManager.AddClientCipher(msg.RoundID, msg.ClientID, msg.OpenClosedData)
 	if p.relayState.roundManager.HasAllCiphersForCurrentRound() {
 		p.upstreamPhase1_processCiphers(false)
@@ -321,8 +505,13 @@ func (p *PriFiLibRelayInstance) upstreamPhase1_processCiphers(finishedByTrustee
 // by the window
 func (p *PriFiLibRelayInstance) downstreamPhase_sendMany() {
 	// send the data down
-	for i := p.relayState.numberOfNonAckedDownstreamPackets; i < p.relayState.WindowSize; i++ {
-		log.Lvl3("Relay : Gonna send, non-acked packets is", p.relayState.numberOfNonAckedDownstreamPackets, "(window is", p.relayState.WindowSize, ")")
+	for i := p.relayState.numberOfNonAckedDownstreamPackets; i < p.relayState.roundManager.WindowSize(); i++ {
+		// the slots of a footprint schedule are unknown until its request is decoded
+		if p.relayState.scheduleRequestPending {
+			log.Lvl3("Relay : waiting for the schedule before opening more rounds")
+			break
+		}
+		log.Lvl3("Relay : Gonna send, non-acked packets is", p.relayState.numberOfNonAckedDownstreamPackets, "(window is", p.relayState.roundManager.WindowSize(), ")")
 		p.downstreamPhase1_openRoundAndSendData()
 	}
 }
@@ -330,25 +519,32 @@ func (p *PriFiLibRelayInstance) downstreamPhase_sendMany() {
 // upstreamPhase2a_extractOCMap extracts the open-closed request map, updates the inner OCMap stored, potentially
 // sleeps if all slots are closed.
 func (p *PriFiLibRelayInstance) upstreamPhase2a_extractOCMap(roundID int32) error {
+	p.relayState.scheduleRequestPending = false
+
 	//classical DC-net decoding
 	clientSlices, trusteesSlices, err := p.relayState.roundManager.CollectRoundData()
 	if err != nil {
 		return err
 	}
-	for _, s := range clientSlices {
-		p.relayState.DCNet.DecodeClient(roundID, s)
-	}
-	for _, s := range trusteesSlices {
-		p.relayState.DCNet.DecodeTrustee(roundID, s)
+	if misbehaving := p.decodeRoundCiphers(roundID, clientSlices, trusteesSlices); misbehaving != nil {
+		// the map is meaningless, close all slots; the next round will be a new open/closed request
+		p.closeAllSlots()
+		return p.quarantine(roundID, misbehaving)
 	}
 
 	//here we have the plaintext map
-	openClosedData, _ := p.relayState.DCNet.DecodeCell(true)
+	openClosedData, _, err := p.relayState.DCNet.DecodeCell(true)
+	if err != nil {
+		p.closeAllSlots()
+		return err
+	}
 
 	//compute the map
 	newSchedule := p.relayState.slotScheduler.Relay_ComputeFinalSchedule(openClosedData, p.relayState.nClients)
 	p.relayState.roundManager.SetStoredRoundSchedule(newSchedule)
 	p.relayState.schedulesStatistics.AddSchedule(newSchedule)
+	// the slots of the new schedule may belong to other clients
+	p.relayState.nextSlotLengths = make(map[int]int)
 
 	// if all slots are closed, do not immediately send the next downstream data (which will be a OCSlots schedule)
 	hasOpenSlot := false
@@ -367,6 +563,18 @@ func (p *PriFiLibRelayInstance) upstreamPhase2a_extractOCMap(roundID int32) erro
 	return nil
 }
 
+// closeAllSlots stores a schedule where all slots are closed, when the open/closed request could not be decoded.
+// The next open/closed request follows shortly
+func (p *PriFiLibRelayInstance) closeAllSlots() {
+	p.relayState.scheduleRequestPending = false
+	closedSchedule := make(map[int]bool)
+	for i := 0; i < p.relayState.nClients; i++ {
+		closedSchedule[i] = false
+	}
+	p.relayState.roundManager.SetStoredRoundSchedule(closedSchedule)
+	p.relayState.nextSlotLengths = make(map[int]int)
+}
+
 // upstreamPhase2b_extractPayload is called when we know the payload is data (and not an OCMap message)
 // If enabled, it checks the Disruption protection, and perhaps starts a blame
 // If it's a latency-test message, we send it back to the clients.
@@ -381,19 +589,22 @@ func (p *PriFiLibRelayInstance) upstreamPhase2b_extractPayload() error {
 		return err
 	}
 
-	//decode all clients and trustees
-	for _, s := range clientSlices {
-		p.relayState.DCNet.DecodeClient(roundID, s)
-	}
-	for _, s := range trusteesSlices {
-		p.relayState.DCNet.DecodeTrustee(roundID, s)
+	//decode all clients and trustees; a rejected cipher does not cancel the pads, the output of this round is meaningless
+	if misbehaving := p.decodeRoundCiphers(roundID, clientSlices, trusteesSlices); misbehaving != nil {
+		return p.quarantine(roundID, misbehaving)
 	}
 
-	upstreamPlaintext, ciphertext := p.relayState.DCNet.DecodeCell(false)
+	upstreamPlaintext, ciphertext, err := p.relayState.DCNet.DecodeCell(false)
+	if err != nil {
+		return p.undecodableCell(roundID, err)
+	}
 	if p.relayState.EquivocationProtectionEnabled && p.relayState.DisruptionProtectionEnabled {
 		// Generating and storing the hash from the payload
 		p.relayState.HashOfLastUpstreamMessage = sha256.Sum256([]byte(ciphertext))
-		p.relayState.LastMessageOfClients[roundID] = ciphertext
+		p.recordUpstreamData(roundID, ciphertext)
+	}
+	if p.relayState.VariableSlotLengths {
+		upstreamPlaintext = p.extractNextSlotLength(roundID, upstreamPlaintext)
 	}
 	p.relayState.bitrateStatistics.AddUpstreamCell(int64(len(upstreamPlaintext)))
 
@@ -401,7 +612,7 @@ func (p *PriFiLibRelayInstance) upstreamPhase2b_extractPayload() error {
 
 		var b_echo_last byte
 		b_echo_last = upstreamPlaintext[0]
-		p.relayState.BEchoFlags[roundID] = b_echo_last
+		p.recordBEchoFlag(roundID, b_echo_last)
 		p.relayState.DisruptionReveal = false
 		previousRound := roundID - int32(p.relayState.nClients)
 
@@ -420,6 +631,7 @@ func (p *PriFiLibRelayInstance) upstreamPhase2b_extractPayload() error {
 				p.relayState.DisruptionReveal = true
 
 				p.relayState.blamingData.RoundID = blameRoundID
+				p.relayState.blameRunning = true
 				p.relayState.blamingData.BitPos = blameBitPosition
 
 				// Broadcast Blame phase 1
@@ -441,11 +653,9 @@ func (p *PriFiLibRelayInstance) upstreamPhase2b_extractPayload() error {
 		upstreamPlaintext = upstreamPlaintext[1:]
 		if !p.relayState.EquivocationProtectionEnabled {
 			// Saving in history
-			p.relayState.LastMessageOfClients[roundID] = upstreamPlaintext
+			p.recordUpstreamData(roundID, upstreamPlaintext)
 		}
 
-		// TODO: Clean the lastmessageofclients map
-
 		//TEST
 		if p.relayState.roundManager.CurrentRound() == 100 {
 			//upstreamPlaintext[3] = 8
@@ -513,7 +723,11 @@ func (p *PriFiLibRelayInstance) upstreamPhase2b_extractPayload() error {
 
 	if upstreamPlaintext != nil {
 		// verify that the decoded payload has the correct size
-		expectedSize := p.relayState.PayloadSize
+		expectedSize := p.relayState.DCNet.PayloadSizeOfRound(roundID)
+		if p.relayState.VariableSlotLengths && p.relayState.roundManager.SlotOwnerOfRound(roundID) >= 0 {
+			// the length asked for the next slot was removed
+			expectedSize -= dcnet.SLOT_LENGTH_FIELD_LENGTH
+		}
 		if p.relayState.DisruptionProtectionEnabled {
 			// One less because of the b_echo_last flag
 			expectedSize--
@@ -522,7 +736,7 @@ func (p *PriFiLibRelayInstance) upstreamPhase2b_extractPayload() error {
 			expectedSize -= 16
 		}
 		if len(upstreamPlaintext) != expectedSize {
-			e := "Relay : DecodeCell produced wrong-size payload, " + strconv.Itoa(len(upstreamPlaintext)) + "!=" + strconv.Itoa(p.relayState.PayloadSize)
+			e := "Relay : DecodeCell produced wrong-size payload, " + strconv.Itoa(len(upstreamPlaintext)) + "!=" + strconv.Itoa(expectedSize)
 			log.Error(e)
 			return errors.New(e)
 		}
@@ -540,7 +754,12 @@ func (p *PriFiLibRelayInstance) upstreamPhase2b_extractPayload() error {
 func (p *PriFiLibRelayInstance) upstreamPhase3_finalizeRound(roundID int32) error {
 
 	p.relayState.numberOfNonAckedDownstreamPackets--
-	p.relayState.numberOfConsecutiveFailedRounds = 0
+	if !p.handleMisbehavingNodes(roundID) {
+		p.relayState.numberOfConsecutiveFailedRounds = 0
+	}
+	p.forwardDownstreamDigests(roundID)
+	p.forgetSlotLengths(roundID)
+	p.forgetHistory(roundID)
 
 	// collects timing experiments
 	if roundID == 0 {
@@ -549,8 +768,10 @@ func (p *PriFiLibRelayInstance) upstreamPhase3_finalizeRound(roundID int32) erro
 		log.Lvl2("Relay finished round "+strconv.Itoa(int(roundID))+" (after", p.relayState.roundManager.TimeSpentInRound(roundID), ").")
 		p.collectExperimentResult(p.relayState.bitrateStatistics.Report())
 		p.collectExperimentResult(p.relayState.schedulesStatistics.Report())
+		p.collectExperimentResult(p.relayState.timeoutStatistics.Report())
 		timeSpent := p.relayState.roundManager.TimeSpentInRound(roundID)
-		p.relayState.timeStatistics["round-duration"].AddTime(timeSpent.Nanoseconds() / 1e6) //ms
+		p.relayState.timeStatistics["round-duration"].AddTime(timeSpent.Nanoseconds() / 1e6)              //ms
+		p.relayState.timeStatistics["window-size"].AddTime(int64(p.relayState.roundManager.WindowSize())) //rounds
 		for k, v := range p.relayState.timeStatistics {
 			p.collectExperimentResult(v.ReportWithInfo(k))
 		}
@@ -652,11 +873,23 @@ func (p *PriFiLibRelayInstance) downstreamPhase1_openRoundAndSendData() error {
 		p.relayState.roundManager.IsNextDownstreamRoundForOpenClosedRequest(p.relayState.nClients)
 	if flagOpenClosedRequest {
 		p.relayState.OpenClosedSlotsRequestsRoundID[nextDownstreamRoundID] = true
+		p.relayState.scheduleRequestPending = p.relayState.SlotScheduler == scheduler.SLOT_SCHEDULER_FOOTPRINT
 	}
 
 	//compute next owner
 	nextOwner := p.relayState.roundManager.UpdateAndGetNextOwnerID()
 
+	// switch to the re-shuffled slots if they are ready, start a re-shuffle if one is due
+	p.switchToReshuffledSlotsIfReady(nextDownstreamRoundID, flagOpenClosedRequest)
+	p.startReshuffleIfNeeded(nextDownstreamRoundID)
+
+	// announce the next DC-net epoch, if it is due
+	p.scheduleNextEpochIfNeeded(nextDownstreamRoundID)
+	epochID, epochStartRoundID := p.lastEpoch()
+
+	// the length of the slot, as asked by its owner
+	slotLength := p.openSlotOfLength(nextDownstreamRoundID, nextOwner, flagOpenClosedRequest)
+
 	//sending data part
 	timing.StartMeasure("sending-data")
 	if flagOpenClosedRequest {
@@ -671,7 +904,11 @@ func (p *PriFiLibRelayInstance) downstreamPhase1_openRoundAndSendData() error {
 		HashOfPreviousUpstreamData: p.relayState.HashOfLastUpstreamMessage[:],
 		Data:                       downstreamCellContent,
 		FlagResync:                 flagResync,
-		FlagOpenClosedRequest:      flagOpenClosedRequest}
+		FlagOpenClosedRequest:      flagOpenClosedRequest,
+		EpochID:                    epochID,
+		EpochStartRoundID:          epochStartRoundID,
+		SlotLength:                 slotLength,
+		SlotsStartRoundID:          p.relayState.slotsStartRound}
 
 	if roundOpened, _ := p.relayState.roundManager.currentRound(); !roundOpened {
 		//prepare for the next round (this empties the dc-net buffer, making them ready for a new round)
@@ -681,9 +918,15 @@ func (p *PriFiLibRelayInstance) downstreamPhase1_openRoundAndSendData() error {
 	p.relayState.roundManager.OpenNextRound()
 	p.relayState.roundManager.SetDataAlreadySent(nextDownstreamRoundID, toSend)
 
+	// with the equivocation protection, the clients absorb this cell in their history before encoding this round
+	p.relayState.DCNet.UpdateSentMessageHistory(nextDownstreamRoundID, toSend.Data)
+
 	if !p.relayState.UseUDP {
 		// broadcast to all clients
 		for i := 0; i < p.relayState.nClients; i++ {
+			if p.isDeparted(i) {
+				continue
+			}
 			//send to the i-th client
 			p.messageSender.SendToClientWithLog(i, toSend, "(client "+strconv.Itoa(i)+", round "+strconv.Itoa(int(nextDownstreamRoundID))+")")
 		}
@@ -702,7 +945,9 @@ func (p *PriFiLibRelayInstance) downstreamPhase1_openRoundAndSendData() error {
 	log.Lvl3("Relay is done broadcasting messages for round " + strconv.Itoa(int(nextDownstreamRoundID)) + ".")
 
 	//we just sent the data down, initiating a round. Let's prevent being blocked by a dead client
-	go p.checkIfRoundHasEndedAfterTimeOut_Phase1(nextDownstreamRoundID)
+	timeOut := p.roundTimeOut()
+	p.relayState.timeoutStatistics.AddRound(timeOut)
+	go p.checkIfRoundHasEndedAfterTimeOut_Phase1(nextDownstreamRoundID, timeOut)
 
 	//now relay enters a waiting state (collecting all ciphers from clients/trustees)
 	timing.StartMeasure("waiting-on-someone")
@@ -733,16 +978,7 @@ func (p *PriFiLibRelayInstance) Received_TRU_REL_TELL_PK(msg net.TRU_REL_TELL_PK
 		}
 
 		//send that to the clients, along with the parameters
-		toSend := new(net.ALL_ALL_PARAMETERS)
-		toSend.Add("NClients", p.relayState.nClients)
-		toSend.Add("NTrustees", p.relayState.nTrustees)
-		toSend.Add("UseUDP", p.relayState.UseUDP)
-		toSend.Add("StartNow", true)
-		toSend.Add("PayloadSize", p.relayState.PayloadSize)
-		toSend.Add("DCNetType", p.relayState.dcNetType)
-		toSend.Add("DisruptionProtectionEnabled", p.relayState.DisruptionProtectionEnabled)
-		toSend.Add("EquivocationProtectionEnabled", p.relayState.EquivocationProtectionEnabled)
-		toSend.Add("ForceDisruptionSinceRound3", p.relayState.ForceDisruptionSinceRound3)
+		toSend := p.clientsParameters()
 		toSend.TrusteesPks = trusteesPk
 
 		// Send those parameters to all clients
@@ -864,9 +1100,21 @@ func (p *PriFiLibRelayInstance) Received_TRU_REL_TELL_NEW_BASE_AND_EPH_PKS(msg n
 			// send to the j-th trustee
 			p.messageSender.SendToTrusteeWithLog(j, toSend, "(trustee "+strconv.Itoa(j+1)+")")
 		}
-
-		p.relayState.DCNet = dcnet.NewDCNetEntity(0, dcnet.DCNET_RELAY, p.relayState.PayloadSize,
-			p.relayState.EquivocationProtectionEnabled, nil)
+		p.sendTranscriptToClientsIfNeeded(toSend, "")
+
+		if p.relayState.dcNetType == "Verifiable" {
+			p.relayState.DCNet = dcnet.NewVerifiableDCNetEntity(p.relayState.suite, 0, dcnet.DCNET_RELAY, p.relayState.PayloadSize, nil)
+			err := p.relayState.DCNet.SetVerifiableDCNetKeys(p.relayState.VerifiableDCNetKeys, p.relayState.nClients)
+			if err != nil {
+				e := "Relay : could not use the verifiable DC-net keys of the trustees, " + err.Error()
+				log.Error(e)
+				return errors.New(e)
+			}
+			p.relayState.DCNet.SetPseudonyms(p.relayState.neffShuffle.LastBase, p.relayState.EphemeralPublicKeys, nil)
+		} else {
+			p.relayState.DCNet = dcnet.NewDCNetEntity(p.relayState.suite, 0, dcnet.DCNET_RELAY, p.relayState.PayloadSize,
+				p.relayState.EquivocationProtectionEnabled, p.relayState.PadCipher, nil)
+		}
 
 		// prepare to collect the ciphers
 		p.relayState.DCNet.DecodeStart(0)
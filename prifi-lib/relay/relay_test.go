@@ -9,9 +9,13 @@ import (
 	"github.com/dedis/prifi/prifi-lib/crypto"
 	"github.com/dedis/prifi/prifi-lib/dcnet"
 	"github.com/dedis/prifi/prifi-lib/net"
+	"github.com/dedis/prifi/prifi-lib/scheduler"
+	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/sign/schnorr"
 	"go.dedis.ch/onet/v3/log"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -151,6 +155,7 @@ func TestRelayRun1(t *testing.T) {
 	msg.Add("UseDummyDataDown", true)
 	msg.Add("ExperimentRoundLimit", 2)
 	msg.Add("DCNetType", dcNetType)
+	msg.Add("DCNetPadCipher", dcnet.PAD_CIPHER_AES_CTR)
 	msg.Add("UseOpenClosedSlots", true)
 	msg.Add("UseDummyDataDown", true)
 	msg.Add("DisruptionProtectionEnabled", true)
@@ -203,6 +208,9 @@ func TestRelayRun1(t *testing.T) {
 	if rs.dcNetType != "Simple" {
 		t.Error("DCNetType was not set correctly")
 	}
+	if rs.PadCipher != dcnet.PAD_CIPHER_AES_CTR {
+		t.Error("PadCipher was not set correctly")
+	}
 	if rs.UseOpenClosedSlots != true {
 		t.Error("UseOpenClosedSlots should be true")
 	}
@@ -265,9 +273,12 @@ func TestRelayRun1(t *testing.T) {
 	if msg3.ParamsStr["DCNetType"] != "Simple" {
 		t.Error("DCNetType not set correctly")
 	}
+	if msg3.ParamsStr["DCNetPadCipher"] != dcnet.PAD_CIPHER_AES_CTR {
+		t.Error("DCNetPadCipher not set correctly")
+	}
 
 	//since startNow = true, trustee sends TRU_REL_TELL_PK
-	trusteePub, trusteePriv := crypto.NewKeyPair()
+	trusteePub, trusteePriv := crypto.NewKeyPair(config.CryptoSuite)
 	_ = trusteePriv
 	msg6 := net.TRU_REL_TELL_PK{
 		TrusteeID: 0,
@@ -305,13 +316,16 @@ func TestRelayRun1(t *testing.T) {
 	if msg5.ParamsStr["DCNetType"] != "Simple" {
 		t.Error("DCNetType not set correctly")
 	}
+	if msg5.ParamsStr["DCNetPadCipher"] != dcnet.PAD_CIPHER_AES_CTR {
+		t.Error("DCNetPadCipher not set correctly")
+	}
 	if !msg5.TrusteesPks[0].Equal(trusteePub) {
 		t.Error("Relay sent wrong public key")
 	}
 
 	//should receive a CLI_REL_TELL_PK_AND_EPH_PK
-	cliPub, cliPriv := crypto.NewKeyPair()
-	cliEphPub, cliEphPriv := crypto.NewKeyPair()
+	cliPub, cliPriv := crypto.NewKeyPair(config.CryptoSuite)
+	cliEphPub, cliEphPriv := crypto.NewKeyPair(config.CryptoSuite)
 	_ = cliPriv
 	_ = cliEphPriv
 	msg9 := net.CLI_REL_TELL_PK_AND_EPH_PK{
@@ -503,7 +517,7 @@ func TestRelayRun2(t *testing.T) {
 	_ = msg4.(*net.ALL_ALL_PARAMETERS)
 
 	//since startNow = true, trustee sends TRU_REL_TELL_PK
-	trusteePub, trusteePriv := crypto.NewKeyPair()
+	trusteePub, trusteePriv := crypto.NewKeyPair(config.CryptoSuite)
 	_ = trusteePriv
 	msg6 := net.TRU_REL_TELL_PK{
 		TrusteeID: 0,
@@ -521,8 +535,8 @@ func TestRelayRun2(t *testing.T) {
 	_ = msg2.(*net.ALL_ALL_PARAMETERS)
 
 	//should receive a CLI_REL_TELL_PK_AND_EPH_PK
-	cliPub, cliPriv := crypto.NewKeyPair()
-	cliEphPub, cliEphPriv := crypto.NewKeyPair()
+	cliPub, cliPriv := crypto.NewKeyPair(config.CryptoSuite)
+	cliEphPub, cliEphPriv := crypto.NewKeyPair(config.CryptoSuite)
 	_ = cliPriv
 	_ = cliEphPriv
 	msg9 := net.CLI_REL_TELL_PK_AND_EPH_PK{
@@ -624,6 +638,7 @@ func TestRelayRun2(t *testing.T) {
 	}
 
 	// should receive a TRU_REL_DATA_UPSTREAM
+	emptyData.RoundID = 1
 	msg19 := net.TRU_REL_DC_CIPHER{
 		TrusteeID: 0,
 		RoundID:   1,
@@ -708,7 +723,7 @@ func TestRelayRun3(t *testing.T) {
 	_ = msg4.(*net.ALL_ALL_PARAMETERS)
 
 	//since startNow = true, trustee sends TRU_REL_TELL_PK
-	trusteePub, trusteePriv := crypto.NewKeyPair()
+	trusteePub, trusteePriv := crypto.NewKeyPair(config.CryptoSuite)
 	_ = trusteePriv
 	msg6 := net.TRU_REL_TELL_PK{
 		TrusteeID: 0,
@@ -733,8 +748,8 @@ func TestRelayRun3(t *testing.T) {
 	_ = msg2.(*net.ALL_ALL_PARAMETERS)
 
 	//should receive a CLI_REL_TELL_PK_AND_EPH_PK
-	cliPub, cliPriv := crypto.NewKeyPair()
-	cliEphPub, cliEphPriv := crypto.NewKeyPair()
+	cliPub, cliPriv := crypto.NewKeyPair(config.CryptoSuite)
+	cliEphPub, cliEphPriv := crypto.NewKeyPair(config.CryptoSuite)
 	_ = cliPriv
 	_ = cliEphPriv
 	msg9 := net.CLI_REL_TELL_PK_AND_EPH_PK{
@@ -856,8 +871,9 @@ func TestRelayRun3(t *testing.T) {
 	latencyMessage := []byte{170, 170, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0}
 	binary.BigEndian.PutUint64(latencyMessage[4:12], uint64(currentTime))
 
+	// a client always sends a full cell
 	latencyMessage2 := dcnet.DCNetCipher{
-		Payload: latencyMessage,
+		Payload: append(append([]byte{}, latencyMessage...), make([]byte, upCellSize-len(latencyMessage))...),
 	}
 
 	msg18 := net.CLI_REL_UPSTREAM_DATA{
@@ -895,8 +911,6 @@ func TestRelayRun3(t *testing.T) {
 
 func TestRelayRun4(t *testing.T) {
 
-	t.Skip() //Verifiable DC-net disabled for now
-
 	timeoutHandler := func(clients, trustees []int) { log.Error(clients, trustees) }
 	resultChan := make(chan interface{}, 1)
 
@@ -936,6 +950,14 @@ func TestRelayRun4(t *testing.T) {
 	msg.Add("RelayTrusteeCacheLowBound", 10)
 	msg.Add("RelayTrusteeCacheHighBound", 15)
 
+	// the verifiable DC-net has no blame protocol nor open/closed slots, asking for them is a configuration error
+	err := relay.ReceivedMessage(*msg)
+	if err == nil || !strings.Contains(err.Error(), "DisruptionProtectionEnabled") || !strings.Contains(err.Error(), "UseOpenClosedSlots") {
+		t.Error("Relay should refuse the disruption protection and the open/closed slots with the Verifiable DC-net, got", err)
+	}
+	msg.Add("UseOpenClosedSlots", false)
+	msg.Add("DisruptionProtectionEnabled", false)
+
 	if err := relay.ReceivedMessage(*msg); err != nil {
 		t.Error("Relay should be able to receive this message, but", err)
 	}
@@ -956,7 +978,7 @@ func TestRelayRun4(t *testing.T) {
 	}
 
 	//since startNow = true, trustee sends TRU_REL_TELL_PK
-	trusteePub, trusteePriv := crypto.NewKeyPair()
+	trusteePub, trusteePriv := crypto.NewKeyPair(config.CryptoSuite)
 	_ = trusteePriv
 	msg6 := net.TRU_REL_TELL_PK{
 		TrusteeID: 0,
@@ -1005,3 +1027,1028 @@ func TestRelayRun4(t *testing.T) {
 		t.Error("Relay should output an error when DCNetType != {Simple, Verifiable}")
 	}
 }
+
+func TestRelayQuarantinesMalformedCiphers(t *testing.T) {
+	reportedClients := make([]int, 0)
+	timeoutHandler := func(clients, trustees []int) { reportedClients = append(reportedClients, clients...) }
+	resultChan := make(chan interface{}, 1)
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	sentToClient = make([]interface{}, 0)
+	sentToTrustee = make([]interface{}, 0)
+	dataForClients := make(chan []byte, 6)
+	dataFromDCNet := make(chan []byte, 3)
+
+	relay := NewRelay(true, dataForClients, dataFromDCNet, resultChan, timeoutHandler, msw)
+	rs := relay.relayState
+
+	upCellSize := 100
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.ForceParams = true
+	msg.Add("StartNow", false)
+	msg.Add("NClients", 2)
+	msg.Add("NTrustees", 1)
+	msg.Add("PayloadSize", upCellSize)
+	msg.Add("DownstreamCellSize", 10*upCellSize)
+	msg.Add("WindowSize", 1)
+	msg.Add("DCNetType", "Simple")
+	msg.Add("RelayMaxNumberOfConsecutiveFailedRounds", 2)
+	if err := relay.ReceivedMessage(*msg); err != nil {
+		t.Error("Relay should be able to receive this message, but", err)
+	}
+	rs.DCNet = dcnet.NewDCNetEntity(config.CryptoSuite, 0, dcnet.DCNET_RELAY, upCellSize, false, dcnet.PAD_CIPHER_XOF, nil)
+
+	goodCipher := func(roundID int32) []byte {
+		return (&dcnet.DCNetCipher{RoundID: roundID, Payload: make([]byte, upCellSize)}).ToBytes()
+	}
+	shortCipher := (&dcnet.DCNetCipher{RoundID: 1, Payload: make([]byte, upCellSize-1)}).ToBytes()
+	garbage := []byte{1, 2, 3}
+
+	// all the ciphers are fine
+	rs.DCNet.DecodeStart(0)
+	if misbehaving := relay.decodeRoundCiphers(0, [][]byte{goodCipher(0), goodCipher(0)}, [][]byte{goodCipher(0)}); misbehaving != nil {
+		t.Error("No cipher should be rejected, got", misbehaving)
+	}
+	if relay.handleMisbehavingNodes(0) {
+		t.Error("Round 0 should not have failed")
+	}
+
+	// client 1 sends garbage, the trustee a cipher of the wrong length then of the wrong round; this must not crash the relay
+	for roundID := int32(1); roundID <= 2; roundID++ {
+		rs.DCNet.DecodeStart(roundID)
+		misbehaving := relay.decodeRoundCiphers(roundID, [][]byte{goodCipher(roundID), garbage}, [][]byte{shortCipher})
+		if misbehaving == nil || len(misbehaving.Clients) != 1 || misbehaving.Clients[0] != 1 ||
+			len(misbehaving.Trustees) != 1 || misbehaving.Trustees[0] != 0 {
+			t.Fatal("Client 1 and trustee 0 should be rejected, got", misbehaving)
+		}
+		if err := relay.quarantine(roundID, misbehaving); err == nil {
+			t.Error("The round should be discarded")
+		}
+		if !relay.handleMisbehavingNodes(roundID) {
+			t.Error("Round", roundID, "should have failed")
+		}
+	}
+
+	if _, found := rs.MisbehavingNodes[2]; found {
+		t.Error("The misbehaving nodes of a finalized round should be forgotten")
+	}
+	if rs.numberOfConsecutiveFailedRounds != 2 {
+		t.Error("There should be 2 consecutive failed rounds, got", rs.numberOfConsecutiveFailedRounds)
+	}
+	if len(reportedClients) != 1 || reportedClients[0] != 1 {
+		t.Error("Client 1 should have been reported to the timeout handler, got", reportedClients)
+	}
+}
+
+func TestRelayCompareBits(t *testing.T) {
+	relay := new(PriFiLibRelayInstance)
+	relay.relayState = new(RelayState)
+	relay.relayState.blamingData.RoundID = 4
+	relay.relayState.blamingData.BitPos = 9
+
+	// the b_echo_last flag, then the upstream data whose bit 9 is set
+	cipher := dcnet.DCNetCipher{
+		RoundID:                   4,
+		EquivocationProtectionTag: make([]byte, 32),
+		Payload:                   []byte{0xFF, 0x00, 0x40, 0x00},
+	}
+	history := map[int32]map[int32][]byte{
+		0: {4: cipher.ToBytes()},
+		1: {4: []byte{1, 2, 3}},
+	}
+
+	if !relay.compareBits(0, map[int]int{0: 1, 1: 1, 2: 1}, history) {
+		t.Error("The revealed bits XOR to 1, like the disrupted bit")
+	}
+	if relay.compareBits(0, map[int]int{0: 1, 1: 1}, history) {
+		t.Error("The revealed bits XOR to 0, the disrupted bit is 1")
+	}
+	if relay.compareBits(1, map[int]int{0: 1}, history) {
+		t.Error("A malformed cipher in the history cannot be compared")
+	}
+
+	relay.relayState.blamingData.BitPos = 100
+	if relay.compareBits(0, map[int]int{0: 0}, history) {
+		t.Error("A bit outside of the payload cannot be compared")
+	}
+}
+
+func TestRelayBlameSharedSecrets(t *testing.T) {
+	var reportedClients, reportedTrustees []int
+	timeoutHandler := func(clients, trustees []int) {
+		reportedClients = clients
+		reportedTrustees = trustees
+	}
+	msgSender := new(TestMessageSender)
+	relay := NewRelay(true, make(chan []byte, 6), make(chan []byte, 3), make(chan interface{}, 1), timeoutHandler,
+		newTestMessageSenderWrapper(msgSender))
+	rs := relay.relayState
+
+	upCellSize := 100
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.ForceParams = true
+	msg.Add("StartNow", false)
+	msg.Add("NClients", 1)
+	msg.Add("NTrustees", 1)
+	msg.Add("PayloadSize", upCellSize)
+	msg.Add("DownstreamCellSize", 10*upCellSize)
+	msg.Add("WindowSize", 1)
+	msg.Add("DCNetType", "Simple")
+	if err := relay.ReceivedMessage(*msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// client 0 and trustee 0 share pads, the disrupted round 7 is in epoch 1
+	suite := config.CryptoSuite
+	clientPub, clientPriv := crypto.NewKeyPair(suite)
+	trusteePub, trusteePriv := crypto.NewKeyPair(suite)
+	rs.clients[0].PublicKey = clientPub
+	rs.trustees[0].PublicKey = trusteePub
+	rs.epochStarts = []int32{0, 5}
+	trustee := dcnet.NewDCNetEntity(suite, 0, dcnet.DCNET_TRUSTEE, upCellSize, false, rs.PadCipher,
+		[]kyber.Point{suite.Point().Mul(trusteePriv, clientPub)})
+	if err := trustee.ScheduleEpoch(1, 5); err != nil {
+		t.Fatal(err)
+	}
+	for roundID := int32(0); roundID <= 7; roundID++ {
+		trustee.TrusteeEncodeForRound(roundID)
+	}
+	bits, _ := trustee.GetBitsOfRound(7, 3)
+	padBit := bits[0]
+
+	blame := func(clientBit, trusteeBit int) {
+		rs.blameRunning = true
+		rs.blamingData = BlamingData{RoundID: 7, BitPos: 3, ClientID: 0, ClientBitRevealed: clientBit, TrusteeID: 0,
+			TrusteeBitRevealed: trusteeBit}
+		reportedClients, reportedTrustees = nil, nil
+	}
+	clientSecret := func(private kyber.Scalar, roundID int32) net.CLI_REL_SHARED_SECRET {
+		secret, NIZK, err := crypto.ProveSharedSecret(suite, private, trusteePub, roundID, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return net.CLI_REL_SHARED_SECRET{ClientID: 0, TrusteeID: 0, RoundID: roundID, Secret: secret, NIZK: NIZK}
+	}
+	trusteeSecret, NIZK, err := crypto.ProveSharedSecret(suite, trusteePriv, clientPub, 7, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client lied about its bit, the trustee reveals the secret : the pad tells who lied
+	blame(1-padBit, padBit)
+	if err := relay.Received_TRU_REL_SHARED_SECRETS(net.TRU_REL_SHARED_SECRET{TrusteeID: 0, ClientID: 0, RoundID: 7,
+		Secret: trusteeSecret, NIZK: NIZK}); err != nil {
+		t.Fatal(err)
+	}
+	if len(reportedClients) != 1 || reportedClients[0] != 0 || len(reportedTrustees) != 0 || rs.blameRunning {
+		t.Error("Client 0 should be the disruptor, got clients", reportedClients, "and trustees", reportedTrustees)
+	}
+
+	// the verdict is reached, the blame is over
+	if err := relay.Received_CLI_REL_SHARED_SECRET(clientSecret(clientPriv, 7)); err == nil {
+		t.Error("A secret should not be accepted once the blame is over")
+	}
+
+	// the trustee lied about its bit, the client reveals the secret
+	blame(padBit, 1-padBit)
+	if err := relay.Received_CLI_REL_SHARED_SECRET(clientSecret(clientPriv, 8)); err == nil || reportedTrustees != nil {
+		t.Error("A secret for another round should be refused")
+	}
+	if err := relay.Received_CLI_REL_SHARED_SECRET(clientSecret(clientPriv, 7)); err != nil {
+		t.Fatal(err)
+	}
+	if len(reportedTrustees) != 1 || reportedTrustees[0] != 0 || len(reportedClients) != 0 {
+		t.Error("Trustee 0 should be the disruptor, got clients", reportedClients, "and trustees", reportedTrustees)
+	}
+
+	// the client reveals a wrong secret, and is the disruptor whatever the bits
+	blame(padBit, 1-padBit)
+	_, otherPriv := crypto.NewKeyPair(suite)
+	if err := relay.Received_CLI_REL_SHARED_SECRET(clientSecret(otherPriv, 7)); err != nil {
+		t.Fatal(err)
+	}
+	if len(reportedClients) != 1 || reportedClients[0] != 0 || len(reportedTrustees) != 0 {
+		t.Error("Client 0 revealed a wrong secret and should be the disruptor, got clients", reportedClients,
+			"and trustees", reportedTrustees)
+	}
+}
+
+func TestRelayEpochs(t *testing.T) {
+	timeoutHandler := func(clients, trustees []int) {}
+	resultChan := make(chan interface{}, 1)
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	sentToClient = make([]interface{}, 0)
+	sentToTrustee = make([]interface{}, 0)
+	dataForClients := make(chan []byte, 6)
+	dataFromDCNet := make(chan []byte, 3)
+
+	relay := NewRelay(true, dataForClients, dataFromDCNet, resultChan, timeoutHandler, msw)
+	rs := relay.relayState
+
+	upCellSize := 100
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.ForceParams = true
+	msg.Add("StartNow", false)
+	msg.Add("NClients", 1)
+	msg.Add("NTrustees", 1)
+	msg.Add("PayloadSize", upCellSize)
+	msg.Add("DownstreamCellSize", 10*upCellSize)
+	msg.Add("WindowSize", 1)
+	msg.Add("DCNetType", "Simple")
+	msg.Add("DCNetEpochRounds", 3)
+	if err := relay.ReceivedMessage(*msg); err != nil {
+		t.Error("Relay should be able to receive this message, but", err)
+	}
+	rs.DCNet = dcnet.NewDCNetEntity(config.CryptoSuite, 0, dcnet.DCNET_RELAY, upCellSize, false, dcnet.PAD_CIPHER_XOF, nil)
+
+	trusteeCipher := func(roundID, epochID int32) net.TRU_REL_DC_CIPHER {
+		data := (&dcnet.DCNetCipher{RoundID: roundID, Payload: make([]byte, upCellSize)}).ToBytes()
+		return net.TRU_REL_DC_CIPHER{RoundID: roundID, TrusteeID: 0, EpochID: epochID, Data: data}
+	}
+
+	// the trustee is ahead of the relay, the new epoch starts after its last cipher
+	for roundID := int32(0); roundID < 5; roundID++ {
+		if err := relay.Received_TRU_REL_DC_CIPHER(trusteeCipher(roundID, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sentToTrustee = make([]interface{}, 0) // forget the rate changes
+	relay.scheduleNextEpochIfNeeded(0)
+	if epoch, start := relay.lastEpoch(); epoch != 1 || start != 5 {
+		t.Fatal("Epoch 1 should start at round 5, got epoch", epoch, "at round", start)
+	}
+	msg2, err := getTrusteeMessage("REL_TRU_TELL_EPOCH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	announce := msg2.(*net.REL_TRU_TELL_EPOCH)
+	if announce.EpochID != 1 || announce.StartRoundID != 5 {
+		t.Error("Wrong epoch announced", announce)
+	}
+	if relay.epochOfRound(4) != 0 || relay.epochOfRound(5) != 1 || relay.epochOfRound(100) != 1 {
+		t.Error("epochOfRound is wrong")
+	}
+
+	// nothing more until epoch 1 starts
+	relay.scheduleNextEpochIfNeeded(4)
+	if epoch, _ := relay.lastEpoch(); epoch != 1 {
+		t.Error("A new epoch should not be announced before the last one started")
+	}
+
+	// a trustee cipher of round 5 computed in epoch 0 is dropped, the one of epoch 1 is kept
+	if err := relay.Received_TRU_REL_DC_CIPHER(trusteeCipher(5, 0)); err != nil {
+		t.Error(err)
+	}
+	if _, found := rs.CiphertextsHistoryTrustees[0][5]; found {
+		t.Error("The cipher of the wrong epoch should be dropped")
+	}
+	if err := relay.Received_TRU_REL_DC_CIPHER(trusteeCipher(5, 1)); err != nil {
+		t.Error(err)
+	}
+	if _, found := rs.CiphertextsHistoryTrustees[0][5]; !found {
+		t.Error("The cipher of the right epoch should be kept")
+	}
+
+	// epoch 2 is due 3 rounds after the start of epoch 1
+	relay.scheduleNextEpochIfNeeded(6)
+	if epoch, _ := relay.lastEpoch(); epoch != 1 {
+		t.Error("Epoch 2 is not due yet")
+	}
+	relay.scheduleNextEpochIfNeeded(7)
+	if epoch, start := relay.lastEpoch(); epoch != 2 || start != 8 {
+		t.Error("Epoch 2 should start at round 8, got epoch", epoch, "at round", start)
+	}
+
+	// the epochs which ended before the oldest round of the history are pruned
+	relay.pruneEpochs(7)
+	if len(rs.epochStarts) != 2 || rs.firstEpoch != 1 {
+		t.Error("Epoch 0 should be pruned, got", rs.epochStarts, "from epoch", rs.firstEpoch)
+	}
+	if relay.epochOfRound(7) != 1 || relay.epochOfRound(8) != 2 {
+		t.Error("epochOfRound is wrong after pruning")
+	}
+	if epoch, start := relay.lastEpoch(); epoch != 2 || start != 8 {
+		t.Error("lastEpoch is wrong after pruning, got epoch", epoch, "at round", start)
+	}
+	relay.pruneEpochs(100)
+	if len(rs.epochStarts) != 1 || rs.firstEpoch != 2 {
+		t.Error("The last epoch should never be pruned, got", rs.epochStarts, "from epoch", rs.firstEpoch)
+	}
+}
+
+func TestRelayCryptoSuite(t *testing.T) {
+	timeoutHandler := func(clients, trustees []int) {}
+	resultChan := make(chan interface{}, 1)
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	sentToClient = make([]interface{}, 0)
+	sentToTrustee = make([]interface{}, 0)
+	dataForClients := make(chan []byte, 6)
+	dataFromDCNet := make(chan []byte, 3)
+
+	relay := NewRelay(true, dataForClients, dataFromDCNet, resultChan, timeoutHandler, msw)
+	rs := relay.relayState
+	if rs.CryptoSuite != config.DefaultCryptoSuiteName {
+		t.Error("The relay should start with the default suite, got", rs.CryptoSuite)
+	}
+	oldPublicKey := rs.PublicKey
+
+	params := func(suite string) net.ALL_ALL_PARAMETERS {
+		msg := new(net.ALL_ALL_PARAMETERS)
+		msg.ForceParams = true
+		msg.Add("StartNow", true)
+		msg.Add("NClients", 1)
+		msg.Add("NTrustees", 1)
+		msg.Add("PayloadSize", 100)
+		msg.Add("DownstreamCellSize", 1000)
+		msg.Add("WindowSize", 1)
+		msg.Add("DCNetType", "Simple")
+		msg.Add("CryptoSuite", suite)
+		return *msg
+	}
+
+	if err := relay.ReceivedMessage(params("secp256k1")); err == nil {
+		t.Error("Relay should refuse an unknown crypto suite")
+	}
+	if err := relay.ReceivedMessage(params(config.CRYPTO_SUITE_P256)); err != nil {
+		t.Fatal(err)
+	}
+	if rs.CryptoSuite != config.CRYPTO_SUITE_P256 || rs.suite.String() != config.CRYPTO_SUITE_P256 {
+		t.Error("The relay should use P256, got", rs.CryptoSuite)
+	}
+	if rs.PublicKey.String() == oldPublicKey.String() {
+		t.Error("The keys of the relay should be regenerated in the new suite")
+	}
+	if rs.neffShuffle.Suite.String() != config.CRYPTO_SUITE_P256 {
+		t.Error("The shuffle should use the new suite")
+	}
+
+	// the trustees are told which suite to use
+	msg, err := getTrusteeMessage("ALL_ALL_PARAMETERS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suite := msg.(*net.ALL_ALL_PARAMETERS).StringValueOrElse("CryptoSuite", ""); suite != config.CRYPTO_SUITE_P256 {
+		t.Error("The crypto suite was not passed to the trustees, got", suite)
+	}
+}
+
+func TestRelayDownstreamConsistency(t *testing.T) {
+	timeoutHandler := func(clients, trustees []int) {}
+	resultChan := make(chan interface{}, 1)
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	sentToClient = make([]interface{}, 0)
+	sentToTrustee = make([]interface{}, 0)
+
+	relay := NewRelay(true, make(chan []byte, 6), make(chan []byte, 3), resultChan, timeoutHandler, msw)
+	rs := relay.relayState
+
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.ForceParams = true
+	msg.Add("StartNow", false)
+	msg.Add("NClients", 2)
+	msg.Add("NTrustees", 2)
+	msg.Add("PayloadSize", 100)
+	msg.Add("DCNetType", "Simple")
+	msg.Add("DownstreamConsistencyCheck", true)
+	if err := relay.ReceivedMessage(*msg); err != nil {
+		t.Fatal("Relay should be able to receive this message, but", err)
+	}
+
+	// each client sends its digest of the downstream cell of round 4, with one tag per trustee
+	for clientID := 0; clientID < 2; clientID++ {
+		tags := []net.ByteArray{{Bytes: []byte{byte(clientID), 0}}, {Bytes: []byte{byte(clientID), 1}}}
+		relay.collectDownstreamDigest(4, clientID, []byte{4, byte(clientID)}, tags)
+	}
+	relay.collectDownstreamDigest(3, 0, []byte{3}, nil)
+	relay.forwardDownstreamDigests(4)
+
+	for j := 0; j < 2; j++ {
+		m, err := getTrusteeMessage("REL_TRU_DOWNSTREAM_DIGESTS")
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests := m.(*net.REL_TRU_DOWNSTREAM_DIGESTS)
+		if digests.RoundID != 4 || len(digests.ClientIDs) != 2 {
+			t.Fatal("Wrong REL_TRU_DOWNSTREAM_DIGESTS", digests)
+		}
+		for i, clientID := range digests.ClientIDs {
+			if !bytes.Equal(digests.Digests[i].Bytes, []byte{4, byte(clientID)}) ||
+				!bytes.Equal(digests.Tags[i].Bytes, []byte{byte(clientID), byte(j)}) {
+				t.Error("Trustee", j, "should receive the digest of client", clientID, "with its own tag")
+			}
+		}
+	}
+	if len(rs.downstreamDigests) != 0 {
+		t.Error("The digests of the rounds forwarded should be forgotten")
+	}
+
+	// the trustees expect the digests of every round, even a round without any
+	relay.forwardDownstreamDigests(5)
+	for j := 0; j < 2; j++ {
+		m, err := getTrusteeMessage("REL_TRU_DOWNSTREAM_DIGESTS")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digests := m.(*net.REL_TRU_DOWNSTREAM_DIGESTS); digests.RoundID != 5 || len(digests.ClientIDs) != 0 {
+			t.Error("Wrong REL_TRU_DOWNSTREAM_DIGESTS for a round without digests", digests)
+		}
+	}
+
+	// a trustee reports an equivocation
+	trusteePk, trusteePriv := crypto.NewKeyPair(config.CryptoSuite)
+	rs.trustees[1].PublicKey = trusteePk
+	evidence := net.TRU_REL_DOWNSTREAM_EQUIVOCATION{
+		TrusteeID: 1,
+		RoundID:   4,
+		ClientIDs: []int{0, 1},
+		Digests:   []net.ByteArray{{Bytes: []byte{1}}, {Bytes: []byte{2}}}}
+	evidence.Signature, _ = schnorr.Sign(config.CryptoSuite, trusteePriv, evidence.SignedData())
+	if err := relay.Received_TRU_REL_DOWNSTREAM_EQUIVOCATION(evidence); err != nil {
+		t.Error("The relay should accept the evidence, but", err)
+	}
+	if len(rs.downstreamEquivocations) != 1 {
+		t.Error("The relay should record the evidence")
+	}
+	evidence.RoundID = 5
+	if err := relay.Received_TRU_REL_DOWNSTREAM_EQUIVOCATION(evidence); err == nil {
+		t.Error("The relay should refuse an evidence with a wrong signature")
+	}
+}
+
+func TestRelaySlotScheduler(t *testing.T) {
+	timeoutHandler := func(clients, trustees []int) {}
+	resultChan := make(chan interface{}, 1)
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	sentToClient = make([]interface{}, 0)
+	sentToTrustee = make([]interface{}, 0)
+	dataForClients := make(chan []byte, 6)
+	dataFromDCNet := make(chan []byte, 3)
+
+	relay := NewRelay(true, dataForClients, dataFromDCNet, resultChan, timeoutHandler, msw)
+	rs := relay.relayState
+	if rs.SlotScheduler != scheduler.SLOT_SCHEDULER_BITMASK {
+		t.Error("The relay should start with the bit mask scheduler, got", rs.SlotScheduler)
+	}
+
+	params := func(slotScheduler string, payloadSize int) net.ALL_ALL_PARAMETERS {
+		msg := new(net.ALL_ALL_PARAMETERS)
+		msg.ForceParams = true
+		msg.Add("StartNow", true)
+		msg.Add("NClients", 10)
+		msg.Add("NTrustees", 1)
+		msg.Add("PayloadSize", payloadSize)
+		msg.Add("DownstreamCellSize", 1000)
+		msg.Add("WindowSize", 1)
+		msg.Add("DCNetType", "Simple")
+		msg.Add("UseOpenClosedSlots", true)
+		msg.Add("SlotScheduler", slotScheduler)
+		return *msg
+	}
+
+	if err := relay.ReceivedMessage(params("Lottery", 1000)); err == nil {
+		t.Error("Relay should refuse an unknown slot scheduler")
+	}
+	// 10 clients need 20 reservation cells of 8 bytes
+	if err := relay.ReceivedMessage(params(scheduler.SLOT_SCHEDULER_FOOTPRINT, 100)); err == nil {
+		t.Error("Relay should refuse a payload too small for the footprints")
+	}
+	// only the footprints let a client reserve several slots
+	severalSlots := params(scheduler.SLOT_SCHEDULER_BITMASK, 1000)
+	severalSlots.Add("MaxSlotsPerClient", 3)
+	if err := relay.ReceivedMessage(severalSlots); err == nil {
+		t.Error("Relay should refuse several slots per client with the bit mask scheduler")
+	}
+	severalSlots = params(scheduler.SLOT_SCHEDULER_FOOTPRINT, 1000)
+	severalSlots.Add("MaxSlotsPerClient", 3)
+	if err := relay.ReceivedMessage(severalSlots); err != nil {
+		t.Fatal(err)
+	}
+	if rs.MaxSlotsPerClient != 3 {
+		t.Error("The relay should let the clients reserve 3 slots, got", rs.MaxSlotsPerClient)
+	}
+	if err := relay.ReceivedMessage(params(scheduler.SLOT_SCHEDULER_FOOTPRINT, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rs.slotScheduler.(*scheduler.FootprintSlotScheduler_Relay); !ok || rs.SlotScheduler != scheduler.SLOT_SCHEDULER_FOOTPRINT {
+		t.Error("The relay should use the footprint scheduler, got", rs.SlotScheduler)
+	}
+
+	// the rounds following a footprint schedule request wait for its decoding
+	rs.WindowSize = 5
+	rs.scheduleRequestPending = true
+	relay.downstreamPhase_sendMany()
+	if rs.numberOfNonAckedDownstreamPackets != 0 {
+		t.Error("The relay should not open rounds before the schedule is decoded, opened", rs.numberOfNonAckedDownstreamPackets)
+	}
+}
+
+func TestRelayVariableSlotLengths(t *testing.T) {
+	timeoutHandler := func(clients, trustees []int) {}
+	resultChan := make(chan interface{}, 1)
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	sentToClient = make([]interface{}, 0)
+	sentToTrustee = make([]interface{}, 0)
+	dataForClients := make(chan []byte, 6)
+	dataFromDCNet := make(chan []byte, 3)
+
+	relay := NewRelay(true, dataForClients, dataFromDCNet, resultChan, timeoutHandler, msw)
+	rs := relay.relayState
+
+	upCellSize := 100
+	params := func(maxPayloadSize int) net.ALL_ALL_PARAMETERS {
+		msg := new(net.ALL_ALL_PARAMETERS)
+		msg.ForceParams = true
+		msg.Add("StartNow", false)
+		msg.Add("NClients", 2)
+		msg.Add("NTrustees", 1)
+		msg.Add("PayloadSize", upCellSize)
+		msg.Add("DownstreamCellSize", 10*upCellSize)
+		msg.Add("WindowSize", 1)
+		msg.Add("DCNetType", "Simple")
+		msg.Add("RelayTrusteeCacheLowBound", 10)
+		msg.Add("RelayTrusteeCacheHighBound", 20)
+		msg.Add("VariableSlotLengths", true)
+		msg.Add("MaxPayloadSize", maxPayloadSize)
+		return *msg
+	}
+	if err := relay.ReceivedMessage(params(50)); err == nil {
+		t.Error("Relay should refuse a MaxPayloadSize smaller than PayloadSize")
+	}
+	if err := relay.ReceivedMessage(params(300)); err != nil {
+		t.Fatal(err)
+	}
+	rs.DCNet = dcnet.NewDCNetEntity(config.CryptoSuite, 0, dcnet.DCNET_RELAY, upCellSize, false, dcnet.PAD_CIPHER_XOF, nil)
+
+	trusteeCipher := func(roundID int32, length int) net.TRU_REL_DC_CIPHER {
+		data := (&dcnet.DCNetCipher{RoundID: roundID, Payload: make([]byte, length)}).ToBytes()
+		return net.TRU_REL_DC_CIPHER{RoundID: roundID, TrusteeID: 0, Data: data}
+	}
+
+	// in round 0, the owner of slot 1 asks for 250 bytes in its next slot
+	rs.roundManager.OpenNextRound()
+	rs.roundManager.SetDataAlreadySent(0, &net.REL_CLI_DOWNSTREAM_DATA{RoundID: 0, OwnershipID: 1})
+	if plaintext := relay.extractNextSlotLength(0, dcnet.PutNextSlotLength([]byte{1, 2, 3}, 10, 1000)); !bytes.Equal(plaintext, []byte{1, 2, 3, 0, 0, 0}) {
+		t.Error("The length asked should be removed from the payload, got", plaintext)
+	}
+	if rs.nextSlotLengths[1] != 300 {
+		t.Error("The length asked should be at most MaxPayloadSize, got", rs.nextSlotLengths[1])
+	}
+	relay.extractNextSlotLength(0, dcnet.PutNextSlotLength(nil, 10, 250))
+	if rs.nextSlotLengths[1] != 250 {
+		t.Error("The owner of slot 1 asked for 250 bytes, got", rs.nextSlotLengths[1])
+	}
+
+	// the trustee is ahead, and sent round 1 with the default length
+	if err := relay.Received_TRU_REL_DC_CIPHER(trusteeCipher(1, upCellSize)); err != nil {
+		t.Fatal(err)
+	}
+	sentToTrustee = make([]interface{}, 0) // forget the rate changes
+
+	// round 1 belongs to slot 1, it has the length asked, announced to the trustee
+	if length := relay.openSlotOfLength(1, 1, false); length != 250 || rs.DCNet.PayloadSizeOfRound(1) != 250 {
+		t.Error("Round 1 should have 250 bytes, got", length)
+	}
+	msg, err := getTrusteeMessage("REL_TRU_TELL_SLOT_LENGTH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if announce := msg.(*net.REL_TRU_TELL_SLOT_LENGTH); announce.RoundID != 1 || announce.Length != 250 {
+		t.Error("Wrong length announced", announce)
+	}
+	if _, found := rs.roundManager.bufferedTrusteeCiphers[0][1]; found {
+		t.Error("The cipher of the trustee computed with the default length should be discarded")
+	}
+	if _, found := rs.nextSlotLengths[1]; found {
+		t.Error("The length asked should only apply to the next slot")
+	}
+
+	// a cipher of the wrong length is dropped, the one of the length announced is kept
+	if err := relay.Received_TRU_REL_DC_CIPHER(trusteeCipher(1, upCellSize)); err != nil {
+		t.Error(err)
+	}
+	if _, found := rs.roundManager.bufferedTrusteeCiphers[0][1]; found {
+		t.Error("The cipher of the wrong length should be dropped")
+	}
+	if err := relay.Received_TRU_REL_DC_CIPHER(trusteeCipher(1, 250)); err != nil {
+		t.Error(err)
+	}
+	if _, found := rs.roundManager.bufferedTrusteeCiphers[0][1]; !found {
+		t.Error("The cipher of the right length should be kept")
+	}
+
+	// no request : PayloadSize, not announced. All slots closed : the shortest slot. Open/closed request : PayloadSize
+	sentToTrustee = make([]interface{}, 0)
+	if length := relay.openSlotOfLength(2, 0, false); length != upCellSize {
+		t.Error("Round 2 should have PayloadSize bytes, got", length)
+	}
+	if len(sentToTrustee) != 0 {
+		t.Error("The default length should not be announced to the trustees")
+	}
+	if length := relay.openSlotOfLength(3, -1, false); length != dcnet.SLOT_LENGTH_FIELD_LENGTH {
+		t.Error("Round 3 has no owner, it should have", dcnet.SLOT_LENGTH_FIELD_LENGTH, "bytes, got", length)
+	}
+	rs.nextSlotLengths[1] = 250
+	if length := relay.openSlotOfLength(4, 1, true); length != upCellSize {
+		t.Error("An open/closed request should have PayloadSize bytes, got", length)
+	}
+}
+
+func TestRelayHistory(t *testing.T) {
+	timeoutHandler := func(clients, trustees []int) {}
+	resultChan := make(chan interface{}, 1)
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	dataForClients := make(chan []byte, 6)
+	dataFromDCNet := make(chan []byte, 3)
+
+	relay := NewRelay(true, dataForClients, dataFromDCNet, resultChan, timeoutHandler, msw)
+	rs := relay.relayState
+
+	nClients := 3
+	nTrustees := 2
+	upCellSize := 1000
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.ForceParams = true
+	msg.Add("StartNow", false)
+	msg.Add("NClients", nClients)
+	msg.Add("NTrustees", nTrustees)
+	msg.Add("PayloadSize", upCellSize)
+	msg.Add("DownstreamCellSize", upCellSize)
+	msg.Add("WindowSize", 1)
+	msg.Add("DCNetType", "Simple")
+	msg.Add("DisruptionProtectionEnabled", true)
+	if err := relay.ReceivedMessage(*msg); err != nil {
+		t.Error("Relay should be able to receive this message, but", err)
+	}
+
+	// the trustees send their ciphers ahead, the round is decoded, then closed
+	runRound := func(roundID int32) {
+		for j := 0; j < nTrustees; j++ {
+			relay.recordCipher(rs.CiphertextsHistoryTrustees, j, nTrustees, roundID+5, make([]byte, upCellSize))
+		}
+		for i := 0; i < nClients; i++ {
+			relay.recordCipher(rs.CiphertextsHistoryClients, i, nClients, roundID, make([]byte, upCellSize))
+		}
+		relay.recordBEchoFlag(roundID, 0)
+		relay.recordUpstreamData(roundID, make([]byte, upCellSize))
+		relay.forgetHistory(roundID)
+	}
+	heapAlloc := func() uint64 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+
+	// the history covers the blame window, whatever the number of rounds
+	roundID := int32(0)
+	for ; roundID < 1000; roundID++ {
+		runRound(roundID)
+	}
+	bytesKept := rs.historyBytes
+	heapBefore := heapAlloc()
+	for ; roundID < 5000; roundID++ {
+		runRound(roundID)
+	}
+	heapAfter := heapAlloc()
+
+	if rs.historyBytes != bytesKept {
+		t.Error("The history should keep the same size, got", rs.historyBytes, "B instead of", bytesKept, "B")
+	}
+	if heapAfter > heapBefore+1024*1024 {
+		t.Error("The memory used should stay flat, went from", heapBefore, "B to", heapAfter, "B")
+	}
+	last := roundID - 1
+	if _, found := rs.CiphertextsHistoryClients[0][last-int32(2*nClients)+1]; !found {
+		t.Error("The ciphers of the blame window should be kept")
+	}
+	if _, found := rs.CiphertextsHistoryClients[0][last-int32(2*nClients)]; found {
+		t.Error("The ciphers older than the blame window should be evicted")
+	}
+	if _, found := rs.CiphertextsHistoryTrustees[1][last+5]; !found {
+		t.Error("The ciphers of the rounds not closed yet should be kept")
+	}
+	if len(rs.LastMessageOfClients) != 2*nClients || len(rs.BEchoFlags) != 2*nClients {
+		t.Error("The upstream data and flags should cover the blame window, got", len(rs.LastMessageOfClients), "and",
+			len(rs.BEchoFlags), "rounds")
+	}
+
+	// the round being blamed is kept, and late ciphers of evicted rounds are ignored
+	rs.blamingData.RoundID = last - int32(2*nClients) + 1
+	rs.blameRunning = true
+	for i := 0; i < 20; i++ {
+		runRound(roundID)
+		roundID++
+	}
+	if _, found := rs.CiphertextsHistoryTrustees[0][rs.blamingData.RoundID]; !found {
+		t.Error("The round being blamed should be kept")
+	}
+	relay.recordCipher(rs.CiphertextsHistoryClients, 0, nClients, 10, make([]byte, upCellSize))
+	if _, found := rs.CiphertextsHistoryClients[0][10]; found {
+		t.Error("A cipher of an evicted round should not be stored")
+	}
+
+	// with a byte budget, the oldest rounds of the blame window are evicted too, but not the rounds not closed yet
+	rs.blameRunning = false
+	rs.HistoryMaxBytes = 20 * upCellSize
+	runRound(roundID)
+	if rs.historyBytes > rs.HistoryMaxBytes {
+		t.Error("The history should stay under", rs.HistoryMaxBytes, "B, got", rs.historyBytes, "B")
+	}
+	if _, found := rs.LastMessageOfClients[roundID]; !found || len(rs.LastMessageOfClients) != 1 {
+		t.Error("Only the last round closed should be kept, got", len(rs.LastMessageOfClients), "rounds")
+	}
+	if _, found := rs.CiphertextsHistoryTrustees[0][roundID+5]; !found {
+		t.Error("The ciphers of the rounds not closed yet should be kept")
+	}
+}
+
+func TestRelayHistoryRounds(t *testing.T) {
+	timeoutHandler := func(clients, trustees []int) {}
+	resultChan := make(chan interface{}, 1)
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	sentToClient = make([]interface{}, 0)
+	sentToTrustee = make([]interface{}, 0)
+	dataForClients := make(chan []byte, 6)
+	dataFromDCNet := make(chan []byte, 3)
+
+	relay := NewRelay(false, dataForClients, dataFromDCNet, resultChan, timeoutHandler, msw)
+	rs := relay.relayState
+
+	nClients := 2
+	nTrustees := 1
+	upCellSize := 100
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.ForceParams = true
+	msg.Add("StartNow", false)
+	msg.Add("NClients", nClients)
+	msg.Add("NTrustees", nTrustees)
+	msg.Add("PayloadSize", upCellSize)
+	msg.Add("DownstreamCellSize", 10*upCellSize)
+	msg.Add("WindowSize", 1)
+	msg.Add("DCNetType", "Simple")
+	msg.Add("DisruptionProtectionEnabled", true)
+	if err := relay.ReceivedMessage(*msg); err != nil {
+		t.Error("Relay should be able to receive this message, but", err)
+	}
+	rs.DCNet = dcnet.NewDCNetEntity(config.CryptoSuite, 0, dcnet.DCNET_RELAY, upCellSize, false, dcnet.PAD_CIPHER_XOF, nil)
+
+	cipher := func(roundID int32) []byte {
+		return (&dcnet.DCNetCipher{RoundID: roundID, Payload: make([]byte, upCellSize)}).ToBytes()
+	}
+
+	// the trustee is ahead, the clients close the rounds
+	relay.downstreamPhase_sendMany()
+	for roundID := int32(0); roundID < 10; roundID++ {
+		if err := relay.Received_TRU_REL_DC_CIPHER(net.TRU_REL_DC_CIPHER{RoundID: roundID + 1, TrusteeID: 0, Data: cipher(roundID + 1)}); err != nil {
+			t.Fatal(err)
+		}
+		if roundID == 0 {
+			if err := relay.Received_TRU_REL_DC_CIPHER(net.TRU_REL_DC_CIPHER{RoundID: 0, TrusteeID: 0, Data: cipher(0)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < nClients; i++ {
+			if err := relay.Received_CLI_REL_UPSTREAM_DATA(net.CLI_REL_UPSTREAM_DATA{RoundID: roundID, ClientID: i, Data: cipher(roundID)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if rs.roundManager.CurrentRound() != roundID+1 {
+			t.Fatal("Round", roundID, "should be closed, the relay is in round", rs.roundManager.CurrentRound())
+		}
+	}
+
+	// the last 2*nClients rounds closed are kept, and the round of the trustee not closed yet
+	for roundID := int32(0); roundID <= 10; roundID++ {
+		_, clientFound := rs.CiphertextsHistoryClients[1][roundID]
+		_, trusteeFound := rs.CiphertextsHistoryTrustees[0][roundID]
+		_, flagFound := rs.BEchoFlags[roundID]
+		kept := roundID >= 10-int32(2*nClients)
+		if trusteeFound != kept || (roundID < 10 && (clientFound != kept || flagFound != kept)) {
+			t.Error("Round", roundID, "should be kept:", kept, ", got", clientFound, trusteeFound, flagFound)
+		}
+	}
+	for i, r := range rs.historyRounds {
+		if r != 10-int32(2*nClients)+int32(i) {
+			t.Error("The rounds of the history should be in increasing order, got", rs.historyRounds)
+			break
+		}
+	}
+
+	// the ciphers of unknown clients and trustees are not recorded
+	if err := relay.Received_CLI_REL_UPSTREAM_DATA(net.CLI_REL_UPSTREAM_DATA{RoundID: 10, ClientID: nClients, Data: cipher(10)}); err != nil {
+		t.Error(err)
+	}
+	if err := relay.Received_CLI_REL_UPSTREAM_DATA(net.CLI_REL_UPSTREAM_DATA{RoundID: 10, ClientID: -1, Data: cipher(10)}); err != nil {
+		t.Error(err)
+	}
+	if err := relay.Received_TRU_REL_DC_CIPHER(net.TRU_REL_DC_CIPHER{RoundID: 11, TrusteeID: nTrustees, Data: cipher(11)}); err != nil {
+		t.Error(err)
+	}
+	if len(rs.CiphertextsHistoryClients) != nClients || len(rs.CiphertextsHistoryTrustees) != nTrustees {
+		t.Error("The history should only hold the known clients and trustees, got", len(rs.CiphertextsHistoryClients),
+			"clients and", len(rs.CiphertextsHistoryTrustees), "trustees")
+	}
+	if _, found := rs.historySizes[11]; found {
+		t.Error("The cipher of an unknown trustee should not be counted in the history")
+	}
+}
+
+func TestRelayAdaptiveRoundTimeOut(t *testing.T) {
+	timeoutHandler := func(clients, trustees []int) {}
+	resultChan := make(chan interface{}, 1)
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	dataForClients := make(chan []byte, 6)
+	dataFromDCNet := make(chan []byte, 3)
+
+	relay := NewRelay(true, dataForClients, dataFromDCNet, resultChan, timeoutHandler, msw)
+	rs := relay.relayState
+
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.ForceParams = true
+	msg.Add("StartNow", false)
+	msg.Add("NClients", 2)
+	msg.Add("NTrustees", 1)
+	msg.Add("PayloadSize", 100)
+	msg.Add("DownstreamCellSize", 100)
+	msg.Add("WindowSize", 1)
+	msg.Add("DCNetType", "Simple")
+	msg.Add("RelayRoundTimeOut", 1000)
+	msg.Add("RelayAdaptiveRoundTimeOut", true)
+	if err := relay.ReceivedMessage(*msg); err != nil {
+		t.Error("Relay should be able to receive this message, but", err)
+	}
+	maxTimeOut := time.Second
+
+	// the fixed timeout holds until every entity answered once
+	if relay.roundTimeOut() != maxTimeOut {
+		t.Error("The timeout should be RoundTimeOut before any response, got", relay.roundTimeOut())
+	}
+
+	// the trustee sent its cipher ahead, the clients answer after the round opened
+	relay.recordResponseTime(rs.trusteesRTT, 0, rs.nTrustees, 1)
+	roundID := rs.roundManager.OpenNextRound()
+	time.Sleep(20 * time.Millisecond)
+	relay.recordResponseTime(rs.clientsRTT, 0, rs.nClients, roundID)
+	if relay.roundTimeOut() != maxTimeOut {
+		t.Error("The timeout should be RoundTimeOut until client 1 answers, got", relay.roundTimeOut())
+	}
+	relay.recordResponseTime(rs.clientsRTT, 1, rs.nClients, roundID)
+	if srtt := rs.clientsRTT[0].srtt; srtt < 20*time.Millisecond || srtt > 500*time.Millisecond {
+		t.Error("The response time of client 0 should be about 20 ms, got", srtt)
+	}
+	if rs.trusteesRTT[0].srtt != 0 {
+		t.Error("A cipher sent ahead should count as an instant response, got", rs.trusteesRTT[0].srtt)
+	}
+
+	// unknown entities are not given an estimator
+	relay.recordResponseTime(rs.clientsRTT, 2, rs.nClients, roundID)
+	relay.recordResponseTime(rs.clientsRTT, -1, rs.nClients, roundID)
+	relay.recordResponseTime(rs.trusteesRTT, 1, rs.nTrustees, roundID)
+	if len(rs.clientsRTT) != 2 || len(rs.trusteesRTT) != 1 {
+		t.Error("The response times of unknown entities should not be recorded, got", len(rs.clientsRTT), "clients and",
+			len(rs.trusteesRTT), "trustees")
+	}
+
+	// the timeout is SRTT + 4*RTTVAR of the slowest entity, at least minRoundTimeOut
+	rs.clientsRTT[0] = new(rttEstimator)
+	rs.clientsRTT[0].addSample(5 * time.Millisecond)
+	rs.clientsRTT[1] = new(rttEstimator)
+	rs.clientsRTT[1].addSample(5 * time.Millisecond)
+	if relay.roundTimeOut() != minRoundTimeOut {
+		t.Error("The timeout should be at least", minRoundTimeOut, ", got", relay.roundTimeOut())
+	}
+	rs.clientsRTT[1].addSample(100 * time.Millisecond)
+	srtt, rttvar := rs.clientsRTT[1].srtt, rs.clientsRTT[1].rttvar
+	if srtt != (7*5*time.Millisecond+100*time.Millisecond)/8 || rttvar != (3*5*time.Millisecond/2+95*time.Millisecond)/4 {
+		t.Error("Wrong SRTT", srtt, "or RTTVAR", rttvar)
+	}
+	if relay.roundTimeOut() != srtt+4*rttvar {
+		t.Error("The timeout should follow the slowest client, got", relay.roundTimeOut(), "instead of", srtt+4*rttvar)
+	}
+
+	// a timeout doubles the timeout of the entities missing, up to RoundTimeOut, until they answer again
+	relay.backOffTimeOuts([]int{1}, []int{})
+	if relay.roundTimeOut() != 2*(srtt+4*rttvar) {
+		t.Error("The timeout should double after a timeout, got", relay.roundTimeOut())
+	}
+	for i := 0; i < 4; i++ {
+		relay.backOffTimeOuts([]int{1}, []int{})
+	}
+	if relay.roundTimeOut() != maxTimeOut {
+		t.Error("The timeout should not exceed RoundTimeOut, got", relay.roundTimeOut())
+	}
+	rs.clientsRTT[1].addSample(5 * time.Millisecond)
+	if relay.roundTimeOut() >= 2*(srtt+4*rttvar) {
+		t.Error("A response should reset the back-off, got", relay.roundTimeOut())
+	}
+
+	// a client dropped does not count
+	rs.departedClients[1] = 0
+	if relay.roundTimeOut() != minRoundTimeOut {
+		t.Error("The timeout should ignore the clients dropped, got", relay.roundTimeOut())
+	}
+}
+
+func TestRelayDownstreamNACK(t *testing.T) {
+	timeoutHandler := func(clients, trustees []int) {}
+	resultChan := make(chan interface{}, 1)
+
+	msgSender := new(TestMessageSender)
+	msw := newTestMessageSenderWrapper(msgSender)
+	sentToClient = make([]interface{}, 0)
+	dataForClients := make(chan []byte, 6)
+	dataFromDCNet := make(chan []byte, 3)
+
+	relay := NewRelay(true, dataForClients, dataFromDCNet, resultChan, timeoutHandler, msw)
+	rs := relay.relayState
+
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.ForceParams = true
+	msg.Add("StartNow", false)
+	msg.Add("NClients", 2)
+	msg.Add("NTrustees", 1)
+	msg.Add("PayloadSize", 100)
+	msg.Add("DownstreamCellSize", 100)
+	msg.Add("WindowSize", 2)
+	msg.Add("DCNetType", "Simple")
+	msg.Add("UseUDP", true)
+	if err := relay.ReceivedMessage(*msg); err != nil {
+		t.Error("Relay should be able to receive this message, but", err)
+	}
+	if relay.clientsParameters().IntValueOrElse("WindowSize", 0) != 2 {
+		t.Error("The clients should know the window size")
+	}
+
+	// rounds 0 and 1 are broadcast, then round 0 times out
+	for r := int32(0); r < 2; r++ {
+		rs.roundManager.OpenNextRound()
+		rs.roundManager.SetDataAlreadySent(r, &net.REL_CLI_DOWNSTREAM_DATA{RoundID: r, Data: []byte{byte(r)}})
+	}
+	rs.roundManager.ForceCloseRound()
+
+	// only the round still open is sent again
+	if err := relay.Received_CLI_REL_DOWNSTREAM_NACK(net.CLI_REL_DOWNSTREAM_NACK{ClientID: 1, RoundIDs: []int32{0, 1, 2}}); err != nil {
+		t.Error(err)
+	}
+	if len(sentToClient) != 1 {
+		t.Fatal("The relay should send one round again, sent", len(sentToClient))
+	}
+	if data, ok := sentToClient[0].(*net.REL_CLI_DOWNSTREAM_DATA); !ok || data.RoundID != 1 {
+		t.Error("The relay should send round 1 again, sent", sentToClient[0])
+	}
+
+	// unknown clients are ignored
+	relay.Received_CLI_REL_DOWNSTREAM_NACK(net.CLI_REL_DOWNSTREAM_NACK{ClientID: 2, RoundIDs: []int32{1}})
+	if len(sentToClient) != 1 {
+		t.Error("The relay should ignore the NACK of an unknown client")
+	}
+}
+
+func TestRelayJoinNeedsRestart(t *testing.T) {
+	for _, disruptionProtection := range []bool{false, true} {
+		msgSender := new(TestMessageSender)
+		relay := NewRelay(true, make(chan []byte, 6), make(chan []byte, 3), make(chan interface{}, 1), nil,
+			newTestMessageSenderWrapper(msgSender))
+
+		msg := new(net.ALL_ALL_PARAMETERS)
+		msg.ForceParams = true
+		msg.Add("StartNow", false)
+		msg.Add("NClients", 1)
+		msg.Add("NTrustees", 1)
+		msg.Add("PayloadSize", 100)
+		msg.Add("DownstreamCellSize", 100)
+		msg.Add("WindowSize", 1)
+		msg.Add("DCNetType", "Simple")
+		msg.Add("EquivocationProtectionEnabled", true)
+		msg.Add("DisruptionProtectionEnabled", disruptionProtection)
+		if err := relay.ReceivedMessage(*msg); err != nil {
+			t.Fatal(err)
+		}
+
+		// the equivocation protection hands its history to the joining clients, the disruption protection cannot
+		err := relay.Received_CLI_REL_JOIN(net.CLI_REL_JOIN{ClientID: 1})
+		if disruptionProtection && (err == nil || len(relay.relayState.joiningClients) != 0) {
+			t.Error("A client should not join with the disruption protection")
+		}
+		if !disruptionProtection && (err != nil || len(relay.relayState.joiningClients) != 1) {
+			t.Error("A client should join with the equivocation protection, got", err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package relay
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/dedis/prifi/prifi-lib/net"
+	"github.com/dedis/prifi/prifi-lib/scheduler"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Re-shuffles. The Neff shuffle run at setup gives each client the same slot for the whole session, hence all the messages
+sent in this slot are linkable. Every ReshuffleRounds rounds, or every ReshuffleDuration ms, the relay runs a new shuffle
+in the background, while the DC-net keeps running with the current slots :
+1) it asks the clients for a fresh ephemeral key (REL_CLI_ASK_EPH_PK, answered with CLI_REL_TELL_PK_AND_EPH_PK)
+2) the trustees shuffle those keys one after the other, then sign the transcript, as at setup
+3) the signed shuffle waits for the next round where the slots can change : the next round opened, or, with the
+open/closed slots, the next schedule request, so that a whole schedule is reserved and used with the same slots.
+The relay sends it to the clients (REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG, with StartRoundID) right before the downstream
+data of this round, on the same connection, and all the clients switch to their new slot when processing this round.
+The downstream data of each round carries the first round of the slots in use (SlotsStartRoundID) : with UDP, a client
+that receives the downstream data of this round before the shuffle holds it until the shuffle arrives.
+A re-shuffle also adds the clients joining (see join.go) : their ephemeral key is shuffled with the fresh ones. The
+clients dropped (see departures.go) are not asked for a key, we give one of our own for their slot.
+*/
+
+// startReshuffleIfNeeded is called when opening the round "roundID". If a re-shuffle is due, or some clients are
+// ready to join, and none is running, it asks the clients for fresh ephemeral keys
+func (p *PriFiLibRelayInstance) startReshuffleIfNeeded(roundID int32) {
+	joining := p.joiningClientsReady()
+	if p.relayState.ReshuffleRounds <= 0 && p.relayState.ReshuffleDuration <= 0 && joining == 0 {
+		return
+	}
+	if p.relayState.reshuffle != nil {
+		// running, or waiting for its first round
+		return
+	}
+
+	roundsElapsed := p.relayState.ReshuffleRounds > 0 && int(roundID-p.relayState.slotsStartRound) >= p.relayState.ReshuffleRounds
+	timeElapsed := p.relayState.ReshuffleDuration > 0 &&
+		time.Since(p.relayState.slotsStartedAt) >= time.Duration(p.relayState.ReshuffleDuration)*time.Millisecond
+	if !roundsElapsed && !timeElapsed && joining == 0 {
+		return
+	}
+
+	neffShuffle := new(scheduler.NeffShuffle)
+	neffShuffle.Init(p.relayState.suite)
+	if err := neffShuffle.RelayView.Init(p.relayState.nTrustees); err != nil {
+		log.Error("Relay : cannot start a re-shuffle, " + err.Error())
+		return
+	}
+	p.relayState.reshuffle = neffShuffle.RelayView
+	p.relayState.reshuffleEphPks = make(map[int]kyber.Point)
+	p.relayState.reshuffleResult = nil
+	p.relayState.reshuffleJoiners = joining
+	for i := 0; i < joining; i++ {
+		p.relayState.reshuffleEphPks[p.relayState.nClients+i] = p.relayState.joiningClients[i].EphemeralPublicKey
+	}
+
+	log.Lvl2("Relay : starting a re-shuffle of the slots at round", roundID, ",", joining, "clients joining")
+	toSend := &net.REL_CLI_ASK_EPH_PK{}
+	for i := 0; i < p.relayState.nClients; i++ {
+		if !p.isDeparted(i) {
+			p.messageSender.SendToClientWithLog(i, toSend, "(client "+strconv.Itoa(i)+", re-shuffle)")
+		}
+	}
+	p.reshuffleWithoutDepartedClients()
+}
+
+/*
+reshuffle1_collectEphPk handles the CLI_REL_TELL_PK_AND_EPH_PK messages received while communicating, i.e., the fresh
+ephemeral keys of the clients for the re-shuffle. When we have one per client, joining clients included, we send them
+to the first trustee.
+*/
+func (p *PriFiLibRelayInstance) reshuffle1_collectEphPk(msg net.CLI_REL_TELL_PK_AND_EPH_PK) error {
+	if p.relayState.reshuffle == nil || p.relayState.reshuffle.CannotAddNewKeys {
+		return errors.New("Relay : received an ephemeral key from client " + strconv.Itoa(msg.ClientID) + ", but no re-shuffle is collecting them")
+	}
+	if msg.ClientID < 0 || msg.ClientID >= p.relayState.nClients || p.isDeparted(msg.ClientID) {
+		return errors.New("Relay : received an ephemeral key from unknown client " + strconv.Itoa(msg.ClientID))
+	}
+	if msg.Pk == nil || msg.EphPk == nil || !msg.Pk.Equal(p.relayState.clients[msg.ClientID].PublicKey) {
+		return errors.New("Relay : client " + strconv.Itoa(msg.ClientID) + " sent an ephemeral key with the wrong identity")
+	}
+
+	p.relayState.reshuffleEphPks[msg.ClientID] = msg.EphPk
+	log.Lvl2("Relay : received a fresh ephemeral key (" + strconv.Itoa(len(p.relayState.reshuffleEphPks)) + "/" +
+		strconv.Itoa(p.relayState.nClients+p.relayState.reshuffleJoiners) + ")")
+	return p.reshuffle1_sendKeysIfComplete()
+}
+
+// reshuffle1_sendKeysIfComplete sends the keys to re-shuffle to the first trustee, if we have one per client
+func (p *PriFiLibRelayInstance) reshuffle1_sendKeysIfComplete() error {
+	nKeys := p.relayState.nClients + p.relayState.reshuffleJoiners
+	if len(p.relayState.reshuffleEphPks) < nKeys {
+		return nil
+	}
+	for i := 0; i < nKeys; i++ {
+		if err := p.relayState.reshuffle.AddClient(p.relayState.reshuffleEphPks[i]); err != nil {
+			return errors.New("Relay : cannot re-shuffle the key of client " + strconv.Itoa(i) + ", " + err.Error())
+		}
+	}
+	return p.reshuffle_sendToNextTrustee()
+}
+
+// reshuffle_sendToNextTrustee sends the keys being re-shuffled to the next trustee. Unlike at setup, the trustees do not
+// need the long-term keys of the clients
+func (p *PriFiLibRelayInstance) reshuffle_sendToNextTrustee() error {
+	msg, trusteeID, err := p.relayState.reshuffle.SendToNextTrustee()
+	if err != nil {
+		return errors.New("Relay : cannot send the re-shuffle to the next trustee, " + err.Error())
+	}
+	toSend := msg.(*net.REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE)
+	p.messageSender.SendToTrusteeWithLog(trusteeID, toSend, "(re-shuffle, "+strconv.Itoa(trusteeID)+"-th iteration)")
+	return nil
+}
+
+/*
+reshuffle2_collectShuffle handles the TRU_REL_TELL_NEW_BASE_AND_EPH_PKS messages received while communicating. We forward
+the keys to the next trustee; after the last one, we send the transcript to all the trustees for them to sign it.
+*/
+func (p *PriFiLibRelayInstance) reshuffle2_collectShuffle(msg net.TRU_REL_TELL_NEW_BASE_AND_EPH_PKS) error {
+	if p.relayState.reshuffle == nil || !p.relayState.reshuffle.CannotAddNewKeys {
+		return errors.New("Relay : received a shuffle, but no re-shuffle is running")
+	}
+
+	done, err := p.relayState.reshuffle.ReceivedShuffleFromTrustee(msg.NewBase, msg.NewEphPks, msg.Proof)
+	if err != nil {
+		return errors.New("Relay : cannot use the re-shuffle of the trustee, " + err.Error())
+	}
+	if !done {
+		return p.reshuffle_sendToNextTrustee()
+	}
+
+	transcript, err := p.relayState.reshuffle.SendTranscript()
+	if err != nil {
+		return errors.New("Relay : cannot send the transcript of the re-shuffle, " + err.Error())
+	}
+	toSend := transcript.(*net.REL_TRU_TELL_TRANSCRIPT)
+	for j := 0; j < p.relayState.nTrustees; j++ {
+		p.messageSender.SendToTrusteeWithLog(j, toSend, "(trustee "+strconv.Itoa(j)+", re-shuffle)")
+	}
+	p.sendTranscriptToClientsIfNeeded(toSend, ", re-shuffle")
+	return nil
+}
+
+/*
+reshuffle3_collectSignature handles the TRU_REL_SHUFFLE_SIG messages received while communicating. When all the trustees
+signed the re-shuffle, we check the signatures and keep the result until the round where the new slots start.
+*/
+func (p *PriFiLibRelayInstance) reshuffle3_collectSignature(msg net.TRU_REL_SHUFFLE_SIG) error {
+	if p.relayState.reshuffle == nil || p.relayState.reshuffleResult != nil {
+		return errors.New("Relay : received the signature of trustee " + strconv.Itoa(msg.TrusteeID) + ", but no re-shuffle is running")
+	}
+
+	done, err := p.relayState.reshuffle.ReceivedSignatureFromTrustee(msg.TrusteeID, msg.Sig)
+	if err != nil {
+		return errors.New("Relay : cannot use the signature of the re-shuffle, " + err.Error())
+	}
+	if !done {
+		return nil
+	}
+
+	trusteesPks := make([]kyber.Point, p.relayState.nTrustees)
+	for i := range trusteesPks {
+		trusteesPks[i] = p.relayState.trustees[i].PublicKey
+	}
+	result, err := p.relayState.reshuffle.VerifySigsAndSendToClients(trusteesPks)
+	if err != nil {
+		return errors.New("Relay : cannot verify the signatures of the re-shuffle, " + err.Error())
+	}
+	p.relayState.reshuffleResult = result.(*net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG)
+	log.Lvl2("Relay : the re-shuffle is signed, waiting for the next round boundary to switch the slots")
+	return nil
+}
+
+// switchToReshuffledSlotsIfReady is called when opening the round "roundID", before sending its downstream data. If a
+// re-shuffle is signed, and the slots can change at this round, the new slots start at this round. If the re-shuffle
+// has joining clients, they start at this round too, with a new epoch
+func (p *PriFiLibRelayInstance) switchToReshuffledSlotsIfReady(roundID int32, isOpenClosedRequest bool) {
+	result := p.relayState.reshuffleResult
+	if result == nil || (p.relayState.UseOpenClosedSlots && !isOpenClosedRequest) {
+		return
+	}
+	if _, lastEpochStart := p.lastEpoch(); p.relayState.reshuffleJoiners > 0 && lastEpochStart >= roundID {
+		// the epoch of the joining clients cannot start before the last epoch announced
+		return
+	}
+
+	result.StartRoundID = roundID
+	if p.relayState.reshuffleJoiners > 0 {
+		// the joining clients absorb the downstream cells from this round on, they start from our history
+		history, err := p.relayState.DCNet.MessageHistory()
+		if err != nil {
+			log.Error("Relay : cannot hand the history of the downstream cells to the joining clients,", err)
+		}
+		result.History = history
+	}
+	for i := 0; i < p.relayState.nClients+p.relayState.reshuffleJoiners; i++ {
+		if !p.isDeparted(i) {
+			p.messageSender.SendToClientWithLog(i, result, "(client "+strconv.Itoa(i)+", slots from round "+strconv.Itoa(int(roundID))+")")
+		}
+	}
+
+	if p.relayState.reshuffleJoiners > 0 {
+		p.join2_addClients(roundID)
+	}
+	p.relayState.EphemeralPublicKeys = result.EphPks
+	p.relayState.reshuffle = nil
+	p.relayState.reshuffleEphPks = nil
+	p.relayState.reshuffleResult = nil
+	p.relayState.slotsStartRound = roundID
+	p.relayState.slotsStartedAt = time.Now()
+	log.Lvl2("Relay : the re-shuffled slots start at round", roundID)
+}
+
+// sendTranscriptToClientsIfNeeded forwards the transcript of a shuffle to the clients, if they verify it themselves.
+// They receive it before the signed shuffle, on the same connection
+func (p *PriFiLibRelayInstance) sendTranscriptToClientsIfNeeded(transcript *net.REL_TRU_TELL_TRANSCRIPT, logInfo string) {
+	if !p.relayState.ClientsVerifyShuffle {
+		return
+	}
+	for i := 0; i < p.relayState.nClients+p.relayState.reshuffleJoiners; i++ {
+		if !p.isDeparted(i) {
+			p.messageSender.SendToClientWithLog(i, transcript, "(client "+strconv.Itoa(i)+logInfo+")")
+		}
+	}
+}
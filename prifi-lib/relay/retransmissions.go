@@ -0,0 +1,59 @@
+package relay
+
+import (
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Retransmissions of the downstream data. With UseUDP, a client which misses a broadcast cannot send its cipher for this
+round, which would time out. A client which sees a gap in the rounds it receives asks for the missing ones with a
+CLI_REL_DOWNSTREAM_NACK; we send their data again over TCP, as long as the rounds are open. The clients know the window
+size : a cell WindowSize rounds after the missing one means that this round was closed, and the client skips it.
+A client which missed the last round sent sees no gap : if its cipher is still missing after a part of the round
+timeout (see timeouts.go), we send it the data of this round again over TCP, without waiting for a NACK.
+*/
+
+// Received_CLI_REL_DOWNSTREAM_NACK handles CLI_REL_DOWNSTREAM_NACK messages, sent by a client which missed the
+// downstream data of some rounds. The data of the rounds still open is sent again to this client, the others are ignored
+func (p *PriFiLibRelayInstance) Received_CLI_REL_DOWNSTREAM_NACK(msg net.CLI_REL_DOWNSTREAM_NACK) error {
+	if msg.ClientID < 0 || msg.ClientID >= p.relayState.nClients || p.isDeparted(msg.ClientID) {
+		log.Lvl2("Relay : ignoring a NACK from unknown client", msg.ClientID)
+		return nil
+	}
+
+	for _, roundID := range msg.RoundIDs {
+		toSend, found := p.relayState.roundManager.FindDataAlreadySent(roundID)
+		if !found {
+			log.Lvl3("Relay : client", msg.ClientID, "missed round", roundID, ", which is closed already")
+			continue
+		}
+		log.Lvl2("Relay : client", msg.ClientID, "missed round", roundID, ", sending it again")
+		p.messageSender.SendToClientWithLog(msg.ClientID, toSend, "(client "+strconv.Itoa(msg.ClientID)+", retransmission of round "+strconv.Itoa(int(roundID))+")")
+		p.relayState.bitrateStatistics.AddDownstreamRetransmitCell(int64(len(toSend.Data)))
+	}
+	return nil
+}
+
+// retransmitToLateClients is called after a part of the round timeout, if we use UDP. The clients which did not send
+// their cipher for the round "roundID" may have missed its broadcast, they get its data again over TCP
+func (p *PriFiLibRelayInstance) retransmitToLateClients(roundID int32) {
+	p.relayState.processingLock.Lock()
+	defer p.relayState.processingLock.Unlock()
+
+	if p.stateMachine.State() == "SHUTDOWN" {
+		return
+	}
+	toSend, found := p.relayState.roundManager.FindDataAlreadySent(roundID)
+	if !found {
+		return // the round is closed already
+	}
+
+	for _, clientID := range p.relayState.roundManager.MissingClientCiphers(roundID) {
+		log.Lvl2("Relay : client", clientID, "did not answer round", roundID, "yet, sending it again")
+		p.messageSender.SendToClientWithLog(clientID, toSend, "(client "+strconv.Itoa(clientID)+", retransmission of round "+strconv.Itoa(int(roundID))+")")
+		p.relayState.bitrateStatistics.AddDownstreamRetransmitCell(int64(len(toSend.Data)))
+	}
+}
@@ -0,0 +1,143 @@
+package relay
+
+import (
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Adaptive round timeout. A fixed RoundTimeOut is too long on a LAN, and may be too short on Wi-Fi. With
+RelayAdaptiveRoundTimeOut, we estimate the response time of each client and trustee as TCP does (RFC 6298) : the time
+between the opening of a round and the reception of its cipher is smoothed into SRTT, with a variation RTTVAR, and the
+timeout of the entity is SRTT + 4*RTTVAR. A trustee cipher received before its round opens counts as an instant
+response. When a round times out, the timeout of the entities which did not answer doubles, until their next response.
+The timeout of a round is the longest of the timeouts of the entities taking part, at least minRoundTimeOut; it stays
+RoundTimeOut until every entity answered once, and never exceeds it.
+*/
+
+// the shortest round timeout, whatever the response times
+const minRoundTimeOut = 50 * time.Millisecond
+
+// rttEstimator estimates the response time of a client or a trustee
+type rttEstimator struct {
+	measured bool
+	srtt     time.Duration
+	rttvar   time.Duration
+	backoff  uint // the timeout is doubled that many times, since the last response
+}
+
+// addSample updates the estimation with the response time "rtt"
+func (e *rttEstimator) addSample(rtt time.Duration) {
+	if rtt < 0 {
+		rtt = 0
+	}
+	if !e.measured {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+		e.measured = true
+	} else {
+		delta := e.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		e.rttvar = (3*e.rttvar + delta) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+	e.backoff = 0
+}
+
+// timeOut returns how long to wait for this entity, and false if it never answered
+func (e *rttEstimator) timeOut() (time.Duration, bool) {
+	if !e.measured {
+		return 0, false
+	}
+	return (e.srtt + 4*e.rttvar) << e.backoff, true
+}
+
+// newRTTEstimators returns an estimator for each of "n" entities
+func newRTTEstimators(n int) map[int]*rttEstimator {
+	estimators := make(map[int]*rttEstimator)
+	for i := 0; i < n; i++ {
+		estimators[i] = new(rttEstimator)
+	}
+	return estimators
+}
+
+// recordResponseTime is called when the cipher of an entity for the round "roundID" is received, and updates the
+// estimation of its response time in "estimators", if "id" is one of the "nEntities"
+func (p *PriFiLibRelayInstance) recordResponseTime(estimators map[int]*rttEstimator, id int, nEntities int, roundID int32) {
+	if !p.relayState.AdaptiveRoundTimeOut {
+		return
+	}
+	if id < 0 || id >= nEntities {
+		log.Error("Relay : not recording the response time of unknown entity", id, "for round", roundID)
+		return
+	}
+	e, found := estimators[id]
+	if !found {
+		e = new(rttEstimator)
+		estimators[id] = e
+	}
+	openedFor, isOpen := p.relayState.roundManager.TimeSinceOpened(roundID)
+	if isOpen {
+		e.addSample(openedFor)
+	} else if roundID >= p.relayState.roundManager.NextRoundToOpen() {
+		// sent ahead
+		e.addSample(0)
+	}
+}
+
+// roundTimeOut returns how long to wait for the ciphers of a round opened now
+func (p *PriFiLibRelayInstance) roundTimeOut() time.Duration {
+	maxTimeOut := time.Duration(p.relayState.RoundTimeOut) * time.Millisecond
+	if !p.relayState.AdaptiveRoundTimeOut {
+		return maxTimeOut
+	}
+
+	estimators := make([]*rttEstimator, 0, p.relayState.nClients+p.relayState.nTrustees)
+	for i := 0; i < p.relayState.nClients; i++ {
+		if !p.isDeparted(i) {
+			estimators = append(estimators, p.relayState.clientsRTT[i])
+		}
+	}
+	for j := 0; j < p.relayState.nTrustees; j++ {
+		estimators = append(estimators, p.relayState.trusteesRTT[j])
+	}
+
+	timeOut := minRoundTimeOut
+	for _, e := range estimators {
+		if e == nil {
+			// a client which joined, and did not answer yet
+			return maxTimeOut
+		}
+		t, measured := e.timeOut()
+		if !measured {
+			return maxTimeOut
+		}
+		if t > timeOut {
+			timeOut = t
+		}
+	}
+	if timeOut > maxTimeOut {
+		return maxTimeOut
+	}
+	return timeOut
+}
+
+// backOffTimeOuts is called when a round timed out, and doubles the timeouts of the entities which did not answer
+func (p *PriFiLibRelayInstance) backOffTimeOuts(missingClients, missingTrustees []int) {
+	if !p.relayState.AdaptiveRoundTimeOut {
+		return
+	}
+	for _, i := range missingClients {
+		if e, found := p.relayState.clientsRTT[i]; found && e.backoff < 16 {
+			e.backoff++
+		}
+	}
+	for _, j := range missingTrustees {
+		if e, found := p.relayState.trusteesRTT[j]; found && e.backoff < 16 {
+			e.backoff++
+		}
+	}
+}
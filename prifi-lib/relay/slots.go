@@ -0,0 +1,110 @@
+package relay
+
+import (
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/dcnet"
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Variable-length slots (see dcnet/slots.go). The owner of a slot asks, at the end of its payload, for the length of its
+next slot. When opening a round, the relay announces its length to the clients in REL_CLI_DOWNSTREAM_DATA, and to the
+trustees with REL_TRU_TELL_SLOT_LENGTH when it is not PayloadSize. The trustees run ahead of the relay; a trustee that
+already sent the round with the default length sends it again, and the relay drops the ciphers of the wrong length.
+The open/closed requests, and the rounds where all slots are closed, do not belong to any owner : the former have
+PayloadSize bytes, the latter the shortest length possible.
+*/
+
+// slotLengthOverhead returns the shortest length of a slot
+func (p *PriFiLibRelayInstance) slotLengthOverhead() int {
+	return dcnet.SlotLengthOverhead(p.relayState.DisruptionProtectionEnabled, p.relayState.EquivocationProtectionEnabled)
+}
+
+// clampSlotLength returns "length" within [slotLengthOverhead(), MaxPayloadSize]
+func (p *PriFiLibRelayInstance) clampSlotLength(length int) int {
+	if length < p.slotLengthOverhead() {
+		return p.slotLengthOverhead()
+	}
+	if length > p.relayState.MaxPayloadSize {
+		return p.relayState.MaxPayloadSize
+	}
+	return length
+}
+
+// openSlotOfLength is called when opening the round "roundID", owned by "owner", before decoding it. It returns the
+// length of its payload, as asked by the owner in its previous slot, or 0 if the slots have a fixed length. A length
+// other than PayloadSize is announced to the trustees, and the ciphers they already sent for this round are dropped
+func (p *PriFiLibRelayInstance) openSlotOfLength(roundID int32, owner int, isOpenClosedRequest bool) int {
+	if !p.relayState.VariableSlotLengths {
+		return 0
+	}
+
+	length := p.relayState.PayloadSize
+	switch asked, found := p.relayState.nextSlotLengths[owner]; {
+	case isOpenClosedRequest:
+		// the contributions to the schedule have a fixed length
+	case owner < 0:
+		length = p.slotLengthOverhead()
+	case found:
+		length = asked
+		delete(p.relayState.nextSlotLengths, owner)
+	}
+
+	p.relayState.DCNet.SetPayloadSizeOfRound(roundID, length)
+	if length == p.relayState.PayloadSize {
+		return length
+	}
+	p.relayState.roundSlotLengths[roundID] = length
+	p.relayState.roundManager.DiscardTrusteeCiphers(roundID)
+
+	toSend := &net.REL_TRU_TELL_SLOT_LENGTH{
+		RoundID: roundID,
+		Length:  length}
+	for j := 0; j < p.relayState.nTrustees; j++ {
+		p.messageSender.SendToTrusteeWithLog(j, toSend, "(trustee "+strconv.Itoa(j)+", round "+strconv.Itoa(int(roundID))+
+			", length "+strconv.Itoa(length)+")")
+	}
+	return length
+}
+
+// hasSlotLength returns false if the trustee cipher "data" for the round "roundID" does not have the length announced
+// for this round, i.e., if it was computed before the trustee learnt about this length
+func (p *PriFiLibRelayInstance) hasSlotLength(roundID int32, data []byte) bool {
+	length, found := p.relayState.roundSlotLengths[roundID]
+	if !found {
+		return true
+	}
+	cipher, err := dcnet.DCNetCipherFromBytes(data)
+	if err != nil {
+		// rejected when decoding
+		return true
+	}
+	return len(cipher.Payload) == length
+}
+
+// extractNextSlotLength reads, in the decoded payload "upstreamPlaintext" of the round "roundID", the length asked by
+// the owner for its next slot, and returns the payload without it
+func (p *PriFiLibRelayInstance) extractNextSlotLength(roundID int32, upstreamPlaintext []byte) []byte {
+	owner := p.relayState.roundManager.SlotOwnerOfRound(roundID)
+	if owner < 0 {
+		return upstreamPlaintext
+	}
+
+	length, payload := dcnet.NextSlotLength(upstreamPlaintext)
+	if length > 0 {
+		p.relayState.nextSlotLengths[owner] = p.clampSlotLength(length)
+		log.Lvl3("Relay : the owner of slot", owner, "asks for", length, "bytes in its next slot")
+	}
+	return payload
+}
+
+// forgetSlotLengths forgets the lengths of the rounds up to "roundID"
+func (p *PriFiLibRelayInstance) forgetSlotLengths(roundID int32) {
+	for r := range p.relayState.roundSlotLengths {
+		if r <= roundID {
+			delete(p.relayState.roundSlotLengths, r)
+		}
+	}
+}
@@ -5,15 +5,33 @@ import (
 	"time"
 )
 
+// with UDP, the clients which did not send their cipher after this part of the round timeout get the downstream data
+// again over TCP
+const retransmissionTimeOutDivisor = 4
+
 /*
 This first timeout happens after a short delay. Clients will not be considered disconnected yet,
 but if we use UDP, it can mean that a client missed a broadcast, and we re-sent the message.
 If the round was *not* done, we do another timeout (Phase 2), and then, clients/trustees will be considered
 online if they didn't answer by that time.
 */
-func (p *PriFiLibRelayInstance) checkIfRoundHasEndedAfterTimeOut_Phase1(roundID int32) {
+func (p *PriFiLibRelayInstance) checkIfRoundHasEndedAfterTimeOut_Phase1(roundID int32, timeOut time.Duration) {
+
+	if p.relayState.UseUDP {
+		retransmitAfter := timeOut / retransmissionTimeOutDivisor
+		time.Sleep(retransmitAfter)
+		p.retransmitToLateClients(roundID)
+		timeOut -= retransmitAfter
+	}
 
-	time.Sleep(time.Duration(p.relayState.RoundTimeOut) * time.Millisecond)
+	p.checkIfRoundHasEndedAfterTimeOut_Phase2(roundID, timeOut)
+}
+
+// checkIfRoundHasEndedAfterTimeOut_Phase2 closes the round "roundID" if it is still open after "timeOut" : the
+// entities which did not send their ciphers are late, or gone
+func (p *PriFiLibRelayInstance) checkIfRoundHasEndedAfterTimeOut_Phase2(roundID int32, timeOut time.Duration) {
+
+	time.Sleep(timeOut)
 
 	// never start treating two timeout concurrently (or receiving a message)
 	p.relayState.processingLock.Lock()
@@ -27,17 +45,22 @@ func (p *PriFiLibRelayInstance) checkIfRoundHasEndedAfterTimeOut_Phase1(roundID
 		return //nothing to ensure in that case
 	}
 
-	// new policy : just kill that round, do not retransmit, let SOCKS take care of the loss
+	// new policy : just kill that round, let SOCKS take care of the loss. The clients which missed a UDP broadcast
+	// got it again in Phase 1, see retransmissions.go
 
 	p.relayState.numberOfConsecutiveFailedRounds++
+	p.relayState.timeoutStatistics.AddTimeout()
 	log.Lvl1("WARNING: Timeout for round", roundID, ", force closing. Already", p.relayState.numberOfConsecutiveFailedRounds,
 		"consecutive missed rounds (killing when =>", p.relayState.MaxNumberOfConsecutiveFailedRounds, ")")
 
 	// if we missed too many rounds, kill the experiment
 	missingClientCiphers, missingTrusteeCiphers := p.relayState.roundManager.MissingCiphersForCurrentRound()
 	log.Lvl1("missing clients", missingClientCiphers, "and trustees", missingTrusteeCiphers)
+	p.backOffTimeOuts(missingClientCiphers, missingTrusteeCiphers)
 
-	if p.relayState.numberOfConsecutiveFailedRounds >= p.relayState.MaxNumberOfConsecutiveFailedRounds {
+	// if only some clients are missing, we can drop them and keep communicating
+	if p.relayState.numberOfConsecutiveFailedRounds >= p.relayState.MaxNumberOfConsecutiveFailedRounds &&
+		!p.dropDepartedClients(missingClientCiphers, missingTrusteeCiphers) {
 		log.Error("MAX_NUMBER_OF_CONSECUTIVE_FAILED_ROUNDS (", p.relayState.MaxNumberOfConsecutiveFailedRounds,
 			") reached, killing protocol.")
 
@@ -48,6 +71,10 @@ func (p *PriFiLibRelayInstance) checkIfRoundHasEndedAfterTimeOut_Phase1(roundID
 		// cleanup, start the transition to next round
 		log.Lvl1("Gonna Force close...")
 		p.relayState.roundManager.Dump()
+		if _, isOCRound := p.relayState.OpenClosedSlotsRequestsRoundID[roundID]; isOCRound {
+			// no schedule for this request, close all slots until the next one
+			p.closeAllSlots()
+		}
 		p.relayState.roundManager.ForceCloseRound()
 		p.relayState.roundManager.Dump()
 
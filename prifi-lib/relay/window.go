@@ -0,0 +1,93 @@
+package relay
+
+import (
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Adaptive window. The relay keeps up to WindowSize rounds open at once; the best value depends on the latency of the
+clients and on the load. With RelayAdaptiveWindow, the number of rounds opened at once follows the round durations, like
+the congestion window of TCP Vegas, between 1 and WindowSize. The shortest round duration seen is the duration of a round
+which does not queue behind others; a round taking longer means that about window*(1 - shortest/duration) rounds are
+queued (at the relay, the clients or the trustees) :
+- less than windowMinQueued rounds queued grows the window by 1/window, i.e., by one round per window of rounds closed :
+the rounds are bound by the latency, opening more increases the throughput
+- more than windowMaxQueued rounds queued shrinks the window by 1/window : the rounds are bound by the processing, opening
+more would only make them longer
+- a round timing out halves the window
+The window starts at 1.
+*/
+
+const (
+	// under this many rounds queued, the window grows
+	windowMinQueued = 1
+	// over this many rounds queued, the window shrinks
+	windowMaxQueued = 2
+)
+
+// EnableAdaptiveWindow starts the window at 1 round, then adapts it to the round durations and timeouts, up to the
+// maximum number of concurrent rounds
+func (b *BufferableRoundManager) EnableAdaptiveWindow() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.adaptiveWindow = true
+	b.window = 1
+	b.shortestRoundDuration = 0
+}
+
+// WindowSize returns the number of rounds to keep open at once
+func (b *BufferableRoundManager) WindowSize() int {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.windowSize()
+}
+
+// windowSize returns the number of rounds to keep open at once
+func (b *BufferableRoundManager) windowSize() int {
+	return int(b.window)
+}
+
+// roundClosedAfter is called when the current round is closed with all its ciphers, "duration" after being opened
+func (b *BufferableRoundManager) roundClosedAfter(duration time.Duration) {
+	if !b.adaptiveWindow || duration <= 0 {
+		return
+	}
+	if b.shortestRoundDuration == 0 || duration < b.shortestRoundDuration {
+		b.shortestRoundDuration = duration
+	}
+
+	previous := b.windowSize()
+	queued := b.window * float64(duration-b.shortestRoundDuration) / float64(duration)
+	if queued < windowMinQueued {
+		b.window += 1 / b.window
+		if b.window > float64(b.maxNumberOfConcurrentRounds) {
+			b.window = float64(b.maxNumberOfConcurrentRounds)
+		}
+	} else if queued > windowMaxQueued {
+		b.window -= 1 / b.window
+		if b.window < 1 {
+			b.window = 1
+		}
+	}
+	if b.windowSize() > previous {
+		log.Lvl2("Relay : rounds do not queue, window grows to", b.windowSize())
+	} else if b.windowSize() < previous {
+		log.Lvl2("Relay : rounds queue, window shrinks to", b.windowSize())
+	}
+}
+
+// roundTimedOut is called when the current round is closed after a timeout, without all its ciphers
+func (b *BufferableRoundManager) roundTimedOut() {
+	if !b.adaptiveWindow {
+		return
+	}
+	b.window /= 2
+	if b.window < 1 {
+		b.window = 1
+	}
+	log.Lvl2("Relay : a round timed out, window shrinks to", b.windowSize())
+}
@@ -4,26 +4,6 @@ import (
 	"math"
 )
 
-// SlotScheduler is a protocol between the relay and the clients that allows to decide which slots are gonna be
-// "open" (fixed-length byte array) or "closed" (inexistant, no message at all)
-type SlotScheduler interface {
-
-	//the client receives a new schedule request from the relay
-	Client_ReceivedScheduleRequest()
-
-	//the client alters the schedule being computed, and ask to transmit
-	Client_ReserveRound(slotID int)
-
-	//return the schedule to send as payload
-	Client_GetOpenScheduleContribution() []byte
-
-	//Called with each client's contribution
-	Relay_CombineContributions(contributions ...[]byte) []byte
-
-	// returns all contributions in forms of a map of open slots
-	Relay_ComputeFinalSchedule() map[int]bool
-}
-
 // BitMaskScheduler_Client holds the info necessary for a client to compute his "contribution", or part of the bitmask
 type BitMaskSlotScheduler_Client struct {
 	NClients          int
@@ -64,16 +44,20 @@ func (bmc *BitMaskSlotScheduler_Client) Client_GetOpenScheduleContribution() []b
 	return payload
 }
 
+// Client_OwnsSlot returns true if the slot "slotID" is the slot of the client from the shuffle
+func (bmc *BitMaskSlotScheduler_Client) Client_OwnsSlot(slotID int, mySlot int) bool {
+	return slotID == mySlot
+}
+
 // Relay_CombineContributions combines (XOR) the received contributions from each clients. In the real DC-net,
 // this is done automatically by the DC-net
 func (bmr *BitMaskSlotScheduler_Relay) Relay_CombineContributions(contributions ...[]byte) []byte {
-	out := make([]byte, len(contributions[0]))
-	for j := range contributions {
-		for i := range contributions[j] {
-			out[i] ^= contributions[j][i]
-		}
-	}
-	return out
+	return xorContributions(contributions...)
+}
+
+// Relay_ContributionLength returns the length of the contribution of each client, nClients/8 bytes
+func (bmr *BitMaskSlotScheduler_Relay) Relay_ContributionLength(nClients int) int {
+	return int(math.Ceil(float64(nClients) / 8))
 }
 
 // Relay_ComputeFinalSchedule computes the map[int32]bool of open slots in the next round given the stored contributions
@@ -0,0 +1,180 @@
+package scheduler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Footprint scheduling, in the style of Dissent. Instead of one bit per client, the contribution is a vector of
+reservation cells, FOOTPRINT_CELLS_PER_CLIENT per client. A client that wants to transmit picks a random cell for each
+slot it wants, and writes a footprint in it : a random value, followed by a checksum of this value. The relay gets the
+XOR of all contributions; a cell is open if it holds a valid footprint, closed if it is empty, and a collision if two
+clients or more wrote in it, which breaks the checksum. Colliding cells are closed, and their clients retry in the next
+schedule. The open cells are the slots of the schedule, in the order of the vector.
+
+Unlike the bit mask, the reservations are not bound to the slots from the shuffle : a client can reserve up to
+MaxSlotsPerClient slots in one schedule, and the relay only learns how many slots are reserved, not by whom. There are
+FOOTPRINT_CELLS_PER_CLIENT cells per slot that a client may reserve, to keep the collisions rare when all clients reserve
+as much as they can. The relay announces the index of the cell as
+OwnershipID; since the cells are only known once the schedule is decoded, the relay does not open the rounds that
+follow a schedule request before decoding it. Until the first schedule, the slots are the ones from the shuffle.
+*/
+
+// FOOTPRINT_CELLS_PER_CLIENT is the number of reservation cells per client, and per slot it may reserve, in a schedule
+const FOOTPRINT_CELLS_PER_CLIENT = 2
+
+// FOOTPRINT_LENGTH is the length of the random part of a footprint
+const FOOTPRINT_LENGTH = 4
+
+// FOOTPRINT_CHECKSUM_LENGTH is the length of the checksum of a footprint
+const FOOTPRINT_CHECKSUM_LENGTH = 4
+
+// FOOTPRINT_CELL_LENGTH is the length of a reservation cell
+const FOOTPRINT_CELL_LENGTH = FOOTPRINT_LENGTH + FOOTPRINT_CHECKSUM_LENGTH
+
+// domain separation between the checksums of the footprints and the other uses of sha256
+const footprintDomain = "footprint-checksum"
+
+// FootprintSlotScheduler_Client holds the reservations of a client in the schedule being computed
+type FootprintSlotScheduler_Client struct {
+	MaxSlotsPerClient int // the most slots a client may reserve in one schedule, 0 means as many as there are cells
+	NCells            int
+	Requested         bool           // true once the client contributed to a schedule; before, it owns its slot from the shuffle
+	Reserved          map[int][]byte // the cells reserved by the client, and their footprint
+}
+
+// FootprintSlotScheduler_Relay decodes the reservations of the clients
+type FootprintSlotScheduler_Relay struct {
+	MaxSlotsPerClient int // the most slots a client may reserve in one schedule, 0 counts as 1 for the number of cells
+	Collisions        int // the number of cells in which several clients wrote, in the last schedule
+}
+
+// slotsPerClient returns the most slots a client may reserve in one schedule, at least one
+func slotsPerClient(maxSlotsPerClient int) int {
+	if maxSlotsPerClient < 1 {
+		return 1
+	}
+	return maxSlotsPerClient
+}
+
+// footprintCells returns the number of reservation cells for "nClients" clients, which may reserve up to
+// "maxSlotsPerClient" slots each
+func footprintCells(nClients int, maxSlotsPerClient int) int {
+	return FOOTPRINT_CELLS_PER_CLIENT * nClients * slotsPerClient(maxSlotsPerClient)
+}
+
+// footprintChecksum returns the checksum of the footprint "footprint"
+func footprintChecksum(footprint []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(footprintDomain))
+	h.Write(footprint)
+	return h.Sum(nil)[:FOOTPRINT_CHECKSUM_LENGTH]
+}
+
+// Client_ReceivedScheduleRequest forgets the previous reservations, and prepares a schedule for "nClients" clients
+func (fsc *FootprintSlotScheduler_Client) Client_ReceivedScheduleRequest(nClients int) {
+	fsc.NCells = footprintCells(nClients, fsc.MaxSlotsPerClient)
+	fsc.Requested = true
+	fsc.Reserved = make(map[int][]byte)
+}
+
+// Client_ReserveRound reserves one more slot in a random cell, up to MaxSlotsPerClient; "slotID" is ignored, the
+// reservations being anonymous
+func (fsc *FootprintSlotScheduler_Client) Client_ReserveRound(slotID int) {
+	if len(fsc.Reserved) >= fsc.NCells || fsc.MaxSlotsPerClient > 0 && len(fsc.Reserved) >= fsc.MaxSlotsPerClient {
+		return
+	}
+
+	cell := -1
+	for cell == -1 {
+		r, err := rand.Int(rand.Reader, big.NewInt(int64(fsc.NCells)))
+		if err != nil {
+			log.Error("Footprint scheduler : could not pick a cell,", err)
+			return
+		}
+		if _, found := fsc.Reserved[int(r.Int64())]; !found {
+			cell = int(r.Int64())
+		}
+	}
+
+	// a zero footprint would look like an empty cell
+	footprint := make([]byte, FOOTPRINT_LENGTH)
+	for bytes.Equal(footprint, make([]byte, FOOTPRINT_LENGTH)) {
+		if _, err := rand.Read(footprint); err != nil {
+			log.Error("Footprint scheduler : could not pick a footprint,", err)
+			return
+		}
+	}
+	fsc.Reserved[cell] = footprint
+}
+
+// Client_GetOpenScheduleContribution returns the reservation cells, with a footprint in the cells reserved
+func (fsc *FootprintSlotScheduler_Client) Client_GetOpenScheduleContribution() []byte {
+	payload := make([]byte, fsc.NCells*FOOTPRINT_CELL_LENGTH)
+	for cell, footprint := range fsc.Reserved {
+		offset := cell * FOOTPRINT_CELL_LENGTH
+		copy(payload[offset:], footprint)
+		copy(payload[offset+FOOTPRINT_LENGTH:], footprintChecksum(footprint))
+	}
+	return payload
+}
+
+// Client_OwnsSlot returns true if the client reserved the cell "slotID"; before the first schedule, if "slotID" is
+// the slot of the client from the shuffle
+func (fsc *FootprintSlotScheduler_Client) Client_OwnsSlot(slotID int, mySlot int) bool {
+	if !fsc.Requested {
+		return slotID == mySlot
+	}
+	_, found := fsc.Reserved[slotID]
+	return found
+}
+
+// Relay_CombineContributions combines (XOR) the received contributions from each clients. In the real DC-net,
+// this is done automatically by the DC-net
+func (fsr *FootprintSlotScheduler_Relay) Relay_CombineContributions(contributions ...[]byte) []byte {
+	return xorContributions(contributions...)
+}
+
+// Relay_ComputeFinalSchedule returns the open cells, those holding exactly one footprint. The schedule goes from
+// [0; FOOTPRINT_CELLS_PER_CLIENT * nClients * MaxSlotsPerClient[
+func (fsr *FootprintSlotScheduler_Relay) Relay_ComputeFinalSchedule(allContributions []byte, nClients int) map[int]bool {
+	res := make(map[int]bool)
+	empty := make([]byte, FOOTPRINT_CELL_LENGTH)
+	fsr.Collisions = 0
+
+	for cell := 0; cell < footprintCells(nClients, fsr.MaxSlotsPerClient); cell++ {
+		offset := cell * FOOTPRINT_CELL_LENGTH
+		if offset+FOOTPRINT_CELL_LENGTH > len(allContributions) {
+			res[cell] = false
+			continue
+		}
+		c := allContributions[offset : offset+FOOTPRINT_CELL_LENGTH]
+		footprint := c[:FOOTPRINT_LENGTH]
+		checksum := c[FOOTPRINT_LENGTH:]
+
+		switch {
+		case bytes.Equal(c, empty):
+			res[cell] = false
+		case bytes.Equal(checksum, footprintChecksum(footprint)):
+			res[cell] = true
+		default:
+			res[cell] = false
+			fsr.Collisions++
+		}
+	}
+
+	if fsr.Collisions > 0 {
+		log.Lvl2("Footprint scheduler :", fsr.Collisions, "collisions in the schedule, closing those slots")
+	}
+	return res
+}
+
+// Relay_ContributionLength returns the length of the contribution of each client, one cell per reservable slot
+func (fsr *FootprintSlotScheduler_Relay) Relay_ContributionLength(nClients int) int {
+	return footprintCells(nClients, fsr.MaxSlotsPerClient) * FOOTPRINT_CELL_LENGTH
+}
@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"testing"
+)
+
+func TestFootprint1Client(t *testing.T) {
+
+	fsc := new(FootprintSlotScheduler_Client)
+	mySlot := 2
+	nClients := 5
+
+	//before any schedule, the client owns its slot from the shuffle
+	if !fsc.Client_OwnsSlot(mySlot, mySlot) || fsc.Client_OwnsSlot(mySlot+1, mySlot) {
+		t.Error("before the first schedule, the client should only own its slot from the shuffle")
+	}
+
+	fsc.Client_ReceivedScheduleRequest(nClients)
+	fsc.Client_ReserveRound(mySlot)
+	fsc.Client_ReserveRound(mySlot)
+	fsc.Client_ReserveRound(mySlot)
+
+	contribution := fsc.Client_GetOpenScheduleContribution()
+	fsr := new(FootprintSlotScheduler_Relay)
+
+	if len(contribution) != fsr.Relay_ContributionLength(nClients) {
+		t.Error("Contribution should have length", fsr.Relay_ContributionLength(nClients), ", has length", len(contribution))
+	}
+
+	finalSched := fsr.Relay_ComputeFinalSchedule(contribution, nClients)
+
+	if len(finalSched) != FOOTPRINT_CELLS_PER_CLIENT*nClients {
+		t.Error("finalSched should have length", FOOTPRINT_CELLS_PER_CLIENT*nClients, ", has length", len(finalSched))
+	}
+	nOpen := 0
+	for slot, open := range finalSched {
+		if open {
+			nOpen++
+		}
+		if open != fsc.Client_OwnsSlot(slot, mySlot) {
+			t.Error("slot", slot, "is open:", open, "but owned by the client:", fsc.Client_OwnsSlot(slot, mySlot))
+		}
+	}
+	if nOpen != 3 {
+		t.Error("the client reserved 3 slots, but", nOpen, "are open")
+	}
+	if fsr.Collisions != 0 {
+		t.Error("there should be no collision, got", fsr.Collisions)
+	}
+}
+
+func TestFootprint2ClientsCollision(t *testing.T) {
+
+	fsc1 := new(FootprintSlotScheduler_Client)
+	fsc2 := new(FootprintSlotScheduler_Client)
+	nClients := 2
+
+	fsc1.Client_ReceivedScheduleRequest(nClients)
+	fsc2.Client_ReceivedScheduleRequest(nClients)
+
+	//both clients write in cell 1, client 2 also in cell 3
+	fsc1.Reserved[1] = []byte{1, 2, 3, 4}
+	fsc2.Reserved[1] = []byte{5, 6, 7, 8}
+	fsc2.Reserved[3] = []byte{9, 10, 11, 12}
+
+	fsr := new(FootprintSlotScheduler_Relay)
+	allContributions := fsr.Relay_CombineContributions(fsc1.Client_GetOpenScheduleContribution(), fsc2.Client_GetOpenScheduleContribution())
+	finalSched := fsr.Relay_ComputeFinalSchedule(allContributions, nClients)
+
+	if finalSched[1] {
+		t.Error("slot 1 should be closed, the reservations collided")
+	}
+	if !finalSched[3] {
+		t.Error("slot 3 should be open")
+	}
+	if finalSched[0] || finalSched[2] {
+		t.Error("slots 0 and 2 should be closed")
+	}
+	if fsr.Collisions != 1 {
+		t.Error("there should be one collision, got", fsr.Collisions)
+	}
+}
+
+func TestFootprintNoReservation(t *testing.T) {
+
+	fsc := new(FootprintSlotScheduler_Client)
+	fsc.Client_ReceivedScheduleRequest(3)
+
+	if fsc.Client_OwnsSlot(0, 0) {
+		t.Error("the client did not reserve any slot")
+	}
+
+	fsr := new(FootprintSlotScheduler_Relay)
+	for slot, open := range fsr.Relay_ComputeFinalSchedule(fsc.Client_GetOpenScheduleContribution(), 3) {
+		if open {
+			t.Error("slot", slot, "should be closed")
+		}
+	}
+}
+
+func TestNewSlotScheduler(t *testing.T) {
+
+	for _, name := range []string{SLOT_SCHEDULER_BITMASK, SLOT_SCHEDULER_FOOTPRINT} {
+		if _, err := NewSlotScheduler_Client(name, 1); err != nil {
+			t.Error(err)
+		}
+		if _, err := NewSlotScheduler_Relay(name, 1); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := NewSlotScheduler_Relay("Nope", 1); err == nil {
+		t.Error("should not create an unknown slot scheduler")
+	}
+}
+
+func TestFootprintSeveralSlotsPerClient(t *testing.T) {
+
+	nClients := 3
+	maxSlots := 4
+	heavy := &FootprintSlotScheduler_Client{MaxSlotsPerClient: maxSlots}
+	light := &FootprintSlotScheduler_Client{MaxSlotsPerClient: maxSlots}
+	idle := &FootprintSlotScheduler_Client{MaxSlotsPerClient: maxSlots}
+
+	heavy.Client_ReceivedScheduleRequest(nClients)
+	light.Client_ReceivedScheduleRequest(nClients)
+	idle.Client_ReceivedScheduleRequest(nClients)
+	if heavy.NCells != FOOTPRINT_CELLS_PER_CLIENT*nClients*maxSlots {
+		t.Error("there should be", FOOTPRINT_CELLS_PER_CLIENT*nClients*maxSlots, "cells, got", heavy.NCells)
+	}
+
+	//the heavy client asks for more slots than allowed
+	for i := 0; i < 2*maxSlots; i++ {
+		heavy.Client_ReserveRound(0)
+	}
+	light.Client_ReserveRound(1)
+	if len(heavy.Reserved) != maxSlots {
+		t.Error("a client should reserve at most", maxSlots, "slots, got", len(heavy.Reserved))
+	}
+
+	fsr := &FootprintSlotScheduler_Relay{MaxSlotsPerClient: maxSlots}
+	contributions := []*FootprintSlotScheduler_Client{heavy, light, idle}
+	all := make([][]byte, len(contributions))
+	for i, c := range contributions {
+		all[i] = c.Client_GetOpenScheduleContribution()
+		if len(all[i]) != fsr.Relay_ContributionLength(nClients) {
+			t.Error("Contribution should have length", fsr.Relay_ContributionLength(nClients), ", has length", len(all[i]))
+		}
+	}
+	finalSched := fsr.Relay_ComputeFinalSchedule(fsr.Relay_CombineContributions(all...), nClients)
+
+	//every open slot belongs to exactly one client; collided cells are lost to both
+	nOpen, nHeavy := 0, 0
+	for slot, open := range finalSched {
+		owners := 0
+		for _, c := range contributions {
+			if c.Client_OwnsSlot(slot, -1) {
+				owners++
+			}
+		}
+		if open {
+			nOpen++
+			if heavy.Client_OwnsSlot(slot, -1) {
+				nHeavy++
+			}
+			if owners != 1 {
+				t.Error("open slot", slot, "has", owners, "owners")
+			}
+		}
+	}
+	if nOpen != maxSlots+1-2*fsr.Collisions {
+		t.Error("expected", maxSlots+1-2*fsr.Collisions, "open slots, got", nOpen)
+	}
+	if fsr.Collisions == 0 && nHeavy != maxSlots {
+		t.Error("the heavy client should own", maxSlots, "slots, got", nHeavy)
+	}
+}
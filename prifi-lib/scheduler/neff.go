@@ -1,5 +1,7 @@
 package scheduler
 
+import "go.dedis.ch/kyber/v3/suites"
+
 /**
  * Holds all the components to do a Neff Shuffle. Both the Relay and the Trustee have one instance of it, but uses only
  * their part in it.
@@ -7,15 +9,19 @@ package scheduler
  * caller
  */
 type NeffShuffle struct {
+	Suite       suites.Suite
 	RelayView   *NeffShuffleRelay
 	TrusteeView *NeffShuffleTrustee
 	//client do not have a "view", no state to hold
 }
 
 /**
- * Instanciates both the relay and the trustee view (but you still need to call init on the correct one)
+ * Instanciates both the relay and the trustee view in the given suite (but you still need to call init on the correct one)
  */
-func (n *NeffShuffle) Init() {
+func (n *NeffShuffle) Init(suite suites.Suite) {
+	n.Suite = suite
 	n.RelayView = new(NeffShuffleRelay)
+	n.RelayView.Suite = suite
 	n.TrusteeView = new(NeffShuffleTrustee)
+	n.TrusteeView.Suite = suite
 }
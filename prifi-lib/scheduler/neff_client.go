@@ -2,9 +2,11 @@ package scheduler
 
 import (
 	"errors"
-	"github.com/dedis/prifi/prifi-lib/config"
+	"github.com/dedis/prifi/prifi-lib/crypto"
 	"go.dedis.ch/kyber/v3"
+	"runtime"
 	"strconv"
+	"sync"
 )
 
 /**
@@ -36,13 +38,13 @@ func (n *NeffShuffle) ClientVerifySigAndRecognizeSlot(privateKey kyber.Scalar, t
 	}
 
 	//batch-verify all signatures
-	success, err := multiSigVerify(trusteesPublicKeys, lastBase, shuffledPublicKeys, signatures)
+	success, err := multiSigVerify(n.Suite, trusteesPublicKeys, lastBase, shuffledPublicKeys, signatures)
 	if success != true {
 		return -1, err
 	}
 
 	//locate our public key in shuffle
-	publicKeyInNewBase := config.CryptoSuite.Point().Mul(privateKey, lastBase)
+	publicKeyInNewBase := n.Suite.Point().Mul(privateKey, lastBase)
 
 	mySlot := -1
 
@@ -57,3 +59,90 @@ func (n *NeffShuffle) ClientVerifySigAndRecognizeSlot(privateKey kyber.Scalar, t
 	}
 	return mySlot, nil
 }
+
+/**
+ * Verifies the whole transcript of the shuffle, instead of trusting the trustees to have checked each other's proofs :
+ * our ephemeral key is given to the first trustee, each trustee's proof is valid, and the last shuffle is the one
+ * signed (lastBase, shuffledPublicKeys). The proofs are verified in parallel, on all the cores
+ */
+func (n *NeffShuffle) ClientVerifyTranscript(myEphemeralPublicKey kyber.Point, initialPublicKeys []kyber.Point, bases []kyber.Point,
+	transcriptPublicKeys [][]kyber.Point, proofs [][]byte, lastBase kyber.Point, shuffledPublicKeys []kyber.Point) error {
+
+	if myEphemeralPublicKey == nil {
+		return errors.New("Can't verify the transcript without our ephemeral public key")
+	}
+	if lastBase == nil || shuffledPublicKeys == nil {
+		return errors.New("Can't verify the transcript without the shuffle to use")
+	}
+	nTrustees := len(bases)
+	if nTrustees == 0 {
+		return errors.New("Can't verify an empty transcript")
+	}
+	if len(transcriptPublicKeys) != nTrustees || len(proofs) != nTrustees {
+		return errors.New("Size not matching, bases is " + strconv.Itoa(nTrustees) + ", transcriptPublicKeys is " + strconv.Itoa(len(transcriptPublicKeys)) + ", proofs is " + strconv.Itoa(len(proofs)) + ".")
+	}
+
+	myKeyFound := false
+	for _, k := range initialPublicKeys {
+		if k != nil && k.Equal(myEphemeralPublicKey) {
+			myKeyFound = true
+		}
+	}
+	if !myKeyFound {
+		return errors.New("Our ephemeral public key was not shuffled")
+	}
+
+	last := transcriptPublicKeys[nTrustees-1]
+	if bases[nTrustees-1] == nil || !bases[nTrustees-1].Equal(lastBase) || len(last) != len(shuffledPublicKeys) {
+		return errors.New("The transcript does not end with the signed shuffle")
+	}
+	for k := range last {
+		if last[k] == nil || !last[k].Equal(shuffledPublicKeys[k]) {
+			return errors.New("The transcript does not end with the signed shuffle")
+		}
+	}
+
+	// each shuffle takes the output of the previous one
+	verifyShuffle := func(j int) error {
+		base := n.Suite.Point().Base()
+		keys := initialPublicKeys
+		if j > 0 {
+			base = bases[j-1]
+			keys = transcriptPublicKeys[j-1]
+		}
+		if err := crypto.VerifyNeffShuffle(n.Suite, keys, base, transcriptPublicKeys[j], bases[j], proofs[j]); err != nil {
+			return errors.New("Could not verify the " + strconv.Itoa(j) + "th neff shuffle, error is " + err.Error())
+		}
+		return nil
+	}
+
+	nWorkers := runtime.NumCPU()
+	if nWorkers > nTrustees {
+		nWorkers = nTrustees
+	}
+	jobs := make(chan int, nTrustees)
+	for j := 0; j < nTrustees; j++ {
+		jobs <- j
+	}
+	close(jobs)
+
+	errs := make([]error, nTrustees)
+	var wg sync.WaitGroup
+	for w := 0; w < nWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				errs[j] = verifyShuffle(j)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
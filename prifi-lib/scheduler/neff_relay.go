@@ -2,10 +2,10 @@ package scheduler
 
 import (
 	"errors"
-	"github.com/dedis/prifi/prifi-lib/config"
 	"github.com/dedis/prifi/prifi-lib/net"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/kyber/v3/suites"
 	"strconv"
 )
 
@@ -13,10 +13,12 @@ import (
  * The view of the relay for the Neff Shuffle
  */
 type NeffShuffleRelay struct {
+	Suite       suites.Suite
 	NTrustees   int
 	InitialBase kyber.Point
 
 	//this is the transcript, i.e. we keep everything
+	InitialPublicKeys  []kyber.Point
 	Bases              []kyber.Point
 	ShuffledPublicKeys []net.PublicKeyArray
 	Proofs             []net.ByteArray
@@ -48,7 +50,7 @@ func (r *NeffShuffleRelay) Init(nTrustees int) error {
 	r.NTrustees = nTrustees
 
 	//the relay picks c0
-	r.InitialBase = config.CryptoSuite.Point().Base()
+	r.InitialBase = r.Suite.Point().Base()
 
 	//the share of products is c0 (will become c1*c0, c2*c1*c0, ...)
 	r.LastBase = r.InitialBase
@@ -88,6 +90,9 @@ func (r *NeffShuffleRelay) SendToNextTrustee() (interface{}, int, error) {
 		return nil, -1, errors.New("RelayView's public key array is empty")
 	}
 	r.CannotAddNewKeys = true
+	if r.currentTrusteeShuffling == 0 {
+		r.InitialPublicKeys = r.PublicKeyBeingShuffled
+	}
 
 	// send to the next trustee
 	msg := &net.REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE{
@@ -144,9 +149,10 @@ func (r *NeffShuffleRelay) SendTranscript() (interface{}, error) {
 	}
 
 	msg := &net.REL_TRU_TELL_TRANSCRIPT{
-		Bases:  r.Bases,
-		EphPks: r.ShuffledPublicKeys,
-		Proofs: r.Proofs}
+		InitialEphPks: r.InitialPublicKeys,
+		Bases:         r.Bases,
+		EphPks:        r.ShuffledPublicKeys,
+		Proofs:        r.Proofs}
 	return msg, nil
 }
 
@@ -173,7 +179,7 @@ func (r *NeffShuffleRelay) ReceivedSignatureFromTrustee(trusteeID int, signature
  * Packages the shares, the shuffledPublicKeys in a byte array, and test the signatures from the trustees.
  * Fails if any one signature is invalid
  */
-func multiSigVerify(trusteesPublicKeys []kyber.Point, lastBase kyber.Point, shuffledPublicKeys []kyber.Point, signatures [][]byte) (bool, error) {
+func multiSigVerify(suite suites.Suite, trusteesPublicKeys []kyber.Point, lastBase kyber.Point, shuffledPublicKeys []kyber.Point, signatures [][]byte) (bool, error) {
 
 	nTrustees := len(trusteesPublicKeys)
 
@@ -212,7 +218,7 @@ func multiSigVerify(trusteesPublicKeys []kyber.Point, lastBase kyber.Point, shuf
 
 	//we test the signatures
 	for j := 0; j < nTrustees; j++ {
-		err := schnorr.Verify(config.CryptoSuite, trusteesPublicKeys[j], M, signatures[j])
+		err := schnorr.Verify(suite, trusteesPublicKeys[j], M, signatures[j])
 
 		if err != nil {
 			return false, errors.New("Can't verify sig n°" + strconv.Itoa(j) + "; " + err.Error())
@@ -246,7 +252,7 @@ func (r *NeffShuffleRelay) VerifySigsAndSendToClients(trusteesPublicKeys []kyber
 		sigArray = append(sigArray, r.Signatures[k].Bytes)
 	}
 
-	success, err := multiSigVerify(trusteesPublicKeys, lastBase, ephPubKeys.Keys, sigArray)
+	success, err := multiSigVerify(r.Suite, trusteesPublicKeys, lastBase, ephPubKeys.Keys, sigArray)
 	if success != true {
 		return nil, err
 	}
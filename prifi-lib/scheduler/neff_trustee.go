@@ -13,11 +13,11 @@ OUTPUT : list of slots
 import (
 	"bytes"
 	"errors"
-	"github.com/dedis/prifi/prifi-lib/config"
 	"github.com/dedis/prifi/prifi-lib/crypto"
 	"github.com/dedis/prifi/prifi-lib/net"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/onet/v3/log"
 	"strconv"
 )
@@ -26,6 +26,7 @@ import (
  * The view of one trustee for the Neff Shuffle
  */
 type NeffShuffleTrustee struct {
+	Suite      suites.Suite
 	TrusteeID  int
 	PrivateKey kyber.Scalar
 	PublicKey  kyber.Point
@@ -71,7 +72,7 @@ func (t *NeffShuffleTrustee) ReceivedShuffleFromRelay(lastBase kyber.Point, clie
 		return nil, errors.New("Cannot perform a shuffle is len(clientPublicKeys) is 0")
 	}
 
-	shuffledKeys, newBase, secretCoeff, proof, err := crypto.NeffShuffle(clientPublicKeys, lastBase, shuffleKeyPositions)
+	shuffledKeys, newBase, secretCoeff, proof, err := crypto.NeffShuffle(t.Suite, clientPublicKeys, lastBase, shuffleKeyPositions)
 	if err != nil {
 		return nil, err
 	}
@@ -182,7 +183,7 @@ func (t *NeffShuffleTrustee) ReceivedTranscriptFromRelay(bases []kyber.Point, sh
 	}
 
 	//sign this blob
-	signature, err := schnorr.Sign(config.CryptoSuite, t.PrivateKey, blob)
+	signature, err := schnorr.Sign(t.Suite, t.PrivateKey, blob)
 	if err != nil {
 		log.Panic("Could not schnorr-sign the transcript:", err)
 	}
@@ -1 +1,90 @@
 package scheduler
+
+import (
+	"errors"
+)
+
+// Names of the slot schedulers, as used in prifi.toml and in ALL_ALL_PARAMETERS
+const (
+	// One bit per client, set if the client wants to transmit in its slot from the shuffle
+	SLOT_SCHEDULER_BITMASK = "BitMask"
+
+	// Anonymous reservation of slots with collision-detecting footprints, see footprint_scheduler.go
+	SLOT_SCHEDULER_FOOTPRINT = "Footprint"
+)
+
+// SlotScheduler_Client is the client side of a protocol between the relay and the clients that allows to decide which
+// slots are gonna be "open" (fixed-length byte array) or "closed" (inexistant, no message at all).
+// The contributions of the clients are combined (XORed) by the DC-net.
+type SlotScheduler_Client interface {
+
+	//the client receives a new schedule request from the relay
+	Client_ReceivedScheduleRequest(nClients int)
+
+	//the client alters the schedule being computed, and ask to transmit. "slotID" is its slot from the shuffle
+	Client_ReserveRound(slotID int)
+
+	//return the schedule to send as payload
+	Client_GetOpenScheduleContribution() []byte
+
+	//returns true if the slot "slotID" announced by the relay belongs to the client, whose slot from the shuffle is "mySlot"
+	Client_OwnsSlot(slotID int, mySlot int) bool
+}
+
+// SlotScheduler_Relay is the relay side of a protocol between the relay and the clients that allows to decide which
+// slots are gonna be "open" or "closed"
+type SlotScheduler_Relay interface {
+
+	//Called with each client's contribution (in the real DC-net, this is done by the DC-net itself)
+	Relay_CombineContributions(contributions ...[]byte) []byte
+
+	// returns all contributions in forms of a map of open slots, for "nClients" clients
+	Relay_ComputeFinalSchedule(allContributions []byte, nClients int) map[int]bool
+
+	// returns the length of the contribution of each client, for "nClients" clients
+	Relay_ContributionLength(nClients int) int
+}
+
+// ValidateSlotScheduler returns an error if "name" is not a known slot scheduler
+func ValidateSlotScheduler(name string) error {
+	switch name {
+	case SLOT_SCHEDULER_BITMASK, SLOT_SCHEDULER_FOOTPRINT:
+		return nil
+	}
+	return errors.New("Unknown slot scheduler " + name + ", should be " + SLOT_SCHEDULER_BITMASK + " or " + SLOT_SCHEDULER_FOOTPRINT)
+}
+
+// NewSlotScheduler_Client returns the client side of the slot scheduler "name", where a client may reserve up to
+// "maxSlotsPerClient" slots in one schedule (the bit mask only has one)
+func NewSlotScheduler_Client(name string, maxSlotsPerClient int) (SlotScheduler_Client, error) {
+	switch name {
+	case SLOT_SCHEDULER_BITMASK:
+		return new(BitMaskSlotScheduler_Client), nil
+	case SLOT_SCHEDULER_FOOTPRINT:
+		return &FootprintSlotScheduler_Client{MaxSlotsPerClient: maxSlotsPerClient}, nil
+	}
+	return nil, ValidateSlotScheduler(name)
+}
+
+// NewSlotScheduler_Relay returns the relay side of the slot scheduler "name", where a client may reserve up to
+// "maxSlotsPerClient" slots in one schedule (the bit mask only has one)
+func NewSlotScheduler_Relay(name string, maxSlotsPerClient int) (SlotScheduler_Relay, error) {
+	switch name {
+	case SLOT_SCHEDULER_BITMASK:
+		return new(BitMaskSlotScheduler_Relay), nil
+	case SLOT_SCHEDULER_FOOTPRINT:
+		return &FootprintSlotScheduler_Relay{MaxSlotsPerClient: maxSlotsPerClient}, nil
+	}
+	return nil, ValidateSlotScheduler(name)
+}
+
+// xorContributions combines (XOR) the contributions of the clients, as the DC-net does
+func xorContributions(contributions ...[]byte) []byte {
+	out := make([]byte, len(contributions[0]))
+	for j := range contributions {
+		for i := range contributions[j] {
+			out[i] ^= contributions[j][i]
+		}
+	}
+	return out
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/dedis/prifi/prifi-lib/crypto"
 	"github.com/dedis/prifi/prifi-lib/net"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
 	"strconv"
 	"testing"
 )
@@ -48,10 +49,22 @@ func TestWholeNeffShuffle(t *testing.T) {
 	}
 }
 
+func TestWholeNeffShuffleP256(t *testing.T) {
+	suite, err := config.FindCryptoSuite(config.CRYPTO_SUITE_P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	neffShuffleTestHelperWithSuite(t, suite, 3, 2, true)
+}
+
 func NeffShuffleTestHelper(t *testing.T, nClients int, nTrustees int, shuffleKeyPos bool) []int {
+	return neffShuffleTestHelperWithSuite(t, config.CryptoSuite, nClients, nTrustees, shuffleKeyPos)
+}
+
+func neffShuffleTestHelperWithSuite(t *testing.T, suite suites.Suite, nClients int, nTrustees int, shuffleKeyPos bool) []int {
 	clients := make([]*PrivatePublicPair, nClients)
 	for i := 0; i < nClients; i++ {
-		pub, priv := crypto.NewKeyPair()
+		pub, priv := crypto.NewKeyPair(suite)
 		clients[i] = new(PrivatePublicPair)
 		clients[i].Public = pub
 		clients[i].Private = priv
@@ -59,14 +72,14 @@ func NeffShuffleTestHelper(t *testing.T, nClients int, nTrustees int, shuffleKey
 
 	//create the scheduler
 	n := new(NeffShuffle) //this will hold 1 relay, 1 trustee at most. Recreate n for >1 trustee
-	n.Init()
+	n.Init(suite)
 
 	//init the trustees
 	trustees := make([]*NeffShuffle, nTrustees)
 	for i := 0; i < nTrustees; i++ {
 		trustees[i] = new(NeffShuffle)
-		trustees[i].Init()
-		pub, priv := crypto.NewKeyPair()
+		trustees[i].Init(suite)
+		pub, priv := crypto.NewKeyPair(suite)
 		trustees[i].TrusteeView.Init(i, priv, pub)
 	}
 
@@ -106,7 +119,7 @@ func NeffShuffleTestHelper(t *testing.T, nClients int, nTrustees int, shuffleKey
 			B_i_minus_1 = n.RelayView.Bases[i-1]
 		}
 		c_i := trustees[i].TrusteeView.SecretCoeff
-		B_i := config.CryptoSuite.Point().Mul(c_i, B_i_minus_1)
+		B_i := suite.Point().Mul(c_i, B_i_minus_1)
 
 		if !parsed2.NewBase.Equal(B_i) {
 			t.Error("B[" + strconv.Itoa(i+1) + "] is computed incorrectly")
@@ -118,14 +131,14 @@ func NeffShuffleTestHelper(t *testing.T, nClients int, nTrustees int, shuffleKey
 
 			for clientID := 0; clientID < nClients; clientID++ {
 				p1 := clients[clientID].Private
-				c_s := config.CryptoSuite.Scalar().One()
+				c_s := suite.Scalar().One()
 				for j := 0; j <= i; j++ {
 					c_j := trustees[j].TrusteeView.SecretCoeff
-					c_s = config.CryptoSuite.Scalar().Mul(c_s, c_j)
+					c_s = suite.Scalar().Mul(c_s, c_j)
 				}
-				B := config.CryptoSuite.Point().Base()
-				p1_c_s := config.CryptoSuite.Scalar().Mul(p1, c_s)
-				LHS := config.CryptoSuite.Point().Mul(p1_c_s, B)
+				B := suite.Point().Base()
+				p1_c_s := suite.Scalar().Mul(p1, c_s)
+				LHS := suite.Point().Mul(p1_c_s, B)
 
 				if !parsed2.NewEphPks[clientID].Equal(LHS) {
 					t.Error("P" + strconv.Itoa(clientID) + "'[" + strconv.Itoa(i+1) + "] is computed incorrectly")
@@ -137,14 +150,14 @@ func NeffShuffleTestHelper(t *testing.T, nClients int, nTrustees int, shuffleKey
 				// Trustee 1 compute B1 = B * c1
 				B := n.RelayView.InitialBase
 				c1 := trustees[0].TrusteeView.SecretCoeff
-				if !parsed2.NewBase.Equal(config.CryptoSuite.Point().Mul(c1, B)) {
+				if !parsed2.NewBase.Equal(suite.Point().Mul(c1, B)) {
 					t.Error("B1 is computed incorrectly")
 				}
 
 				// Trustee 1 compute P1' = P1 * c1 = p1 * c1 * B
 				for clientID := 0; clientID < nClients; clientID++ {
-					p1prime := config.CryptoSuite.Scalar().Mul(clients[clientID].Private, c1)
-					if !parsed2.NewEphPks[clientID].Equal(config.CryptoSuite.Point().Mul(p1prime, B)) {
+					p1prime := suite.Scalar().Mul(clients[clientID].Private, c1)
+					if !parsed2.NewEphPks[clientID].Equal(suite.Point().Mul(p1prime, B)) {
 						t.Error("P" + strconv.Itoa(clientID) + "' is computed incorrectly")
 					}
 				}
@@ -157,15 +170,15 @@ func NeffShuffleTestHelper(t *testing.T, nClients int, nTrustees int, shuffleKey
 				B := n.RelayView.InitialBase
 				c1 := trustees[0].TrusteeView.SecretCoeff
 				c2 := trustees[1].TrusteeView.SecretCoeff
-				c1c2 := config.CryptoSuite.Scalar().Mul(c1, c2)
-				if !parsed2.NewBase.Equal(config.CryptoSuite.Point().Mul(c1c2, B)) {
+				c1c2 := suite.Scalar().Mul(c1, c2)
+				if !parsed2.NewBase.Equal(suite.Point().Mul(c1c2, B)) {
 					t.Error("B2 is computed incorrectly (2)")
 				}
 
 				//* Trustee 2 compute P1'' = P1' * c2 = p1 * c1 * c2 * B
 				for clientID := 0; clientID < nClients; clientID++ {
-					p1prime2 := config.CryptoSuite.Scalar().Mul(clients[clientID].Private, c1c2)
-					if !parsed2.NewEphPks[clientID].Equal(config.CryptoSuite.Point().Mul(p1prime2, B)) {
+					p1prime2 := suite.Scalar().Mul(clients[clientID].Private, c1c2)
+					if !parsed2.NewEphPks[clientID].Equal(suite.Point().Mul(p1prime2, B)) {
 						t.Error("P" + strconv.Itoa(clientID) + "'' is computed incorrectly")
 					}
 				}
@@ -228,6 +241,52 @@ func NeffShuffleTestHelper(t *testing.T, nClients int, nTrustees int, shuffleKey
 			t.Error(err)
 		}
 		mapping[j] = mySlot
+
+		//clients verifying the shuffle themselves check the whole transcript
+		err = n.ClientVerifyTranscript(clients[j].Public, parsed3.InitialEphPks, parsed3.Bases, parsed3.GetKeys(), parsed3.GetProofs(), parsed5.Base, parsed5.EphPks)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	//a transcript with a wrong proof, or without our key, or ending with another shuffle is refused
+	wrongProofs := parsed3.GetProofs()
+	wrongProofs[nTrustees-1] = wrongProofs[nTrustees-1][1:]
+	if n.ClientVerifyTranscript(clients[0].Public, parsed3.InitialEphPks, parsed3.Bases, parsed3.GetKeys(), wrongProofs, parsed5.Base, parsed5.EphPks) == nil {
+		t.Error("ClientVerifyTranscript should fail with a wrong proof")
+	}
+	otherKey, _ := crypto.NewKeyPair(suite)
+	if n.ClientVerifyTranscript(otherKey, parsed3.InitialEphPks, parsed3.Bases, parsed3.GetKeys(), parsed3.GetProofs(), parsed5.Base, parsed5.EphPks) == nil {
+		t.Error("ClientVerifyTranscript should fail if our key was not shuffled")
+	}
+	if n.ClientVerifyTranscript(clients[0].Public, parsed3.InitialEphPks, parsed3.Bases, parsed3.GetKeys(), parsed3.GetProofs(), otherKey, parsed5.EphPks) == nil {
+		t.Error("ClientVerifyTranscript should fail if the transcript does not end with the signed shuffle")
+	}
+
+	//the last trustee swaps two keys after proving its shuffle : the signed shuffle is not the permutation proven
+	if nClients > 1 {
+		swappedKeys := parsed3.GetKeys()
+		swappedKeys[nTrustees-1] = append([]kyber.Point{}, swappedKeys[nTrustees-1]...)
+		swappedKeys[nTrustees-1][0], swappedKeys[nTrustees-1][1] = swappedKeys[nTrustees-1][1], swappedKeys[nTrustees-1][0]
+		if n.ClientVerifyTranscript(clients[0].Public, parsed3.InitialEphPks, parsed3.Bases, swappedKeys, parsed3.GetProofs(), parsed5.Base, swappedKeys[nTrustees-1]) == nil {
+			t.Error("ClientVerifyTranscript should fail with a wrong permutation")
+		}
+	}
+
+	//the proof starts with the commitments to the permutation, and ends with the responses
+	otherPoint, _ := crypto.NewKeyPair(suite)
+	commitment, _ := otherPoint.MarshalBinary()
+	tamperedCommitment := parsed3.GetProofs()
+	tamperedCommitment[0] = append(append([]byte{}, commitment...), tamperedCommitment[0][len(commitment):]...)
+	if n.ClientVerifyTranscript(clients[0].Public, parsed3.InitialEphPks, parsed3.Bases, parsed3.GetKeys(), tamperedCommitment, parsed5.Base, parsed5.EphPks) == nil {
+		t.Error("ClientVerifyTranscript should fail with a tampered commitment")
+	}
+	response, _ := suite.Scalar().Pick(suite.RandomStream()).MarshalBinary()
+	tamperedResponse := parsed3.GetProofs()
+	proofLen := len(tamperedResponse[0])
+	tamperedResponse[0] = append(append([]byte{}, tamperedResponse[0][:proofLen-len(response)]...), response...)
+	if n.ClientVerifyTranscript(clients[0].Public, parsed3.InitialEphPks, parsed3.Bases, parsed3.GetKeys(), tamperedResponse, parsed5.Base, parsed5.EphPks) == nil {
+		t.Error("ClientVerifyTranscript should fail with a tampered response")
 	}
 
 	//test that mapping is valid
@@ -253,14 +312,14 @@ func NeffShuffleTestHelper(t *testing.T, nClients int, nTrustees int, shuffleKey
 
 func TestWholeNeffShuffleClientErrors(t *testing.T) {
 	n := new(NeffShuffle) //this will hold 1 relay, 1 trustee at most. Recreate n for >1 trustee
-	n.Init()
-	_, priv := crypto.NewKeyPair()
+	n.Init(config.CryptoSuite)
+	_, priv := crypto.NewKeyPair(config.CryptoSuite)
 
 	//init the trustees
 	nTrustees := 2
 	trusteesPks := make([]kyber.Point, nTrustees)
 	for i := 0; i < nTrustees; i++ {
-		pub, _ := crypto.NewKeyPair()
+		pub, _ := crypto.NewKeyPair(config.CryptoSuite)
 		trusteesPks[i] = pub
 	}
 
@@ -268,7 +327,7 @@ func TestWholeNeffShuffleClientErrors(t *testing.T) {
 	nClients := 4
 	ephPks := make([]kyber.Point, nClients)
 	for i := 0; i < nTrustees; i++ {
-		pub, _ := crypto.NewKeyPair()
+		pub, _ := crypto.NewKeyPair(config.CryptoSuite)
 		ephPks[i] = pub
 	}
 
@@ -312,10 +371,10 @@ func TestWholeNeffShuffleClientErrors(t *testing.T) {
 
 func TestWholeNeffShuffleRelayErrors(t *testing.T) {
 
-	pub, _ := crypto.NewKeyPair()
+	pub, _ := crypto.NewKeyPair(config.CryptoSuite)
 	//create the scheduler
 	n := new(NeffShuffle)
-	n.Init()
+	n.Init(config.CryptoSuite)
 
 	err := n.RelayView.Init(0)
 	if err == nil {
@@ -352,7 +411,7 @@ func TestWholeNeffShuffleRelayErrors(t *testing.T) {
 	nClients := 4
 	ephPks := make([]kyber.Point, nClients)
 	for i := 0; i < nClients; i++ {
-		pub, _ := crypto.NewKeyPair()
+		pub, _ := crypto.NewKeyPair(config.CryptoSuite)
 		ephPks[i] = pub
 	}
 	proof := make([]byte, 10)
@@ -415,10 +474,10 @@ func TestWholeNeffShuffleRelayErrors(t *testing.T) {
 
 func TestWholeNeffShuffleTrusteeErrors(t *testing.T) {
 
-	pub, priv := crypto.NewKeyPair()
+	pub, priv := crypto.NewKeyPair(config.CryptoSuite)
 	//create the scheduler
 	n := new(NeffShuffle)
-	n.Init()
+	n.Init(config.CryptoSuite)
 
 	err := n.TrusteeView.Init(-1, priv, pub)
 	if err == nil {
@@ -438,7 +497,7 @@ func TestWholeNeffShuffleTrusteeErrors(t *testing.T) {
 	nClients := 4
 	ephPks := make([]kyber.Point, nClients)
 	for i := 0; i < nClients; i++ {
-		pub, _ := crypto.NewKeyPair()
+		pub, _ := crypto.NewKeyPair(config.CryptoSuite)
 		ephPks[i] = pub
 	}
 	_, err = n.TrusteeView.ReceivedShuffleFromRelay(nil, ephPks, true, make([]byte, 1))
@@ -486,7 +545,7 @@ func TestWholeNeffShuffleTrusteeErrors(t *testing.T) {
 
 	n.TrusteeView.EphemeralKeys = ephPks
 
-	newPub, _ := crypto.NewKeyPair()
+	newPub, _ := crypto.NewKeyPair(config.CryptoSuite)
 	ephPks_s := make([][]kyber.Point, 1)
 	for i := 0; i < len(ephPks_s); i++ {
 		ephPks_s[i] = make([]kyber.Point, len(ephPks))
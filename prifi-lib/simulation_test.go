@@ -0,0 +1,620 @@
+package prifi_lib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dedis/prifi/prifi-lib/net"
+	"github.com/dedis/prifi/prifi-lib/scheduler"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/**
+ * In-memory network, which runs a relay, some trustees and some clients in this process
+ */
+type localNetwork struct {
+	relay    chan interface{}
+	clients  []chan interface{}
+	trustees []chan interface{}
+	observer func(clientID int, msg interface{})    // if not nil, sees the messages sent to the clients
+	left     map[int]bool                           // the clients which left, they receive nothing anymore
+	lost     func(clientID int, roundID int32) bool // if not nil, the broadcasts which the clients miss
+	// if slotsLate, client 0 receives the re-shuffled slots after the next broadcast
+	slotsLate    bool
+	delayedSlots interface{}
+	// if latency > 0, the messages reach the clients after this time, through their delay line
+	latency    time.Duration
+	delayLines []chan delayedMessage
+	// if not nil, the rounds whose downstream data the relay changes for some clients
+	equivocate func(clientID int, roundID int32) bool
+}
+
+// delayedMessage is a message in a delay line, to deliver at "due"
+type delayedMessage struct {
+	msg interface{}
+	due time.Time
+}
+
+// the entities receive messages by value, and must not share the maps of the parameters
+func localCopy(msg interface{}) interface{} {
+	if v := reflect.ValueOf(msg); v.Kind() == reflect.Ptr {
+		msg = v.Elem().Interface()
+	}
+	params, ok := msg.(net.ALL_ALL_PARAMETERS)
+	if !ok {
+		return msg
+	}
+	c := params
+	c.ParamsInt = make(map[string]int)
+	c.ParamsStr = make(map[string]string)
+	c.ParamsBool = make(map[string]bool)
+	for k, v := range params.ParamsInt {
+		c.ParamsInt[k] = v
+	}
+	for k, v := range params.ParamsStr {
+		c.ParamsStr[k] = v
+	}
+	for k, v := range params.ParamsBool {
+		c.ParamsBool[k] = v
+	}
+	return c
+}
+
+func (n *localNetwork) SendToClient(i int, msg interface{}) error {
+	if n.left[i] {
+		return nil
+	}
+	if slots, ok := localCopy(msg).(net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG); ok && n.slotsLate && i == 0 && slots.StartRoundID > 0 {
+		n.delayedSlots = msg
+		return nil
+	}
+	n.deliverToClient(i, msg)
+	return nil
+}
+func (n *localNetwork) deliverToClient(i int, msg interface{}) {
+	if data, ok := localCopy(msg).(net.REL_CLI_DOWNSTREAM_DATA); ok && n.equivocate != nil && n.equivocate(i, data.RoundID) {
+		data.Data = append([]byte{}, data.Data...)
+		data.Data[0] ^= 1
+		msg = data
+	}
+	if n.observer != nil {
+		n.observer(i, localCopy(msg))
+	}
+	if n.latency > 0 {
+		n.delayLines[i] <- delayedMessage{msg: localCopy(msg), due: time.Now().Add(n.latency)}
+		return
+	}
+	n.clients[i] <- localCopy(msg)
+}
+func (n *localNetwork) SendToTrustee(i int, msg interface{}) error {
+	n.trustees[i] <- localCopy(msg)
+	return nil
+}
+func (n *localNetwork) SendToRelay(msg interface{}) error {
+	n.relay <- localCopy(msg)
+	return nil
+}
+func (n *localNetwork) BroadcastToAllClients(msg interface{}) error {
+	data, ok := msg.(*net.REL_CLI_DOWNSTREAM_DATA_UDP)
+	for i := range n.clients {
+		if ok && n.lost != nil && n.lost(i, data.RoundID) {
+			continue
+		}
+		n.SendToClient(i, msg)
+		if i == 0 && n.delayedSlots != nil {
+			n.deliverToClient(i, n.delayedSlots)
+			n.delayedSlots = nil
+		}
+	}
+	return nil
+}
+func (n *localNetwork) ClientSubscribeToBroadcast(clientID int, messageReceived func(interface{}) error, startStopChan chan bool) error {
+	return nil
+}
+
+// delay hands the messages of "line" to "queue" when they are due, in order
+func delay(line chan delayedMessage, queue chan interface{}) {
+	for d := range line {
+		time.Sleep(time.Until(d.due))
+		queue <- d.msg
+	}
+}
+
+// deliver hands the messages of "queue" to "entity", one at a time
+func deliver(queue chan interface{}, entity *PriFiLibInstance) {
+	for msg := range queue {
+		if err := entity.ReceivedMessage(msg); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// simulate runs PriFi with the parameters "params" until the relay reaches ExperimentRoundLimit. The data in
+// "clientsData" is queued at each client before starting; "observer", if not nil, sees the messages sent to the clients.
+// Returns the payloads output by the relay
+func simulate(t *testing.T, nTrustees int, clientsData [][][]byte, params *net.ALL_ALL_PARAMETERS,
+	observer func(clientID int, msg interface{})) [][]byte {
+	return simulateWithChurn(t, nTrustees, clientsData, churn{}, params, observer)
+}
+
+// churn describes the clients joining and leaving during a simulation
+type churn struct {
+	joiningData [][][]byte                             // the data queued at the clients joining, which get the next IDs
+	joinRound   int32                                  // the clients join when client 0 receives the downstream data of this round
+	leaving     []int                                  // the IDs of the clients leaving
+	leaveRound  int32                                  // they receive nothing after the downstream data of this round
+	leaveKnown  bool                                   // our host sees their connection close, and tells the relay
+	lost        func(clientID int, roundID int32) bool // if not nil, the UDP broadcasts which the clients miss
+	slotsLate   bool                                   // client 0 receives the re-shuffled slots after the next UDP broadcast
+	latency     time.Duration                          // if > 0, the time the messages take to reach the clients
+	equivocate  func(clientID int, roundID int32) bool // if not nil, the rounds whose downstream data the relay changes for some clients
+}
+
+// simulateWithJoins is simulate, where the clients of "joiningData" join when the relay opens the round "joinRound"
+func simulateWithChurn(t *testing.T, nTrustees int, clientsData [][][]byte, churn churn, params *net.ALL_ALL_PARAMETERS,
+	observer func(clientID int, msg interface{})) [][]byte {
+	queueSize := 100000
+	joiningData := churn.joiningData
+	network := &localNetwork{relay: make(chan interface{}, queueSize), left: make(map[int]bool), lost: churn.lost,
+		slotsLate: churn.slotsLate, latency: churn.latency, equivocate: churn.equivocate}
+	network.observer = func(clientID int, msg interface{}) {
+		data, ok := msg.(net.REL_CLI_DOWNSTREAM_DATA)
+		if ok && clientID == 0 && data.RoundID == churn.joinRound && len(joiningData) > 0 {
+			// our host tells the relay about the clients which connected
+			for i := range joiningData {
+				network.relay <- net.CLI_REL_JOIN{ClientID: len(clientsData) + i}
+			}
+		}
+		if ok && clientID == 0 && data.RoundID == churn.leaveRound {
+			// called by the relay, the only sender to the clients
+			for _, c := range churn.leaving {
+				network.left[c] = true
+				if churn.leaveKnown {
+					network.relay <- net.CLI_REL_LEAVE{ClientID: c}
+				}
+			}
+		}
+		if observer != nil {
+			observer(clientID, msg)
+		}
+	}
+
+	relayOutput := make(chan []byte, queueSize)
+	resultChan := make(chan interface{}, 1)
+	timeoutHandler := func(clients, trustees []int) { log.Error("Timeout of clients", clients, "and trustees", trustees) }
+	relay := NewPriFiRelay(true, make(chan []byte), relayOutput, resultChan, timeoutHandler, network)
+	go deliver(network.relay, relay)
+
+	for i := 0; i < nTrustees; i++ {
+		network.trustees = append(network.trustees, make(chan interface{}, queueSize))
+		// the trustees pace the rounds, so that the shuffles run in the background last a few rounds only
+		go deliver(network.trustees[i], NewPriFiTrustee(false, true, 2, network))
+	}
+	for i, data := range append(append([][][]byte{}, clientsData...), joiningData...) {
+		dataForDCNet := make(chan []byte, len(data))
+		for _, d := range data {
+			dataForDCNet <- d
+		}
+		network.clients = append(network.clients, make(chan interface{}, queueSize))
+		network.delayLines = append(network.delayLines, make(chan delayedMessage, queueSize))
+		go delay(network.delayLines[i], network.clients[i])
+		go deliver(network.clients[i], NewPriFiClient(false, false, dataForDCNet, make(chan []byte), false, "./", network))
+	}
+
+	params.Add("StartNow", true)
+	params.Add("NTrustees", nTrustees)
+	params.Add("NClients", len(clientsData))
+	params.ForceParams = true
+	network.relay <- localCopy(params)
+
+	select {
+	case <-resultChan:
+	case <-time.After(60 * time.Second):
+		t.Fatal("The simulation did not reach the round limit")
+	}
+
+	output := make([][]byte, 0)
+	for len(relayOutput) > 0 {
+		output = append(output, <-relayOutput)
+	}
+	return output
+}
+
+// simulationParams returns the parameters shared by the simulations : cells of 100 bytes, the simple DC-net, rounds
+// which time out after 10s, and trustee caches of 10 to 20 ciphers
+func simulationParams(useOpenClosedSlots bool) *net.ALL_ALL_PARAMETERS {
+	params := new(net.ALL_ALL_PARAMETERS)
+	params.Add("PayloadSize", 100)
+	params.Add("DownstreamCellSize", 100)
+	params.Add("DCNetType", "Simple")
+	params.Add("UseOpenClosedSlots", useOpenClosedSlots)
+	params.Add("RelayRoundTimeOut", 10000)
+	params.Add("RelayTrusteeCacheLowBound", 10)
+	params.Add("RelayTrusteeCacheHighBound", 20)
+	return params
+}
+
+// forEachSlotsMode runs "test" as two subtests, with the slots in a fixed order and with open/closed slots, each time
+// with fresh simulationParams
+func forEachSlotsMode(t *testing.T, test func(t *testing.T, params *net.ALL_ALL_PARAMETERS)) {
+	for _, useOpenClosedSlots := range []bool{false, true} {
+		name := "FixedSlots"
+		if useOpenClosedSlots {
+			name = "OpenClosedSlots"
+		}
+		params := simulationParams(useOpenClosedSlots)
+		t.Run(name, func(t *testing.T) {
+			test(t, params)
+		})
+	}
+}
+
+// clientMessages returns 15 messages of 50 bytes made of "clientID" + 1 and a sequence number
+func clientMessages(clientID int) [][]byte {
+	messages := make([][]byte, 0)
+	for i := 0; i < 15; i++ {
+		message := make([]byte, 50)
+		message[0] = byte(clientID + 1)
+		message[1] = byte(i)
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// checkMessagesInOrder checks that each of the "nClients" clients delivered its 15 clientMessages, in order : no
+// message is garbled, lost or duplicated
+func checkMessagesInOrder(t *testing.T, output [][]byte, nClients int) {
+	nextMessage := make(map[byte]byte)
+	for _, o := range output {
+		if len(o) < 2 || o[0] == 0 {
+			continue
+		}
+		if int(o[0]) > nClients || o[1] != nextMessage[o[0]] {
+			t.Fatal("Unexpected output", o[:2])
+		}
+		nextMessage[o[0]]++
+	}
+	for c := byte(1); int(c) <= nClients; c++ {
+		if nextMessage[c] != 15 {
+			t.Error("Client", c-1, "sent", nextMessage[c], "messages out of 15")
+		}
+	}
+}
+
+// heavyMarker starts the messages of the heavy client in the uneven load simulations
+const heavyMarker = 0xAB
+
+// countHeavyMessages returns the number of messages of the heavy client in "output"
+func countHeavyMessages(output [][]byte) int {
+	n := 0
+	for _, o := range output {
+		if len(o) > 0 && o[0] == heavyMarker {
+			n++
+		}
+	}
+	return n
+}
+
+// simulateUnevenLoad runs 4 clients for 80 rounds, client 0 having lots to send and the others nothing, where a client
+// may reserve up to "maxSlotsPerClient" slots per schedule. Returns the number of messages of client 0 received
+func simulateUnevenLoad(t *testing.T, maxSlotsPerClient int) int {
+	nClients := 4
+	clientsData := make([][][]byte, nClients)
+	for i := 0; i < 200; i++ {
+		message := make([]byte, 100)
+		message[0] = heavyMarker
+		message[1] = byte(i)
+		clientsData[0] = append(clientsData[0], message)
+	}
+
+	params := simulationParams(true)
+	params.Add("PayloadSize", 500)
+	params.Add("WindowSize", 1)
+	params.Add("ExperimentRoundLimit", 80)
+	params.Add("SlotScheduler", scheduler.SLOT_SCHEDULER_FOOTPRINT)
+	params.Add("MaxSlotsPerClient", maxSlotsPerClient)
+
+	return countHeavyMessages(simulate(t, 2, clientsData, params, nil))
+}
+
+func TestSimulationUnevenLoad(t *testing.T) {
+	oneSlot := simulateUnevenLoad(t, 1)
+	severalSlots := simulateUnevenLoad(t, 4)
+	log.Lvl1("Messages of the heavy client in 80 rounds : ", oneSlot, "with one slot per schedule,", severalSlots, "with up to 4 slots")
+
+	if oneSlot == 0 {
+		t.Fatal("The heavy client could not send anything")
+	}
+	// one slot per schedule : a data round for each schedule round. Four slots : four data rounds for each schedule round
+	if severalSlots < 3*oneSlot/2 {
+		t.Error("Reserving several slots should increase the throughput of the heavy client, got", severalSlots,
+			"messages instead of", oneSlot)
+	}
+}
+
+// simulateReshuffles runs 3 clients for 120 rounds with the parameters "params", the slots being re-shuffled every 15
+// rounds. Each client sends its clientMessages. Returns the payloads output by the relay, the first round of each
+// re-shuffle, as announced to client 0, and the number of shuffle transcripts sent to client 0
+func simulateReshuffles(t *testing.T, params *net.ALL_ALL_PARAMETERS) ([][]byte, []int32, int) {
+	clientsData := [][][]byte{clientMessages(0), clientMessages(1), clientMessages(2)}
+	params.Add("WindowSize", 1)
+	params.Add("ExperimentRoundLimit", 120)
+	params.Add("ReshuffleRounds", 15)
+
+	starts := make(chan int32, 100)
+	transcripts := make(chan bool, 100)
+	observer := func(clientID int, msg interface{}) {
+		if slots, ok := msg.(net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG); ok && clientID == 0 && slots.StartRoundID > 0 {
+			starts <- slots.StartRoundID
+		}
+		if _, ok := msg.(net.REL_TRU_TELL_TRANSCRIPT); ok && clientID == 0 {
+			transcripts <- true
+		}
+	}
+	output := simulate(t, 2, clientsData, params, observer)
+
+	reshuffles := make([]int32, 0)
+	for len(starts) > 0 {
+		reshuffles = append(reshuffles, <-starts)
+	}
+	return output, reshuffles, len(transcripts)
+}
+
+func TestSimulationReshuffles(t *testing.T) {
+	forEachSlotsMode(t, func(t *testing.T, params *net.ALL_ALL_PARAMETERS) {
+		output, reshuffles, _ := simulateReshuffles(t, params)
+		log.Lvl1("Re-shuffled slots starting at rounds", reshuffles)
+
+		if len(reshuffles) < 2 {
+			t.Error("The slots should be re-shuffled several times, got", reshuffles)
+		}
+
+		// the slots change at once for all the clients
+		checkMessagesInOrder(t, output, 3)
+	})
+}
+
+func TestSimulationClientsVerifyShuffle(t *testing.T) {
+	params := simulationParams(false)
+	params.Add("ClientsVerifyShuffle", true)
+	output, reshuffles, transcripts := simulateReshuffles(t, params)
+	log.Lvl1("Re-shuffled slots starting at rounds", reshuffles, ",", transcripts, "transcripts verified by client 0")
+
+	// the clients switch to a re-shuffle only if they verified its transcript
+	if len(reshuffles) < 2 {
+		t.Error("The slots should be re-shuffled several times, got", reshuffles)
+	}
+	if transcripts < len(reshuffles)+1 {
+		t.Error("Client 0 should receive the transcript of the setup and of each re-shuffle, got", transcripts,
+			"transcripts for", len(reshuffles), "re-shuffles")
+	}
+	checkMessagesInOrder(t, output, 3)
+}
+
+func TestSimulationClientJoins(t *testing.T) {
+	forEachSlotsMode(t, func(t *testing.T, params *net.ALL_ALL_PARAMETERS) {
+		params.Add("WindowSize", 1)
+		params.Add("ExperimentRoundLimit", 160)
+		params.Add("DCNetEpochRounds", 10)
+		// client 3 starts from the history of the downstream cells sent before it joins
+		params.Add("EquivocationProtectionEnabled", true)
+
+		joinedAt := make(chan int32, 10)
+		observer := func(clientID int, msg interface{}) {
+			if slots, ok := msg.(net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG); ok && clientID == 3 {
+				joinedAt <- slots.StartRoundID
+			}
+		}
+		clientsData := [][][]byte{clientMessages(0), clientMessages(1), clientMessages(2)}
+		output := simulateWithChurn(t, 2, clientsData, churn{joiningData: [][][]byte{clientMessages(3)}, joinRound: 20}, params, observer)
+
+		// no re-shuffle is due, the join triggers one
+		if len(joinedAt) != 1 {
+			t.Fatal("Client 3 should join once")
+		}
+		log.Lvl1("Client 3 joined at round", <-joinedAt)
+
+		// the other clients keep communicating while it joins
+		checkMessagesInOrder(t, output, 4)
+	})
+}
+
+func TestSimulationClientLeaves(t *testing.T) {
+	forEachSlotsMode(t, func(t *testing.T, params *net.ALL_ALL_PARAMETERS) {
+		params.Add("WindowSize", 1)
+		params.Add("ExperimentRoundLimit", 120)
+		params.Add("DCNetEpochRounds", 10)
+		params.Add("ReshuffleRounds", 30)
+		params.Add("RelayRoundTimeOut", 200)
+		params.Add("RelayMaxNumberOfConsecutiveFailedRounds", 2)
+		params.Add("EquivocationProtectionEnabled", true)
+
+		// client 2 has nothing to send, and leaves at round 20
+		clientsData := [][][]byte{clientMessages(0), clientMessages(1), nil}
+		output := simulateWithChurn(t, 2, clientsData, churn{leaving: []int{2}, leaveRound: 20}, params, nil)
+
+		// the rounds opened before client 2 is dropped are lost, the next ones are decoded, re-shuffles included
+		checkMessagesAfterLeave(t, output)
+	})
+}
+
+// checkMessagesAfterLeave checks that the clients 0 and 1 delivered their last clientMessages, while client 2 left :
+// the messages sent in the rounds lost are missing, but none is garbled, duplicated or reordered
+func checkMessagesAfterLeave(t *testing.T, output [][]byte) {
+	lastMessage := map[byte]int{1: -1, 2: -1}
+	for _, o := range output {
+		if len(o) < 2 || o[0] == 0 {
+			continue
+		}
+		last, known := lastMessage[o[0]]
+		if !known || int(o[1]) <= last {
+			t.Fatal("Unexpected output", o[:2], "after client 2 left")
+		}
+		lastMessage[o[0]] = int(o[1])
+	}
+	for c, last := range lastMessage {
+		if last != 14 {
+			t.Error("The last message of client", c-1, "is", last, "after client 2 left")
+		}
+	}
+}
+
+func TestSimulationClientLeaveKnown(t *testing.T) {
+	forEachSlotsMode(t, func(t *testing.T, params *net.ALL_ALL_PARAMETERS) {
+		params.Add("WindowSize", 1)
+		params.Add("ExperimentRoundLimit", 120)
+		params.Add("RelayRoundTimeOut", 3000)
+		params.Add("RelayMaxNumberOfConsecutiveFailedRounds", 4)
+
+		// client 2 leaves at round 20, and our host tells the relay : it is dropped at the next round, only the round
+		// already opened times out, instead of 4 rounds in a row
+		start := time.Now()
+		clientsData := [][][]byte{clientMessages(0), clientMessages(1), nil}
+		output := simulateWithChurn(t, 2, clientsData, churn{leaving: []int{2}, leaveRound: 20, leaveKnown: true}, params, nil)
+		if elapsed := time.Since(start); elapsed > 6*time.Second {
+			t.Error("Client 2 should be dropped at the next round, the simulation took", elapsed)
+		}
+		checkMessagesAfterLeave(t, output)
+	})
+}
+
+func TestSimulationAdaptiveWindow(t *testing.T) {
+	forEachSlotsMode(t, func(t *testing.T, params *net.ALL_ALL_PARAMETERS) {
+		params.Add("WindowSize", 4)
+		params.Add("RelayAdaptiveWindow", true)
+		params.Add("ExperimentRoundLimit", 100)
+
+		// the window adapts, no message is lost or reordered
+		clientsData := [][][]byte{clientMessages(0), clientMessages(1), clientMessages(2)}
+		output := simulate(t, 2, clientsData, params, nil)
+		checkMessagesInOrder(t, output, 3)
+	})
+
+	// the rounds are bound by the latency of the clients, the window grows up to 4 rounds. The rounds opened when the
+	// relay stops tell the window : with the round limit closing, rounds limit to limit+window-1 are open
+	params := simulationParams(false)
+	params.Add("WindowSize", 4)
+	params.Add("RelayAdaptiveWindow", true)
+	params.Add("ExperimentRoundLimit", 100)
+	lastRoundOpened := int32(0)
+	stopped := make(chan int32, 1)
+	observer := func(clientID int, msg interface{}) {
+		if data, ok := msg.(net.REL_CLI_DOWNSTREAM_DATA); ok && clientID == 0 && data.RoundID > lastRoundOpened {
+			lastRoundOpened = data.RoundID
+		}
+		if _, ok := msg.(net.ALL_ALL_SHUTDOWN); ok && clientID == 0 {
+			stopped <- lastRoundOpened
+		}
+	}
+	clientsData := [][][]byte{clientMessages(0), clientMessages(1), clientMessages(2)}
+	simulateWithChurn(t, 2, clientsData, churn{latency: 20 * time.Millisecond}, params, observer)
+	select {
+	case last := <-stopped:
+		if window := last - 100 + 1; window != 4 {
+			t.Error("The window should grow to 4 rounds when the rounds are bound by the latency, got", window)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("The relay did not stop")
+	}
+}
+
+func TestSimulationUDPRetransmissions(t *testing.T) {
+	forEachSlotsMode(t, func(t *testing.T, params *net.ALL_ALL_PARAMETERS) {
+		params.Add("UseUDP", true)
+		params.Add("WindowSize", 3)
+		params.Add("ExperimentRoundLimit", 100)
+
+		// each client misses one broadcast out of 10, which it gets again over TCP
+		lost := func(clientID int, roundID int32) bool {
+			return (7*int(roundID)+clientID)%10 == 0
+		}
+		retransmissions := 0
+		observer := func(clientID int, msg interface{}) {
+			if _, ok := msg.(net.REL_CLI_DOWNSTREAM_DATA); ok {
+				retransmissions++
+			}
+		}
+		clientsData := [][][]byte{clientMessages(0), clientMessages(1), clientMessages(2)}
+		output := simulateWithChurn(t, 2, clientsData, churn{lost: lost}, params, observer)
+
+		// no round times out, no message is lost
+		checkMessagesInOrder(t, output, 3)
+		if retransmissions == 0 {
+			t.Error("The relay should send the missed broadcasts again")
+		}
+	})
+}
+
+func TestSimulationUDPRetransmissionsWindow1(t *testing.T) {
+	forEachSlotsMode(t, func(t *testing.T, params *net.ALL_ALL_PARAMETERS) {
+		params.Add("UseUDP", true)
+		params.Add("WindowSize", 1)
+		params.Add("ExperimentRoundLimit", 100)
+		params.Add("RelayRoundTimeOut", 400)
+		params.Add("RelayMaxNumberOfConsecutiveFailedRounds", 1)
+
+		// with one round open at once, no later broadcast tells a client that it missed one : the relay sends the
+		// round again over TCP to the clients which did not answer after a part of the round timeout
+		lost := func(clientID int, roundID int32) bool {
+			return (7*int(roundID)+clientID)%10 == 0
+		}
+		clientsData := [][][]byte{clientMessages(0), clientMessages(1), clientMessages(2)}
+		output := simulateWithChurn(t, 2, clientsData, churn{lost: lost}, params, nil)
+
+		// no round times out, no message is lost
+		checkMessagesInOrder(t, output, 3)
+	})
+}
+
+func TestSimulationUDPReshuffles(t *testing.T) {
+	forEachSlotsMode(t, func(t *testing.T, params *net.ALL_ALL_PARAMETERS) {
+		params.Add("UseUDP", true)
+		params.Add("WindowSize", 1)
+		params.Add("ExperimentRoundLimit", 120)
+		params.Add("ReshuffleRounds", 15)
+
+		reshuffles := 0
+		observer := func(clientID int, msg interface{}) {
+			if slots, ok := msg.(net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG); ok && clientID == 0 && slots.StartRoundID > 0 {
+				reshuffles++
+			}
+		}
+		// client 0 receives the downstream data of the first round of each re-shuffle before the slots
+		clientsData := [][][]byte{clientMessages(0), clientMessages(1), clientMessages(2)}
+		output := simulateWithChurn(t, 2, clientsData, churn{slotsLate: true}, params, observer)
+
+		if reshuffles < 2 {
+			t.Error("The slots should be re-shuffled several times, got", reshuffles)
+		}
+		// client 0 waits for the slots, and switches at the same round as the others
+		checkMessagesInOrder(t, output, 3)
+	})
+}
+
+func TestSimulationEquivocation(t *testing.T) {
+	params := simulationParams(false)
+	params.Add("EquivocationProtectionEnabled", true)
+	params.Add("WindowSize", 3)
+	params.Add("ExperimentRoundLimit", 100)
+
+	// the relay and the clients absorb the same downstream cells, each round decodes
+	clientsData := [][][]byte{clientMessages(0), clientMessages(1), clientMessages(2)}
+	output := simulate(t, 2, clientsData, params, nil)
+	checkMessagesInOrder(t, output, 3)
+
+	// the relay sends other downstream data to client 0 in round 30 : its history differs from the one of the relay,
+	// and no round decodes anymore
+	params = simulationParams(false)
+	params.Add("EquivocationProtectionEnabled", true)
+	params.Add("WindowSize", 3)
+	params.Add("ExperimentRoundLimit", 100)
+	equivocate := func(clientID int, roundID int32) bool {
+		return clientID == 0 && roundID == 30
+	}
+	clientsData = [][][]byte{clientMessages(0), clientMessages(1), clientMessages(2)}
+	output = simulateWithChurn(t, 2, clientsData, churn{equivocate: equivocate}, params, nil)
+	if len(output) != 30 {
+		t.Error("Only the 30 rounds before the equivocation should be decoded, got", len(output))
+	}
+}
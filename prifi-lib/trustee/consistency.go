@@ -0,0 +1,123 @@
+package trustee
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/dcnet"
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3/log"
+)
+
+/*
+Received_REL_TRU_DOWNSTREAM_DIGESTS handles REL_TRU_DOWNSTREAM_DIGESTS messages, which contain the digests of the
+downstream cell of a round sent by the clients (see dcnet/consistency.go). The relay forwards the digests of every
+round, in order, and each client in the DC-net must have sent one : a digest which is missing, or has a wrong tag (the
+relay modified it), is evidence against the relay, as are digests which are not all equal. The trustee signs the
+evidence, and sends it to the relay and to the clients, since the relay might be the one that equivocated.
+*/
+func (p *PriFiLibTrusteeInstance) Received_REL_TRU_DOWNSTREAM_DIGESTS(msg net.REL_TRU_DOWNSTREAM_DIGESTS) error {
+	if !p.trusteeState.DownstreamConsistencyCheck {
+		return nil
+	}
+	if len(msg.Digests) != len(msg.ClientIDs) || len(msg.Tags) != len(msg.ClientIDs) {
+		e := "Trustee " + strconv.Itoa(p.trusteeState.ID) + " : malformed REL_TRU_DOWNSTREAM_DIGESTS for round " + strconv.Itoa(int(msg.RoundID))
+		log.Error(e)
+		return errors.New(e)
+	}
+
+	// the rounds the relay skipped miss all their digests
+	for roundID := p.trusteeState.nextDigestsRound; roundID < msg.RoundID; roundID++ {
+		if err := p.checkDownstreamDigests(net.REL_TRU_DOWNSTREAM_DIGESTS{RoundID: roundID}); err != nil {
+			return err
+		}
+	}
+	if msg.RoundID >= p.trusteeState.nextDigestsRound {
+		p.trusteeState.nextDigestsRound = msg.RoundID + 1
+	}
+
+	return p.checkDownstreamDigests(msg)
+}
+
+// sendsDownstreamDigest returns true if the client "clientID" is in the DC-net in the round "roundID", and must have
+// sent the digest of its downstream cell
+func (p *PriFiLibTrusteeInstance) sendsDownstreamDigest(clientID int, roundID int32) bool {
+	if clientID < 0 || clientID >= len(p.trusteeState.clientsFirstRound) || roundID < p.trusteeState.clientsFirstRound[clientID] {
+		return false
+	}
+	departure, departed := p.trusteeState.departedClients[clientID]
+	return !departed || roundID < departure
+}
+
+// checkDownstreamDigests checks that every client in the DC-net sent a digest with a valid tag in the round of "msg",
+// and that those digests are equal. Otherwise, the evidence is reported; a missing digest is reported empty
+func (p *PriFiLibTrusteeInstance) checkDownstreamDigests(msg net.REL_TRU_DOWNSTREAM_DIGESTS) error {
+	received := make(map[int][]byte)
+	for i, clientID := range msg.ClientIDs {
+		if !p.sendsDownstreamDigest(clientID, msg.RoundID) {
+			log.Lvl3("Trustee "+strconv.Itoa(p.trusteeState.ID)+" : ignoring the downstream digest of client", clientID,
+				", not in the DC-net in round", msg.RoundID)
+			continue
+		}
+		if !dcnet.CheckDownstreamDigestTag(p.trusteeState.downstreamDigestKeys[clientID], msg.RoundID, msg.Digests[i].Bytes, msg.Tags[i].Bytes) {
+			log.Error("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : wrong tag on the downstream digest of client " +
+				strconv.Itoa(clientID) + " in round " + strconv.Itoa(int(msg.RoundID)) + ", the relay modified it")
+			continue
+		}
+		received[clientID] = msg.Digests[i].Bytes
+	}
+
+	clientIDs := make([]int, 0, len(p.trusteeState.clientsFirstRound))
+	digests := make([]net.ByteArray, 0, len(p.trusteeState.clientsFirstRound))
+	equivocation := false
+	var reference []byte
+	for clientID := range p.trusteeState.clientsFirstRound {
+		if !p.sendsDownstreamDigest(clientID, msg.RoundID) {
+			continue
+		}
+		digest, found := received[clientID]
+		if !found {
+			log.Error("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : no valid downstream digest of client " +
+				strconv.Itoa(clientID) + " in round " + strconv.Itoa(int(msg.RoundID)))
+			equivocation = true
+			digest = make([]byte, 0)
+		} else if reference == nil {
+			reference = digest
+		} else if !bytes.Equal(digest, reference) {
+			equivocation = true
+		}
+		clientIDs = append(clientIDs, clientID)
+		digests = append(digests, net.ByteArray{Bytes: digest})
+	}
+	if !equivocation {
+		return nil
+	}
+
+	return p.reportDownstreamEquivocation(msg.RoundID, clientIDs, digests)
+}
+
+// reportDownstreamEquivocation signs the digests received by the clients "clientIDs" in the round "roundID", and sends
+// them to the relay and to those clients
+func (p *PriFiLibTrusteeInstance) reportDownstreamEquivocation(roundID int32, clientIDs []int, digests []net.ByteArray) error {
+	toSend := &net.TRU_REL_DOWNSTREAM_EQUIVOCATION{
+		TrusteeID: p.trusteeState.ID,
+		RoundID:   roundID,
+		ClientIDs: clientIDs,
+		Digests:   digests}
+	signature, err := schnorr.Sign(p.trusteeState.suite, p.trusteeState.privateKey, toSend.SignedData())
+	if err != nil {
+		return errors.New("Could not sign the evidence of equivocation, error is " + err.Error())
+	}
+	toSend.Signature = signature
+
+	log.Error("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : the clients did not receive the same downstream cell in round " +
+		strconv.Itoa(int(roundID)) + ", sending the evidence to the relay and to the clients")
+	p.messageSender.SendToRelayWithLog(toSend, "(round "+strconv.Itoa(int(roundID))+")")
+	for _, clientID := range clientIDs {
+		p.messageSender.SendToClientWithLog(clientID, toSend, "(client "+strconv.Itoa(clientID)+", round "+strconv.Itoa(int(roundID))+")")
+	}
+
+	return nil
+}
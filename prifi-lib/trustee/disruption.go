@@ -1,8 +1,8 @@
 package trustee
 
 import (
-	"fmt"
-	"github.com/dedis/prifi/prifi-lib/config"
+	"errors"
+	"github.com/dedis/prifi/prifi-lib/crypto"
 	"github.com/dedis/prifi/prifi-lib/net"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/proof"
@@ -22,7 +22,7 @@ func (p *PriFiLibTrusteeInstance) Received_REL_ALL_DISRUPTION_REVEAL(msg net.REL
 	var pred_array []proof.Predicate
 	sval := make(map[string]kyber.Scalar)
 	pval := make(map[string]kyber.Point)
-	suite := config.CryptoSuite
+	suite := p.trusteeState.suite
 	B := suite.Point().Base()
 	pval["B"] = B
 	for i, prg := range PRGs {
@@ -55,56 +55,31 @@ func (p *PriFiLibTrusteeInstance) Received_REL_ALL_DISRUPTION_REVEAL(msg net.REL
 
 /*
 * Received_REL_ALL_REVEAL_SHARED_SECRETS handles REL_ALL_REVEAL_SHARED_SECRETS messages.
-* The method gets the shared secret and sends it to the relay.
+* The method recomputes the secret shared with the client, from which the pads of every epoch are derived, and sends
+* it to the relay with a proof that it is correct, bound to the disrupted round.
  */
 func (p *PriFiLibTrusteeInstance) Received_REL_ALL_REVEAL_SHARED_SECRETS(msg net.REL_ALL_REVEAL_SHARED_SECRETS) error {
-	log.Lvl1("Disruption Phase 2: Received a reveal secret message for client", msg.EntityID)
+	log.Lvl1("Disruption Phase 2: Received a reveal secret message for client", msg.EntityID, "round", msg.RoundID)
 	// TODO: check that the relay asks for the correct entity, and not a honest entity. There should be a signature check on the TRU_REL_DISRUPTION_REVEAL the relay received (and forwarded to the client)
-	secret := p.trusteeState.sharedSecrets[msg.EntityID]
-	// as a pseudorandom base point multiplied by our private key.
-	suite := config.CryptoSuite
-	X := make([]kyber.Point, 1)
-	X[0] = p.trusteeState.PublicKey
-	B := suite.Point().Base() //BACK
-	// Generate the proof predicate: an OR branch for each public key.
-	sec := map[string]kyber.Scalar{"x": p.trusteeState.privateKey} //BACK
-	pub := map[string]kyber.Point{"B": B, "BT": p.trusteeState.ClientPublicKeys[msg.EntityID], "T": p.trusteeState.sharedSecrets[msg.EntityID]}
-	preds := make([]proof.Predicate, len(X))
-	for i := range X {
-		name := fmt.Sprintf("X[%d]", i) // "X[0]","X[1]",...
-		pub[name] = X[i]                // public point value
-
-		// Predicate indicates knowledge of the private key for X[i]
-		// and correspondence of the key with the linkage tag
-		preds[i] = proof.And(proof.Rep(name, "x", "B"), proof.Rep("T", "x", "BT"))
+	if msg.EntityID < 0 || msg.EntityID >= len(p.trusteeState.ClientPublicKeys) {
+		return errors.New("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : cannot reveal the secret shared with unknown client " +
+			strconv.Itoa(msg.EntityID))
 	}
-	pred := proof.Or(preds...) // make a big Or predicate
-
-	// The prover needs to know which Or branch (mine) is actually true.
-	choice := make(map[proof.Predicate]int)
-	choice[pred] = 0
-
-	// Generate the signature
-	M := "SHAREDKEY"
-	prover := pred.Prover(suite, sec, pub, choice)
-	NIZK, _ := proof.HashProve(suite, M, prover)
 
-	// Verify the signature
-	verifier := pred.Verifier(suite, pub)
-	err := proof.HashVerify(suite, M, verifier, NIZK)
+	secret, NIZK, err := crypto.ProveSharedSecret(p.trusteeState.suite, p.trusteeState.privateKey,
+		p.trusteeState.ClientPublicKeys[msg.EntityID], msg.RoundID, msg.EntityID, p.trusteeState.ID)
 	if err != nil {
-		log.Lvl1("signature failed to verify: ", err)
+		return errors.New("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : cannot prove the shared secret, " + err.Error())
 	}
-	log.Lvl1("Linkable Ring Signature verified.")
 
 	toSend := &net.TRU_REL_SHARED_SECRET{
 		TrusteeID: p.trusteeState.ID,
 		ClientID:  msg.EntityID,
+		RoundID:   msg.RoundID,
 		Secret:    secret,
 		NIZK:      NIZK,
-		Pub:       pub,
 	}
-	p.messageSender.SendToRelayWithLog(toSend, "Sent secret to relay")
-	log.Lvl1("Reveling secret with client", msg.EntityID)
+	p.messageSender.SendToRelayWithLog(toSend, "Sent shared secret to relay")
+	log.Lvl1("Reveling the secret shared with client", msg.EntityID)
 	return nil
 }
@@ -2,12 +2,14 @@ package trustee
 
 import (
 	"errors"
+	"github.com/dedis/prifi/prifi-lib/config"
 	"github.com/dedis/prifi/prifi-lib/crypto"
 	"github.com/dedis/prifi/prifi-lib/dcnet"
 	"github.com/dedis/prifi/prifi-lib/net"
 	"github.com/dedis/prifi/prifi-lib/scheduler"
 	"github.com/dedis/prifi/prifi-lib/utils"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/onet/v3/log"
 	"reflect"
 	"strings"
@@ -34,9 +36,15 @@ func NewTrustee(neverSlowDown bool, alwaysSlowDown bool, baseSleepTime int, msgS
 
 	//init the static stuff
 	trusteeState.sendingRate = make(chan int16, 10)
-	trusteeState.PublicKey, trusteeState.privateKey = crypto.NewKeyPair()
+	trusteeState.epochs = make(chan epochAnnounced, 10)
+	trusteeState.sendingStopped = make(chan bool)
+	trusteeState.slotLengths = make(chan net.REL_TRU_TELL_SLOT_LENGTH, 100)
+	trusteeState.departedClients = make(map[int]int32)
+	trusteeState.CryptoSuite = config.DefaultCryptoSuiteName
+	trusteeState.suite = config.CryptoSuite
+	trusteeState.PublicKey, trusteeState.privateKey = crypto.NewKeyPair(trusteeState.suite)
 	neffShuffle := new(scheduler.NeffShuffle)
-	neffShuffle.Init()
+	neffShuffle.Init(trusteeState.suite)
 	trusteeState.neffShuffle = neffShuffle.TrusteeView
 	trusteeState.NeverSlowDown = neverSlowDown
 	trusteeState.AlwaysSlowDown = alwaysSlowDown
@@ -84,12 +92,23 @@ type TrusteeState struct {
 	privateKey                    kyber.Scalar
 	PublicKey                     kyber.Point
 	sendingRate                   chan int16
-	sharedSecrets                 []kyber.Point
+	sendingStopped                chan bool                         // closed when the sending goroutine stops
+	epochs                        chan epochAnnounced               // the epochs announced, handled by the sending goroutine
+	slotLengths                   chan net.REL_TRU_TELL_SLOT_LENGTH // the lengths of the rounds announced, handled by the sending goroutine
 	TrusteeID                     int
 	BaseSleepTime                 int
 	AlwaysSlowDown                bool //enforce the sleep in the sending function even if rate is FULL
 	NeverSlowDown                 bool //ignore the sleep in the sending function if rate is STOPPED
 	EquivocationProtectionEnabled bool
+	VerifiableDCNetEnabled        bool
+	PadCipher                     string
+	CryptoSuite                   string // see config.CRYPTO_SUITE_*
+	suite                         suites.Suite
+	DownstreamConsistencyCheck    bool
+	downstreamDigestKeys          [][]byte      // the keys of the tags of the downstream digests, one per client
+	clientsFirstRound             []int32       // the first round in which each client sends a downstream digest
+	departedClients               map[int]int32 // the clients which left the DC-net, and the first round without them
+	nextDigestsRound              int32         // the round of the next REL_TRU_DOWNSTREAM_DIGESTS expected
 }
 
 // NeffShuffleResult holds the result of the NeffShuffle,
@@ -114,17 +133,34 @@ func (p *PriFiLibTrusteeInstance) ReceivedMessage(msg interface{}) error {
 	case net.ALL_ALL_SHUTDOWN:
 		err = p.Received_ALL_ALL_SHUTDOWN(typedMsg)
 	case net.REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE:
-		if p.stateMachine.AssertState("INITIALIZING") {
+		if p.stateMachine.State() == "READY" {
+			// a re-shuffle, while we keep sending ciphers
+			err = p.reshuffleEphPks(typedMsg)
+		} else if p.stateMachine.AssertState("INITIALIZING") {
 			err = p.Received_REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE(typedMsg)
 		}
 	case net.REL_TRU_TELL_TRANSCRIPT:
-		if p.stateMachine.AssertState("SHUFFLE_DONE") {
+		if p.stateMachine.State() == "READY" {
+			err = p.signReshuffle(typedMsg)
+		} else if p.stateMachine.AssertState("SHUFFLE_DONE") {
 			err = p.Received_REL_TRU_TELL_TRANSCRIPT(typedMsg)
 		}
 	case net.REL_TRU_TELL_RATE_CHANGE:
 		if p.stateMachine.AssertState("READY") {
 			err = p.Received_REL_TRU_TELL_RATE_CHANGE(typedMsg)
 		}
+	case net.REL_TRU_TELL_EPOCH:
+		if p.stateMachine.AssertState("READY") {
+			err = p.Received_REL_TRU_TELL_EPOCH(typedMsg)
+		}
+	case net.REL_TRU_TELL_SLOT_LENGTH:
+		if p.stateMachine.AssertState("READY") {
+			err = p.Received_REL_TRU_TELL_SLOT_LENGTH(typedMsg)
+		}
+	case net.REL_TRU_DOWNSTREAM_DIGESTS:
+		if p.stateMachine.AssertState("READY") {
+			err = p.Received_REL_TRU_DOWNSTREAM_DIGESTS(typedMsg)
+		}
 	case net.REL_ALL_DISRUPTION_REVEAL:
 		if p.stateMachine.AssertState("READY") {
 			err = p.Received_REL_ALL_DISRUPTION_REVEAL(typedMsg)
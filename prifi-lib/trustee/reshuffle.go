@@ -0,0 +1,42 @@
+package trustee
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/net"
+)
+
+/*
+Re-shuffles (see relay/reshuffle.go). While we keep sending ciphers, the relay may ask us to shuffle fresh ephemeral
+keys of the clients, then to sign the transcript, as at setup. The DC-net secrets do not depend on the shuffle, hence
+our DC-net state is left untouched. A re-shuffle may include the keys of clients joining the DC-net, announced with the
+epoch where they join, after the re-shuffle.
+*/
+
+// reshuffleEphPks handles the REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE messages received while sending ciphers :
+// we shuffle the keys and send the result to the relay
+func (p *PriFiLibTrusteeInstance) reshuffleEphPks(msg net.REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE) error {
+	if len(msg.EphPks) < p.trusteeState.nClients {
+		return errors.New("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : cannot re-shuffle " + strconv.Itoa(len(msg.EphPks)) +
+			" keys for " + strconv.Itoa(p.trusteeState.nClients) + " clients")
+	}
+
+	toSend, err := p.trusteeState.neffShuffle.ReceivedShuffleFromRelay(msg.Base, msg.EphPks, true, make([]byte, 1))
+	if err != nil {
+		return errors.New("Could not do ReceivedShuffleFromRelay for a re-shuffle, error is " + err.Error())
+	}
+	p.messageSender.SendToRelayWithLog(toSend, "(re-shuffle)")
+	return nil
+}
+
+// signReshuffle handles the REL_TRU_TELL_TRANSCRIPT messages received while sending ciphers : we check that our
+// re-shuffle is in the transcript, and sign its result
+func (p *PriFiLibTrusteeInstance) signReshuffle(msg net.REL_TRU_TELL_TRANSCRIPT) error {
+	toSend, err := p.trusteeState.neffShuffle.ReceivedTranscriptFromRelay(msg.Bases, msg.GetKeys(), msg.GetProofs())
+	if err != nil {
+		return errors.New("Could not do ReceivedTranscriptFromRelay for a re-shuffle, error is " + err.Error())
+	}
+	p.messageSender.SendToRelayWithLog(toSend, "(re-shuffle)")
+	return nil
+}
@@ -10,15 +10,23 @@ Then, this file simple handle the answer to the different message kind :
 - ALL_ALL_PARAMETERS - (specialized into ALL_TRU_PARAMETERS) - used to initialize the relay over the network / overwrite its configuration
 - REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE - the client's identities (and ephemeral ones), and a base. We react by Neff-Shuffling and sending the result
 - REL_TRU_TELL_TRANSCRIPT - the Neff-Shuffle's results. We perform some checks, sign the last one, send it to the relay, and follow by continuously sending ciphers.
+- REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE, REL_TRU_TELL_TRANSCRIPT - once sending ciphers, the steps of a re-shuffle of the slots, see reshuffle.go
 - REL_TRU_TELL_RATE_CHANGE - Received when the relay requests a sending rate change, the message contains the necessary information needed to perform this change
+- REL_TRU_TELL_EPOCH - Received when the relay announces a new DC-net epoch. The ciphers of the rounds of this epoch are (re)computed with the new pads
+- REL_TRU_TELL_SLOT_LENGTH - Received when the relay announces the length of a round, as asked by the owner of the slot. The cipher of this round is (re)computed with this length
+- REL_TRU_DOWNSTREAM_DIGESTS - the digests of a downstream cell, as received by the clients. We check that they are equal, see consistency.go
 */
 
 import (
 	"errors"
+	"fmt"
 	"github.com/dedis/prifi/prifi-lib/config"
+	"github.com/dedis/prifi/prifi-lib/crypto"
 	"github.com/dedis/prifi/prifi-lib/dcnet"
 	"github.com/dedis/prifi/prifi-lib/net"
+	"github.com/dedis/prifi/prifi-lib/scheduler"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/onet/v3/log"
 	"strconv"
 	"time"
@@ -54,7 +62,10 @@ func (p *PriFiLibTrusteeInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PA
 	nClients := msg.IntValueOrElse("NClients", p.trusteeState.nClients)
 	payloadSize := msg.IntValueOrElse("PayloadSize", p.trusteeState.PayloadSize)
 	dcNetType := msg.StringValueOrElse("DCNetType", "not initilaized")
+	padCipher := msg.StringValueOrElse("DCNetPadCipher", dcnet.PAD_CIPHER_XOF)
+	cryptoSuite := msg.StringValueOrElse("CryptoSuite", config.DefaultCryptoSuiteName)
 	equivProtection := msg.BoolValueOrElse("EquivocationProtectionEnabled", false)
+	downstreamConsistencyCheck := msg.BoolValueOrElse("DownstreamConsistencyCheck", false)
 
 	//sanity checks
 	if trusteeID < -1 {
@@ -69,10 +80,12 @@ func (p *PriFiLibTrusteeInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PA
 	if payloadSize < 1 {
 		return errors.New("payloadSize cannot be 0")
 	}
-
-	switch dcNetType {
-	case "Verifiable":
-		panic("not supported yet")
+	if err := dcnet.ValidatePadCipher(padCipher); err != nil {
+		return err
+	}
+	suite, err := config.FindCryptoSuite(cryptoSuite)
+	if err != nil {
+		return err
 	}
 
 	p.trusteeState.ID = trusteeID
@@ -82,11 +95,23 @@ func (p *PriFiLibTrusteeInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PA
 	p.trusteeState.PayloadSize = payloadSize
 	p.trusteeState.TrusteeID = trusteeID
 	p.trusteeState.EquivocationProtectionEnabled = equivProtection
+	p.trusteeState.DownstreamConsistencyCheck = downstreamConsistencyCheck
+	p.trusteeState.VerifiableDCNetEnabled = dcNetType == "Verifiable"
+	p.trusteeState.PadCipher = padCipher
+	p.setCryptoSuite(cryptoSuite, suite)
 	p.trusteeState.neffShuffle.Init(trusteeID, p.trusteeState.privateKey, p.trusteeState.PublicKey)
 
 	//placeholders for pubkeys and secrets
 	p.trusteeState.ClientPublicKeys = make([]kyber.Point, nClients)
-	p.trusteeState.sharedSecrets = make([]kyber.Point, nClients)
+	p.trusteeState.downstreamDigestKeys = make([][]byte, nClients)
+
+	//the clients send a downstream digest from round 1, round 0 has no downstream cell
+	p.trusteeState.clientsFirstRound = make([]int32, nClients)
+	for i := range p.trusteeState.clientsFirstRound {
+		p.trusteeState.clientsFirstRound[i] = 1
+	}
+	p.trusteeState.departedClients = make(map[int]int32)
+	p.trusteeState.nextDigestsRound = 0
 
 	if startNow {
 		// send our public key to the relay
@@ -100,6 +125,23 @@ func (p *PriFiLibTrusteeInstance) Received_ALL_ALL_PARAMETERS(msg net.ALL_ALL_PA
 	return nil
 }
 
+/*
+setCryptoSuite switches the trustee to the crypto suite "suite" named "name", imposed by the relay.
+The keys of the trustee live in the suite, hence are regenerated if it changes.
+*/
+func (p *PriFiLibTrusteeInstance) setCryptoSuite(name string, suite suites.Suite) {
+	if name == p.trusteeState.CryptoSuite {
+		return
+	}
+	log.Lvl2("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : switching from crypto suite " + p.trusteeState.CryptoSuite + " to " + name)
+	p.trusteeState.CryptoSuite = name
+	p.trusteeState.suite = suite
+	p.trusteeState.PublicKey, p.trusteeState.privateKey = crypto.NewKeyPair(suite)
+	neffShuffle := new(scheduler.NeffShuffle)
+	neffShuffle.Init(suite)
+	p.trusteeState.neffShuffle = neffShuffle.TrusteeView
+}
+
 /*
 Send_TRU_REL_PK tells the relay's public key to the relay
 (this, of course, provides no security, but this is an early version of the protocol).
@@ -113,7 +155,8 @@ func (p *PriFiLibTrusteeInstance) Send_TRU_REL_PK() error {
 
 /*
 Send_TRU_REL_DC_CIPHER sends DC-net ciphers to the relay continuously once started.
-One can control the rate by sending flags to "rateChan".
+One can control the rate by sending flags to "rateChan". The DC-net epochs and the lengths of the rounds announced by the
+relay are handled here too, since they change the DC-net state.
 */
 func (p *PriFiLibTrusteeInstance) Send_TRU_REL_DC_CIPHER(rateChan chan int16) {
 
@@ -138,6 +181,35 @@ func (p *PriFiLibTrusteeInstance) Send_TRU_REL_DC_CIPHER(rateChan chan int16) {
 				stop = true
 			}
 
+		case epoch := <-p.trusteeState.epochs:
+			var err error
+			if len(epoch.newSharedSecrets) > 0 {
+				err = p.trusteeState.DCNet.ScheduleEpochWithNewPeers(epoch.EpochID, epoch.StartRoundID, epoch.newSharedSecrets)
+			} else if len(epoch.DepartedClients) > 0 {
+				err = p.trusteeState.DCNet.ScheduleEpochWithoutPeers(epoch.EpochID, epoch.StartRoundID, epoch.DepartedClients)
+			} else {
+				err = p.trusteeState.DCNet.ScheduleEpoch(epoch.EpochID, epoch.StartRoundID)
+			}
+			if err != nil {
+				log.Error("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : " + err.Error())
+			} else if roundID > epoch.StartRoundID {
+				// those rounds were sent with the pads of the previous epoch, the relay drops them
+				log.Lvl2("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : epoch " + strconv.Itoa(int(epoch.EpochID)) +
+					" starts at round " + strconv.Itoa(int(epoch.StartRoundID)) + ", re-sending from there")
+				roundID = epoch.StartRoundID
+			}
+
+		case slotLength := <-p.trusteeState.slotLengths:
+			p.trusteeState.DCNet.SetPayloadSizeOfRound(slotLength.RoundID, slotLength.Length)
+			if slotLength.RoundID < roundID {
+				// this round was sent with the default length, the relay drops it
+				log.Lvl3("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : round " + strconv.Itoa(int(slotLength.RoundID)) +
+					" has " + strconv.Itoa(slotLength.Length) + " bytes, re-sending it")
+				if _, err := sendData(p, slotLength.RoundID); err != nil {
+					stop = true
+				}
+			}
+
 		default:
 			if currentRate == TRUSTEE_RATE_ACTIVE {
 				if p.trusteeState.AlwaysSlowDown {
@@ -188,16 +260,95 @@ func (p *PriFiLibTrusteeInstance) Received_REL_TRU_TELL_RATE_CHANGE(msg net.REL_
 	return nil
 }
 
+// epochAnnounced is an epoch announced by the relay, with the secrets shared with the clients joining at this epoch
+type epochAnnounced struct {
+	net.REL_TRU_TELL_EPOCH
+	newSharedSecrets []kyber.Point
+}
+
+/*
+Received_REL_TRU_TELL_EPOCH handles REL_TRU_TELL_EPOCH messages, sent when the relay announces a new DC-net epoch.
+If some clients join the DC-net at this epoch, we derive the secrets shared with them; if some clients leave, we stop
+XORing in their pads from this epoch on. The epoch is handed to the sending goroutine, unless it stopped.
+*/
+func (p *PriFiLibTrusteeInstance) Received_REL_TRU_TELL_EPOCH(msg net.REL_TRU_TELL_EPOCH) error {
+	epoch := epochAnnounced{REL_TRU_TELL_EPOCH: msg}
+	for _, pk := range msg.NewClientsPks {
+		if pk == nil {
+			return errors.New("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : a client joining at epoch " +
+				strconv.Itoa(int(msg.EpochID)) + " has no public key")
+		}
+		sharedSecret := p.trusteeState.suite.Point().Mul(p.trusteeState.privateKey, pk)
+		var digestKey []byte
+		if p.trusteeState.DownstreamConsistencyCheck {
+			key, err := dcnet.DownstreamDigestKey(sharedSecret)
+			if err != nil {
+				return errors.New("Could not derive the key of the downstream digests, error is " + err.Error())
+			}
+			digestKey = key
+		}
+		p.trusteeState.ClientPublicKeys = append(p.trusteeState.ClientPublicKeys, pk)
+		p.trusteeState.downstreamDigestKeys = append(p.trusteeState.downstreamDigestKeys, digestKey)
+		p.trusteeState.clientsFirstRound = append(p.trusteeState.clientsFirstRound, msg.StartRoundID)
+		epoch.newSharedSecrets = append(epoch.newSharedSecrets, sharedSecret)
+	}
+	if len(msg.NewClientsPks) > 0 {
+		p.trusteeState.nClients += len(msg.NewClientsPks)
+		log.Lvl2("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : " + strconv.Itoa(len(msg.NewClientsPks)) +
+			" clients join at epoch " + strconv.Itoa(int(msg.EpochID)) + ", we now have " + strconv.Itoa(p.trusteeState.nClients))
+	}
+
+	for _, clientID := range msg.DepartedClients {
+		p.trusteeState.departedClients[clientID] = msg.StartRoundID
+	}
+	if len(msg.DepartedClients) > 0 {
+		log.Lvl2("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : clients " + fmt.Sprint(msg.DepartedClients) +
+			" leave at epoch " + strconv.Itoa(int(msg.EpochID)) + ", we stop sharing pads with them")
+	}
+
+	// the sending goroutine might be stopped, with nobody left to empty the channel
+	select {
+	case p.trusteeState.epochs <- epoch:
+	case <-p.trusteeState.sendingStopped:
+		return errors.New("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : stopped sending, ignoring epoch " +
+			strconv.Itoa(int(msg.EpochID)))
+	}
+	return nil
+}
+
+/*
+Received_REL_TRU_TELL_SLOT_LENGTH handles REL_TRU_TELL_SLOT_LENGTH messages, sent when the relay opens a round whose
+length is not PayloadSize. The length is handed to the sending goroutine.
+*/
+func (p *PriFiLibTrusteeInstance) Received_REL_TRU_TELL_SLOT_LENGTH(msg net.REL_TRU_TELL_SLOT_LENGTH) error {
+	// like the epochs, nobody empties the channel once the sending goroutine is stopped
+	select {
+	case p.trusteeState.slotLengths <- msg:
+	case <-p.trusteeState.sendingStopped:
+		return errors.New("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : stopped sending, ignoring the length of round " +
+			strconv.Itoa(int(msg.RoundID)))
+	}
+	return nil
+}
+
 /*
 sendData is an auxiliary function used by Send_TRU_REL_DC_CIPHER. It computes the DC-net's cipher and sends it.
 It returns the new round number (previous + 1).
 */
 func sendData(p *PriFiLibTrusteeInstance, roundID int32) (int32, error) {
 	data := p.trusteeState.DCNet.TrusteeEncodeForRound(roundID)
+	if data == nil {
+		// the round is in an epoch already erased, skip it
+		log.Error("Trustee " + strconv.Itoa(p.trusteeState.ID) + " : cannot encode round " + strconv.Itoa(int(roundID)) + ", its epoch is erased")
+		return roundID + 1, nil
+	}
+	epochID, _ := p.trusteeState.DCNet.Epoch()
+
 	//send the data
 	toSend := &net.TRU_REL_DC_CIPHER{
 		RoundID:   roundID,
 		TrusteeID: p.trusteeState.ID,
+		EpochID:   epochID,
 		Data:      data}
 	if !p.messageSender.SendToRelayWithLog(toSend, "(round "+strconv.Itoa(int(roundID))+")") {
 		return -1, errors.New("Could not send")
@@ -237,18 +388,38 @@ func (p *PriFiLibTrusteeInstance) Received_REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_
 		return errors.New(e)
 	}
 
-	//fill in the clients keys
+	//fill in the clients keys; the shared secrets only key the DC-net and the downstream digests, they are not kept
+	sharedSecrets := make([]kyber.Point, len(p.trusteeState.ClientPublicKeys))
 	for i := 0; i < len(clientsPks); i++ {
 		p.trusteeState.ClientPublicKeys[i] = clientsPks[i]
-		p.trusteeState.sharedSecrets[i] = config.CryptoSuite.Point().Mul(p.trusteeState.privateKey, clientsPks[i])
+		sharedSecrets[i] = p.trusteeState.suite.Point().Mul(p.trusteeState.privateKey, clientsPks[i])
+		if p.trusteeState.DownstreamConsistencyCheck {
+			key, err := dcnet.DownstreamDigestKey(sharedSecrets[i])
+			if err != nil {
+				return errors.New("Could not derive the key of the downstream digests, error is " + err.Error())
+			}
+			p.trusteeState.downstreamDigestKeys[i] = key
+		}
 	}
 
-	p.trusteeState.DCNet = dcnet.NewDCNetEntity(p.trusteeState.ID, dcnet.DCNET_TRUSTEE,
-		p.trusteeState.PayloadSize, p.trusteeState.EquivocationProtectionEnabled, p.trusteeState.sharedSecrets)
-
 	//In case we use the simple dcnet, vkey isn't needed
 	vkey := make([]byte, 1)
 
+	if p.trusteeState.VerifiableDCNetEnabled {
+		p.trusteeState.DCNet = dcnet.NewVerifiableDCNetEntity(p.trusteeState.suite, p.trusteeState.ID, dcnet.DCNET_TRUSTEE,
+			p.trusteeState.PayloadSize, sharedSecrets)
+
+		//the relay needs it to verify the clients' proofs
+		var err error
+		vkey, err = p.trusteeState.DCNet.VerifiableDCNetKey()
+		if err != nil {
+			return errors.New("Could not compute the verifiable DC-net key, error is " + err.Error())
+		}
+	} else {
+		p.trusteeState.DCNet = dcnet.NewDCNetEntity(p.trusteeState.suite, p.trusteeState.ID, dcnet.DCNET_TRUSTEE,
+			p.trusteeState.PayloadSize, p.trusteeState.EquivocationProtectionEnabled, p.trusteeState.PadCipher, sharedSecrets)
+	}
+
 	toSend, err := p.trusteeState.neffShuffle.ReceivedShuffleFromRelay(msg.Base, msg.EphPks, true, vkey)
 	if err != nil {
 		return errors.New("Could not do ReceivedShuffleFromRelay, error is " + err.Error())
@@ -285,7 +456,12 @@ func (p *PriFiLibTrusteeInstance) Received_REL_TRU_TELL_TRANSCRIPT(msg net.REL_T
 	p.stateMachine.ChangeState("READY")
 
 	//everything is ready, we start sending
-	go p.Send_TRU_REL_DC_CIPHER(p.trusteeState.sendingRate)
+	stopped := make(chan bool)
+	p.trusteeState.sendingStopped = stopped
+	go func() {
+		p.Send_TRU_REL_DC_CIPHER(p.trusteeState.sendingRate)
+		close(stopped)
+	}()
 
 	return nil
 }
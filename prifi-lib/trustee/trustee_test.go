@@ -1,14 +1,17 @@
 package trustee
 
 import (
+	"bytes"
 	"errors"
 	"testing"
 
 	"github.com/dedis/prifi/prifi-lib/config"
 	"github.com/dedis/prifi/prifi-lib/crypto"
+	"github.com/dedis/prifi/prifi-lib/dcnet"
 	"github.com/dedis/prifi/prifi-lib/net"
 	"github.com/dedis/prifi/prifi-lib/scheduler"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
 	"go.dedis.ch/onet/v3/log"
 	"time"
 )
@@ -17,11 +20,16 @@ import (
  * Message Sender
  */
 type TestMessageSender struct {
-	sentToRelay chan interface{}
+	sentToRelay  chan interface{}
+	sentToClient chan interface{}
 }
 
 func (t *TestMessageSender) SendToClient(i int, msg interface{}) error {
-	return errors.New("Trustees should never sent to clients")
+	if t.sentToClient == nil {
+		return errors.New("Trustees should only send evidence to clients")
+	}
+	t.sentToClient <- msg
+	return nil
 }
 func (t *TestMessageSender) SendToTrustee(i int, msg interface{}) error {
 	return errors.New("Trustees should never sent to other trustees")
@@ -135,9 +143,6 @@ func TestTrustee(t *testing.T) {
 	if len(ts.ClientPublicKeys) != nClients {
 		t.Error("Len(TrusteePKs) should be equal to NTrustees")
 	}
-	if len(ts.sharedSecrets) != nClients {
-		t.Error("Len(SharedSecrets) should be equal to NTrustees")
-	}
 	if trustee.stateMachine.State() != "INITIALIZING" {
 		t.Error("Trustee should be in state INITIALIZING")
 	}
@@ -158,13 +163,13 @@ func TestTrustee(t *testing.T) {
 
 	//do the shuffle
 	n := new(scheduler.NeffShuffle)
-	n.Init()
+	n.Init(config.CryptoSuite)
 	n.RelayView.Init(1)
 
 	clientPubKeys := make([]kyber.Point, nClients)
 	clientPrivKeys := make([]kyber.Scalar, nClients)
 	for i := 0; i < nClients; i++ {
-		clientPubKeys[i], clientPrivKeys[i] = crypto.NewKeyPair()
+		clientPubKeys[i], clientPrivKeys[i] = crypto.NewKeyPair(config.CryptoSuite)
 		n.RelayView.AddClient(clientPubKeys[i])
 	}
 	toSend, _, err := n.RelayView.SendToNextTrustee()
@@ -189,7 +194,8 @@ func TestTrustee(t *testing.T) {
 			t.Error("Pub key", i, "has not been stored correctly")
 		}
 		myPrivKey := ts.privateKey
-		if !ts.sharedSecrets[i].Equal(config.CryptoSuite.Point().Mul(myPrivKey, clientPubKeys[i])) {
+		expectedSeed, _ := dcnet.PadSeed(config.CryptoSuite.Point().Mul(myPrivKey, clientPubKeys[i]))
+		if seed, err := ts.DCNet.RevealPadSeed(i, 0); err != nil || !bytes.Equal(seed, expectedSeed) {
 			t.Error("Shared secret", i, "has not been computed correctly")
 		}
 	}
@@ -254,7 +260,7 @@ func TestTrustee(t *testing.T) {
 		if msg8_parsed.RoundID != 0 {
 			t.Error("TRU_REL_DC_CIPHER has the wrong round ID")
 		}
-		if len(msg8_parsed.Data) != upCellSize+8 {
+		if len(msg8_parsed.Data) != upCellSize+dcnet.DCNET_CIPHER_HEADER_LENGTH {
 			t.Error("TRU_REL_DC_CIPHER sent a payload with wrong size")
 		}
 
@@ -302,7 +308,7 @@ func TestTrustee(t *testing.T) {
 		if msg8_parsed.TrusteeID != trusteeID {
 			t.Error("TRU_REL_DC_CIPHER has the wrong trustee ID")
 		}
-		if len(msg8_parsed.Data) != upCellSize+8 {
+		if len(msg8_parsed.Data) != upCellSize+dcnet.DCNET_CIPHER_HEADER_LENGTH {
 			t.Error("TRU_REL_DC_CIPHER sent a payload with wrong size")
 		}
 
@@ -323,5 +329,235 @@ func TestTrustee(t *testing.T) {
 		t.Error("Trustee should be in state SHUTDOWN")
 	}
 
+	// once the sending goroutine is stopped, the lengths of the slots are refused instead of blocking the handler
+	select {
+	case <-trustee.trusteeState.sendingStopped:
+	case <-time.After(time.Second):
+		t.Fatal("Trustee should stop sending after the shutdown")
+	}
+	refused := make(chan bool)
+	go func() {
+		for i := 0; i <= cap(trustee.trusteeState.slotLengths); i++ {
+			if err := trustee.Received_REL_TRU_TELL_SLOT_LENGTH(net.REL_TRU_TELL_SLOT_LENGTH{RoundID: int32(i), Length: 10}); err != nil {
+				refused <- true
+				return
+			}
+		}
+		refused <- false
+	}()
+	select {
+	case ok := <-refused:
+		if !ok {
+			t.Error("Trustee should refuse the lengths of the slots once stopped")
+		}
+	case <-time.After(time.Second):
+		t.Error("Trustee should not block on the lengths of the slots once stopped")
+	}
+
 	t.SkipNow() //we started a goroutine, let's kill everything, we're good
 }
+
+func TestTrusteeVerifiable(t *testing.T) {
+
+	msgSender := new(TestMessageSender)
+	msgSender.sentToRelay = make(chan interface{}, 15)
+	msw := newTestMessageSenderWrapper(msgSender)
+	trustee := NewTrustee(false, false, 1000, msw)
+	ts := trustee.trusteeState
+
+	msg := new(net.ALL_ALL_PARAMETERS)
+	msg.ForceParams = true
+	nClients := 3
+	msg.Add("StartNow", true)
+	msg.Add("NClients", nClients)
+	msg.Add("NTrustees", 1)
+	msg.Add("PayloadSize", 100)
+	msg.Add("NextFreeTrusteeID", 0)
+	msg.Add("DCNetType", "Verifiable")
+
+	if err := trustee.ReceivedMessage(*msg); err != nil {
+		t.Error("Trustee should be able to receive this message:", err)
+	}
+	if !ts.VerifiableDCNetEnabled {
+		t.Error("VerifiableDCNetEnabled should be true")
+	}
+	<-msgSender.sentToRelay // TRU_REL_TELL_PK
+
+	n := new(scheduler.NeffShuffle)
+	n.Init(config.CryptoSuite)
+	n.RelayView.Init(1)
+	clientPubKeys := make([]kyber.Point, nClients)
+	for i := 0; i < nClients; i++ {
+		clientPubKeys[i], _ = crypto.NewKeyPair(config.CryptoSuite)
+		n.RelayView.AddClient(clientPubKeys[i])
+	}
+	toSend, _, err := n.RelayView.SendToNextTrustee()
+	if err != nil {
+		t.Error(err)
+	}
+	msg2 := toSend.(*net.REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE)
+	msg2.Pks = clientPubKeys
+
+	if err := trustee.ReceivedMessage(*msg2); err != nil {
+		t.Error("Trustee should be able to receive this message:", err)
+	}
+	if !ts.DCNet.IsVerifiable() {
+		t.Error("Trustee should use the verifiable DC-net")
+	}
+
+	//the verifiable DC-net key should be usable by the relay
+	select {
+	case msg3 := <-msgSender.sentToRelay:
+		msg3Parsed := msg3.(*net.TRU_REL_TELL_NEW_BASE_AND_EPH_PKS)
+		relayDCNet := dcnet.NewVerifiableDCNetEntity(config.CryptoSuite, 0, dcnet.DCNET_RELAY, 100, nil)
+		if err := relayDCNet.SetVerifiableDCNetKeys([][]byte{msg3Parsed.VerifiableDCNetKey}, nClients); err != nil {
+			t.Error("Trustee sent an invalid verifiable DC-net key,", err)
+		}
+	default:
+		t.Error("Trustee should have sent a TRU_REL_TELL_NEW_BASE_AND_EPH_PKS to the relay")
+	}
+}
+
+func TestTrusteeDownstreamConsistency(t *testing.T) {
+	msgSender := new(TestMessageSender)
+	msgSender.sentToRelay = make(chan interface{}, 15)
+	msgSender.sentToClient = make(chan interface{}, 15)
+	trustee := NewTrustee(false, false, 1000, newTestMessageSenderWrapper(msgSender))
+	ts := trustee.trusteeState
+	ts.ID = 1
+	ts.DownstreamConsistencyCheck = true
+
+	nClients := 3
+	ts.downstreamDigestKeys = make([][]byte, nClients)
+	ts.clientsFirstRound = make([]int32, nClients)
+	for i := range ts.downstreamDigestKeys {
+		clientPk, _ := crypto.NewKeyPair(config.CryptoSuite)
+		key, err := dcnet.DownstreamDigestKey(config.CryptoSuite.Point().Mul(ts.privateKey, clientPk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.downstreamDigestKeys[i] = key
+		ts.clientsFirstRound[i] = 1
+	}
+
+	// the digests of the downstream cell of a round received by each client, as forwarded by the relay
+	roundID := int32(7)
+	ts.nextDigestsRound = roundID
+	digestsMsg := func(roundID int32, cells [][]byte) net.REL_TRU_DOWNSTREAM_DIGESTS {
+		msg := net.REL_TRU_DOWNSTREAM_DIGESTS{RoundID: roundID}
+		for i, cell := range cells {
+			digest := dcnet.DownstreamDigest(cell)
+			msg.ClientIDs = append(msg.ClientIDs, i)
+			msg.Digests = append(msg.Digests, net.ByteArray{Bytes: digest})
+			msg.Tags = append(msg.Tags, net.ByteArray{Bytes: dcnet.DownstreamDigestTag(ts.downstreamDigestKeys[i], roundID, digest)})
+		}
+		return msg
+	}
+	// the trustee sends the evidence to the relay and to the clients, with an empty digest for the client "missing"
+	expectEvidence := func(roundID int32, clients int, missing int) {
+		t.Helper()
+		select {
+		case msg := <-msgSender.sentToRelay:
+			evidence := msg.(*net.TRU_REL_DOWNSTREAM_EQUIVOCATION)
+			if evidence.TrusteeID != 1 || evidence.RoundID != roundID || len(evidence.ClientIDs) != clients {
+				t.Error("Wrong evidence", evidence)
+			}
+			for i, clientID := range evidence.ClientIDs {
+				if (clientID == missing) != (len(evidence.Digests[i].Bytes) == 0) {
+					t.Error("Wrong digest of client", clientID, "in the evidence", evidence)
+				}
+			}
+			if err := schnorr.Verify(config.CryptoSuite, ts.PublicKey, evidence.SignedData(), evidence.Signature); err != nil {
+				t.Error("The evidence should be signed by the trustee,", err)
+			}
+		default:
+			t.Error("Trustee should have sent a TRU_REL_DOWNSTREAM_EQUIVOCATION to the relay")
+		}
+		for i := 0; i < clients; i++ {
+			select {
+			case msg := <-msgSender.sentToClient:
+				if evidence := msg.(*net.TRU_REL_DOWNSTREAM_EQUIVOCATION); evidence.RoundID != roundID {
+					t.Error("Wrong evidence sent to the clients", evidence)
+				}
+			default:
+				t.Error("Trustee should have sent a TRU_REL_DOWNSTREAM_EQUIVOCATION to", clients, "clients")
+			}
+		}
+	}
+	expectNoEvidence := func() {
+		t.Helper()
+		select {
+		case msg := <-msgSender.sentToRelay:
+			t.Error("Trustee should not report an equivocation, sent", msg)
+		case msg := <-msgSender.sentToClient:
+			t.Error("Trustee should not report an equivocation, sent", msg)
+		default:
+		}
+	}
+	cell := []byte("downstream cell")
+	otherCell := []byte("another downstream cell")
+
+	// all clients received the same cell
+	if err := trustee.Received_REL_TRU_DOWNSTREAM_DIGESTS(digestsMsg(roundID, [][]byte{cell, cell, cell})); err != nil {
+		t.Error(err)
+	}
+	expectNoEvidence()
+
+	// client 1 received another cell
+	roundID++
+	if err := trustee.Received_REL_TRU_DOWNSTREAM_DIGESTS(digestsMsg(roundID, [][]byte{cell, otherCell, cell})); err != nil {
+		t.Error(err)
+	}
+	expectEvidence(roundID, nClients, -1)
+
+	// the relay replaced the digest of client 2, without being able to tag it
+	roundID++
+	forged := digestsMsg(roundID, [][]byte{cell, cell, cell})
+	forged.Digests[2] = net.ByteArray{Bytes: dcnet.DownstreamDigest(otherCell)}
+	if err := trustee.Received_REL_TRU_DOWNSTREAM_DIGESTS(forged); err != nil {
+		t.Error(err)
+	}
+	expectEvidence(roundID, nClients, 2)
+
+	// the relay left out the digest of client 1
+	roundID++
+	omitted := digestsMsg(roundID, [][]byte{cell, cell, cell})
+	omitted.ClientIDs = []int{0, 2}
+	omitted.Digests = []net.ByteArray{omitted.Digests[0], omitted.Digests[2]}
+	omitted.Tags = []net.ByteArray{omitted.Tags[0], omitted.Tags[2]}
+	if err := trustee.Received_REL_TRU_DOWNSTREAM_DIGESTS(omitted); err != nil {
+		t.Error(err)
+	}
+	expectEvidence(roundID, nClients, 1)
+
+	// the relay skipped a round : it is missing all the digests
+	roundID += 2
+	if err := trustee.Received_REL_TRU_DOWNSTREAM_DIGESTS(digestsMsg(roundID, [][]byte{cell, cell, cell})); err != nil {
+		t.Error(err)
+	}
+	select {
+	case msg := <-msgSender.sentToRelay:
+		evidence := msg.(*net.TRU_REL_DOWNSTREAM_EQUIVOCATION)
+		if evidence.RoundID != roundID-1 || len(evidence.ClientIDs) != nClients {
+			t.Error("Wrong evidence for the round skipped", evidence)
+		}
+		for i := 0; i < nClients; i++ {
+			<-msgSender.sentToClient
+		}
+	default:
+		t.Error("Trustee should have reported the round skipped")
+	}
+	expectNoEvidence()
+
+	// client 2 leaves the DC-net, its digest is not expected anymore
+	ts.departedClients[2] = roundID + 1
+	roundID++
+	if err := trustee.Received_REL_TRU_DOWNSTREAM_DIGESTS(digestsMsg(roundID, [][]byte{cell, cell})); err != nil {
+		t.Error(err)
+	}
+	expectNoEvidence()
+
+	if err := trustee.Received_REL_TRU_DOWNSTREAM_DIGESTS(net.REL_TRU_DOWNSTREAM_DIGESTS{ClientIDs: []int{0}}); err == nil {
+		t.Error("Trustee should refuse a malformed REL_TRU_DOWNSTREAM_DIGESTS")
+	}
+}
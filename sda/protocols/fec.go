@@ -0,0 +1,259 @@
+package protocols
+
+/*
+ * Forward error correction for the UDP broadcast. A lost datagram is never retransmitted : the client misses the
+ * downstream cell, and the relay only force-closes the round later. The FECChannel wraps a UDPChannel, and broadcasts
+ * each message as dataShards shards plus parityShards parity shards (Reed-Solomon), one datagram each. A client rebuilds
+ * the message as soon as it has any dataShards of them, hence up to parityShards lost datagrams per message are
+ * recovered locally.
+ * The code works on each cell alone, not across consecutive cells : the relay opens at most WindowSize rounds at once,
+ * and the cell of a round is only sent when a round before it completes. A code across a group of cells would recover
+ * a lost cell only once the rest of its group is sent, which waits for the round of the lost cell, hence never with a
+ * window smaller than the group. The cells which lose more than parityShards datagrams are sent again over TCP by the
+ * relay, see prifi-lib/relay/retransmissions.go.
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// FEC_MAX_PENDING_MESSAGES is the number of incomplete messages a listener keeps shards for
+const FEC_MAX_PENDING_MESSAGES = 64
+
+// fecShard is one datagram of the FECChannel
+type fecShard struct {
+	MessageID    uint32
+	Index        int
+	DataShards   int
+	ParityShards int
+	Length       int // the length of the message
+	Payload      []byte
+}
+
+// Print prints the header of the shard, for debug
+func (s *fecShard) Print() {
+	log.Lvl1("FEC shard", s.Index, "of message", s.MessageID, "(", s.DataShards, "+", s.ParityShards, "shards,",
+		s.Length, "bytes)")
+}
+
+// ToBytes encodes a shard into a slice of bytes.
+func (s *fecShard) ToBytes() ([]byte, error) {
+	// [0:4 messageID] [4:5 index] [5:6 dataShards-1] [6:7 parityShards] [7:11 length] [11:end payload]
+	buf := make([]byte, 11+len(s.Payload))
+	binary.BigEndian.PutUint32(buf[0:4], s.MessageID)
+	buf[4] = byte(s.Index)
+	buf[5] = byte(s.DataShards - 1)
+	buf[6] = byte(s.ParityShards)
+	binary.BigEndian.PutUint32(buf[7:11], uint32(s.Length))
+	copy(buf[11:], s.Payload)
+	return buf, nil
+}
+
+// FromBytes decodes the shard contained in "buffer"
+func (s *fecShard) FromBytes(buffer []byte) (interface{}, error) {
+	if len(buffer) < 11 {
+		return fecShard{}, errors.New("fec.go : FromBytes() : cannot decode, smaller than 11 bytes")
+	}
+	shard := fecShard{
+		MessageID:    binary.BigEndian.Uint32(buffer[0:4]),
+		Index:        int(buffer[4]),
+		DataShards:   int(buffer[5]) + 1,
+		ParityShards: int(buffer[6]),
+		Length:       int(binary.BigEndian.Uint32(buffer[7:11])),
+		Payload:      buffer[11:],
+	}
+	if shard.Index >= shard.DataShards+shard.ParityShards {
+		e := "fec.go : FromBytes() : cannot decode, shard " + strconv.Itoa(shard.Index) + " out of " +
+			strconv.Itoa(shard.DataShards+shard.ParityShards)
+		return fecShard{}, errors.New(e)
+	}
+	return shard, nil
+}
+
+// FECChannel is a UDPChannel which adds forward error correction to another UDPChannel
+type FECChannel struct {
+	sync.Mutex
+	channel       UDPChannel
+	code          *reedSolomon
+	nextMessageID uint32
+	listeners     map[string]*fecListener
+}
+
+// fecListener holds the shards received by one listener, for the messages not rebuilt yet
+type fecListener struct {
+	lastSeenShard   int
+	delivered       bool
+	lastDeliveredID uint32
+	pending         map[uint32][][]byte
+	lengths         map[uint32]int
+	code            *reedSolomon // the code of the last message rebuilt
+}
+
+// newFECChannel wraps "channel" with a FEC layer of "dataShards" data shards and "parityShards" parity shards per
+// message. If the parameters are invalid, it returns "channel" as is
+func newFECChannel(channel UDPChannel, dataShards, parityShards int) UDPChannel {
+	code, err := newReedSolomon(dataShards, parityShards)
+	if err != nil {
+		log.Error("Could not enable forward error correction on the UDP channel :", err)
+		return channel
+	}
+	return &FECChannel{
+		channel:   channel,
+		code:      code,
+		listeners: make(map[string]*fecListener),
+	}
+}
+
+// SessionID of FECChannel returns the ID of the session of the underlying channel
+func (c *FECChannel) SessionID() uint32 {
+	return c.channel.SessionID()
+}
+
+// SetSessionID of FECChannel sets the ID of the session of the underlying channel
+func (c *FECChannel) SetSessionID(sessionID uint32) {
+	c.channel.SetSessionID(sessionID)
+}
+
+// Broadcast of FECChannel encodes the message into shards, and broadcasts each of them on the underlying channel
+func (c *FECChannel) Broadcast(msg MarshallableMessage) error {
+	data, err := msg.ToBytes()
+	if err != nil {
+		log.Error("Broadcast: could not marshal message, error is", err.Error())
+		return err
+	}
+
+	c.Lock()
+	messageID := c.nextMessageID
+	c.nextMessageID++
+	c.Unlock()
+
+	for i, payload := range c.code.encode(data) {
+		shard := &fecShard{
+			MessageID:    messageID,
+			Index:        i,
+			DataShards:   c.code.dataShards,
+			ParityShards: c.code.parityShards,
+			Length:       len(data),
+			Payload:      payload,
+		}
+		if err := c.channel.Broadcast(shard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListenAndBlock of FECChannel receives shards from the underlying channel, until it can rebuild a message. The
+// messages missing too many shards are skipped
+func (c *FECChannel) ListenAndBlock(emptyMessage MarshallableMessage, lastSeenMessage int, identityListening string) (interface{}, error) {
+	c.Lock()
+	l, found := c.listeners[identityListening]
+	if !found {
+		l = &fecListener{
+			pending: make(map[uint32][][]byte),
+			lengths: make(map[uint32]int),
+		}
+		c.listeners[identityListening] = l
+	}
+	c.Unlock()
+
+	for {
+		received, err := c.channel.ListenAndBlock(&fecShard{}, l.lastSeenShard, identityListening)
+		l.lastSeenShard++
+		if err != nil {
+			return nil, err
+		}
+		shard, ok := received.(fecShard)
+		if !ok {
+			log.Error("ListenAndBlock(", identityListening, "): dropping a datagram which is not a FEC shard")
+			continue
+		}
+
+		data, complete := l.addShard(shard)
+		if !complete {
+			continue
+		}
+		log.Lvl4("ListenAndBlock(", identityListening, "): rebuilt message", shard.MessageID, "from its shards")
+		return emptyMessage.FromBytes(data)
+	}
+}
+
+// addShard stores "shard", and returns the message it belongs to if it can be rebuilt now
+func (l *fecListener) addShard(shard fecShard) ([]byte, bool) {
+	if l.delivered && int32(shard.MessageID-l.lastDeliveredID) <= 0 {
+		// already rebuilt, or older
+		return nil, false
+	}
+
+	shards, found := l.pending[shard.MessageID]
+	if !found {
+		if len(l.pending) >= FEC_MAX_PENDING_MESSAGES {
+			l.forgetOldest()
+		}
+		shards = make([][]byte, shard.DataShards+shard.ParityShards)
+		l.pending[shard.MessageID] = shards
+		l.lengths[shard.MessageID] = shard.Length
+	}
+	if len(shards) != shard.DataShards+shard.ParityShards {
+		log.Error("FEC : dropping shard", shard.Index, "of message", shard.MessageID, ", inconsistent number of shards")
+		return nil, false
+	}
+	shards[shard.Index] = shard.Payload
+
+	received := 0
+	for _, s := range shards {
+		if s != nil {
+			received++
+		}
+	}
+	if received < shard.DataShards {
+		return nil, false
+	}
+
+	if l.code == nil || l.code.dataShards != shard.DataShards || l.code.parityShards != shard.ParityShards {
+		code, err := newReedSolomon(shard.DataShards, shard.ParityShards)
+		if err != nil {
+			log.Error("FEC : cannot decode message", shard.MessageID, ",", err)
+			return nil, false
+		}
+		l.code = code
+	}
+	data, err := l.code.decode(shards, l.lengths[shard.MessageID])
+	if err != nil {
+		log.Error("FEC : cannot decode message", shard.MessageID, ",", err)
+		return nil, false
+	}
+
+	// the messages before this one will not be rebuilt anymore
+	for id := range l.pending {
+		if int32(id-shard.MessageID) <= 0 {
+			if id != shard.MessageID {
+				log.Lvl3("FEC : lost message", id, ", not enough shards received")
+			}
+			delete(l.pending, id)
+			delete(l.lengths, id)
+		}
+	}
+	l.delivered = true
+	l.lastDeliveredID = shard.MessageID
+	return data, true
+}
+
+// forgetOldest drops the shards of the oldest incomplete message
+func (l *fecListener) forgetOldest() {
+	first := true
+	var oldest uint32
+	for id := range l.pending {
+		if first || int32(id-oldest) < 0 {
+			oldest = id
+			first = false
+		}
+	}
+	delete(l.pending, oldest)
+	delete(l.lengths, oldest)
+}
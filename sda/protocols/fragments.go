@@ -0,0 +1,199 @@
+package protocols
+
+/*
+ * Fragmentation of the messages broadcast by the RealUDPChannel. A message is cut into datagrams of at most
+ * maxDatagramSize bytes, each with a header :
+ * [0:4 sessionID] [4:8 sequence number of the message] [8:10 fragment index] [10:12 number of fragments] [12:end data]
+ * The session ID is drawn by the relay for each protocol run, and given to the clients with the parameters : the
+ * datagrams of a previous run are dropped. The receiver rebuilds the messages in the order of their sequence numbers,
+ * and drops the fragments of the messages older than the last one rebuilt. It only keeps the fragments of the messages
+ * cut into as many datagrams as the longest cell needs, for at most UDP_PENDING_MESSAGE_TIMEOUT.
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// UDP_FRAGMENT_HEADER_SIZE is the size of the header of each datagram
+const UDP_FRAGMENT_HEADER_SIZE int = 12
+
+// UDP_MAX_PENDING_MESSAGES is the number of incomplete messages a receiver keeps fragments for
+const UDP_MAX_PENDING_MESSAGES = 64
+
+// UDP_PENDING_MESSAGE_TIMEOUT is how long a receiver keeps the fragments of an incomplete message
+const UDP_PENDING_MESSAGE_TIMEOUT = 5 * time.Second
+
+// UDP_MESSAGE_OVERHEAD is the size a message broadcast adds to its cell : the header and flags of
+// REL_CLI_DOWNSTREAM_DATA_UDP, the hash of the previous upstream data, and the header of a FEC shard
+const UDP_MESSAGE_OVERHEAD int = 36 + sha256.Size + 11
+
+// udpFragment is one datagram of a message
+type udpFragment struct {
+	sessionID      uint32
+	sequenceNumber uint32
+	index          int
+	count          int
+	data           []byte
+}
+
+// fragmentMessage cuts "data" into datagrams of at most "maxDatagramSize" bytes
+func fragmentMessage(sessionID, sequenceNumber uint32, data []byte, maxDatagramSize int) ([][]byte, error) {
+	fragmentSize := maxDatagramSize - UDP_FRAGMENT_HEADER_SIZE
+	if fragmentSize < 1 {
+		return nil, errors.New("fragments.go : datagrams of " + strconv.Itoa(maxDatagramSize) + " bytes are too small")
+	}
+	count := (len(data) + fragmentSize - 1) / fragmentSize
+	if count == 0 {
+		count = 1
+	}
+	if count > 0xFFFF {
+		return nil, errors.New("fragments.go : message of " + strconv.Itoa(len(data)) + " bytes is too big")
+	}
+
+	datagrams := make([][]byte, count)
+	for i := range datagrams {
+		start := i * fragmentSize
+		end := start + fragmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		datagram := make([]byte, UDP_FRAGMENT_HEADER_SIZE+end-start)
+		binary.BigEndian.PutUint32(datagram[0:4], sessionID)
+		binary.BigEndian.PutUint32(datagram[4:8], sequenceNumber)
+		binary.BigEndian.PutUint16(datagram[8:10], uint16(i))
+		binary.BigEndian.PutUint16(datagram[10:12], uint16(count))
+		copy(datagram[UDP_FRAGMENT_HEADER_SIZE:], data[start:end])
+		datagrams[i] = datagram
+	}
+	return datagrams, nil
+}
+
+// parseFragment decodes the header of "datagram"
+func parseFragment(datagram []byte) (*udpFragment, error) {
+	if len(datagram) < UDP_FRAGMENT_HEADER_SIZE {
+		return nil, errors.New("fragments.go : cannot decode, smaller than " + strconv.Itoa(UDP_FRAGMENT_HEADER_SIZE) + " bytes")
+	}
+	f := &udpFragment{
+		sessionID:      binary.BigEndian.Uint32(datagram[0:4]),
+		sequenceNumber: binary.BigEndian.Uint32(datagram[4:8]),
+		index:          int(binary.BigEndian.Uint16(datagram[8:10])),
+		count:          int(binary.BigEndian.Uint16(datagram[10:12])),
+		data:           datagram[UDP_FRAGMENT_HEADER_SIZE:],
+	}
+	if f.count == 0 || f.index >= f.count {
+		return nil, errors.New("fragments.go : cannot decode, fragment " + strconv.Itoa(f.index) + " out of " + strconv.Itoa(f.count))
+	}
+	return f, nil
+}
+
+// reassembler rebuilds the messages of one session from their fragments
+type reassembler struct {
+	sessionID    uint32
+	maxFragments int // the fragments of the messages cut into more datagrams are dropped, 0 for no limit
+	delivered    bool
+	lastSequence uint32 // the sequence number of the last message rebuilt
+	pending      map[uint32]*pendingMessage
+}
+
+// pendingMessage holds the fragments received of an incomplete message
+type pendingMessage struct {
+	fragments [][]byte
+	firstSeen time.Time
+}
+
+// newReassembler returns a reassembler for the session "sessionID", for messages of at most "maxFragments" fragments
+// (0 for no limit)
+func newReassembler(sessionID uint32, maxFragments int) *reassembler {
+	return &reassembler{
+		sessionID:    sessionID,
+		maxFragments: maxFragments,
+		pending:      make(map[uint32]*pendingMessage),
+	}
+}
+
+// add stores the fragment "datagram", and returns the message it belongs to if it is complete now
+func (r *reassembler) add(datagram []byte) ([]byte, bool) {
+	f, err := parseFragment(datagram)
+	if err != nil {
+		log.Lvl3("Dropping a datagram :", err)
+		return nil, false
+	}
+	if f.sessionID != r.sessionID {
+		log.Lvl3("Dropping a datagram of session", f.sessionID, ", we are in session", r.sessionID)
+		return nil, false
+	}
+	if r.maxFragments > 0 && f.count > r.maxFragments {
+		log.Lvl3("Dropping a datagram of a message of", f.count, "fragments, the cells need at most", r.maxFragments)
+		return nil, false
+	}
+	if r.delivered && int32(f.sequenceNumber-r.lastSequence) <= 0 {
+		// duplicated, or older than the last message rebuilt
+		return nil, false
+	}
+	r.forgetExpired(time.Now())
+
+	message, found := r.pending[f.sequenceNumber]
+	if !found {
+		if len(r.pending) >= UDP_MAX_PENDING_MESSAGES {
+			r.forgetOldest()
+		}
+		message = &pendingMessage{fragments: make([][]byte, f.count), firstSeen: time.Now()}
+		r.pending[f.sequenceNumber] = message
+	}
+	fragments := message.fragments
+	if len(fragments) != f.count {
+		log.Lvl3("Dropping fragment", f.index, "of message", f.sequenceNumber, ", inconsistent number of fragments")
+		return nil, false
+	}
+	fragments[f.index] = f.data
+
+	length := 0
+	for _, fragment := range fragments {
+		if fragment == nil {
+			return nil, false
+		}
+		length += len(fragment)
+	}
+	data := make([]byte, 0, length)
+	for _, fragment := range fragments {
+		data = append(data, fragment...)
+	}
+
+	// the messages before this one will not be rebuilt anymore
+	for sequenceNumber := range r.pending {
+		if int32(sequenceNumber-f.sequenceNumber) <= 0 {
+			delete(r.pending, sequenceNumber)
+		}
+	}
+	r.delivered = true
+	r.lastSequence = f.sequenceNumber
+	return data, true
+}
+
+// forgetExpired drops the fragments of the incomplete messages received UDP_PENDING_MESSAGE_TIMEOUT before "now"
+func (r *reassembler) forgetExpired(now time.Time) {
+	for sequenceNumber, message := range r.pending {
+		if now.Sub(message.firstSeen) > UDP_PENDING_MESSAGE_TIMEOUT {
+			delete(r.pending, sequenceNumber)
+		}
+	}
+}
+
+// forgetOldest drops the fragments of the oldest incomplete message
+func (r *reassembler) forgetOldest() {
+	first := true
+	var oldest uint32
+	for sequenceNumber := range r.pending {
+		if first || int32(sequenceNumber-oldest) < 0 {
+			oldest = sequenceNumber
+			first = false
+		}
+	}
+	delete(r.pending, oldest)
+}
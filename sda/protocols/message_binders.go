@@ -1,5 +1,7 @@
 package protocols
 
+import "go.dedis.ch/onet/v3/log"
+
 //Received_ALL_ALL_SHUTDOWN shuts down the PriFi-lib if it is running
 func (p *PriFiSDAProtocol) Received_ALL_ALL_SHUTDOWN(msg Struct_ALL_ALL_SHUTDOWN) error {
 	p.Stop()
@@ -9,6 +11,13 @@ func (p *PriFiSDAProtocol) Received_ALL_ALL_SHUTDOWN(msg Struct_ALL_ALL_SHUTDOWN
 
 //Received_ALL_ALL_PARAMETERS forwards an ALL_ALL_PARAMETERS message to PriFi's lib
 func (p *PriFiSDAProtocol) Received_ALL_ALL_PARAMETERS_NEW(msg Struct_ALL_ALL_PARAMETERS) error {
+	if err := p.checkParametersAgreement(msg.ALL_ALL_PARAMETERS); err != nil {
+		log.Error(err)
+		return err
+	}
+	if sessionID, ok := msg.ParamsInt["UDPSessionID"]; ok && p.role == Client && p.ms.udpChannel != nil {
+		p.ms.udpChannel.SetSessionID(uint32(sessionID))
+	}
 	return p.prifiLibInstance.ReceivedMessage(msg.ALL_ALL_PARAMETERS)
 }
 
@@ -22,6 +31,11 @@ func (p *PriFiSDAProtocol) Received_REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG(msg St
 	return p.prifiLibInstance.ReceivedMessage(msg.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG)
 }
 
+//Received_REL_CLI_ASK_EPH_PK forwards an REL_CLI_ASK_EPH_PK message to PriFi's lib
+func (p *PriFiSDAProtocol) Received_REL_CLI_ASK_EPH_PK(msg Struct_REL_CLI_ASK_EPH_PK) error {
+	return p.prifiLibInstance.ReceivedMessage(msg.REL_CLI_ASK_EPH_PK)
+}
+
 //Received_CLI_REL_TELL_PK_AND_EPH_PK forwards an CLI_REL_TELL_PK_AND_EPH_PK message to PriFi's lib
 func (p *PriFiSDAProtocol) Received_CLI_REL_TELL_PK_AND_EPH_PK(msg Struct_CLI_REL_TELL_PK_AND_EPH_PK) error {
 	return p.prifiLibInstance.ReceivedMessage(msg.CLI_REL_TELL_PK_AND_EPH_PK)
@@ -37,6 +51,11 @@ func (p *PriFiSDAProtocol) Received_CLI_REL_CLI_REL_OPENCLOSED_DATA(msg Struct_C
 	return p.prifiLibInstance.ReceivedMessage(msg.CLI_REL_OPENCLOSED_DATA)
 }
 
+//Received_CLI_REL_DOWNSTREAM_NACK forwards an CLI_REL_DOWNSTREAM_NACK message to PriFi's lib
+func (p *PriFiSDAProtocol) Received_CLI_REL_DOWNSTREAM_NACK(msg Struct_CLI_REL_DOWNSTREAM_NACK) error {
+	return p.prifiLibInstance.ReceivedMessage(msg.CLI_REL_DOWNSTREAM_NACK)
+}
+
 //Received_TRU_REL_DC_CIPHER forwards an TRU_REL_DC_CIPHER message to PriFi's lib
 func (p *PriFiSDAProtocol) Received_TRU_REL_DC_CIPHER(msg Struct_TRU_REL_DC_CIPHER) error {
 	return p.prifiLibInstance.ReceivedMessage(msg.TRU_REL_DC_CIPHER)
@@ -72,6 +91,26 @@ func (p *PriFiSDAProtocol) Received_REL_TRU_TELL_RATE_CHANGE(msg Struct_REL_TRU_
 	return p.prifiLibInstance.ReceivedMessage(msg.REL_TRU_TELL_RATE_CHANGE)
 }
 
+//Received_REL_TRU_TELL_EPOCH forward a REL_TRU_TELL_EPOCH message to PriFi's lib
+func (p *PriFiSDAProtocol) Received_REL_TRU_TELL_EPOCH(msg Struct_REL_TRU_TELL_EPOCH) error {
+	return p.prifiLibInstance.ReceivedMessage(msg.REL_TRU_TELL_EPOCH)
+}
+
+//Received_REL_TRU_TELL_SLOT_LENGTH forward a REL_TRU_TELL_SLOT_LENGTH message to PriFi's lib
+func (p *PriFiSDAProtocol) Received_REL_TRU_TELL_SLOT_LENGTH(msg Struct_REL_TRU_TELL_SLOT_LENGTH) error {
+	return p.prifiLibInstance.ReceivedMessage(msg.REL_TRU_TELL_SLOT_LENGTH)
+}
+
+//Received_REL_TRU_DOWNSTREAM_DIGESTS forward a REL_TRU_DOWNSTREAM_DIGESTS message to PriFi's lib
+func (p *PriFiSDAProtocol) Received_REL_TRU_DOWNSTREAM_DIGESTS(msg Struct_REL_TRU_DOWNSTREAM_DIGESTS) error {
+	return p.prifiLibInstance.ReceivedMessage(msg.REL_TRU_DOWNSTREAM_DIGESTS)
+}
+
+//Received_TRU_REL_DOWNSTREAM_EQUIVOCATION forward a TRU_REL_DOWNSTREAM_EQUIVOCATION message to PriFi's lib
+func (p *PriFiSDAProtocol) Received_TRU_REL_DOWNSTREAM_EQUIVOCATION(msg Struct_TRU_REL_DOWNSTREAM_EQUIVOCATION) error {
+	return p.prifiLibInstance.ReceivedMessage(msg.TRU_REL_DOWNSTREAM_EQUIVOCATION)
+}
+
 // Received_REL_CLI_DISRUPTED_ROUND forward an REL_CLI_DISRUPTED_ROUND message to PriFi's lib
 func (p *PriFiSDAProtocol) Received_REL_CLI_DISRUPTED_ROUND(msg Struct_REL_CLI_DISRUPTED_ROUND) error {
 	return p.prifiLibInstance.ReceivedMessage(msg.REL_CLI_DISRUPTED_ROUND)
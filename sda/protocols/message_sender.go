@@ -3,6 +3,8 @@ package protocols
 import (
 	"errors"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/dedis/prifi/prifi-lib/net"
 	"go.dedis.ch/onet/v3"
@@ -17,6 +19,14 @@ type MessageSender struct {
 	clients    map[int]*onet.TreeNode
 	trustees   map[int]*onet.TreeNode
 	udpChannel UDPChannel
+	joined     *joinedClients
+}
+
+// joinedClients holds the routes to the clients which joined while the protocol runs. Each of them has its own
+// tree with the relay, hence its own TreeNodeInstance. It is shared by all the copies of the MessageSender
+type joinedClients struct {
+	sync.Mutex
+	routes map[int]*PriFiSDAProtocol
 }
 
 // buildMessageSender creates a MessageSender struct
@@ -26,7 +36,6 @@ func (p *PriFiSDAProtocol) buildMessageSender(identities map[string]PriFiIdentit
 	trustees := make(map[int]*onet.TreeNode)
 	clients := make(map[int]*onet.TreeNode)
 	trusteeID := 0
-	clientID := 0
 	var relay *onet.TreeNode
 
 	for i := 0; i < len(nodes); i++ {
@@ -43,8 +52,7 @@ func (p *PriFiSDAProtocol) buildMessageSender(identities map[string]PriFiIdentit
 		}
 		switch id.Role {
 		case Client:
-			clients[clientID] = nodes[i] //TODO : wrong
-			clientID++
+			clients[id.ID] = nodes[i]
 		case Trustee:
 			trustees[trusteeID] = nodes[i]
 			trusteeID++
@@ -57,15 +65,71 @@ func (p *PriFiSDAProtocol) buildMessageSender(identities map[string]PriFiIdentit
 		}
 	}
 
-	return MessageSender{p.TreeNodeInstance, relay, clients, trustees, newRealUDPChannel()}
+	var udpChannel UDPChannel
+	if p.config.Toml != nil && p.config.Toml.UDPSimulated {
+		udpChannel = sharedLocalhostUDPChannel(p.config.Toml.UDPSimulatedModels)
+	} else {
+		udpChannel = newRealUDPChannel(p.config.Toml.udpConfig())
+	}
+	if p.config.Toml != nil && p.config.Toml.UDPFECDataShards > 0 {
+		udpChannel = newFECChannel(udpChannel, p.config.Toml.UDPFECDataShards, p.config.Toml.UDPFECParityShards)
+	}
+
+	joined := &joinedClients{routes: make(map[int]*PriFiSDAProtocol)}
+	return MessageSender{p.TreeNodeInstance, relay, clients, trustees, udpChannel, joined}
+}
+
+// addJoinedClient routes the messages for client i through the protocol instance "joining"
+func (ms MessageSender) addJoinedClient(i int, joining *PriFiSDAProtocol) {
+	ms.joined.Lock()
+	defer ms.joined.Unlock()
+	ms.joined.routes[i] = joining
+}
+
+// removeJoinedClient forgets the route to client i, and returns its protocol instance, nil if it did not join
+func (ms MessageSender) removeJoinedClient(i int) *PriFiSDAProtocol {
+	ms.joined.Lock()
+	defer ms.joined.Unlock()
+	joining := ms.joined.routes[i]
+	delete(ms.joined.routes, i)
+	return joining
+}
+
+// removeJoinedClients forgets the routes to the joined clients, and returns their protocol instances
+func (ms MessageSender) removeJoinedClients() []*PriFiSDAProtocol {
+	ms.joined.Lock()
+	defer ms.joined.Unlock()
+	res := make([]*PriFiSDAProtocol, 0, len(ms.joined.routes))
+	for i, joining := range ms.joined.routes {
+		res = append(res, joining)
+		delete(ms.joined.routes, i)
+	}
+	return res
+}
+
+// clientRoute returns the tree instance to send to client i with, and the node of client i in this tree
+func (ms MessageSender) clientRoute(i int) (*onet.TreeNodeInstance, *onet.TreeNode, bool) {
+	if client, ok := ms.clients[i]; ok {
+		return ms.tree, client, true
+	}
+	if ms.joined == nil {
+		return nil, nil, false
+	}
+	ms.joined.Lock()
+	defer ms.joined.Unlock()
+	if joining, ok := ms.joined.routes[i]; ok {
+		// the client is the only child of the relay in its tree
+		return joining.TreeNodeInstance, joining.Children()[0], true
+	}
+	return nil, nil, false
 }
 
 //SendToClient sends a message to client i, or fails if it is unknown
 func (ms MessageSender) FastSendToClient(i int, msg *net.REL_CLI_DOWNSTREAM_DATA) error {
 
-	if client, ok := ms.clients[i]; ok {
+	if tree, client, ok := ms.clientRoute(i); ok {
 		log.Lvl5("Sending a message to client ", i, " (", client.Name(), ") - ", msg)
-		return ms.tree.SendTo(client, msg)
+		return tree.SendTo(client, msg)
 	}
 
 	e := "Client " + strconv.Itoa(i) + " is unknown !"
@@ -82,9 +146,14 @@ func (ms MessageSender) FastSendToRelay(msg *net.CLI_REL_UPSTREAM_DATA) error {
 //SendToClient sends a message to client i, or fails if it is unknown
 func (ms MessageSender) SendToClient(i int, msg interface{}) error {
 
-	if client, ok := ms.clients[i]; ok {
+	//the clients need the session of our UDP channel, to drop the datagrams of the previous runs
+	if params, ok := msg.(*net.ALL_ALL_PARAMETERS); ok && ms.udpChannel != nil {
+		params.Add("UDPSessionID", int(ms.udpChannel.SessionID()))
+	}
+
+	if tree, client, ok := ms.clientRoute(i); ok {
 		log.Lvl5("Sending a message to client ", i, " (", client.Name(), ") - ", msg)
-		return ms.tree.SendTo(client, msg)
+		return tree.SendTo(client, msg)
 	}
 
 	e := "Client " + strconv.Itoa(i) + " is unknown !"
@@ -149,18 +218,16 @@ func (ms MessageSender) ClientSubscribeToBroadcast(clientID int, messageReceived
 			//listen and decode
 			log.Lvl4("client", clientName, " calling listen and block...")
 			filledMessage, err := ms.udpChannel.ListenAndBlock(&emptyMessage, lastSeenMessage, clientName)
-			lastSeenMessage++
-
 			if err != nil {
 				log.Error(clientName, " an error occurred : ", err)
+				//do not spin on a broken socket
+				time.Sleep(time.Second)
+				continue
 			}
+			lastSeenMessage++
 
 			log.Lvl4(clientName, " Received an UDP message n°"+strconv.Itoa(lastSeenMessage))
 
-			if err != nil {
-				log.Error(clientName, " an error occurred : ", err)
-			}
-
 			messageReceived(filledMessage)
 
 		}
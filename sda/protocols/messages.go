@@ -35,6 +35,12 @@ type Struct_CLI_REL_OPENCLOSED_DATA struct {
 	net.CLI_REL_OPENCLOSED_DATA
 }
 
+//Struct_CLI_REL_DOWNSTREAM_NACK is a wrapper for CLI_REL_DOWNSTREAM_NACK (but also contains a *onet.TreeNode)
+type Struct_CLI_REL_DOWNSTREAM_NACK struct {
+	*onet.TreeNode
+	net.CLI_REL_DOWNSTREAM_NACK
+}
+
 //Struct_REL_CLI_DOWNSTREAM_DATA is a wrapper for REL_CLI_DOWNSTREAM_DATA (but also contains a *onet.TreeNode)
 type Struct_REL_CLI_DOWNSTREAM_DATA struct {
 	*onet.TreeNode
@@ -47,6 +53,12 @@ type Struct_REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG struct {
 	net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG
 }
 
+//Struct_REL_CLI_ASK_EPH_PK is a wrapper for REL_CLI_ASK_EPH_PK (but also contains a *onet.TreeNode)
+type Struct_REL_CLI_ASK_EPH_PK struct {
+	*onet.TreeNode
+	net.REL_CLI_ASK_EPH_PK
+}
+
 //Struct_REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE is a wrapper for REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE (but also contains a *onet.TreeNode)
 type Struct_REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE struct {
 	*onet.TreeNode
@@ -89,6 +101,30 @@ type Struct_REL_TRU_TELL_RATE_CHANGE struct {
 	net.REL_TRU_TELL_RATE_CHANGE
 }
 
+//Struct_REL_TRU_TELL_EPOCH is a wrapper for REL_TRU_TELL_EPOCH (but also contains a *onet.TreeNode)
+type Struct_REL_TRU_TELL_EPOCH struct {
+	*onet.TreeNode
+	net.REL_TRU_TELL_EPOCH
+}
+
+//Struct_REL_TRU_TELL_SLOT_LENGTH is a wrapper for REL_TRU_TELL_SLOT_LENGTH (but also contains a *onet.TreeNode)
+type Struct_REL_TRU_TELL_SLOT_LENGTH struct {
+	*onet.TreeNode
+	net.REL_TRU_TELL_SLOT_LENGTH
+}
+
+//Struct_REL_TRU_DOWNSTREAM_DIGESTS is a wrapper for REL_TRU_DOWNSTREAM_DIGESTS (but also contains a *onet.TreeNode)
+type Struct_REL_TRU_DOWNSTREAM_DIGESTS struct {
+	*onet.TreeNode
+	net.REL_TRU_DOWNSTREAM_DIGESTS
+}
+
+//Struct_TRU_REL_DOWNSTREAM_EQUIVOCATION is a wrapper for TRU_REL_DOWNSTREAM_EQUIVOCATION (but also contains a *onet.TreeNode)
+type Struct_TRU_REL_DOWNSTREAM_EQUIVOCATION struct {
+	*onet.TreeNode
+	net.TRU_REL_DOWNSTREAM_EQUIVOCATION
+}
+
 //Struct_REL_CLI_DISRUPTED_ROUND is a wrapper for REL_CLI_DISRUPTED_ROUND (but also contains a *onet.TreeNode)
 type Struct_REL_CLI_DISRUPTED_ROUND struct {
 	*onet.TreeNode
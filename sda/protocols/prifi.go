@@ -1,7 +1,11 @@
 package protocols
 
 import (
+	"errors"
 	prifi_lib "github.com/dedis/prifi/prifi-lib"
+	"github.com/dedis/prifi/prifi-lib/config"
+	"github.com/dedis/prifi/prifi-lib/dcnet"
+	"github.com/dedis/prifi/prifi-lib/net"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 )
@@ -40,17 +44,38 @@ type PrifiTomlConfig struct {
 	ClientDataOutputEnabled                 bool
 	RelayDataOutputEnabled                  bool
 	PayloadSize                             int
+	VariableSlotLengths                     bool
+	MaxPayloadSize                          int
 	CellSizeDown                            int
 	RelayWindowSize                         int
+	RelayAdaptiveWindow                     bool
 	RelayUseOpenClosedSlots                 bool
+	RelaySlotScheduler                      string
+	RelayMaxSlotsPerClient                  int
 	RelayUseDummyDataDown                   bool
 	RelayReportingLimit                     int
 	UseUDP                                  bool
+	UDPMulticastAddress                     string
+	UDPPort                                 int
+	UDPMulticastInterface                   string
+	UDPMulticastTTL                         int
+	UDPMaxDatagramSize                      int
+	UDPFECDataShards                        int
+	UDPFECParityShards                      int
+	UDPSimulated                            bool
+	UDPSimulatedModels                      []UDPSimulationModel
 	DoLatencyTests                          bool
 	SocksServerPort                         int
 	SocksClientPort                         int
 	ProtocolVersion                         string
 	DCNetType                               string
+	DCNetPadCipher                          string
+	CryptoSuite                             string
+	DCNetEpochRounds                        int
+	DCNetEpochDuration                      int
+	ReshuffleRounds                         int
+	ReshuffleDuration                       int
+	ClientsVerifyShuffle                    bool
 	ReplayPCAP                              bool
 	PCAPFolder                              string
 	TrusteeSleepTimeBetweenMessages         int
@@ -59,12 +84,16 @@ type PrifiTomlConfig struct {
 	SimulDelayBetweenClients                int
 	DisruptionProtectionEnabled             bool
 	EquivocationProtectionEnabled           bool // not linked in the back
+	DownstreamConsistencyCheck              bool
 	OpenClosedSlotsMinDelayBetweenRequests  int
 	RelayMaxNumberOfConsecutiveFailedRounds int
 	RelayProcessingLoopSleepTime            int
 	RelayRoundTimeOut                       int
+	RelayAdaptiveRoundTimeOut               bool
 	RelayTrusteeCacheLowBound               int
 	RelayTrusteeCacheHighBound              int
+	RelayHistoryRounds                      int
+	RelayHistoryMaxBytes                    int
 	VerboseIngressEgressServers             bool
 	ForceDisruptionSinceRound3              bool
 }
@@ -146,3 +175,72 @@ func (p *PriFiSDAProtocol) SetConfigFromPriFiService(config *PriFiSDAWrapperConf
 func (p *PriFiSDAProtocol) SetTimeoutHandler(handler func([]string, []string)) {
 	p.toHandler = handler
 }
+
+// padCipher returns the pad cipher of the .toml, or the default one if none is set
+func (c *PrifiTomlConfig) padCipher() string {
+	if c.DCNetPadCipher == "" {
+		return dcnet.PAD_CIPHER_XOF
+	}
+	return c.DCNetPadCipher
+}
+
+// cryptoSuite returns the crypto suite of the .toml, or the default one if none is set
+func (c *PrifiTomlConfig) cryptoSuite() string {
+	if c.CryptoSuite == "" {
+		return config.DefaultCryptoSuiteName
+	}
+	return c.CryptoSuite
+}
+
+// udpConfig returns the configuration of the UDP broadcast of the .toml, with the default values for the ones not set
+func (c *PrifiTomlConfig) udpConfig() UDPConfig {
+	udpConfig := DefaultUDPConfig()
+	if c == nil {
+		return udpConfig
+	}
+	if c.UDPMulticastAddress != "" {
+		udpConfig.MulticastAddress = c.UDPMulticastAddress
+	}
+	if c.UDPPort > 0 {
+		udpConfig.Port = c.UDPPort
+	}
+	udpConfig.Interface = c.UDPMulticastInterface
+	if c.UDPMulticastTTL > 0 {
+		udpConfig.TTL = c.UDPMulticastTTL
+	}
+	if c.UDPMaxDatagramSize > 0 {
+		udpConfig.MaxDatagramSize = c.UDPMaxDatagramSize
+	}
+	// the longest cell broadcast : a downstream cell, or an upstream cell sent again after a disruption
+	cellSize := c.CellSizeDown
+	if c.PayloadSize > cellSize {
+		cellSize = c.PayloadSize
+	}
+	if c.MaxPayloadSize > cellSize {
+		cellSize = c.MaxPayloadSize
+	}
+	if cellSize > 0 {
+		udpConfig.MaxMessageSize = cellSize + UDP_MESSAGE_OVERHEAD
+	}
+	return udpConfig
+}
+
+// checkParametersAgreement verifies that the parameters imposed by the relay match our own configuration
+func (p *PriFiSDAProtocol) checkParametersAgreement(msg net.ALL_ALL_PARAMETERS) error {
+	// onet decodes the points in the messages with the suite of the conode, which must hence be the one of PriFi
+	relaySuite := msg.StringValueOrElse("CryptoSuite", config.DefaultCryptoSuiteName)
+	if relaySuite != p.Suite().String() {
+		return errors.New("PriFi uses the crypto suite " + relaySuite + ", but the conode uses " + p.Suite().String())
+	}
+	if p.role == Relay {
+		return nil
+	}
+	if relaySuite != p.config.Toml.cryptoSuite() {
+		return errors.New("The relay uses the crypto suite " + relaySuite + ", but we are configured with " + p.config.Toml.cryptoSuite())
+	}
+	relayPadCipher := msg.StringValueOrElse("DCNetPadCipher", dcnet.PAD_CIPHER_XOF)
+	if relayPadCipher != p.config.Toml.padCipher() {
+		return errors.New("The relay uses the pad cipher " + relayPadCipher + ", but we are configured with " + p.config.Toml.padCipher())
+	}
+	return nil
+}
@@ -67,21 +67,37 @@ func (p *PriFiSDAProtocol) Start() error {
 	msg.Add("NTrustees", len(p.ms.trustees))
 	msg.Add("NClients", len(p.ms.clients))
 	msg.Add("PayloadSize", p.config.Toml.PayloadSize)
+	msg.Add("VariableSlotLengths", p.config.Toml.VariableSlotLengths)
+	msg.Add("MaxPayloadSize", p.config.Toml.MaxPayloadSize)
 	msg.Add("DownstreamCellSize", p.config.Toml.CellSizeDown)
 	msg.Add("WindowSize", p.config.Toml.RelayWindowSize)
+	msg.Add("RelayAdaptiveWindow", p.config.Toml.RelayAdaptiveWindow)
 	msg.Add("UseOpenClosedSlots", p.config.Toml.RelayUseOpenClosedSlots)
+	msg.Add("SlotScheduler", p.config.Toml.RelaySlotScheduler)
+	msg.Add("MaxSlotsPerClient", p.config.Toml.RelayMaxSlotsPerClient)
 	msg.Add("UseDummyDataDown", p.config.Toml.RelayUseDummyDataDown)
 	msg.Add("ExperimentRoundLimit", p.config.Toml.RelayReportingLimit)
 	msg.Add("UseUDP", p.config.Toml.UseUDP)
 	msg.Add("DCNetType", p.config.Toml.DCNetType)
+	msg.Add("DCNetPadCipher", p.config.Toml.padCipher())
+	msg.Add("CryptoSuite", p.config.Toml.cryptoSuite())
+	msg.Add("DCNetEpochRounds", p.config.Toml.DCNetEpochRounds)
+	msg.Add("DCNetEpochDuration", p.config.Toml.DCNetEpochDuration)
+	msg.Add("ReshuffleRounds", p.config.Toml.ReshuffleRounds)
+	msg.Add("ReshuffleDuration", p.config.Toml.ReshuffleDuration)
+	msg.Add("ClientsVerifyShuffle", p.config.Toml.ClientsVerifyShuffle)
 	msg.Add("DisruptionProtectionEnabled", p.config.Toml.DisruptionProtectionEnabled)
 	msg.Add("OpenClosedSlotsMinDelayBetweenRequests", p.config.Toml.OpenClosedSlotsMinDelayBetweenRequests)
 	msg.Add("RelayMaxNumberOfConsecutiveFailedRounds", p.config.Toml.RelayMaxNumberOfConsecutiveFailedRounds)
 	msg.Add("RelayProcessingLoopSleepTime", p.config.Toml.RelayProcessingLoopSleepTime)
 	msg.Add("RelayRoundTimeOut", p.config.Toml.RelayRoundTimeOut)
+	msg.Add("RelayAdaptiveRoundTimeOut", p.config.Toml.RelayAdaptiveRoundTimeOut)
 	msg.Add("RelayTrusteeCacheLowBound", p.config.Toml.RelayTrusteeCacheLowBound)
 	msg.Add("RelayTrusteeCacheHighBound", p.config.Toml.RelayTrusteeCacheHighBound)
+	msg.Add("RelayHistoryRounds", p.config.Toml.RelayHistoryRounds)
+	msg.Add("RelayHistoryMaxBytes", p.config.Toml.RelayHistoryMaxBytes)
 	msg.Add("EquivocationProtectionEnabled", p.config.Toml.EquivocationProtectionEnabled)
+	msg.Add("DownstreamConsistencyCheck", p.config.Toml.DownstreamConsistencyCheck)
 	msg.Add("ForceDisruptionSinceRound3", p.config.Toml.ForceDisruptionSinceRound3)
 	msg.ForceParams = true
 
@@ -106,10 +122,66 @@ func (p *PriFiSDAProtocol) Stop() {
 
 	p.HasStopped = true
 
+	//the clients which joined have their own protocol instance
+	if p.role == Relay && p.ms.joined != nil {
+		for _, joining := range p.ms.removeJoinedClients() {
+			joining.HasStopped = true
+			joining.Shutdown()
+		}
+	}
+
 	p.Shutdown()
 	//TODO : sureley we're missing some allocated resources here...
 }
 
+// AddJoiningClient lets the client "clientID" join the protocol running on the relay. The client connected after the
+// protocol started; "joining" is the protocol instance of a new tree with the relay as root and the client as only
+// child. It forwards the messages of the client to our PriFi-Lib, and our PriFi-Lib sends to the client through it.
+func (p *PriFiSDAProtocol) AddJoiningClient(joining *PriFiSDAProtocol, clientID int) error {
+	if p.role != Relay || !p.configSet || p.HasStopped {
+		return errors.New("only a running relay can add a joining client")
+	}
+	if len(joining.Children()) != 1 {
+		return errors.New("the tree of a joining client must have the client as only child of the relay")
+	}
+
+	joining.config = p.config
+	joining.role = p.role
+	joining.ms = p.ms
+	joining.toHandler = p.toHandler
+	joining.prifiLibInstance = p.prifiLibInstance
+	if err := joining.registerHandlers(); err != nil {
+		return err
+	}
+	joining.configSet = true
+
+	p.ms.addJoinedClient(clientID, joining)
+	if err := p.prifiLibInstance.ReceivedMessage(net.CLI_REL_JOIN{ClientID: clientID}); err != nil {
+		p.ms.removeJoinedClient(clientID)
+		return err
+	}
+	return nil
+}
+
+// RemoveLeavingClient tells our PriFi-Lib that the connection of the client "clientID" closed, for it to drop the
+// client from the DC-net at the next round. If the client joined, its protocol instance is shut down.
+func (p *PriFiSDAProtocol) RemoveLeavingClient(clientID int) error {
+	if p.role != Relay || !p.configSet || p.HasStopped {
+		return errors.New("only a running relay can remove a leaving client")
+	}
+	if err := p.prifiLibInstance.ReceivedMessage(net.CLI_REL_LEAVE{ClientID: clientID}); err != nil {
+		return err
+	}
+	if p.ms.joined == nil {
+		return nil
+	}
+	if joining := p.ms.removeJoinedClient(clientID); joining != nil {
+		joining.HasStopped = true
+		joining.Shutdown()
+	}
+	return nil
+}
+
 /**
  * On initialization of the PriFi-SDA-Wrapper protocol, it need to register the PriFi-Lib messages to be able to marshall them.
  * If we forget some messages there, it will crash when PriFi-Lib will call SendToXXX() with this message !
@@ -122,11 +194,17 @@ func init() {
 	network.RegisterMessage(net.CLI_REL_UPSTREAM_DATA{})
 	network.RegisterMessage(net.REL_CLI_DOWNSTREAM_DATA{})
 	network.RegisterMessage(net.CLI_REL_OPENCLOSED_DATA{})
+	network.RegisterMessage(net.CLI_REL_DOWNSTREAM_NACK{})
 	network.RegisterMessage(net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG{})
+	network.RegisterMessage(net.REL_CLI_ASK_EPH_PK{})
 	network.RegisterMessage(net.REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE{})
 	network.RegisterMessage(net.REL_TRU_TELL_TRANSCRIPT{})
 	network.RegisterMessage(net.TRU_REL_DC_CIPHER{})
 	network.RegisterMessage(net.REL_TRU_TELL_RATE_CHANGE{})
+	network.RegisterMessage(net.REL_TRU_TELL_EPOCH{})
+	network.RegisterMessage(net.REL_TRU_TELL_SLOT_LENGTH{})
+	network.RegisterMessage(net.REL_TRU_DOWNSTREAM_DIGESTS{})
+	network.RegisterMessage(net.TRU_REL_DOWNSTREAM_EQUIVOCATION{})
 	network.RegisterMessage(net.TRU_REL_SHUFFLE_SIG{})
 	network.RegisterMessage(net.TRU_REL_TELL_NEW_BASE_AND_EPH_PKS{})
 	network.RegisterMessage(net.TRU_REL_TELL_PK{})
@@ -149,7 +227,9 @@ func (p *PriFiSDAProtocol) handleTimeout(clientsIds []int, trusteesIds []int) {
 	trustees := make([]string, len(trusteesIds))
 
 	for i, v := range clientsIds {
-		clients[i] = p.ms.clients[v].ServerIdentity.Address.String()
+		if _, client, ok := p.ms.clientRoute(v); ok {
+			clients[i] = client.ServerIdentity.Address.String()
+		}
 	}
 
 	for i, v := range trusteesIds {
@@ -193,6 +273,10 @@ func (p *PriFiSDAProtocol) registerHandlers() error {
 	if err != nil {
 		return errors.New("couldn't register handler: " + err.Error())
 	}
+	err = p.RegisterHandler(p.Received_REL_CLI_ASK_EPH_PK)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
 
 	//register relay handlers
 	err = p.RegisterHandler(p.Received_CLI_REL_TELL_PK_AND_EPH_PK)
@@ -223,6 +307,10 @@ func (p *PriFiSDAProtocol) registerHandlers() error {
 	if err != nil {
 		return errors.New("couldn't register handler: " + err.Error())
 	}
+	err = p.RegisterHandler(p.Received_CLI_REL_DOWNSTREAM_NACK)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
 
 	//register trustees handlers
 	err = p.RegisterHandler(p.Received_REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE)
@@ -237,6 +325,22 @@ func (p *PriFiSDAProtocol) registerHandlers() error {
 	if err != nil {
 		return errors.New("couldn't register handler: " + err.Error())
 	}
+	err = p.RegisterHandler(p.Received_REL_TRU_TELL_EPOCH)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_REL_TRU_TELL_SLOT_LENGTH)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_REL_TRU_DOWNSTREAM_DIGESTS)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
+	err = p.RegisterHandler(p.Received_TRU_REL_DOWNSTREAM_EQUIVOCATION)
+	if err != nil {
+		return errors.New("couldn't register handler: " + err.Error())
+	}
 
 	//register blame procedure handlers
 	err = p.RegisterHandler(p.Received_REL_CLI_DISRUPTED_ROUND)
@@ -0,0 +1,213 @@
+package protocols
+
+/*
+ * A systematic Reed-Solomon erasure code over GF(2^8), used by the FEC layer of the UDP broadcast.
+ * A message is cut into dataShards shards of equal length, and parityShards parity shards are computed with a Cauchy
+ * matrix; the message can be rebuilt from any dataShards of the dataShards+parityShards shards.
+ */
+
+import (
+	"errors"
+	"strconv"
+)
+
+// the primitive polynomial x^8 + x^4 + x^3 + x^2 + 1 of GF(2^8)
+const gfPolynomial = 0x11d
+
+var gfExp [510]byte
+var gfLog [256]int
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPolynomial
+		}
+	}
+}
+
+// gfMul multiplies a and b in GF(2^8)
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+// gfInv returns the inverse of a (non-zero) in GF(2^8)
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+// reedSolomon encodes messages into dataShards+parityShards shards
+type reedSolomon struct {
+	dataShards   int
+	parityShards int
+	matrix       [][]byte // the (dataShards+parityShards) x dataShards encoding matrix; its first rows are the identity
+}
+
+// newReedSolomon returns a code with "dataShards" data shards and "parityShards" parity shards
+func newReedSolomon(dataShards, parityShards int) (*reedSolomon, error) {
+	if dataShards < 1 || parityShards < 0 || dataShards+parityShards > 256 {
+		e := "Reed-Solomon : cannot use " + strconv.Itoa(dataShards) + " data shards and " + strconv.Itoa(parityShards) +
+			" parity shards, need at least one data shard, and at most 256 shards"
+		return nil, errors.New(e)
+	}
+
+	matrix := make([][]byte, dataShards+parityShards)
+	for r := range matrix {
+		matrix[r] = make([]byte, dataShards)
+		if r < dataShards {
+			matrix[r][r] = 1
+			continue
+		}
+		// Cauchy matrix 1/(x_r + y_c), with x_r = r and y_c = c all distinct : all its square sub-matrices are
+		// invertible, hence any dataShards rows of the encoding matrix are
+		for c := 0; c < dataShards; c++ {
+			matrix[r][c] = gfInv(byte(r) ^ byte(c))
+		}
+	}
+
+	return &reedSolomon{dataShards, parityShards, matrix}, nil
+}
+
+// totalShards returns the number of shards of each message
+func (rs *reedSolomon) totalShards() int {
+	return rs.dataShards + rs.parityShards
+}
+
+// encode cuts "data" into data shards, padded with zeros, and appends the parity shards
+func (rs *reedSolomon) encode(data []byte) [][]byte {
+	shardLength := (len(data) + rs.dataShards - 1) / rs.dataShards
+	shards := make([][]byte, rs.totalShards())
+	for i := range shards {
+		shards[i] = make([]byte, shardLength)
+	}
+	for i := 0; i < rs.dataShards; i++ {
+		start := i * shardLength
+		if start < len(data) {
+			copy(shards[i], data[start:])
+		}
+	}
+
+	for r := rs.dataShards; r < rs.totalShards(); r++ {
+		for c := 0; c < rs.dataShards; c++ {
+			coefficient := rs.matrix[r][c]
+			for b, value := range shards[c] {
+				shards[r][b] ^= gfMul(coefficient, value)
+			}
+		}
+	}
+	return shards
+}
+
+// decode rebuilds the "length" bytes of a message from its shards, where the missing ones are nil. It needs at least
+// dataShards shards
+func (rs *reedSolomon) decode(shards [][]byte, length int) ([]byte, error) {
+	if len(shards) != rs.totalShards() {
+		e := "Reed-Solomon : expected " + strconv.Itoa(rs.totalShards()) + " shards, got " + strconv.Itoa(len(shards))
+		return nil, errors.New(e)
+	}
+
+	// pick the first dataShards shards received
+	rows := make([]int, 0, rs.dataShards)
+	shardLength := -1
+	for i, shard := range shards {
+		if shard == nil || len(rows) == rs.dataShards {
+			continue
+		}
+		if shardLength != -1 && len(shard) != shardLength {
+			return nil, errors.New("Reed-Solomon : shards have different lengths")
+		}
+		shardLength = len(shard)
+		rows = append(rows, i)
+	}
+	if len(rows) < rs.dataShards {
+		e := "Reed-Solomon : cannot decode with " + strconv.Itoa(len(rows)) + " shards, need " + strconv.Itoa(rs.dataShards)
+		return nil, errors.New(e)
+	}
+	if length > shardLength*rs.dataShards {
+		return nil, errors.New("Reed-Solomon : message length " + strconv.Itoa(length) + " is bigger than the shards")
+	}
+
+	data := make([]byte, shardLength*rs.dataShards)
+	if rows[rs.dataShards-1] == rs.dataShards-1 {
+		// no data shard is missing
+		for i := 0; i < rs.dataShards; i++ {
+			copy(data[i*shardLength:], shards[i])
+		}
+		return data[:length], nil
+	}
+
+	sub := make([][]byte, rs.dataShards)
+	for i, r := range rows {
+		sub[i] = rs.matrix[r]
+	}
+	inverse, err := gfInvertMatrix(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < rs.dataShards; i++ {
+		out := data[i*shardLength : (i+1)*shardLength]
+		for j, r := range rows {
+			coefficient := inverse[i][j]
+			if coefficient == 0 {
+				continue
+			}
+			for b, value := range shards[r] {
+				out[b] ^= gfMul(coefficient, value)
+			}
+		}
+	}
+	return data[:length], nil
+}
+
+// gfInvertMatrix inverts the square matrix "m" in GF(2^8) by Gauss-Jordan elimination
+func gfInvertMatrix(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	work := make([][]byte, n)
+	for i := range m {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot == -1 {
+			return nil, errors.New("Reed-Solomon : singular matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := gfInv(work[col][col])
+		for c := range work[col] {
+			work[col][c] = gfMul(work[col][c], scale)
+		}
+		for r := 0; r < n; r++ {
+			factor := work[r][col]
+			if r == col || factor == 0 {
+				continue
+			}
+			for c := range work[r] {
+				work[r][c] ^= gfMul(factor, work[col][c])
+			}
+		}
+	}
+
+	inverse := make([][]byte, n)
+	for i := range work {
+		inverse[i] = work[i][n:]
+	}
+	return inverse, nil
+}
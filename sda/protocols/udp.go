@@ -5,17 +5,23 @@ package protocols
  * When emulating in localhost with thread, we cannot use UDP broadcast (network interfaces usually ignore their self-sent messages),
  * hence this UDPChannel has two implementations : the classical UDP, and a cheating, localhost, fake-UDP broadcast done through go
  * channels.
+ * The classical UDP broadcasts on a multicast group (IPv4 or IPv6) configured in prifi.toml, and cuts the messages into
+ * datagrams, see fragments.go.
  */
 
 import (
-	"math/rand"
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
 	"net"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 
-	"encoding/binary"
 	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // MULTICAST_ADDR is the address used for multicasting
@@ -27,6 +33,9 @@ const UDP_PORT int = 10101
 // MAX_UDP_SIZE is the max size of one broadcasted packet
 const MAX_UDP_SIZE int = 65507
 
+// UDP_MULTICAST_TTL is the default TTL of the broadcasted packets : they stay on the local network
+const UDP_MULTICAST_TTL int = 1
+
 // FAKE_LOCAL_UDP_SIMULATED_LOSS_PERCENTAGE is the simulated loss percentage when we use a non-lossy local chanel
 const FAKE_LOCAL_UDP_SIMULATED_LOSS_PERCENTAGE = 0
 
@@ -47,57 +56,190 @@ type UDPChannel interface {
 
 	//we take an empty MarshallableMessage as input, because the method does know how to parse the message
 	ListenAndBlock(msg MarshallableMessage, lastSeenMessage int, identityListening string) (interface{}, error)
+
+	//SessionID returns the ID of this protocol run, drawn by the relay
+	SessionID() uint32
+
+	//SetSessionID sets the ID of this protocol run, the messages of other runs are dropped
+	SetSessionID(sessionID uint32)
+}
+
+//UDPConfig is the configuration of the real UDP channel
+type UDPConfig struct {
+	MulticastAddress string // an IPv4 or IPv6 multicast group
+	Port             int
+	Interface        string // the name of the network interface, empty for the default one
+	TTL              int    // the TTL (hop limit in IPv6) of the datagrams
+	MaxDatagramSize  int    // the messages are cut into datagrams of at most this size
+	MaxMessageSize   int    // the fragments of longer messages are dropped, 0 for no limit
+}
+
+//DefaultUDPConfig returns the historical configuration : 224.0.0.1:10101, datagrams of MAX_UDP_SIZE
+func DefaultUDPConfig() UDPConfig {
+	return UDPConfig{
+		MulticastAddress: MULTICAST_ADDR,
+		Port:             UDP_PORT,
+		TTL:              UDP_MULTICAST_TTL,
+		MaxDatagramSize:  MAX_UDP_SIZE,
+	}
+}
+
+//multicastGroup returns the address of the multicast group, and the network to use ("udp4" or "udp6")
+func (c UDPConfig) multicastGroup() (*net.UDPAddr, string, error) {
+	ip := net.ParseIP(c.MulticastAddress)
+	if ip == nil || !ip.IsMulticast() {
+		return nil, "", errors.New("udp.go : " + c.MulticastAddress + " is not a multicast address")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return nil, "", errors.New("udp.go : invalid port " + strconv.Itoa(c.Port))
+	}
+	network := "udp6"
+	if ip.To4() != nil {
+		network = "udp4"
+	}
+	return &net.UDPAddr{IP: ip, Port: c.Port}, network, nil
+}
+
+//maxFragments returns the number of datagrams a message of MaxMessageSize bytes is cut into, 0 for no limit
+func (c UDPConfig) maxFragments() int {
+	if c.MaxMessageSize <= 0 {
+		return 0
+	}
+	fragmentSize := c.MaxDatagramSize - UDP_FRAGMENT_HEADER_SIZE
+	return (c.MaxMessageSize + fragmentSize - 1) / fragmentSize
+}
+
+//networkInterface returns the network interface to use, nil for the default one
+func (c UDPConfig) networkInterface() (*net.Interface, error) {
+	if c.Interface == "" {
+		return nil, nil
+	}
+	return net.InterfaceByName(c.Interface)
 }
 
 /**
  * The localhost, non-udp, cheating udp channel that uses go-channels to transmit information.
- * It has perfect orderding, and no loss.
+ * It has perfect orderding, and loses FAKE_LOCAL_UDP_SIMULATED_LOSS_PERCENTAGE % of the messages, unless the models of
+ * the network of the listeners are set, see udp_models.go.
+ */
+func newLocalhostUDPChannel() *LocalhostChannel {
+	return newLossyLocalhostUDPChannel(FAKE_LOCAL_UDP_SIMULATED_LOSS_PERCENTAGE)
+}
+
+/**
+ * The localhost channel, where each listener misses lossPercentage % of the messages.
+ */
+func newLossyLocalhostUDPChannel(lossPercentage int) *LocalhostChannel {
+	lc := &LocalhostChannel{
+		messages:  make(map[int]localhostMessage),
+		listeners: make(map[string]*localhostListener),
+		models:    make(map[string]*ChannelModel),
+	}
+	if lossPercentage > 0 {
+		lc.defaultModel = &ChannelModel{Loss: &BernoulliLoss{Probability: float64(lossPercentage) / 100}}
+	}
+	return lc
+}
+
+//the fake channel shared by all the nodes of this process, when the .toml asks for UDPSimulated
+var sharedLocalhostChannel struct {
+	sync.Once
+	channel *LocalhostChannel
+}
+
+/**
+ * The localhost channel shared by all the nodes running in this process, with the models of the .toml. The models are
+ * set by the first node only.
  */
-func newLocalhostUDPChannel() UDPChannel {
-	return &LocalhostChannel{}
+func sharedLocalhostUDPChannel(models []UDPSimulationModel) UDPChannel {
+	sharedLocalhostChannel.Do(func() {
+		sharedLocalhostChannel.channel = newLocalhostUDPChannel()
+		if err := sharedLocalhostChannel.channel.setSimulationModels(models); err != nil {
+			log.Error("Could not set the models of the simulated UDP channel, error is", err.Error())
+		}
+	})
+	return sharedLocalhostChannel.channel
 }
 
 /**
  * The real UDP thing. IT DOES NOT WORK IN LOCAL, as network interfaces usually ignore self-sent broadcasted messages.
+ * It starts a new session, the clients are given its ID.
  */
-func newRealUDPChannel() UDPChannel {
-	return &RealUDPChannel{}
+func newRealUDPChannel(config UDPConfig) UDPChannel {
+	var sessionID [4]byte
+	if _, err := crand.Read(sessionID[:]); err != nil {
+		log.Error("Could not draw a UDP session ID, error is", err.Error())
+	}
+	if config.MaxDatagramSize <= UDP_FRAGMENT_HEADER_SIZE || config.MaxDatagramSize > MAX_UDP_SIZE {
+		config.MaxDatagramSize = MAX_UDP_SIZE
+	}
+	return &RealUDPChannel{
+		config:    config,
+		sessionID: binary.BigEndian.Uint32(sessionID[:]),
+	}
 }
 
+// FAKE_LOCAL_UDP_BUFFERED_MESSAGES is the number of messages the fake local channel keeps for slow listeners
+const FAKE_LOCAL_UDP_BUFFERED_MESSAGES = 4096
+
 //LocalhostChannel is the fake, local UDP channel that uses channels
 type LocalhostChannel struct {
 	sync.RWMutex
-	lastMessageID int //the first real message has ID 1, as the struct puts in a 0 when initialized
-	lastMessage   []byte
+	lastMessageID int                      //the first real message has ID 1
+	messages      map[int]localhostMessage //the last FAKE_LOCAL_UDP_BUFFERED_MESSAGES messages, by ID
+	listeners     map[string]*localhostListener
+	defaultModel  *ChannelModel            //the model of the listeners without one of their own, nil for a perfect network
+	models        map[string]*ChannelModel //the model of the network of each listener
+	sessionID     uint32                   //all the listeners are in this process, there is no previous run
+}
+
+//localhostMessage is a message broadcast on the LocalhostChannel
+type localhostMessage struct {
+	data   []byte
+	sentAt time.Time
+}
+
+//localhostListener is the state of one listener of the LocalhostChannel
+type localhostListener struct {
+	model         *ChannelModel //its own copy, the loss models have a state
+	lastScheduled int           //the ID of the last message that went through the model
+	scheduled     []scheduledMessage
+}
+
+//scheduledMessage is a message which will be received by a listener at some time
+type scheduledMessage struct {
+	id int
+	at time.Time
 }
 
 //RealUDPChannel is the real UDP channel
 type RealUDPChannel struct {
-	relayConn *net.UDPConn
-	localConn *net.UDPConn
+	sync.Mutex
+	config         UDPConfig
+	sessionID      uint32
+	nextSequence   uint32 // the sequence number of the next message broadcast
+	relayConn      *net.UDPConn
+	multicastGroup *net.UDPAddr
+	localConn      *net.UDPConn
+	reassembler    *reassembler
 }
 
 //Broadcast of LocalhostChannel is the implementation of broadcast for the fake localhost channel
 func (lc *LocalhostChannel) Broadcast(msg MarshallableMessage) error {
 
-	lc.Lock()
-	defer lc.Unlock()
-
-	if lc.lastMessage == nil {
-
-		log.Lvl4("Broadcast - setting msg # to 0")
-		lc.lastMessageID = 0
-		lc.lastMessage = make([]byte, 0)
-	}
-
 	data, err := msg.ToBytes()
 	if err != nil {
 		log.Error("Broadcast: could not marshal message, error is", err.Error())
+		return err
 	}
 
-	//append message to the buffer bool
-	lc.lastMessage = data
+	lc.Lock()
+	defer lc.Unlock()
+
+	//append message to the buffer, and forget the oldest one
 	lc.lastMessageID++
+	lc.messages[lc.lastMessageID] = localhostMessage{data: data, sentAt: time.Now()}
+	delete(lc.messages, lc.lastMessageID-FAKE_LOCAL_UDP_BUFFERED_MESSAGES)
 	log.Lvl4("Broadcast - added message, new message has Id ", lc.lastMessageID, ".")
 
 	return nil
@@ -106,76 +248,249 @@ func (lc *LocalhostChannel) Broadcast(msg MarshallableMessage) error {
 //ListenAndBlock of LocalhostChannel is the implementation of message reception for the fake localhost channel
 func (lc *LocalhostChannel) ListenAndBlock(emptyMessage MarshallableMessage, lastSeenMessage int, identityListening string) (interface{}, error) {
 
-	//we wait until there is a new message
-	lc.RLock()
-	defer lc.RUnlock()
+	lc.Lock()
+	defer lc.Unlock()
 
-	//our channel is lossy. we decide "in advance" if we will miss next message
-	r := rand.Intn(100)
-	willIgnoreNextMessage := false
-	if r < FAKE_LOCAL_UDP_SIMULATED_LOSS_PERCENTAGE {
-		willIgnoreNextMessage = true
+	//each listener goes through the messages in order; lastSeenMessage only matters for a new listener
+	l, found := lc.listeners[identityListening]
+	if !found {
+		l = &localhostListener{lastScheduled: lastSeenMessage}
+		lc.listeners[identityListening] = l
 	}
-
-	if willIgnoreNextMessage {
-		log.Lvl4("ListenAndBlock : Lossy UDP (loss", FAKE_LOCAL_UDP_SIMULATED_LOSS_PERCENTAGE, "%), we will ignore message coming after", lastSeenMessage, "and wait for message after", (lastSeenMessage + 1))
-		lastSeenMessage++
+	if l.model == nil {
+		l.model = lc.modelOf(identityListening)
 	}
 
-	log.Lvl4("ListenAndBlock - waiting on message ", (lastSeenMessage + 1), ".")
+	for {
+		//the new messages go through the network of the listener
+		for l.lastScheduled < lc.lastMessageID {
+			l.lastScheduled++
+			if oldest := lc.lastMessageID - FAKE_LOCAL_UDP_BUFFERED_MESSAGES + 1; l.lastScheduled < oldest {
+				log.Lvl3("ListenAndBlock - listener", identityListening, "is too slow, skipping to message", oldest)
+				l.lastScheduled = oldest
+			}
+			at, received := l.model.deliveryTime(lc.messages[l.lastScheduled].sentAt)
+			if !received {
+				log.Lvl4("ListenAndBlock : Lossy UDP, listener", identityListening, "loses message", l.lastScheduled)
+				continue
+			}
+			l.schedule(scheduledMessage{id: l.lastScheduled, at: at})
+		}
+
+		//we return the first message received, if any
+		wait := 5 * time.Millisecond
+		if len(l.scheduled) > 0 {
+			first := l.scheduled[0]
+			now := time.Now()
+			if !first.at.After(now) {
+				l.scheduled = l.scheduled[1:]
+				msg, found := lc.messages[first.id]
+				if !found {
+					continue
+				}
+				log.Lvl4("ListenAndBlock - returning message n°" + strconv.Itoa(first.id) + ".")
+				return emptyMessage.FromBytes(msg.data)
+			}
+			if first.at.Sub(now) < wait {
+				wait = first.at.Sub(now)
+			}
+		}
 
-	for lc.lastMessageID == lastSeenMessage {
 		//unlock before wait !
-		lc.RUnlock()
+		lc.Unlock()
+		log.Lvl5("ListenAndBlock - last message is ", l.lastScheduled, ", waiting.")
+		time.Sleep(wait)
+		lc.Lock()
+	}
+}
+
+//schedule adds "msg" to the messages to receive, in order of reception
+func (l *localhostListener) schedule(msg scheduledMessage) {
+	i := sort.Search(len(l.scheduled), func(i int) bool {
+		return l.scheduled[i].at.After(msg.at)
+	})
+	l.scheduled = append(l.scheduled, scheduledMessage{})
+	copy(l.scheduled[i+1:], l.scheduled[i:])
+	l.scheduled[i] = msg
+}
 
-		log.Lvl5("ListenAndBlock - last message is ", (lc.lastMessageID + 1), ", waiting.")
-		time.Sleep(5 * time.Millisecond)
-		lc.RLock()
+//modelOf returns a copy of the model of the network of the listener "identity". The lock must be held
+func (lc *LocalhostChannel) modelOf(identity string) *ChannelModel {
+	if model, found := lc.models[identity]; found {
+		return model.copy(identity)
 	}
+	return lc.defaultModel.copy(identity)
+}
 
-	log.Lvl4("ListenAndBlock - returning message n°" + strconv.Itoa(lastSeenMessage+1) + ".")
-	//there's one
-	lastMsg := lc.lastMessage
+//SetChannelModel sets the model of the network of the listener "identity", e.g., "client-0". A nil model is a perfect
+//network
+func (lc *LocalhostChannel) SetChannelModel(identity string, model *ChannelModel) {
+	lc.Lock()
+	defer lc.Unlock()
+	lc.models[identity] = model
+	if l, found := lc.listeners[identity]; found {
+		l.model = nil
+	}
+}
 
-	emptyMessage.FromBytes(lastMsg)
+//SetDefaultChannelModel sets the model of the network of the listeners without one of their own. A nil model is a
+//perfect network
+func (lc *LocalhostChannel) SetDefaultChannelModel(model *ChannelModel) {
+	lc.Lock()
+	defer lc.Unlock()
+	lc.defaultModel = model
+	for identity, l := range lc.listeners {
+		if _, found := lc.models[identity]; !found {
+			l.model = nil
+		}
+	}
+}
+
+//setSimulationModels sets the models of the network of the clients described in the .toml
+func (lc *LocalhostChannel) setSimulationModels(models []UDPSimulationModel) error {
+	for _, m := range models {
+		model, err := m.channelModel()
+		if err != nil {
+			return err
+		}
+		if m.ClientID < 0 {
+			lc.SetDefaultChannelModel(model)
+		} else {
+			lc.SetChannelModel("client-"+strconv.Itoa(m.ClientID), model)
+		}
+	}
+	return nil
+}
 
-	return emptyMessage, nil
+//SessionID of LocalhostChannel returns the ID of the session
+func (lc *LocalhostChannel) SessionID() uint32 {
+	lc.RLock()
+	defer lc.RUnlock()
+	return lc.sessionID
+}
+
+//SetSessionID of LocalhostChannel sets the ID of the session
+func (lc *LocalhostChannel) SetSessionID(sessionID uint32) {
+	lc.Lock()
+	defer lc.Unlock()
+	lc.sessionID = sessionID
+}
+
+//SessionID of RealUDPChannel returns the ID of the session
+func (c *RealUDPChannel) SessionID() uint32 {
+	c.Lock()
+	defer c.Unlock()
+	return c.sessionID
+}
+
+//SetSessionID of RealUDPChannel sets the ID of the session, the datagrams of other sessions are dropped
+func (c *RealUDPChannel) SetSessionID(sessionID uint32) {
+	c.Lock()
+	defer c.Unlock()
+	if sessionID != c.sessionID {
+		log.Lvl3("UDP channel : switching to session", sessionID)
+		c.sessionID = sessionID
+		c.reassembler = nil
+	}
+}
+
+//dialMulticast opens the connection to broadcast on the multicast group, with the interface and TTL configured
+func (c *RealUDPChannel) dialMulticast() error {
+	group, network, err := c.config.multicastGroup()
+	if err != nil {
+		return err
+	}
+	ifi, err := c.config.networkInterface()
+	if err != nil {
+		return errors.New("udp.go : could not find interface " + c.config.Interface + ", error is " + err.Error())
+	}
+
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return errors.New("udp.go : could not open a UDP socket, error is " + err.Error())
+	}
+	if network == "udp4" {
+		p := ipv4.NewPacketConn(conn)
+		if ifi != nil {
+			err = p.SetMulticastInterface(ifi)
+		}
+		if err == nil {
+			err = p.SetMulticastTTL(c.config.TTL)
+		}
+	} else {
+		p := ipv6.NewPacketConn(conn)
+		if ifi != nil {
+			err = p.SetMulticastInterface(ifi)
+		}
+		if err == nil {
+			err = p.SetMulticastHopLimit(c.config.TTL)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return errors.New("udp.go : could not configure the multicast socket, error is " + err.Error())
+	}
+
+	c.relayConn = conn
+	c.multicastGroup = group
+	return nil
 }
 
 //Broadcast of RealUDPChannel is the implementation of broadcast for the real UDP channel
 func (c *RealUDPChannel) Broadcast(msg MarshallableMessage) error {
+	c.Lock()
+	defer c.Unlock()
 
 	//if we're not ready with the connnection yet
 	if c.relayConn == nil {
-		ServerAddr, err := net.ResolveUDPAddr("udp", MULTICAST_ADDR+":"+strconv.Itoa(UDP_PORT))
-		if err != nil {
-			log.Error("Broadcast: could not resolve multicast address, error is", err.Error())
-		}
-
-		c.relayConn, err = net.DialUDP("udp", nil, ServerAddr)
-		if err != nil {
-			log.Error("Broadcast: could not UDP Dial, error is", err.Error())
+		if err := c.dialMulticast(); err != nil {
+			log.Error("Broadcast:", err)
+			return err
 		}
-
 		//TODO : connection is never closed
 	}
 
 	data, err := msg.ToBytes()
 	if err != nil {
 		log.Error("Broadcast: could not marshal message, error is", err.Error())
+		return err
+	}
+
+	datagrams, err := fragmentMessage(c.sessionID, c.nextSequence, data, c.config.MaxDatagramSize)
+	if err != nil {
+		log.Error("Broadcast:", err)
+		return err
 	}
+	c.nextSequence++
 
-	message := make([]byte, 4+len(data))
-	binary.BigEndian.PutUint32(message[0:4], uint32(len(data)))
-	copy(message[4:], data)
+	for _, datagram := range datagrams {
+		if _, err := c.relayConn.WriteToUDP(datagram, c.multicastGroup); err != nil {
+			log.Error("Broadcast: could not write message, error is", err.Error())
+			return err
+		}
+	}
+	log.Lvl4("Broadcast: broadcasted one message of length", len(data), "in", len(datagrams), "datagrams")
+
+	return nil
+}
 
-	_, err = c.relayConn.Write(message)
+//listenMulticast joins the multicast group on the interface configured
+func (c *RealUDPChannel) listenMulticast() error {
+	group, network, err := c.config.multicastGroup()
 	if err != nil {
-		log.Error("Broadcast: could not write message, error is", err.Error())
-	} else {
-		log.Lvl4("Broadcast: broadcasted one message of length", len(message))
+		return err
+	}
+	ifi, err := c.config.networkInterface()
+	if err != nil {
+		return errors.New("udp.go : could not find interface " + c.config.Interface + ", error is " + err.Error())
 	}
 
+	conn, err := net.ListenMulticastUDP(network, ifi, group)
+	if err != nil {
+		return errors.New("udp.go : could not join the multicast group " + group.String() + ", error is " + err.Error())
+	}
+	conn.SetReadBuffer(MAX_UDP_SIZE)
+	c.localConn = conn
 	return nil
 }
 
@@ -184,41 +499,40 @@ func (c *RealUDPChannel) ListenAndBlock(emptyMessage MarshallableMessage, lastSe
 
 	//if we're not ready with the connection yet
 	if c.localConn == nil {
-
-		mcastAddr, err := net.ResolveUDPAddr("udp", MULTICAST_ADDR+":"+strconv.Itoa(UDP_PORT))
-		if err != nil {
-			log.Error("ListenAndBlock(", identityListening, "): could not resolve BCast address, error is", err.Error())
+		if err := c.listenMulticast(); err != nil {
+			log.Error("ListenAndBlock(", identityListening, "):", err)
+			return nil, err
 		}
-
-		c.localConn, err = net.ListenMulticastUDP("udp", nil, mcastAddr)
-		if err != nil {
-			log.Error("ListenAndBlock(", identityListening, "): could not UDP Dial, error is", err.Error())
-		}
-
-		log.Lvl4("ListenAndBlock(", identityListening, "): listening on", mcastAddr)
-		c.localConn.SetReadBuffer(MAX_UDP_SIZE)
+		log.Lvl4("ListenAndBlock(", identityListening, "): listening on", c.config.MulticastAddress, "port", c.config.Port)
 	}
 
 	buf := make([]byte, MAX_UDP_SIZE)
-	n, addr, err := c.localConn.ReadFromUDP(buf)
-
-	log.Lvl4("ListenAndBlock(", identityListening, "): Received a UDP message of length", n, "from", addr)
-	sizeAdvertised := int(binary.BigEndian.Uint32(buf[0:4]))
+	for {
+		n, addr, err := c.localConn.ReadFromUDP(buf)
+		if err != nil {
+			log.Error("ListenAndBlock(", identityListening, "): could not receive message, error is", err.Error())
+			return nil, err
+		}
+		log.Lvl4("ListenAndBlock(", identityListening, "): Received a UDP message of length", n, "from", addr)
 
-	if sizeAdvertised+4 != n {
-		log.Error("ListenAndBlock(", identityListening, "): could not receive read the ", string(sizeAdvertised+4), ", only", n, ", error is", err.Error())
-	}
-	message := make([]byte, sizeAdvertised)
-	copy(message[:], buf[4:sizeAdvertised+4])
+		datagram := make([]byte, n)
+		copy(datagram, buf[:n])
 
-	if err != nil {
-		log.Error("ListenAndBlock(", identityListening, "): could not receive message, error is", err.Error())
-	}
+		c.Lock()
+		if c.reassembler == nil {
+			c.reassembler = newReassembler(c.sessionID, c.config.maxFragments())
+		}
+		message, complete := c.reassembler.add(datagram)
+		c.Unlock()
+		if !complete {
+			continue
+		}
 
-	newMessage, err3 := emptyMessage.FromBytes(message)
-	if err3 != nil {
-		log.Error("ListenAndBlock(", identityListening, "): could not unmarshall message, error3 is", err3.Error())
+		newMessage, err := emptyMessage.FromBytes(message)
+		if err != nil {
+			log.Error("ListenAndBlock(", identityListening, "): could not unmarshall message, error is", err.Error())
+			return nil, err
+		}
+		return newMessage, nil
 	}
-
-	return newMessage, nil
 }
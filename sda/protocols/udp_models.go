@@ -0,0 +1,186 @@
+package protocols
+
+/*
+ * Models of the network between the relay and each client, for the fake localhost UDP channel. A ChannelModel tells
+ * which messages a client misses (the loss model), and when it gets the others : after Delay, plus a random jitter up
+ * to Jitter, plus ReorderDelay for the messages held back with probability Reorder. A jitter larger than the time
+ * between two messages, or a message held back, reorders them.
+ * The loss models are Bernoulli (independent losses) and Gilbert-Elliott (bursts of losses).
+ * Each client draws from its own source of randomness, seeded with the Seed of its model and its identity : with the same
+ * seed, a simulation loses and delays the same messages.
+ */
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// LOSS_MODEL_BERNOULLI names the BernoulliLoss model in prifi.toml
+const LOSS_MODEL_BERNOULLI = "bernoulli"
+
+// LOSS_MODEL_GILBERT_ELLIOTT names the GilbertElliottLoss model in prifi.toml
+const LOSS_MODEL_GILBERT_ELLIOTT = "gilbert-elliott"
+
+// LossModel decides which messages a client misses
+type LossModel interface {
+	// Lose is called for each message, in order, and returns true if it is lost, drawing from "rng"
+	Lose(rng *rand.Rand) bool
+
+	// Copy returns a model with the same parameters and a fresh state, for another client
+	Copy() LossModel
+}
+
+// BernoulliLoss loses each message with the probability Probability, independently
+type BernoulliLoss struct {
+	Probability float64
+}
+
+// Lose returns true with the probability Probability
+func (m *BernoulliLoss) Lose(rng *rand.Rand) bool {
+	return rng.Float64() < m.Probability
+}
+
+// Copy returns a BernoulliLoss with the same probability
+func (m *BernoulliLoss) Copy() LossModel {
+	return &BernoulliLoss{Probability: m.Probability}
+}
+
+// GilbertElliottLoss is a two-state Markov chain : in the good state, messages are lost with the probability LossGood,
+// in the bad state with the probability LossBad. Before each message, the chain moves from good to bad with the
+// probability GoodToBad, and from bad to good with the probability BadToGood : the bursts last 1/BadToGood messages on
+// average.
+type GilbertElliottLoss struct {
+	GoodToBad float64
+	BadToGood float64
+	LossGood  float64
+	LossBad   float64
+	bad       bool
+}
+
+// Lose moves the chain, then returns true with the loss probability of its state
+func (m *GilbertElliottLoss) Lose(rng *rand.Rand) bool {
+	if m.bad {
+		m.bad = rng.Float64() >= m.BadToGood
+	} else {
+		m.bad = rng.Float64() < m.GoodToBad
+	}
+	if m.bad {
+		return rng.Float64() < m.LossBad
+	}
+	return rng.Float64() < m.LossGood
+}
+
+// Copy returns a GilbertElliottLoss with the same parameters, in the good state
+func (m *GilbertElliottLoss) Copy() LossModel {
+	return &GilbertElliottLoss{GoodToBad: m.GoodToBad, BadToGood: m.BadToGood, LossGood: m.LossGood, LossBad: m.LossBad}
+}
+
+// ChannelModel is the network between the relay and one client
+type ChannelModel struct {
+	Loss         LossModel // nil for no loss
+	Delay        time.Duration
+	Jitter       time.Duration
+	Reorder      float64 // the probability of holding a message back by ReorderDelay
+	ReorderDelay time.Duration
+	Seed         int64 // the seed of the random choices, 0 for a random seed
+	rng          *rand.Rand
+}
+
+// random returns the source of the random choices of the model, seeded with Seed
+func (m *ChannelModel) random() *rand.Rand {
+	if m.rng == nil {
+		seed := m.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		m.rng = rand.New(rand.NewSource(seed))
+	}
+	return m.rng
+}
+
+// deliveryTime returns when a message broadcast at "sentAt" is received, or false if it is lost
+func (m *ChannelModel) deliveryTime(sentAt time.Time) (time.Time, bool) {
+	if m == nil {
+		return sentAt, true
+	}
+	rng := m.random()
+	if m.Loss != nil && m.Loss.Lose(rng) {
+		return time.Time{}, false
+	}
+	at := sentAt.Add(m.Delay)
+	if m.Jitter > 0 {
+		at = at.Add(time.Duration(rng.Int63n(int64(m.Jitter) + 1)))
+	}
+	if m.Reorder > 0 && rng.Float64() < m.Reorder {
+		at = at.Add(m.ReorderDelay)
+	}
+	return at, true
+}
+
+// copy returns a ChannelModel with the same parameters, a fresh loss model and its own source of randomness, for the
+// client "identity". With a Seed, the seed of the copy depends on the identity : the clients do not lose the same messages
+func (m *ChannelModel) copy(identity string) *ChannelModel {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	if c.Loss != nil {
+		c.Loss = c.Loss.Copy()
+	}
+	if c.Seed != 0 {
+		h := fnv.New64a()
+		h.Write([]byte(identity))
+		c.Seed ^= int64(h.Sum64())
+	}
+	c.rng = nil
+	return &c
+}
+
+// UDPSimulationModel is the model of the network of one client in prifi.toml, see ChannelModel
+type UDPSimulationModel struct {
+	ClientID       int    // -1 for all the clients without a model of their own
+	LossModel      string // LOSS_MODEL_BERNOULLI, LOSS_MODEL_GILBERT_ELLIOTT, or empty for no loss
+	Loss           float64
+	LossGood       float64 // for LOSS_MODEL_GILBERT_ELLIOTT, Loss is the loss in the bad state
+	BurstStart     float64 // for LOSS_MODEL_GILBERT_ELLIOTT, the probability of moving to the bad state
+	BurstEnd       float64 // for LOSS_MODEL_GILBERT_ELLIOTT, the probability of moving back to the good state
+	DelayMs        int
+	JitterMs       int
+	Reorder        float64
+	ReorderDelayMs int
+	Seed           int64 // the seed of the random choices, 0 for a random seed
+}
+
+// channelModel returns the ChannelModel described
+func (m UDPSimulationModel) channelModel() (*ChannelModel, error) {
+	probabilities := []float64{m.Loss, m.LossGood, m.BurstStart, m.BurstEnd, m.Reorder}
+	for _, p := range probabilities {
+		if p < 0 || p > 1 {
+			return nil, errors.New("udp_models.go : client " + strconv.Itoa(m.ClientID) + " has a probability out of [0, 1]")
+		}
+	}
+	if m.DelayMs < 0 || m.JitterMs < 0 || m.ReorderDelayMs < 0 {
+		return nil, errors.New("udp_models.go : client " + strconv.Itoa(m.ClientID) + " has a negative delay")
+	}
+
+	model := &ChannelModel{
+		Delay:        time.Duration(m.DelayMs) * time.Millisecond,
+		Jitter:       time.Duration(m.JitterMs) * time.Millisecond,
+		Reorder:      m.Reorder,
+		ReorderDelay: time.Duration(m.ReorderDelayMs) * time.Millisecond,
+		Seed:         m.Seed,
+	}
+	switch m.LossModel {
+	case "":
+	case LOSS_MODEL_BERNOULLI:
+		model.Loss = &BernoulliLoss{Probability: m.Loss}
+	case LOSS_MODEL_GILBERT_ELLIOTT:
+		model.Loss = &GilbertElliottLoss{GoodToBad: m.BurstStart, BadToGood: m.BurstEnd, LossGood: m.LossGood, LossBad: m.Loss}
+	default:
+		return nil, errors.New("udp_models.go : unknown loss model " + m.LossModel + " for client " + strconv.Itoa(m.ClientID))
+	}
+	return model, nil
+}
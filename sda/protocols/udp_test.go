@@ -0,0 +1,599 @@
+package protocols
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	prifi_lib "github.com/dedis/prifi/prifi-lib"
+	"github.com/dedis/prifi/prifi-lib/config"
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+func TestReedSolomon(t *testing.T) {
+
+	if _, err := newReedSolomon(0, 2); err == nil {
+		t.Error("Should not accept 0 data shards")
+	}
+	if _, err := newReedSolomon(200, 100); err == nil {
+		t.Error("Should not accept more than 256 shards")
+	}
+
+	code, err := newReedSolomon(4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for length := 1; length < 100; length += 7 {
+		data := make([]byte, length)
+		rand.Read(data)
+		shards := code.encode(data)
+		if len(shards) != 7 {
+			t.Fatal("Should have 7 shards, got", len(shards))
+		}
+
+		// any 3 shards can be lost
+		for i := 0; i < 7; i++ {
+			for j := i + 1; j < 7; j++ {
+				for k := j + 1; k < 7; k++ {
+					received := make([][]byte, 7)
+					copy(received, shards)
+					received[i], received[j], received[k] = nil, nil, nil
+					decoded, err := code.decode(received, length)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(decoded, data) {
+						t.Error("Wrong message rebuilt without shards", i, j, k)
+					}
+				}
+			}
+		}
+
+		received := make([][]byte, 7)
+		copy(received[4:], shards[4:])
+		if _, err := code.decode(received, length); err == nil {
+			t.Error("Should not decode with 3 shards out of 7")
+		}
+	}
+}
+
+// broadcastCells broadcasts "nRounds" downstream cells on "channel", and returns the rounds received by each of
+// "nClients" listeners
+func broadcastCells(channel UDPChannel, nClients, nRounds int) []map[int32]bool {
+
+	type reception struct {
+		client  int
+		roundID int32
+	}
+	receptions := make(chan reception, nClients*nRounds)
+
+	for c := 0; c < nClients; c++ {
+		go func(c int) {
+			lastSeenMessage := 0
+			for {
+				emptyMessage := net.REL_CLI_DOWNSTREAM_DATA_UDP{}
+				filled, err := channel.ListenAndBlock(&emptyMessage, lastSeenMessage, "client-"+strconv.Itoa(c))
+				lastSeenMessage++
+				if err != nil {
+					continue
+				}
+				msg := filled.(net.REL_CLI_DOWNSTREAM_DATA_UDP)
+				receptions <- reception{c, msg.RoundID}
+			}
+		}(c)
+	}
+
+	for r := 0; r < nRounds; r++ {
+		data := make([]byte, 1000)
+		rand.Read(data)
+		msg := &net.REL_CLI_DOWNSTREAM_DATA_UDP{
+			REL_CLI_DOWNSTREAM_DATA: net.REL_CLI_DOWNSTREAM_DATA{
+				RoundID: int32(r),
+				Data:    data,
+			}}
+		channel.Broadcast(msg)
+	}
+
+	received := make([]map[int32]bool, nClients)
+	for c := range received {
+		received[c] = make(map[int32]bool)
+	}
+	for {
+		select {
+		case r := <-receptions:
+			received[r.client][r.roundID] = true
+		case <-time.After(500 * time.Millisecond):
+			return received
+		}
+	}
+}
+
+// seededLossyChannel returns a localhost channel where each listener misses "lossPercentage" % of the messages, with
+// the same random choices at each run
+func seededLossyChannel(lossPercentage int) *LocalhostChannel {
+	lc := newLossyLocalhostUDPChannel(lossPercentage)
+	lc.SetDefaultChannelModel(&ChannelModel{Loss: &BernoulliLoss{Probability: float64(lossPercentage) / 100}, Seed: 1})
+	return lc
+}
+
+func TestLossyLocalhostChannel(t *testing.T) {
+
+	nClients := 3
+	nRounds := 200
+
+	for _, loss := range []int{5, 10} {
+		// without FEC, each client misses a cell, and the round times out, every 100/loss rounds
+		received := broadcastCells(seededLossyChannel(loss), nClients, nRounds)
+		for c := 0; c < nClients; c++ {
+			if len(received[c]) == nRounds {
+				t.Error("Client", c, "should lose cells at", loss, "% loss")
+			}
+		}
+
+		// with 4+3 shards, a cell is lost only if 4 of its 7 datagrams are
+		received = broadcastCells(newFECChannel(seededLossyChannel(loss), 4, 3), nClients, nRounds)
+		for c := 0; c < nClients; c++ {
+			if len(received[c]) < nRounds*98/100 {
+				t.Error("Client", c, "received only", len(received[c]), "cells out of", nRounds, "at", loss, "% loss with FEC")
+			}
+			t.Log("Client", c, "rebuilt", len(received[c]), "cells out of", nRounds, "at", loss, "% loss")
+		}
+	}
+}
+
+// lossyPriFiNetwork runs a relay, its trustees and clients in this process : the messages go through the network
+// encoding, in memory, and the downstream cells through the UDPChannel of the MessageSender
+type lossyPriFiNetwork struct {
+	MessageSender
+	relay    chan interface{}
+	clients  []chan interface{}
+	trustees []chan interface{}
+}
+
+// encoded returns "msg" as the receiver gets it from the network library
+func encoded(msg interface{}) interface{} {
+	if network.MessageType(msg) == network.ErrorType {
+		// registered by the handlers of the protocol, which do not run here
+		network.RegisterMessage(msg)
+	}
+	buf, err := network.Marshal(msg)
+	if err != nil {
+		log.Fatal("Cannot marshal", reflect.TypeOf(msg), err)
+	}
+	_, decoded, err := network.Unmarshal(buf, config.CryptoSuite)
+	if err != nil {
+		log.Fatal("Cannot unmarshal", reflect.TypeOf(msg), err)
+	}
+	return reflect.ValueOf(decoded).Elem().Interface()
+}
+
+func (n *lossyPriFiNetwork) SendToClient(i int, msg interface{}) error {
+	n.clients[i] <- encoded(msg)
+	return nil
+}
+func (n *lossyPriFiNetwork) SendToTrustee(i int, msg interface{}) error {
+	n.trustees[i] <- encoded(msg)
+	return nil
+}
+func (n *lossyPriFiNetwork) SendToRelay(msg interface{}) error {
+	n.relay <- encoded(msg)
+	return nil
+}
+
+// ClientSubscribeToBroadcast of lossyPriFiNetwork hands the cells received to the client with its other messages, one
+// at a time
+func (n *lossyPriFiNetwork) ClientSubscribeToBroadcast(clientID int, messageReceived func(interface{}) error, startStopChan chan bool) error {
+	return n.MessageSender.ClientSubscribeToBroadcast(clientID, func(msg interface{}) error {
+		n.clients[clientID] <- msg
+		return nil
+	}, startStopChan)
+}
+
+// runPriFiOverUDP runs PriFi until the relay reaches "nRounds" rounds, with the downstream cells broadcast on
+// "channel". It returns the time it took, and how many rounds timed out
+func runPriFiOverUDP(t *testing.T, channel UDPChannel, nClients, nRounds int) (time.Duration, int) {
+	n := &lossyPriFiNetwork{MessageSender: MessageSender{udpChannel: channel}, relay: make(chan interface{}, 10000)}
+	deliver := func(queue chan interface{}, entity *prifi_lib.PriFiLibInstance) {
+		for msg := range queue {
+			if err := entity.ReceivedMessage(msg); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+
+	timeOuts := make(chan int, nRounds)
+	resultChan := make(chan interface{}, 1)
+	relay := prifi_lib.NewPriFiRelay(false, make(chan []byte), make(chan []byte, nRounds+10), resultChan,
+		func(clients, trustees []int) { timeOuts <- len(clients) }, n)
+	go deliver(n.relay, relay)
+
+	n.trustees = append(n.trustees, make(chan interface{}, 10000))
+	go deliver(n.trustees[0], prifi_lib.NewPriFiTrustee(false, true, 2, n))
+	for i := 0; i < nClients; i++ {
+		n.clients = append(n.clients, make(chan interface{}, 10000))
+		go deliver(n.clients[i], prifi_lib.NewPriFiClient(false, false, make(chan []byte), make(chan []byte), false, "./", n))
+	}
+
+	params := new(net.ALL_ALL_PARAMETERS)
+	params.Add("StartNow", true)
+	params.Add("NTrustees", 1)
+	params.Add("NClients", nClients)
+	params.Add("PayloadSize", 100)
+	params.Add("DownstreamCellSize", 1000)
+	params.Add("DCNetType", "Simple")
+	params.Add("UseUDP", true)
+	params.Add("WindowSize", 1)
+	params.Add("ExperimentRoundLimit", nRounds)
+	params.Add("RelayRoundTimeOut", 2000)
+	params.Add("RelayMaxNumberOfConsecutiveFailedRounds", 1)
+	params.Add("RelayTrusteeCacheLowBound", 10)
+	params.Add("RelayTrusteeCacheHighBound", 20)
+	params.ForceParams = true
+	start := time.Now()
+	n.relay <- *params
+
+	select {
+	case <-resultChan:
+	case <-time.After(60 * time.Second):
+		t.Fatal("The relay did not reach round", nRounds)
+	}
+	return time.Since(start), len(timeOuts)
+}
+
+func TestPriFiOverLossyLocalhostChannel(t *testing.T) {
+
+	nClients := 3
+	nRounds := 100
+
+	for _, loss := range []int{5, 10} {
+		// each client misses a datagram every 100/loss rounds : with FEC, it rebuilds the cell, the rounds complete
+		// without waiting for a retransmission over TCP, and none times out
+		elapsed, timeOuts := runPriFiOverUDP(t, newFECChannel(seededLossyChannel(loss), 4, 3), nClients, nRounds)
+		if timeOuts != 0 {
+			t.Error(timeOuts, "rounds timed out at", loss, "% loss with FEC")
+		}
+		if elapsed > 3*time.Second {
+			t.Error("The", nRounds, "rounds took", elapsed, "at", loss, "% loss with FEC, the cells were sent again")
+		}
+		t.Log(nRounds, "rounds in", elapsed, "at", loss, "% loss with FEC")
+	}
+}
+
+func TestFragments(t *testing.T) {
+
+	// a cell bigger than a datagram can hold
+	data := make([]byte, 3*MAX_UDP_SIZE)
+	rand.Read(data)
+	datagrams, err := fragmentMessage(7, 0, data, MAX_UDP_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datagrams) != 4 {
+		t.Error("Should cut the message into 4 datagrams, got", len(datagrams))
+	}
+
+	// reordered and duplicated fragments are rebuilt once
+	r := newReassembler(7, 4)
+	order := []int{2, 0, 2, 3}
+	for _, i := range order {
+		if _, complete := r.add(datagrams[i]); complete {
+			t.Fatal("The message should not be complete without fragment 1")
+		}
+	}
+	message, complete := r.add(datagrams[1])
+	if !complete || !bytes.Equal(message, data) {
+		t.Fatal("The message should be rebuilt")
+	}
+	if _, complete := r.add(datagrams[1]); complete {
+		t.Error("A message should be rebuilt only once")
+	}
+
+	// the datagrams of a previous run are dropped, even with a newer sequence number
+	previousRun, _ := fragmentMessage(6, 5, []byte{1, 2, 3}, MAX_UDP_SIZE)
+	if _, complete := r.add(previousRun[0]); complete {
+		t.Error("A datagram of another session should be dropped")
+	}
+
+	// the messages older than the last one rebuilt are dropped
+	newer, _ := fragmentMessage(7, 2, []byte{1, 2, 3}, 14)
+	older, _ := fragmentMessage(7, 1, []byte{4, 5, 6}, 14)
+	if len(newer) != 2 {
+		t.Fatal("Should cut 3 bytes into 2 datagrams of 14 bytes, got", len(newer))
+	}
+	r.add(older[0])
+	r.add(newer[1])
+	if message, complete := r.add(newer[0]); !complete || !bytes.Equal(message, []byte{1, 2, 3}) {
+		t.Error("Message 2 should be rebuilt, got", message)
+	}
+	if _, complete := r.add(older[1]); complete {
+		t.Error("Message 1 should be dropped, message 2 was rebuilt")
+	}
+
+	// a message cut into more fragments than the cells need is dropped
+	tooLong, _ := fragmentMessage(7, 3, data, 1000)
+	for _, datagram := range tooLong {
+		if _, complete := r.add(datagram); complete {
+			t.Fatal("A message of", len(tooLong), "fragments should be dropped, the cells need at most 4")
+		}
+	}
+	if len(r.pending) != 0 {
+		t.Error("The fragments of a message too long should not be kept, got", len(r.pending), "messages")
+	}
+
+	// the fragments of an incomplete message are dropped after a while
+	incomplete, _ := fragmentMessage(7, 4, []byte{1, 2, 3}, 14)
+	r.add(incomplete[0])
+	r.forgetExpired(time.Now())
+	if len(r.pending) != 1 {
+		t.Fatal("The fragments of an incomplete message should be kept for a while")
+	}
+	r.forgetExpired(time.Now().Add(UDP_PENDING_MESSAGE_TIMEOUT + time.Second))
+	if len(r.pending) != 0 {
+		t.Error("The fragments of an incomplete message should be dropped after", UDP_PENDING_MESSAGE_TIMEOUT)
+	}
+	if _, complete := r.add(incomplete[1]); complete {
+		t.Error("A message should not be rebuilt from the fragments received after the timeout only")
+	}
+
+	if _, err := fragmentMessage(7, 0, data, UDP_FRAGMENT_HEADER_SIZE); err == nil {
+		t.Error("Datagrams without room for data should be refused")
+	}
+	if _, err := parseFragment([]byte{1, 2, 3}); err == nil {
+		t.Error("A datagram smaller than the header should be refused")
+	}
+}
+
+func TestUDPConfig(t *testing.T) {
+
+	// the historical configuration when nothing is set
+	var toml *PrifiTomlConfig
+	if toml.udpConfig() != DefaultUDPConfig() {
+		t.Error("Should use the default configuration without a .toml")
+	}
+	toml = &PrifiTomlConfig{UDPMulticastAddress: "ff02::114", UDPPort: 20202, UDPMulticastTTL: 4, UDPMaxDatagramSize: 1400}
+	config := toml.udpConfig()
+	if config.MulticastAddress != "ff02::114" || config.Port != 20202 || config.TTL != 4 || config.MaxDatagramSize != 1400 {
+		t.Error("Should use the configuration of the .toml, got", config)
+	}
+
+	if config.MaxMessageSize != 0 || config.maxFragments() != 0 {
+		t.Error("The messages should not be limited without a cell size, got", config.MaxMessageSize)
+	}
+	withCells := &PrifiTomlConfig{UDPMaxDatagramSize: 1400, CellSizeDown: 10000, PayloadSize: 1000, MaxPayloadSize: 3000}
+	if cells := withCells.udpConfig(); cells.MaxMessageSize != 10000+UDP_MESSAGE_OVERHEAD || cells.maxFragments() != 8 {
+		t.Error("The messages should be limited by the downstream cells, got", cells.MaxMessageSize, "bytes in",
+			cells.maxFragments(), "fragments")
+	}
+
+	group, network, err := config.multicastGroup()
+	if err != nil || network != "udp6" || group.String() != "[ff02::114]:20202" {
+		t.Error("Should broadcast on [ff02::114]:20202 in IPv6, got", group, network, err)
+	}
+	group, network, err = DefaultUDPConfig().multicastGroup()
+	if err != nil || network != "udp4" || group.String() != "224.0.0.1:10101" {
+		t.Error("Should broadcast on 224.0.0.1:10101 in IPv4, got", group, network, err)
+	}
+	if _, _, err := (UDPConfig{MulticastAddress: "10.0.0.1", Port: 10101}).multicastGroup(); err == nil {
+		t.Error("Should refuse an address which is not multicast")
+	}
+	if _, _, err := (UDPConfig{MulticastAddress: MULTICAST_ADDR, Port: 70000}).multicastGroup(); err == nil {
+		t.Error("Should refuse an invalid port")
+	}
+
+	// each channel starts its own session
+	if newRealUDPChannel(config).SessionID() == newRealUDPChannel(config).SessionID() {
+		t.Error("Two channels should not share a session")
+	}
+}
+
+func TestLossModels(t *testing.T) {
+
+	n := 50000
+	bursts := func(model LossModel) (float64, float64) {
+		rng := rand.New(rand.NewSource(1))
+		lost, nBursts := 0, 0
+		previous := false
+		for i := 0; i < n; i++ {
+			l := model.Lose(rng)
+			if l {
+				lost++
+				if !previous {
+					nBursts++
+				}
+			}
+			previous = l
+		}
+		return float64(lost) / float64(n), float64(lost) / float64(nBursts)
+	}
+
+	rate, burst := bursts(&BernoulliLoss{Probability: 0.1})
+	if rate < 0.09 || rate > 0.11 || burst > 1.5 {
+		t.Error("Bernoulli(0.1) should lose 10% of the messages, one at a time, got", rate, burst)
+	}
+
+	// the chain is in the bad state 0.05 / (0.05 + 0.25) of the time, for 4 messages on average
+	model := &GilbertElliottLoss{GoodToBad: 0.05, BadToGood: 0.25, LossBad: 1}
+	rate, burst = bursts(model)
+	if rate < 0.15 || rate > 0.185 || burst < 3.5 || burst > 4.5 {
+		t.Error("Gilbert-Elliott should lose 16.7% of the messages, by bursts of 4, got", rate, burst)
+	}
+	if rate, _ := bursts(model.Copy()); rate < 0.15 || rate > 0.185 {
+		t.Error("A copy should have the same parameters, got a loss of", rate)
+	}
+
+	// with a seed, a client makes the same random choices at each run, but not the same as the other clients
+	seeded := &ChannelModel{Loss: &BernoulliLoss{Probability: 0.5}, Jitter: time.Second, Seed: 42}
+	choices := func(model *ChannelModel) []time.Duration {
+		sentAt := time.Now()
+		delays := make([]time.Duration, 100)
+		for i := range delays {
+			if at, received := model.deliveryTime(sentAt); received {
+				delays[i] = at.Sub(sentAt)
+			} else {
+				delays[i] = -1
+			}
+		}
+		return delays
+	}
+	first := choices(seeded.copy("client-0"))
+	if !reflect.DeepEqual(first, choices(seeded.copy("client-0"))) {
+		t.Error("A client should make the same random choices with the same seed")
+	}
+	if reflect.DeepEqual(first, choices(seeded.copy("client-1"))) {
+		t.Error("Two clients should not make the same random choices")
+	}
+	seeded.Seed = 43
+	if reflect.DeepEqual(first, choices(seeded.copy("client-0"))) {
+		t.Error("A client should make other random choices with another seed")
+	}
+}
+
+func TestSimulationModelsConfig(t *testing.T) {
+
+	config := &PrifiTomlConfig{}
+	_, err := toml.Decode(`
+UDPSimulated = true
+[[UDPSimulatedModels]]
+ClientID = -1
+DelayMs = 10
+Seed = 7
+[[UDPSimulatedModels]]
+ClientID = 1
+LossModel = "gilbert-elliott"
+Loss = 0.5
+BurstStart = 0.02
+BurstEnd = 0.25
+JitterMs = 5
+Reorder = 0.01
+ReorderDelayMs = 20
+`, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.UDPSimulated || len(config.UDPSimulatedModels) != 2 {
+		t.Fatal("Should read the simulated models of the .toml, got", config)
+	}
+
+	lc := newLocalhostUDPChannel()
+	if err := lc.setSimulationModels(config.UDPSimulatedModels); err != nil {
+		t.Fatal(err)
+	}
+	if lc.defaultModel == nil || lc.defaultModel.Delay != 10*time.Millisecond || lc.defaultModel.Loss != nil ||
+		lc.defaultModel.Seed != 7 {
+		t.Error("The model of all the clients should be a delay of 10ms, got", lc.defaultModel)
+	}
+	model := lc.models["client-1"]
+	loss, ok := model.Loss.(*GilbertElliottLoss)
+	if !ok || loss.LossBad != 0.5 || loss.GoodToBad != 0.02 || loss.BadToGood != 0.25 || model.Jitter != 5*time.Millisecond ||
+		model.Reorder != 0.01 || model.ReorderDelay != 20*time.Millisecond {
+		t.Error("Client 1 should have its own model, got", model)
+	}
+
+	invalid := []UDPSimulationModel{
+		{LossModel: LOSS_MODEL_BERNOULLI, Loss: 1.5},
+		{LossModel: "uniform"},
+		{DelayMs: -1},
+	}
+	for _, m := range invalid {
+		if _, err := m.channelModel(); err == nil {
+			t.Error("Should refuse the model", m)
+		}
+	}
+}
+
+// listenRounds returns the rounds of the cells received by "identity" on "channel", in order of reception
+func listenRounds(channel UDPChannel, identity string) chan int32 {
+	rounds := make(chan int32, 1000)
+	go func() {
+		for {
+			filled, err := channel.ListenAndBlock(&net.REL_CLI_DOWNSTREAM_DATA_UDP{}, 0, identity)
+			if err != nil {
+				continue
+			}
+			rounds <- filled.(net.REL_CLI_DOWNSTREAM_DATA_UDP).RoundID
+		}
+	}()
+	return rounds
+}
+
+// collectRounds reads "rounds" until nothing comes for "timeout"
+func collectRounds(rounds chan int32, timeout time.Duration) []int32 {
+	received := make([]int32, 0)
+	for {
+		select {
+		case r := <-rounds:
+			received = append(received, r)
+		case <-time.After(timeout):
+			return received
+		}
+	}
+}
+
+func TestLocalhostChannelModels(t *testing.T) {
+
+	lc := newLocalhostUDPChannel()
+	lc.SetChannelModel("client-1", &ChannelModel{Delay: 100 * time.Millisecond})
+	lc.SetChannelModel("client-2", &ChannelModel{Loss: &BernoulliLoss{Probability: 1}})
+	lc.SetChannelModel("client-3", &ChannelModel{Jitter: 50 * time.Millisecond, Seed: 3})
+	lc.SetChannelModel("client-4", &ChannelModel{Reorder: 0.5, ReorderDelay: 50 * time.Millisecond, Seed: 4})
+
+	listeners := make([]chan int32, 5)
+	for i := range listeners {
+		listeners[i] = listenRounds(lc, "client-"+strconv.Itoa(i))
+	}
+
+	nRounds := 50
+	start := time.Now()
+	for r := 0; r < nRounds; r++ {
+		lc.Broadcast(&net.REL_CLI_DOWNSTREAM_DATA_UDP{
+			REL_CLI_DOWNSTREAM_DATA: net.REL_CLI_DOWNSTREAM_DATA{RoundID: int32(r), Data: []byte{1, 2, 3}}})
+	}
+
+	// client 0 is on a perfect network, client 1 gets the same cells, later
+	<-listeners[0]
+	if time.Since(start) >= 100*time.Millisecond {
+		t.Error("Client 0 should not have any delay")
+	}
+	<-listeners[1]
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("Client 1 should receive its first cell after 100ms")
+	}
+
+	received := make([][]int32, len(listeners))
+	for i := range listeners {
+		received[i] = collectRounds(listeners[i], 200*time.Millisecond)
+	}
+	if len(received[0]) != nRounds-1 || len(received[1]) != nRounds-1 {
+		t.Error("Clients 0 and 1 should receive all the cells, got", len(received[0])+1, len(received[1])+1)
+	}
+	if len(received[2]) != 0 {
+		t.Error("Client 2 should lose all the cells, got", len(received[2]))
+	}
+
+	// the jitter and the cells held back reorder them, but none is lost
+	for i := 3; i <= 4; i++ {
+		reordered := false
+		for j := 1; j < len(received[i]); j++ {
+			if received[i][j] < received[i][j-1] {
+				reordered = true
+			}
+		}
+		if !reordered || len(received[i]) != nRounds {
+			t.Error("Client", i, "should receive all the cells out of order, got", received[i])
+		}
+	}
+
+	// a new model applies to the next cells
+	lc.SetChannelModel("client-2", nil)
+	lc.Broadcast(&net.REL_CLI_DOWNSTREAM_DATA_UDP{
+		REL_CLI_DOWNSTREAM_DATA: net.REL_CLI_DOWNSTREAM_DATA{RoundID: int32(nRounds), Data: []byte{1, 2, 3}}})
+	if received := collectRounds(listeners[2], 200*time.Millisecond); len(received) != 1 || received[0] != int32(nRounds) {
+		t.Error("Client 2 should receive the cells after its loss is removed, got", received)
+	}
+}
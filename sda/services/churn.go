@@ -18,9 +18,12 @@ import (
  * When a node connects :
  * the relay identifies him as client or trustee using the stored group.toml
  * he adds it to the list of nodes
- * if PriFi was running, he kills it, and rerun it if > threshold
+ * if PriFi was running and the node is a client, the client joins it at the next re-shuffle
+ * otherwise, if PriFi was running, he kills it, and rerun it if > threshold
  *
  * When a node disconnect :
+ * if PriFi was running and the node is a client, the client is dropped from the DC-net at the next round
+ * otherwise :
  * He sends STOP messages to every other node
  * He kills his local instance of PriFi protocol
  * He empties the list of waiting nodes
@@ -64,6 +67,8 @@ type churnHandler struct {
 	startProtocol     func()
 	stopProtocol      func()
 	isProtocolRunning func() bool
+	joinProtocol      func(client *network.ServerIdentity, clientID int) bool // nil if clients cannot join a running protocol
+	leaveProtocol     func(clientID int) bool                                 // nil if clients cannot leave a running protocol
 }
 
 func (c *churnHandler) init(relayID *network.ServerIdentity, trusteesIDs []*network.ServerIdentity) {
@@ -239,6 +244,11 @@ func (c *churnHandler) handleConnection(msg *network.Envelope) {
 		}
 		log.Lvl3("ID ", ID, " assigned to client #", c.nextFreeClientID)
 		c.nextFreeClientID++
+
+		//a client can join without restarting the protocol
+		if c.joinProtocol != nil && c.isProtocolRunning() && c.joinProtocol(msg.ServerIdentity, c.nextFreeClientID-1) {
+			return
+		}
 	}
 
 	c.tryStartProtocol()
@@ -273,6 +283,11 @@ func (c *churnHandler) handleDisconnection(msg *network.Envelope) {
 
 	log.Lvl3("Received new disconnection request from", ID, " (isATrustee:", isTrustee, ")")
 
+	//a client can leave without restarting the protocol
+	if !isTrustee && c.handleClientLeaving(ID) {
+		return
+	}
+
 	/* This is the smart way. Dumb way first
 	if isTrustee {
 		delete(c.waitQueue.trustees, ID)
@@ -295,12 +310,36 @@ func (c *churnHandler) handleDisconnection(msg *network.Envelope) {
 	c.handleUnknownDisconnection()
 }
 
+// handleClientLeaving is called when the connection of the client "ID" closes. If the protocol runs, the client is
+// dropped from the DC-net at the next round, and it returns true; otherwise, the protocol needs a restart
+func (c *churnHandler) handleClientLeaving(ID string) bool {
+	c.waitQueue.writeMutex.Lock()
+	defer c.waitQueue.writeMutex.Unlock()
+
+	client, ok := c.waitQueue.clients[ID]
+	if !ok || c.leaveProtocol == nil || !c.isProtocolRunning() || !c.leaveProtocol(client.numericID) {
+		return false
+	}
+
+	//the other clients keep their ID until the protocol restarts
+	delete(c.waitQueue.clients, ID)
+	log.Lvl2("Client", ID, "left, dropped from the running protocol")
+	return true
+}
+
 /**
  * restarts the protocol (stop + start) if nClients waiting & nTrustees waiting both > 1
  */
 func (c *churnHandler) tryStartProtocol() {
 	nClients, nTrustees := c.waitQueue.count()
 
+	//the clients which left a running protocol leave holes in the IDs
+	c.nextFreeClientID = 0
+	for _, v := range c.waitQueue.clients {
+		v.numericID = c.nextFreeClientID
+		c.nextFreeClientID++
+	}
+
 	if nClients >= 1 && nTrustees >= 1 {
 		if c.isProtocolRunning() {
 			c.stopProtocol()
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"github.com/dedis/prifi/prifi-lib/config"
 	"github.com/dedis/prifi/prifi-lib/crypto"
 	"github.com/dedis/prifi/sda/protocols"
 	"go.dedis.ch/onet/v3"
@@ -11,7 +12,7 @@ import (
 )
 
 func genSI(addrPort string) *network.ServerIdentity {
-	pub, _ := crypto.NewKeyPair()
+	pub, _ := crypto.NewKeyPair(config.CryptoSuite)
 	addr := network.NewAddress(network.Local, addrPort)
 	return network.NewServerIdentity(pub, addr)
 }
@@ -633,3 +634,155 @@ func TestChurn(t *testing.T) {
 		t.Error("Protocol should have restarted")
 	}
 }
+
+func TestChurnClientJoins(t *testing.T) {
+
+	relayID := genSI("127.0.0.0:1")
+	trustees := []*network.ServerIdentity{genSI("0.127.0.0:0")}
+	clients := make([]*network.ServerIdentity, 3)
+	for i := 0; i < len(clients); i++ {
+		clients[i] = genSI("0.0.127.0:" + strconv.Itoa(i))
+	}
+
+	c := new(churnHandler)
+	c.init(relayID, trustees)
+	c.stopProtocol = stopProtocol
+	c.startProtocol = startProtocol
+	c.isProtocolRunning = func() bool { return false }
+
+	var joinedClient *network.ServerIdentity
+	joinedID := -1
+	canJoin := true
+	c.joinProtocol = func(client *network.ServerIdentity, clientID int) bool {
+		joinedClient = client
+		joinedID = clientID
+		return canJoin
+	}
+
+	//no protocol running, the clients do not join
+	c.handleConnection(genPacketFromSource(trustees[0]))
+	c.handleConnection(genPacketFromSource(clients[0]))
+	if joinedID != -1 {
+		t.Error("Client 0 should not have joined, no protocol was running")
+	}
+	if !startProtocolCalled {
+		t.Error("Protocol should have started at that point (1 client, 1 trustee)")
+	}
+	stopProtocolCalled = false
+	startProtocolCalled = false
+	c.isProtocolRunning = func() bool { return true }
+
+	//a client connects while the protocol runs, it joins
+	c.handleConnection(genPacketFromSource(clients[1]))
+	if joinedClient == nil || !joinedClient.Equal(clients[1]) || joinedID != 1 {
+		t.Error("Client 1 should have joined with ID 1, got", joinedID)
+	}
+	if stopProtocolCalled || startProtocolCalled {
+		t.Error("Protocol should not have been restarted, the client joined")
+	}
+	nClients, _ := c.waitQueue.count()
+	if nClients != 2 {
+		t.Error("nClients should be 2, is", nClients)
+	}
+	if !testIDMapForCollisions(c.createIdentitiesMap()) {
+		t.Error("Something is wrong in the ID map")
+	}
+
+	//the client cannot join, the protocol restarts
+	canJoin = false
+	c.handleConnection(genPacketFromSource(clients[2]))
+	if joinedID != 2 {
+		t.Error("Client 2 should have tried to join with ID 2, got", joinedID)
+	}
+	if !stopProtocolCalled || !startProtocolCalled {
+		t.Error("Protocol should have been restarted, the client could not join")
+	}
+	stopProtocolCalled = false
+	startProtocolCalled = false
+}
+
+func TestChurnClientLeaves(t *testing.T) {
+
+	relayID := genSI("127.0.0.0:1")
+	trustees := []*network.ServerIdentity{genSI("0.127.0.0:0")}
+	clients := make([]*network.ServerIdentity, 3)
+	for i := 0; i < len(clients); i++ {
+		clients[i] = genSI("0.0.127.0:" + strconv.Itoa(i))
+	}
+
+	c := new(churnHandler)
+	c.init(relayID, trustees)
+	c.stopProtocol = stopProtocol
+	c.startProtocol = startProtocol
+	c.isProtocolRunning = func() bool { return false }
+
+	leftID := -1
+	canLeave := true
+	c.leaveProtocol = func(clientID int) bool {
+		leftID = clientID
+		return canLeave
+	}
+
+	c.handleConnection(genPacketFromSource(trustees[0]))
+	for i := range clients {
+		c.handleConnection(genPacketFromSource(clients[i]))
+	}
+	stopProtocolCalled = false
+	startProtocolCalled = false
+	c.isProtocolRunning = func() bool { return true }
+
+	//a client disconnects while the protocol runs, it is dropped, the others keep their ID
+	c.handleDisconnection(genPacketFromSource(clients[1]))
+	if leftID != 1 {
+		t.Error("Client 1 should have left with ID 1, got", leftID)
+	}
+	if stopProtocolCalled || startProtocolCalled {
+		t.Error("Protocol should not have been restarted, the client left")
+	}
+	nClients, _ := c.waitQueue.count()
+	if nClients != 2 {
+		t.Error("nClients should be 2, is", nClients)
+	}
+	if c.waitQueue.clients[idFromServerIdentity(clients[2])].numericID != 2 {
+		t.Error("Client 2 should keep its ID while the protocol runs")
+	}
+
+	//the client cannot be dropped, the protocol restarts
+	canLeave = false
+	c.handleDisconnection(genPacketFromSource(clients[2]))
+	if leftID != 2 {
+		t.Error("Client 2 should have tried to leave with ID 2, got", leftID)
+	}
+	if !stopProtocolCalled {
+		t.Error("Protocol should have been stopped, the client could not leave")
+	}
+	stopProtocolCalled = false
+	startProtocolCalled = false
+
+	//a trustee disconnects, the protocol restarts, even if clients can leave
+	canLeave = true
+	leftID = -1
+	c.handleConnection(genPacketFromSource(trustees[0]))
+	c.handleConnection(genPacketFromSource(clients[0]))
+	c.handleDisconnection(genPacketFromSource(trustees[0]))
+	if leftID != -1 || !stopProtocolCalled {
+		t.Error("Protocol should have been stopped, a trustee left")
+	}
+	stopProtocolCalled = false
+	startProtocolCalled = false
+
+	//the IDs of the clients are contiguous again when the protocol restarts
+	c.handleConnection(genPacketFromSource(trustees[0]))
+	c.handleConnection(genPacketFromSource(clients[0]))
+	c.handleConnection(genPacketFromSource(clients[1]))
+	c.handleDisconnection(genPacketFromSource(clients[0]))
+	c.tryStartProtocol()
+	if c.waitQueue.clients[idFromServerIdentity(clients[1])].numericID != 0 {
+		t.Error("Client 1 should get ID 0 when the protocol restarts")
+	}
+	if !testIDMapForCollisions(c.createIdentitiesMap()) {
+		t.Error("Something is wrong in the ID map")
+	}
+	stopProtocolCalled = false
+	startProtocolCalled = false
+}
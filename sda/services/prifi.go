@@ -3,6 +3,7 @@ package services
 import (
 	prifi_protocol "github.com/dedis/prifi/sda/protocols"
 	"github.com/dedis/prifi/utils"
+	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 	"io/ioutil"
@@ -103,7 +104,7 @@ func (s *ServiceState) HandleStopSOCKS(msg *network.Envelope) error {
 // that sent their ciphertext in time.
 func (s *ServiceState) handleTimeout(lateClients []string, lateTrustees []string) {
 
-	// we can probably do something more clever here, since we know who disconnected. Yet let's just restart everything
+	// the relay could not drop the late clients, or some trustees are late : let's just restart everything
 	s.NetworkErrorHappened(nil)
 }
 
@@ -123,6 +124,12 @@ func (s *ServiceState) NetworkErrorHappened(si *network.ServerIdentity) {
 		log.Fatal("Can't handle a network error without a churnHandler")
 	}
 
+	//a client can leave without restarting the protocol
+	if si != nil && !s.churnHandler.isATrustee(si) && s.churnHandler.handleClientLeaving(idFromServerIdentity(si)) {
+		log.Lvl1("A network error occurred with client", si, ", it left the running PriFi protocol.")
+		return
+	}
+
 	log.Error("A network error occurred with node", si, ", warning other clients.")
 	s.churnHandler.handleUnknownDisconnection()
 }
@@ -183,6 +190,49 @@ func (s *ServiceState) StartPriFiCommunicateProtocol() {
 	wrapper.Start()
 }
 
+// JoinPriFiCommunicateProtocol lets a client which connects while the PriFi protocol
+// runs join it, instead of restarting the protocol. The client gets its own tree
+// with the relay, and joins at the next re-shuffle. It returns false if the client
+// cannot join, e.g. the protocol is not communicating yet.
+func (s *ServiceState) JoinPriFiCommunicateProtocol(client *network.ServerIdentity, clientID int) bool {
+	if s.role != prifi_protocol.Relay || !s.IsPriFiProtocolRunning() {
+		return false
+	}
+	log.Lvl1("Client", clientID, "joins the running PriFi protocol")
+
+	// a flat tree with the relay as root and the client as only child
+	roster := onet.NewRoster([]*network.ServerIdentity{s.churnHandler.relayIdentity, client})
+	tree := roster.GenerateNaryTreeWithRoot(1, s.churnHandler.relayIdentity)
+	pi, err := s.CreateProtocol(prifi_protocol.ProtocolName, tree)
+	if err != nil {
+		log.Error("Unable to create a PriFi protocol for client", clientID, "to join:", err)
+		return false
+	}
+
+	wrapper := pi.(*prifi_protocol.PriFiSDAProtocol)
+	if err := s.PriFiSDAProtocol.AddJoiningClient(wrapper, clientID); err != nil {
+		log.Lvl1("Client", clientID, "cannot join the running PriFi protocol, restarting it:", err)
+		wrapper.Shutdown()
+		return false
+	}
+	return true
+}
+
+// LeavePriFiCommunicateProtocol drops a client whose connection closed from the PriFi protocol running, instead of
+// restarting the protocol. It returns false if the client cannot be dropped, e.g. it is the last client.
+func (s *ServiceState) LeavePriFiCommunicateProtocol(clientID int) bool {
+	if s.role != prifi_protocol.Relay || !s.IsPriFiProtocolRunning() {
+		return false
+	}
+	log.Lvl1("Client", clientID, "leaves the running PriFi protocol")
+
+	if err := s.PriFiSDAProtocol.RemoveLeavingClient(clientID); err != nil {
+		log.Lvl1("Client", clientID, "cannot leave the running PriFi protocol, restarting it:", err)
+		return false
+	}
+	return true
+}
+
 // stopPriFi stops the PriFi protocol currently running.
 func (s *ServiceState) StopPriFiCommunicateProtocol() {
 	log.Lvl1("Stopping PriFi protocol")
@@ -147,6 +147,8 @@ func (s *ServiceState) StartRelay(group *app.Group) error {
 		s.churnHandler.startProtocol = nil
 	}
 	s.churnHandler.stopProtocol = s.StopPriFiCommunicateProtocol
+	s.churnHandler.joinProtocol = s.JoinPriFiCommunicateProtocol
+	s.churnHandler.leaveProtocol = s.LeavePriFiCommunicateProtocol
 
 	socksServerConfig = &prifi_protocol.SOCKSConfig{
 		ListeningAddr:     "127.0.0.1:" + strconv.Itoa(s.prifiTomlConfig.SocksClientPort),
@@ -0,0 +1,137 @@
+package transport
+
+/*
+ * The configuration of an entity, read from a .toml, instead of the group.toml of onet. The keys are hex-encoded.
+ *	CryptoSuite = "Ed25519"
+ *	Role = "client"                     # "relay", "client" or "trustee"
+ *	ID = 0
+ *	PrivateKey = "..."
+ *	RelayAddress = "10.0.0.254:7000"
+ *	RelayPublicKey = "..."              # for the clients and trustees
+ *	[[Clients]]                         # for the relay, one per client allowed to connect
+ *	ID = 0
+ *	PublicKey = "..."
+ *	[[Trustees]]                        # for the relay, one per trustee allowed to connect
+ *	ID = 0
+ *	PublicKey = "..."
+ */
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dedis/prifi/prifi-lib/config"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
+)
+
+// tomlPeer is a client or a trustee in the .toml
+type tomlPeer struct {
+	ID        int
+	PublicKey string
+}
+
+// tomlConfig is the content of the .toml
+type tomlConfig struct {
+	CryptoSuite    string
+	Role           string
+	ID             int
+	PrivateKey     string
+	RelayAddress   string
+	RelayPublicKey string
+	Clients        []tomlPeer
+	Trustees       []tomlPeer
+}
+
+// LoadConfig reads the configuration in the .toml "path"
+func LoadConfig(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return ParseConfig(string(data))
+}
+
+// ParseConfig reads the configuration in the content of a .toml
+func ParseConfig(data string) (Config, error) {
+	tc := tomlConfig{}
+	if _, err := toml.Decode(data, &tc); err != nil {
+		return Config{}, err
+	}
+
+	suiteName := tc.CryptoSuite
+	if suiteName == "" {
+		suiteName = config.DefaultCryptoSuiteName
+	}
+	suite, err := config.FindCryptoSuite(suiteName)
+	if err != nil {
+		return Config{}, err
+	}
+
+	c := Config{
+		Suite:          suite,
+		ID:             tc.ID,
+		RelayAddress:   tc.RelayAddress,
+		ClientsPublic:  make(map[int]kyber.Point),
+		TrusteesPublic: make(map[int]kyber.Point),
+	}
+	switch tc.Role {
+	case "relay":
+		c.Role = Relay
+	case "client":
+		c.Role = Client
+	case "trustee":
+		c.Role = Trustee
+	default:
+		return Config{}, errors.New("config.go : unknown role " + tc.Role + ", should be relay, client or trustee")
+	}
+
+	if c.Private, err = decodeScalar(suite, tc.PrivateKey); err != nil {
+		return Config{}, errors.New("config.go : invalid PrivateKey, " + err.Error())
+	}
+	if tc.RelayPublicKey != "" {
+		if c.RelayPublic, err = decodePoint(suite, tc.RelayPublicKey); err != nil {
+			return Config{}, errors.New("config.go : invalid RelayPublicKey, " + err.Error())
+		}
+	}
+	for _, p := range tc.Clients {
+		if c.ClientsPublic[p.ID], err = decodePoint(suite, p.PublicKey); err != nil {
+			return Config{}, errors.New("config.go : invalid PublicKey of client " + strconv.Itoa(p.ID) + ", " + err.Error())
+		}
+	}
+	for _, p := range tc.Trustees {
+		if c.TrusteesPublic[p.ID], err = decodePoint(suite, p.PublicKey); err != nil {
+			return Config{}, errors.New("config.go : invalid PublicKey of trustee " + strconv.Itoa(p.ID) + ", " + err.Error())
+		}
+	}
+	return c, nil
+}
+
+// decodeScalar decodes the hex-encoded scalar "s"
+func decodeScalar(suite suites.Suite, s string) (kyber.Scalar, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	scalar := suite.Scalar()
+	if err := scalar.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return scalar, nil
+}
+
+// decodePoint decodes the hex-encoded point "s"
+func decodePoint(suite suites.Suite, s string) (kyber.Point, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	point := suite.Point()
+	if err := point.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return point, nil
+}
@@ -0,0 +1,181 @@
+package transport
+
+/*
+ * Frames of the TCP transport. Each frame is [0:4 length of the rest] [rest], and the rest of the frames sent after the
+ * handshake is [0:1 length of the type name] [type name] [protobuf encoding of the message] [HMAC-SHA256].
+ * Each direction of a connection has its own HMAC key, and each frame is authenticated with its sequence number in
+ * this direction : the frames cannot be forged, replayed or reordered.
+ */
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/protobuf"
+)
+
+// MAX_FRAME_SIZE is the size of the biggest frame accepted, 64 MiB
+const MAX_FRAME_SIZE = 64 << 20
+
+// MAC_SIZE is the size of the authentication tag of each frame
+const MAC_SIZE = sha256.Size
+
+// messageTypes are the messages of the prifi-lib, by name
+var messageTypes = make(map[string]reflect.Type)
+
+func init() {
+	messages := []interface{}{
+		net.ALL_ALL_SHUTDOWN{},
+		net.ALL_ALL_PARAMETERS{},
+		net.CLI_REL_TELL_PK_AND_EPH_PK{},
+		net.CLI_REL_JOIN{},
+		net.CLI_REL_UPSTREAM_DATA{},
+		net.CLI_REL_OPENCLOSED_DATA{},
+		net.CLI_REL_DOWNSTREAM_NACK{},
+		net.REL_CLI_DOWNSTREAM_DATA{},
+		net.REL_CLI_DOWNSTREAM_DATA_UDP{},
+		net.REL_CLI_TELL_EPH_PKS_AND_TRUSTEES_SIG{},
+		net.REL_CLI_ASK_EPH_PK{},
+		net.REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE{},
+		net.REL_TRU_TELL_TRANSCRIPT{},
+		net.TRU_REL_DC_CIPHER{},
+		net.REL_TRU_TELL_RATE_CHANGE{},
+		net.REL_TRU_TELL_EPOCH{},
+		net.REL_TRU_TELL_SLOT_LENGTH{},
+		net.REL_TRU_DOWNSTREAM_DIGESTS{},
+		net.TRU_REL_DOWNSTREAM_EQUIVOCATION{},
+		net.TRU_REL_SHUFFLE_SIG{},
+		net.TRU_REL_TELL_NEW_BASE_AND_EPH_PKS{},
+		net.TRU_REL_TELL_PK{},
+		net.REL_CLI_DISRUPTED_ROUND{},
+		net.CLI_REL_DISRUPTION_BLAME{},
+		net.REL_ALL_DISRUPTION_REVEAL{},
+		net.CLI_REL_DISRUPTION_REVEAL{},
+		net.TRU_REL_DISRUPTION_REVEAL{},
+		net.REL_ALL_REVEAL_SHARED_SECRETS{},
+		net.CLI_REL_SHARED_SECRET{},
+		net.TRU_REL_SHARED_SECRET{},
+	}
+	for _, m := range messages {
+		t := reflect.TypeOf(m)
+		messageTypes[t.Name()] = t
+	}
+}
+
+// encodeMessage returns the type name and the protobuf encoding of "msg", a message of the prifi-lib or a pointer to one
+func encodeMessage(msg interface{}) ([]byte, error) {
+	v := reflect.ValueOf(msg)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, errors.New("frames.go : cannot encode a nil message")
+		}
+		v = v.Elem()
+	}
+	name := v.Type().Name()
+	if t, found := messageTypes[name]; !found || t != v.Type() {
+		return nil, errors.New("frames.go : cannot encode " + v.Type().String() + ", not a message of the prifi-lib")
+	}
+
+	// protobuf needs a pointer to the struct
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	body, err := protobuf.Encode(ptr.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 1+len(name)+len(body))
+	data[0] = byte(len(name))
+	copy(data[1:], name)
+	copy(data[1+len(name):], body)
+	return data, nil
+}
+
+// decodeMessage decodes the message encoded by encodeMessage, and returns it by value, as the prifi-lib expects
+func decodeMessage(data []byte, constructors protobuf.Constructors) (interface{}, error) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, errors.New("frames.go : cannot decode, the type name is truncated")
+	}
+	name := string(data[1 : 1+data[0]])
+	t, found := messageTypes[name]
+	if !found {
+		return nil, errors.New("frames.go : cannot decode, unknown message type " + name)
+	}
+
+	ptr := reflect.New(t)
+	if err := protobuf.DecodeWithConstructors(data[1+len(name):], ptr.Interface(), constructors); err != nil {
+		return nil, errors.New("frames.go : cannot decode " + name + ", " + err.Error())
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// writeFrame writes "payload", prefixed by its length
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads a frame written by writeFrame, of at most "maxSize" bytes, and returns its payload
+func readFrame(r io.Reader, maxSize int) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > uint32(maxSize) {
+		return nil, errors.New("frames.go : frame of " + strconv.FormatUint(uint64(size), 10) + " bytes, the limit is " + strconv.Itoa(maxSize))
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// authenticator computes the tags of the frames sent in one direction of a connection
+type authenticator struct {
+	key      []byte
+	sequence uint64 // the sequence number of the next frame
+}
+
+// tag returns the tag of the next frame, "payload"
+func (a *authenticator) tag(payload []byte) []byte {
+	var sequence [8]byte
+	binary.BigEndian.PutUint64(sequence[:], a.sequence)
+	a.sequence++
+
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(sequence[:])
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// writeAuthenticatedFrame writes "payload" followed by its tag
+func writeAuthenticatedFrame(w io.Writer, a *authenticator, payload []byte) error {
+	return writeFrame(w, append(payload[:len(payload):len(payload)], a.tag(payload)...))
+}
+
+// readAuthenticatedFrame reads a frame written by writeAuthenticatedFrame, and returns its payload if its tag is valid
+func readAuthenticatedFrame(r io.Reader, a *authenticator) ([]byte, error) {
+	frame, err := readFrame(r, MAX_FRAME_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < MAC_SIZE {
+		return nil, errors.New("frames.go : frame of " + strconv.Itoa(len(frame)) + " bytes, smaller than its tag")
+	}
+	payload := frame[:len(frame)-MAC_SIZE]
+	if !hmac.Equal(a.tag(payload), frame[len(frame)-MAC_SIZE:]) {
+		return nil, errors.New("frames.go : invalid tag, the frame is forged, replayed or reordered")
+	}
+	return payload, nil
+}
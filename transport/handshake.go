@@ -0,0 +1,122 @@
+package transport
+
+/*
+ * Mutual authentication of a connection. The clients and trustees know the public key of the relay, and the relay
+ * knows the public keys of the clients and trustees allowed to connect. The side connecting is the initiator.
+ * 1. each side sends a hello [0:1 role] [1:5 ID] [5:37 nonce], the initiator first
+ * 2. each side computes the Diffie-Hellman secret of its private key and of the public key of the other, and derives
+ *    the HMAC keys of both directions from it and from both hellos
+ * 3. each side sends the tag of HANDSHAKE_CONFIRMATION, and checks the one of the other
+ * Only the owners of the two private keys can compute these tags. The frames are authenticated, not encrypted.
+ */
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
+)
+
+// HANDSHAKE_TIMEOUT is the time a connection has to complete the handshake
+const HANDSHAKE_TIMEOUT = 10 * time.Second
+
+// HANDSHAKE_CONFIRMATION is tagged by each side at the end of the handshake
+const HANDSHAKE_CONFIRMATION = "PriFi TCP transport, handshake confirmation"
+
+// helloSize is the size of a hello
+const helloSize = 1 + 4 + 32
+
+// peerKeyFunc returns the public key of the entity "role", "id", or an error if it is not allowed to connect
+type peerKeyFunc func(role Role, id int) (kyber.Point, error)
+
+// handshake authenticates "conn", where we are "role", "id", and returns the connection with the peer
+func handshake(conn net.Conn, suite suites.Suite, private kyber.Scalar, role Role, id int, initiator bool,
+	peerKey peerKeyFunc) (*connection, error) {
+
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := make([]byte, helloSize)
+	hello[0] = byte(role)
+	binary.BigEndian.PutUint32(hello[1:5], uint32(id))
+	if _, err := rand.Read(hello[5:]); err != nil {
+		return nil, err
+	}
+
+	var peerHello []byte
+	var err error
+	if initiator {
+		if err := writeFrame(conn, hello); err != nil {
+			return nil, err
+		}
+	}
+	if peerHello, err = readFrame(conn, helloSize); err != nil {
+		return nil, err
+	}
+	if len(peerHello) != helloSize {
+		return nil, errors.New("handshake.go : invalid hello")
+	}
+	peerRole := Role(peerHello[0])
+	peerID := int(int32(binary.BigEndian.Uint32(peerHello[1:5])))
+	peerPublic, err := peerKey(peerRole, peerID)
+	if err != nil {
+		return nil, err
+	}
+	if !initiator {
+		if err := writeFrame(conn, hello); err != nil {
+			return nil, err
+		}
+	}
+
+	initiatorHello, responderHello := hello, peerHello
+	if !initiator {
+		initiatorHello, responderHello = peerHello, hello
+	}
+	secret, err := suite.Point().Mul(private, peerPublic).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	master := sha256.New()
+	master.Write(secret)
+	master.Write(initiatorHello)
+	master.Write(responderHello)
+	masterKey := master.Sum(nil)
+
+	c := &connection{
+		conn: conn,
+		role: peerRole,
+		id:   peerID,
+		send: &authenticator{key: deriveKey(masterKey, "initiator")},
+		recv: &authenticator{key: deriveKey(masterKey, "responder")},
+	}
+	if !initiator {
+		c.send, c.recv = c.recv, c.send
+	}
+
+	// the confirmations are small, both sides can write before reading
+	if err := writeAuthenticatedFrame(conn, c.send, []byte(HANDSHAKE_CONFIRMATION)); err != nil {
+		return nil, err
+	}
+	confirmation, err := readAuthenticatedFrame(conn, c.recv)
+	if err != nil {
+		return nil, errors.New("handshake.go : " + peerRole.String() + " " + strconv.Itoa(peerID) + " is not authenticated, " + err.Error())
+	}
+	if string(confirmation) != HANDSHAKE_CONFIRMATION {
+		return nil, errors.New("handshake.go : invalid confirmation from " + peerRole.String() + " " + strconv.Itoa(peerID))
+	}
+	return c, nil
+}
+
+// deriveKey returns the key "label" derived from "masterKey"
+func deriveKey(masterKey []byte, label string) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
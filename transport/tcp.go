@@ -0,0 +1,451 @@
+package transport
+
+/*
+ * Package transport runs PriFi without onet : the TCPMessageSender implements the MessageSender of the prifi-lib over
+ * plain TCP connections, mutually authenticated with the keys of the entities (see handshake.go), carrying
+ * length-prefixed protobuf frames (see frames.go).
+ * The relay listens, and each client and trustee connects to it : the prifi-lib only sends messages between the relay
+ * and the others. The broadcasts to the clients are sent on each connection, there is no UDP.
+ * Each entity only accepts the messages the role of the other end sends (e.g., CLI_REL_* from a client), carrying the
+ * ID it authenticated with : a connection sending another message is closed.
+ *
+ * Usage, for each entity :
+ *	ms, err := NewTCPMessageSender(config)
+ *	instance := prifi_lib.NewPriFiRelay(..., ms)    // or NewPriFiClient, NewPriFiTrustee
+ *	err = ms.Start(instance.ReceivedMessage)
+ * then the relay waits for the clients and trustees with WaitForPeers, and starts the protocol by giving
+ * ALL_ALL_PARAMETERS to its instance.
+ */
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+// RECEIVED_MESSAGES_QUEUE_SIZE is the number of received messages waiting to be handled
+const RECEIVED_MESSAGES_QUEUE_SIZE = 10000
+
+// Role is the role of a PriFi entity
+type Role byte
+
+// The possible roles of a PriFi entity, of type Role
+const (
+	Relay Role = iota
+	Client
+	Trustee
+)
+
+// String returns the name of the role
+func (r Role) String() string {
+	switch r {
+	case Relay:
+		return "relay"
+	case Client:
+		return "client"
+	case Trustee:
+		return "trustee"
+	}
+	return "role " + strconv.Itoa(int(r))
+}
+
+// Config is the configuration of one PriFi entity
+type Config struct {
+	Suite          suites.Suite
+	Role           Role
+	ID             int // the ID of the client or trustee, ignored for the relay
+	Private        kyber.Scalar
+	RelayAddress   string              // the relay listens on this address, the clients and trustees connect to it
+	RelayPublic    kyber.Point         // for the clients and trustees
+	ClientsPublic  map[int]kyber.Point // for the relay, the clients allowed to connect, by ID
+	TrusteesPublic map[int]kyber.Point // for the relay, the trustees allowed to connect, by ID
+}
+
+// connection is an authenticated connection with another entity
+type connection struct {
+	sync.Mutex // held while writing
+	conn       net.Conn
+	role       Role
+	id         int
+	send       *authenticator
+	recv       *authenticator // only used by the reading goroutine
+}
+
+// writeMessage sends "msg" on the connection
+func (c *connection) writeMessage(msg interface{}) error {
+	data, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	return writeAuthenticatedFrame(c.conn, c.send, data)
+}
+
+// readMessage receives a message from the connection
+func (c *connection) readMessage(constructors protobuf.Constructors) (interface{}, error) {
+	data, err := readAuthenticatedFrame(c.conn, c.recv)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMessage(data, constructors)
+}
+
+// TCPMessageSender is a MessageSender over TCP
+type TCPMessageSender struct {
+	sync.Mutex
+	config       Config
+	constructors protobuf.Constructors
+	listener     net.Listener
+	relay        *connection
+	clients      map[int]*connection
+	trustees     map[int]*connection
+	received     chan interface{}
+	stop         chan bool
+	stopped      bool
+}
+
+// NewTCPMessageSender returns a TCPMessageSender for the entity "config"
+func NewTCPMessageSender(config Config) (*TCPMessageSender, error) {
+	if config.Suite == nil || config.Private == nil {
+		return nil, errors.New("tcp.go : the suite and the private key are mandatory")
+	}
+	if config.RelayAddress == "" {
+		return nil, errors.New("tcp.go : the address of the relay is mandatory")
+	}
+	switch config.Role {
+	case Relay:
+		if len(config.ClientsPublic) == 0 || len(config.TrusteesPublic) == 0 {
+			return nil, errors.New("tcp.go : the relay needs the public keys of the clients and of the trustees")
+		}
+	case Client, Trustee:
+		if config.RelayPublic == nil {
+			return nil, errors.New("tcp.go : the " + config.Role.String() + " needs the public key of the relay")
+		}
+	default:
+		return nil, errors.New("tcp.go : unknown " + config.Role.String())
+	}
+
+	var point kyber.Point
+	var scalar kyber.Scalar
+	constructors := make(protobuf.Constructors)
+	constructors[reflect.TypeOf(&point).Elem()] = func() interface{} { return config.Suite.Point() }
+	constructors[reflect.TypeOf(&scalar).Elem()] = func() interface{} { return config.Suite.Scalar() }
+
+	return &TCPMessageSender{
+		config:       config,
+		constructors: constructors,
+		clients:      make(map[int]*connection),
+		trustees:     make(map[int]*connection),
+		received:     make(chan interface{}, RECEIVED_MESSAGES_QUEUE_SIZE),
+		stop:         make(chan bool),
+	}, nil
+}
+
+// Start listens (for the relay) or connects to the relay (for the clients and trustees), then gives each message
+// received to "handler", one at a time
+func (t *TCPMessageSender) Start(handler func(interface{}) error) error {
+	if t.config.Role == Relay {
+		listener, err := net.Listen("tcp", t.config.RelayAddress)
+		if err != nil {
+			return err
+		}
+		t.Lock()
+		t.listener = listener
+		t.Unlock()
+		log.Lvl2("TCP transport : relay listening on", listener.Addr())
+		go t.acceptConnections(listener)
+	} else {
+		conn, err := net.Dial("tcp", t.config.RelayAddress)
+		if err != nil {
+			return err
+		}
+		c, err := handshake(conn, t.config.Suite, t.config.Private, t.config.Role, t.config.ID, true, t.relayKey)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		log.Lvl2("TCP transport :", t.config.Role, t.config.ID, "connected to the relay at", t.config.RelayAddress)
+		t.Lock()
+		t.relay = c
+		t.Unlock()
+		go t.readMessages(c)
+	}
+
+	go func() {
+		for {
+			select {
+			case msg := <-t.received:
+				if err := handler(msg); err != nil {
+					log.Error("TCP transport : error handling a message,", err)
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the relay listens on, nil for the others or before Start
+func (t *TCPMessageSender) Addr() net.Addr {
+	t.Lock()
+	defer t.Unlock()
+	if t.listener == nil {
+		return nil
+	}
+	return t.listener.Addr()
+}
+
+// WaitForPeers waits until "nClients" clients and "nTrustees" trustees are connected to the relay
+func (t *TCPMessageSender) WaitForPeers(nClients, nTrustees int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		t.Lock()
+		clients, trustees := len(t.clients), len(t.trustees)
+		t.Unlock()
+		if clients >= nClients && trustees >= nTrustees {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("tcp.go : only " + strconv.Itoa(clients) + " clients and " + strconv.Itoa(trustees) +
+				" trustees connected after " + timeout.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Stop closes all the connections, and stops handling the messages
+func (t *TCPMessageSender) Stop() {
+	t.Lock()
+	defer t.Unlock()
+	if t.stopped {
+		return
+	}
+	t.stopped = true
+	close(t.stop)
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	if t.relay != nil {
+		t.relay.conn.Close()
+	}
+	for _, c := range t.clients {
+		c.conn.Close()
+	}
+	for _, c := range t.trustees {
+		c.conn.Close()
+	}
+}
+
+// relayKey returns the public key of the relay, the only entity the clients and trustees connect to
+func (t *TCPMessageSender) relayKey(role Role, id int) (kyber.Point, error) {
+	if role != Relay {
+		return nil, errors.New("tcp.go : expected the relay, got " + role.String() + " " + strconv.Itoa(id))
+	}
+	return t.config.RelayPublic, nil
+}
+
+// peerKey returns the public key of the client or trustee "id", if it is allowed to connect to the relay
+func (t *TCPMessageSender) peerKey(role Role, id int) (kyber.Point, error) {
+	var public kyber.Point
+	switch role {
+	case Client:
+		public = t.config.ClientsPublic[id]
+	case Trustee:
+		public = t.config.TrusteesPublic[id]
+	}
+	if public == nil {
+		return nil, errors.New("tcp.go : " + role.String() + " " + strconv.Itoa(id) + " is not allowed to connect")
+	}
+	return public, nil
+}
+
+// acceptConnections authenticates the clients and trustees connecting to the relay
+func (t *TCPMessageSender) acceptConnections(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Lvl2("TCP transport : relay stops listening,", err)
+			return
+		}
+		go func() {
+			c, err := handshake(conn, t.config.Suite, t.config.Private, Relay, 0, false, t.peerKey)
+			if err != nil {
+				log.Error("TCP transport : rejecting the connection from", conn.RemoteAddr(), ",", err)
+				conn.Close()
+				return
+			}
+			log.Lvl2("TCP transport :", c.role, c.id, "connected from", conn.RemoteAddr())
+
+			t.Lock()
+			connections := t.clients
+			if c.role == Trustee {
+				connections = t.trustees
+			}
+			if previous, found := connections[c.id]; found {
+				log.Lvl2("TCP transport :", c.role, c.id, "reconnected, closing its previous connection")
+				previous.conn.Close()
+			}
+			connections[c.id] = c
+			t.Unlock()
+
+			t.readMessages(c)
+		}()
+	}
+}
+
+// readMessages queues the messages received on "c", until the connection fails or "c" sends a message it may not send
+func (t *TCPMessageSender) readMessages(c *connection) {
+	for {
+		msg, err := c.readMessage(t.constructors)
+		if err != nil {
+			log.Lvl2("TCP transport : connection with", c.role, c.id, "closed,", err)
+			c.conn.Close()
+			t.forget(c)
+			return
+		}
+		if err := t.checkSender(c, msg); err != nil {
+			log.Error("TCP transport : closing the connection with", c.role, c.id, ",", err)
+			c.conn.Close()
+			t.forget(c)
+			return
+		}
+		select {
+		case t.received <- msg:
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// messagePrefixes returns the prefixes of the names of the messages which the role "from" sends to the role "to"
+func messagePrefixes(from, to Role) []string {
+	switch {
+	case from == Client && to == Relay:
+		return []string{"CLI_REL_"}
+	case from == Trustee && to == Relay:
+		return []string{"TRU_REL_"}
+	case from == Relay && to == Client:
+		// the clients which verify the shuffles get its transcript
+		return []string{"REL_CLI_", "REL_ALL_", "ALL_ALL_", "REL_TRU_TELL_TRANSCRIPT"}
+	case from == Relay && to == Trustee:
+		return []string{"REL_TRU_", "REL_ALL_", "ALL_ALL_"}
+	}
+	return nil
+}
+
+// checkSender returns an error if "msg", received on "c", is not a message which the role of "c" sends us, or if it
+// carries the ID of another client or trustee than the one authenticated
+func (t *TCPMessageSender) checkSender(c *connection, msg interface{}) error {
+	name := reflect.TypeOf(msg).Name()
+	allowed := false
+	for _, prefix := range messagePrefixes(c.role, t.config.Role) {
+		if strings.HasPrefix(name, prefix) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return errors.New("tcp.go : a " + c.role.String() + " cannot send " + name)
+	}
+
+	var idField string
+	switch c.role {
+	case Client:
+		idField = "ClientID"
+	case Trustee:
+		idField = "TrusteeID"
+	default:
+		return nil
+	}
+	id := reflect.ValueOf(msg).FieldByName(idField)
+	if id.IsValid() && id.Kind() == reflect.Int && int(id.Int()) != c.id {
+		return errors.New("tcp.go : " + c.role.String() + " " + strconv.Itoa(c.id) + " sent " + name + " with " +
+			idField + " " + strconv.Itoa(int(id.Int())))
+	}
+	return nil
+}
+
+// forget removes "c" from the connections, unless it was replaced already
+func (t *TCPMessageSender) forget(c *connection) {
+	t.Lock()
+	defer t.Unlock()
+	switch {
+	case c == t.relay:
+		t.relay = nil
+	case c.role == Client && t.clients[c.id] == c:
+		delete(t.clients, c.id)
+	case c.role == Trustee && t.trustees[c.id] == c:
+		delete(t.trustees, c.id)
+	}
+}
+
+// send writes "msg" on the connection with "role" "id"
+func (t *TCPMessageSender) send(role Role, id int, msg interface{}) error {
+	t.Lock()
+	var c *connection
+	switch role {
+	case Relay:
+		c = t.relay
+	case Client:
+		c = t.clients[id]
+	case Trustee:
+		c = t.trustees[id]
+	}
+	t.Unlock()
+	if c == nil {
+		return errors.New("tcp.go : " + role.String() + " " + strconv.Itoa(id) + " is not connected")
+	}
+	if err := c.writeMessage(msg); err != nil {
+		return errors.New("tcp.go : could not send to " + role.String() + " " + strconv.Itoa(id) + ", " + err.Error())
+	}
+	return nil
+}
+
+// SendToClient sends "msg" to the client i
+func (t *TCPMessageSender) SendToClient(i int, msg interface{}) error {
+	return t.send(Client, i, msg)
+}
+
+// SendToTrustee sends "msg" to the trustee i
+func (t *TCPMessageSender) SendToTrustee(i int, msg interface{}) error {
+	return t.send(Trustee, i, msg)
+}
+
+// SendToRelay sends "msg" to the relay
+func (t *TCPMessageSender) SendToRelay(msg interface{}) error {
+	return t.send(Relay, 0, msg)
+}
+
+// BroadcastToAllClients sends "msg" to each client connected
+func (t *TCPMessageSender) BroadcastToAllClients(msg interface{}) error {
+	t.Lock()
+	ids := make([]int, 0, len(t.clients))
+	for id := range t.clients {
+		ids = append(ids, id)
+	}
+	t.Unlock()
+
+	var firstErr error
+	for _, id := range ids {
+		if err := t.SendToClient(id, msg); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ClientSubscribeToBroadcast does nothing : the broadcasts come through the connection with the relay
+func (t *TCPMessageSender) ClientSubscribeToBroadcast(clientID int, messageReceived func(interface{}) error, startStopChan chan bool) error {
+	return nil
+}
@@ -0,0 +1,371 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	prifi_lib "github.com/dedis/prifi/prifi-lib"
+	"github.com/dedis/prifi/prifi-lib/config"
+	"github.com/dedis/prifi/prifi-lib/net"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v3/log"
+)
+
+func TestFrames(t *testing.T) {
+
+	ms, err := NewTCPMessageSender(Config{Suite: config.CryptoSuite, Role: Client, Private: config.CryptoSuite.Scalar(),
+		RelayAddress: "localhost:1", RelayPublic: config.CryptoSuite.Point()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := config.CryptoSuite.Point().Pick(config.CryptoSuite.RandomStream())
+	msg := net.REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE{
+		Pks:    []kyber.Point{base, config.CryptoSuite.Point().Base()},
+		EphPks: []kyber.Point{base},
+		Base:   base,
+	}
+	// the prifi-lib sends pointers or values, and receives values
+	data, err := encodeMessage(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeMessage(data, ms.constructors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, ok := decoded.(net.REL_TRU_TELL_CLIENTS_PKS_AND_EPH_PKS_AND_BASE)
+	if !ok || !received.Base.Equal(base) || len(received.Pks) != 2 || !received.Pks[1].Equal(config.CryptoSuite.Point().Base()) {
+		t.Error("Should decode the points of the message, got", decoded)
+	}
+
+	params := net.ALL_ALL_PARAMETERS{}
+	params.Add("NClients", 3)
+	params.Add("DCNetType", "Simple")
+	data, _ = encodeMessage(params)
+	decoded, err = decodeMessage(data, ms.constructors)
+	if p, ok := decoded.(net.ALL_ALL_PARAMETERS); err != nil || !ok || p.IntValueOrElse("NClients", 0) != 3 ||
+		p.StringValueOrElse("DCNetType", "") != "Simple" {
+		t.Error("Should decode the parameters, got", decoded, err)
+	}
+
+	udp := &net.REL_CLI_DOWNSTREAM_DATA_UDP{REL_CLI_DOWNSTREAM_DATA: net.REL_CLI_DOWNSTREAM_DATA{RoundID: 7, Data: []byte{1, 2}}}
+	data, _ = encodeMessage(udp)
+	decoded, err = decodeMessage(data, ms.constructors)
+	if d, ok := decoded.(net.REL_CLI_DOWNSTREAM_DATA_UDP); err != nil || !ok || d.RoundID != 7 || !bytes.Equal(d.Data, []byte{1, 2}) {
+		t.Error("Should decode the embedded downstream data, got", decoded, err)
+	}
+
+	if _, err := encodeMessage(struct{ A int }{1}); err == nil {
+		t.Error("Should refuse a message which is not one of the prifi-lib")
+	}
+	if _, err := decodeMessage([]byte{3, 'A', 'B', 'C'}, ms.constructors); err == nil {
+		t.Error("Should refuse an unknown message type")
+	}
+
+	// the frames cannot be modified, replayed or reordered
+	sender := &authenticator{key: []byte("key")}
+	buffer := new(bytes.Buffer)
+	writeAuthenticatedFrame(buffer, sender, []byte("first"))
+	first := append([]byte{}, buffer.Bytes()...)
+	writeAuthenticatedFrame(buffer, sender, []byte("second"))
+
+	receiver := &authenticator{key: []byte("key")}
+	if payload, err := readAuthenticatedFrame(buffer, receiver); err != nil || string(payload) != "first" {
+		t.Error("Should read the first frame, got", payload, err)
+	}
+	if _, err := readAuthenticatedFrame(bytes.NewReader(first), receiver); err == nil {
+		t.Error("Should refuse a replayed frame")
+	}
+
+	tampered := append([]byte{}, first...)
+	tampered[5] ^= 1
+	if _, err := readAuthenticatedFrame(bytes.NewReader(tampered), &authenticator{key: []byte("key")}); err == nil {
+		t.Error("Should refuse a modified frame")
+	}
+	if _, err := readFrame(bytes.NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF}), MAX_FRAME_SIZE); err == nil {
+		t.Error("Should refuse a frame bigger than the limit")
+	}
+}
+
+// deployment is the configuration of a relay, its clients and trustees, with fresh keys
+type deployment struct {
+	relay    Config
+	clients  []Config
+	trustees []Config
+}
+
+// newDeployment returns the configuration of a relay, listening on an ephemeral port, "nClients" clients and
+// "nTrustees" trustees
+func newDeployment(nClients, nTrustees int) *deployment {
+	suite := config.CryptoSuite
+	relayKeys := key.NewKeyPair(suite)
+	d := &deployment{relay: Config{
+		Suite:          suite,
+		Role:           Relay,
+		Private:        relayKeys.Private,
+		RelayAddress:   "127.0.0.1:0",
+		ClientsPublic:  make(map[int]kyber.Point),
+		TrusteesPublic: make(map[int]kyber.Point),
+	}}
+	for i := 0; i < nClients+nTrustees; i++ {
+		keys := key.NewKeyPair(suite)
+		c := Config{Suite: suite, Role: Client, ID: i, Private: keys.Private, RelayPublic: relayKeys.Public}
+		if i < nClients {
+			d.relay.ClientsPublic[i] = keys.Public
+			d.clients = append(d.clients, c)
+		} else {
+			c.Role, c.ID = Trustee, i-nClients
+			d.relay.TrusteesPublic[c.ID] = keys.Public
+			d.trustees = append(d.trustees, c)
+		}
+	}
+	return d
+}
+
+// startRelay starts the relay of "d", and sets the address the others connect to
+func (d *deployment) startRelay(t *testing.T, handler func(interface{}) error) *TCPMessageSender {
+	relay, err := NewTCPMessageSender(d.relay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.Start(handler); err != nil {
+		t.Fatal(err)
+	}
+	for i := range d.clients {
+		d.clients[i].RelayAddress = relay.Addr().String()
+	}
+	for i := range d.trustees {
+		d.trustees[i].RelayAddress = relay.Addr().String()
+	}
+	return relay
+}
+
+func TestHandshake(t *testing.T) {
+
+	d := newDeployment(2, 1)
+	received := make(chan interface{}, 10)
+	relay := d.startRelay(t, func(msg interface{}) error {
+		received <- msg
+		return nil
+	})
+	defer relay.Stop()
+
+	client, err := NewTCPMessageSender(d.clients[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Start(func(interface{}) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+	if err := relay.WaitForPeers(1, 0, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	client.SendToRelay(&net.CLI_REL_JOIN{ClientID: 0})
+	select {
+	case msg := <-received:
+		if join, ok := msg.(net.CLI_REL_JOIN); !ok || join.ClientID != 0 {
+			t.Error("The relay should receive the message of client 0, got", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The relay did not receive the message of client 0")
+	}
+
+	// a client with another key, a client not allowed, and a relay with another key are rejected
+	impostor := d.clients[1]
+	impostor.Private = config.CryptoSuite.Scalar().Pick(config.CryptoSuite.RandomStream())
+	unknown := d.clients[1]
+	unknown.ID = 5
+	wrongRelay := d.clients[1]
+	wrongRelay.RelayPublic = key.NewKeyPair(config.CryptoSuite).Public
+	for _, c := range []Config{impostor, unknown, wrongRelay} {
+		ms, err := NewTCPMessageSender(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ms.Start(func(interface{}) error { return nil }); err == nil {
+			ms.Stop()
+			t.Error("The connection of client", c.ID, "should be rejected")
+		}
+	}
+	if err := relay.WaitForPeers(2, 0, 100*time.Millisecond); err == nil {
+		t.Error("Only client 0 should be connected")
+	}
+	if err := relay.SendToClient(1, &net.ALL_ALL_SHUTDOWN{}); err == nil {
+		t.Error("Should not send to a client not connected")
+	}
+
+	if _, err := NewTCPMessageSender(Config{Suite: config.CryptoSuite, Role: Client, Private: impostor.Private,
+		RelayAddress: "localhost:1"}); err == nil {
+		t.Error("A client should need the key of the relay")
+	}
+}
+
+func TestMessageAuthorization(t *testing.T) {
+
+	d := newDeployment(2, 1)
+	received := make(chan interface{}, 10)
+	relay := d.startRelay(t, func(msg interface{}) error {
+		received <- msg
+		return nil
+	})
+	defer relay.Stop()
+
+	// a client sending with the ID of another client, or a message of a trustee, is disconnected
+	forged := []interface{}{&net.CLI_REL_JOIN{ClientID: 1}, &net.TRU_REL_DC_CIPHER{TrusteeID: 0}}
+	for _, msg := range forged {
+		client, err := NewTCPMessageSender(d.clients[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Start(func(interface{}) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		if err := relay.WaitForPeers(1, 0, 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		client.SendToRelay(&net.CLI_REL_JOIN{ClientID: 0})
+		client.SendToRelay(msg)
+		select {
+		case m := <-received:
+			if _, ok := m.(net.CLI_REL_JOIN); !ok {
+				t.Error("The relay should receive the message of client 0, got", m)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("The relay did not receive the message of client 0")
+		}
+		disconnected := false
+		for i := 0; i < 50 && !disconnected; i++ {
+			disconnected = relay.SendToClient(0, &net.ALL_ALL_SHUTDOWN{}) != nil
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !disconnected {
+			t.Error("The relay should close the connection of a client sending", msg)
+		}
+		select {
+		case m := <-received:
+			t.Error("The relay should drop", m)
+		default:
+		}
+		client.Stop()
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+
+	suite := config.CryptoSuite
+	relay := key.NewKeyPair(suite)
+	client := key.NewKeyPair(suite)
+	hexKey := func(m interface{ MarshalBinary() ([]byte, error) }) string {
+		data, _ := m.MarshalBinary()
+		return hex.EncodeToString(data)
+	}
+
+	c, err := ParseConfig(`
+Role = "relay"
+PrivateKey = "` + hexKey(relay.Private) + `"
+RelayAddress = "127.0.0.1:7000"
+[[Clients]]
+ID = 3
+PublicKey = "` + hexKey(client.Public) + `"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the private keys of Ed25519 are clamped, only the key they generate matters
+	if c.Role != Relay || !suite.Point().Mul(c.Private, nil).Equal(relay.Public) || c.RelayAddress != "127.0.0.1:7000" ||
+		c.ClientsPublic[3] == nil || !c.ClientsPublic[3].Equal(client.Public) || c.Suite.String() != config.DefaultCryptoSuiteName {
+		t.Error("Should read the configuration of the relay, got", c)
+	}
+
+	if _, err := ParseConfig(`Role = "observer"`); err == nil {
+		t.Error("Should refuse an unknown role")
+	}
+	if _, err := ParseConfig(`Role = "client"
+PrivateKey = "zz"`); err == nil {
+		t.Error("Should refuse a key which is not hex-encoded")
+	}
+}
+
+func TestPriFiOverTCP(t *testing.T) {
+
+	nClients, nTrustees := 2, 2
+	d := newDeployment(nClients, nTrustees)
+
+	relayOutput := make(chan []byte, 10000)
+	resultChan := make(chan interface{}, 1)
+	timeoutHandler := func(clients, trustees []int) { log.Error("Timeout of clients", clients, "and trustees", trustees) }
+	var relay *prifi_lib.PriFiLibInstance
+	relayMS := d.startRelay(t, func(msg interface{}) error { return relay.ReceivedMessage(msg) })
+	defer relayMS.Stop()
+	relay = prifi_lib.NewPriFiRelay(true, make(chan []byte), relayOutput, resultChan, timeoutHandler, relayMS)
+
+	for _, c := range d.trustees {
+		ms, err := NewTCPMessageSender(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trustee := prifi_lib.NewPriFiTrustee(false, true, 2, ms)
+		if err := ms.Start(trustee.ReceivedMessage); err != nil {
+			t.Fatal(err)
+		}
+		defer ms.Stop()
+	}
+	for i, c := range d.clients {
+		ms, err := NewTCPMessageSender(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataForDCNet := make(chan []byte, 10)
+		for j := 0; j < 5; j++ {
+			dataForDCNet <- []byte{byte(i + 1), byte(j), 0xAB}
+		}
+		client := prifi_lib.NewPriFiClient(false, false, dataForDCNet, make(chan []byte), false, "./", ms)
+		if err := ms.Start(client.ReceivedMessage); err != nil {
+			t.Fatal(err)
+		}
+		defer ms.Stop()
+	}
+	if err := relayMS.WaitForPeers(nClients, nTrustees, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	params := new(net.ALL_ALL_PARAMETERS)
+	params.Add("PayloadSize", 100)
+	params.Add("DownstreamCellSize", 100)
+	params.Add("WindowSize", 1)
+	params.Add("ExperimentRoundLimit", 30)
+	params.Add("DCNetType", "Simple")
+	params.Add("RelayRoundTimeOut", 10000)
+	params.Add("RelayTrusteeCacheLowBound", 10)
+	params.Add("RelayTrusteeCacheHighBound", 20)
+	params.Add("StartNow", true)
+	params.Add("NTrustees", nTrustees)
+	params.Add("NClients", nClients)
+	params.ForceParams = true
+	if err := relay.ReceivedMessage(*params); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-resultChan:
+	case <-time.After(60 * time.Second):
+		t.Fatal("PriFi over TCP did not reach the round limit")
+	}
+
+	// the relay decoded the messages of both clients
+	received := make(map[byte]int)
+	for len(relayOutput) > 0 {
+		o := <-relayOutput
+		if len(o) >= 3 && o[2] == 0xAB {
+			received[o[0]]++
+		}
+	}
+	if received[1] == 0 || received[2] == 0 {
+		t.Error("The relay should output the messages of both clients, got", received)
+	}
+}